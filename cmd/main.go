@@ -3,49 +3,120 @@ package main
 import (
 	"context"
 	"go-raft/internal/adapters/http"
+	"go-raft/internal/adapters/http/account"
 	"go-raft/internal/adapters/http/asset"
+	"go-raft/internal/adapters/http/checksum"
+	"go-raft/internal/adapters/http/cluster"
+	"go-raft/internal/adapters/http/currency"
+	"go-raft/internal/adapters/http/ledger"
+	"go-raft/internal/adapters/http/limit"
 	"go-raft/internal/adapters/http/snapshot"
+	"go-raft/internal/adapters/http/upgrade"
+	"go-raft/internal/blobstore"
 	"go-raft/internal/configs"
+	"go-raft/internal/logging"
 	"go-raft/internal/raft"
-	"log"
+	"go-raft/internal/tracing"
 	"os"
 	"os/signal"
+	"path/filepath"
 	"syscall"
+	"time"
+
+	"github.com/sirupsen/logrus"
 )
 
 func main() {
 	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGINT, syscall.SIGTERM)
 	defer stop()
 
+	// 所有 log（包含 dragonboat）使用同一個 logrus logger，需在建立 NodeHost 前設定
+	if err := logging.Setup(envOr("LOG_LEVEL", configs.LogLevel), envOr("LOG_FORMAT", configs.LogFormat)); err != nil {
+		logrus.Fatalf("failed to setup logger: %v", err)
+	}
+	// 設定 OTEL_EXPORTER_OTLP_ENDPOINT 時以 OTLP 匯出 span，否則 tracing 為 no-op
+	shutdownTracing, err := tracing.Setup(ctx)
+	if err != nil {
+		logrus.Fatalf("failed to setup tracing: %v", err)
+	}
+
 	// Initialize the Raft store
 	defaultCfg := raft.NodeConfig{
 		FileDir:     configs.FileDir,
 		RaftAddress: configs.RaftAddress,
 		NodeID:      configs.NodeID,
 		ClusterID:   configs.ClusterID,
+		OnDisk:      configs.OnDiskStateMachine,
 	}
 	raftstore, err := raft.New(defaultCfg)
 	if err != nil {
-		log.Fatalf("failed to start replica: %v", err)
+		logrus.Fatalf("failed to start replica: %v", err)
+	}
+
+	// 定期備份 snapshot，設定 BACKUP_S3_ENDPOINT 時上傳到 S3 相容服務，否則寫入本機目錄
+	backupstore, err := newBackupStore()
+	if err != nil {
+		logrus.Fatalf("failed to create backup store: %v", err)
 	}
+	backupjob := raft.NewBackupJob(raftstore.NodeHost, raftstore.ClusterID, raftstore.NodeID, backupstore, raft.BackupConfig{
+		Interval:  configs.BackupInterval,
+		ExportDir: filepath.Join(configs.FileDir, "backup-export"),
+		Keep:      configs.BackupKeep,
+	})
+	go backupjob.Run(ctx)
 
 	// Initialize all hanlders
 	assethandler := asset.NewHanlder(raftstore.NodeHost, raftstore.ClusterID)
-	snapshothandler := snapshot.NewHanlder()
+	snapshothandler := snapshot.NewHanlder(raftstore.NodeHost, raftstore.ClusterID)
+	currencyhandler := currency.NewHanlder(raftstore.NodeHost, raftstore.ClusterID)
+	accounthandler := account.NewHanlder(raftstore.NodeHost, raftstore.ClusterID)
+	limithandler := limit.NewHanlder(raftstore.NodeHost, raftstore.ClusterID)
+	upgradehandler := upgrade.NewHanlder(raftstore.NodeHost, raftstore.ClusterID)
+	ledgerhandler := ledger.NewHanlder(raftstore.NodeHost, raftstore.ClusterID)
+	checksumhandler := checksum.NewHanlder(raftstore.NodeHost, raftstore.ClusterID)
+	clusterhandler := cluster.NewHanlder(raftstore.NodeHost, raftstore.ClusterID)
 
 	// [::1]:19090 for ipv6
-	httpserver := http.New([]string{"0.0.0.0:9090"}, assethandler, snapshothandler)
+	httpserver := http.New([]string{"0.0.0.0:9090"}, assethandler, snapshothandler, currencyhandler, accounthandler, limithandler, upgradehandler, ledgerhandler, checksumhandler, clusterhandler)
 	go func() {
 		if err := httpserver.Start(); err != nil {
-			log.Fatalf("failed to start HTTP server: %v", err)
+			logrus.Fatalf("failed to start HTTP server: %v", err)
 		}
 	}()
 
 	// 等待中斷訊號
 	<-ctx.Done()
-	log.Println("Main: shutdown signal received")
+	logrus.Info("Main: shutdown signal received")
 
 	// todo: 放所有需要graceful shutdown 的函式
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := shutdownTracing(shutdownCtx); err != nil {
+		logrus.WithError(err).Warn("Main: flush spans failed")
+	}
+
+	logrus.Info("Main: all servers shutdown cleanly")
+}
 
-	log.Println("Main: all servers shutdown cleanly")
+// envOr 回傳環境變數的值，未設定時回傳 fallback
+func envOr(key, fallback string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return fallback
+}
+
+// newBackupStore 依環境變數建立備份使用的 BlobStore，S3 的憑證不寫在程式碼中
+func newBackupStore() (blobstore.BlobStore, error) {
+	endpoint := os.Getenv("BACKUP_S3_ENDPOINT")
+	if endpoint == "" {
+		return blobstore.NewLocalStore(configs.BackupDir)
+	}
+	return blobstore.NewS3Store(blobstore.S3Config{
+		Endpoint:  endpoint,
+		Region:    os.Getenv("BACKUP_S3_REGION"),
+		Bucket:    os.Getenv("BACKUP_S3_BUCKET"),
+		AccessKey: os.Getenv("BACKUP_S3_ACCESS_KEY"),
+		SecretKey: os.Getenv("BACKUP_S3_SECRET_KEY"),
+	})
 }
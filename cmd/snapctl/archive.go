@@ -0,0 +1,176 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"go-raft/internal/raft"
+	"go-raft/internal/store"
+)
+
+// runExport 從節點的 admin API 下載帳本封存檔，驗證完成後才放到輸出路徑
+func runExport(args []string) error {
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	addr := fs.String("url", "http://127.0.0.1:9090", "base URL of the node's HTTP API")
+	minApplied := fs.Uint64("min-applied", 0, "lower bound: archive includes at least this raft index, the exported index may be higher")
+	out := fs.String("out", "", "output archive file")
+	if _, err := parseArgs(fs, args, 0); err != nil {
+		return err
+	}
+	if *out == "" {
+		return errors.New("export: -out is required")
+	}
+
+	u := strings.TrimSuffix(*addr, "/") + "/admin/ledger/export?" + url.Values{"min_applied": {strconv.FormatUint(*minApplied, 10)}}.Encode()
+	resp, err := http.Get(u)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("export: %s returned %s", u, resp.Status)
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(*out), ".snapctl-export-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.ReadFrom(resp.Body); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	a, err := readArchive(tmp.Name())
+	if err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), *out); err != nil {
+		return err
+	}
+	printArchive(*out, a)
+	return nil
+}
+
+// runArchive 將離線的 dragonboat snapshot 轉成帳本封存檔
+func runArchive(args []string) error {
+	fs := flag.NewFlagSet("archive", flag.ExitOnError)
+	cluster := fs.Uint64("cluster", 0, "source shard ID, defaults to the one in snapshot.metadata")
+	out := fs.String("out", "", "output archive file")
+	dirs, err := parseArgs(fs, args, 1)
+	if err != nil {
+		return err
+	}
+	if *out == "" {
+		return errors.New("archive: -out is required")
+	}
+	s, err := load(dirs[0])
+	if err != nil {
+		return err
+	}
+	if s.Meta.History == nil {
+		return fmt.Errorf("archive: %s has no balance history, the applied index is unknown", s.Dir)
+	}
+	if *cluster == 0 && s.Record != nil {
+		*cluster = s.Record.ShardID
+	}
+
+	f, err := os.OpenFile(*out, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	err = errors.Join(store.WriteArchive(f, *cluster, s.SnapshotContent), f.Sync(), f.Close())
+	if err != nil {
+		os.Remove(*out)
+		return err
+	}
+	a, err := readArchive(*out)
+	if err != nil {
+		return err
+	}
+	printArchive(*out, a)
+	return nil
+}
+
+// runRestore 以帳本封存檔在本節點建立全新 shard 的第一個 snapshot，需在節點啟動前執行
+// 每個初始成員都要以相同的封存檔與 -members 各自執行一次，之後以 Join=false、不帶 InitialMembers 啟動
+func runRestore(args []string) error {
+	fs := flag.NewFlagSet("restore", flag.ExitOnError)
+	archive := fs.String("archive", "", "ledger archive file")
+	dir := fs.String("dir", "", "node data directory (NodeHostDir)")
+	addr := fs.String("raft-address", "", "raft address of this node")
+	node := fs.Uint64("node", 0, "replica ID of this node")
+	cluster := fs.Uint64("cluster", 0, "shard ID of the new shard")
+	members := fs.String("members", "", "initial members, e.g. 1=host1:63001,2=host2:63001")
+	if _, err := parseArgs(fs, args, 0); err != nil {
+		return err
+	}
+	if *archive == "" || *dir == "" || *addr == "" || *node == 0 || *cluster == 0 {
+		return errors.New("restore: -archive, -dir, -raft-address, -node and -cluster are required")
+	}
+	memberMap, err := parseMembers(*members)
+	if err != nil {
+		return err
+	}
+	if memberMap[*node] != *addr {
+		return fmt.Errorf("restore: -members must list node %d at %s", *node, *addr)
+	}
+
+	a, err := readArchive(*archive)
+	if err != nil {
+		return err
+	}
+	nc := raft.NodeConfig{FileDir: *dir, RaftAddress: *addr, NodeID: *node, ClusterID: *cluster}
+	if err := raft.ImportArchive(nc, a, memberMap); err != nil {
+		return err
+	}
+	fmt.Printf("shard %d replica %d bootstrapped at index %d from shard %d\n", *cluster, *node, a.Index, a.ClusterID)
+	return nil
+}
+
+func readArchive(path string) (*store.Archive, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	a, err := store.ReadArchive(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return a, nil
+}
+
+func printArchive(path string, a *store.Archive) {
+	var records int
+	for _, byUID := range a.Content.Balances {
+		records += len(byUID)
+	}
+	fmt.Printf("%s: shard %d, index %d, %d currencies, %d balances, %d accounts\n",
+		path, a.ClusterID, a.Index, len(a.Content.Balances), records, len(a.Content.Meta.Accounts))
+}
+
+// parseMembers 解析 "1=host:port,2=host:port"
+func parseMembers(s string) (map[uint64]string, error) {
+	members := make(map[uint64]string)
+	for _, part := range strings.Split(s, ",") {
+		id, addr, ok := strings.Cut(strings.TrimSpace(part), "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid member %q", part)
+		}
+		nodeID, err := strconv.ParseUint(id, 10, 64)
+		if err != nil || nodeID == 0 || addr == "" {
+			return nil, fmt.Errorf("invalid member %q", part)
+		}
+		members[nodeID] = addr
+	}
+	return members, nil
+}
@@ -0,0 +1,283 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"reflect"
+	"slices"
+	"strconv"
+	"text/tabwriter"
+
+	"go-raft/internal/configs"
+	"go-raft/internal/gbsnap"
+	"go-raft/internal/store"
+
+	pb "github.com/lni/dragonboat/v4/raftpb"
+	"github.com/lni/dragonboat/v4/statemachine"
+)
+
+// runInspect 顯示 snapshot 摘要
+func runInspect(args []string) error {
+	dirs, err := parseArgs(flag.NewFlagSet("inspect", flag.ExitOnError), args, 1)
+	if err != nil {
+		return err
+	}
+	s, err := load(dirs[0])
+	if err != nil {
+		return err
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(tw, "snapshot\t%s\n", s.Path)
+	fmt.Fprintf(tw, "index\t%d\n", s.Index)
+	fmt.Fprintf(tw, "term\t%d\n", s.Term)
+	fmt.Fprintf(tw, "size\t%d bytes, %s\n", s.Size, s.Header.CompressionType)
+	fmt.Fprintf(tw, "format\tv%d\n", s.Version)
+	if st := s.Meta.Settings; st != nil {
+		fmt.Fprintf(tw, "settings\tsnapshot v%d (floor %d), command v%d\n", st.SnapshotVersion, st.SnapshotFloor, st.CommandVersion)
+	}
+	if h := s.Meta.History; h != nil {
+		fmt.Fprintf(tw, "applied\t%d (history floor %d)\n", h.Applied, h.Floor)
+	}
+	fmt.Fprintf(tw, "registry\t%d currencies\n", len(s.Meta.Currencies))
+	fmt.Fprintf(tw, "accounts\t%d\n", len(s.Meta.Accounts))
+	if l := s.Meta.Limits; l != nil {
+		fmt.Fprintf(tw, "limits\t%d rules\n", len(l.Rules))
+	}
+	fmt.Fprintf(tw, "nodes\t%d reported\n", len(s.Meta.Nodes))
+	fmt.Fprintf(tw, "files\t%d external\n", len(s.Files))
+	fmt.Fprintf(tw, "manifest\t%t\n", s.Meta.Manifest != nil)
+	fmt.Fprintln(tw)
+	fmt.Fprintln(tw, "CURRENCY\tRECORDS\tTOTAL")
+	for _, currency := range sortedKeys(s.Balances) {
+		byUID := s.Balances[currency]
+		var total float64
+		for _, uid := range sortedKeys(byUID) {
+			total += byUID[uid]
+		}
+		fmt.Fprintf(tw, "%s\t%d\t%s\n", currency, len(byUID), formatFloat(total))
+	}
+	return tw.Flush()
+}
+
+// dumpOutput 為 dump -format json 的輸出格式
+type dumpOutput struct {
+	Index    uint64                        `json:"index"`
+	Term     uint64                        `json:"term"`
+	Version  uint64                        `json:"version"`
+	Meta     *store.StoreMeta              `json:"meta"`
+	Balances map[string]map[string]float64 `json:"balances"` // currency -> uid -> balance
+}
+
+// runDump 以 JSON 或 CSV 輸出 snapshot 內容，CSV 只包含餘額
+func runDump(args []string) error {
+	fs := flag.NewFlagSet("dump", flag.ExitOnError)
+	format := fs.String("format", "json", "output format: json or csv")
+	dirs, err := parseArgs(fs, args, 1)
+	if err != nil {
+		return err
+	}
+	s, err := load(dirs[0])
+	if err != nil {
+		return err
+	}
+
+	switch *format {
+	case "json":
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(dumpOutput{Index: s.Index, Term: s.Term, Version: s.Version, Meta: s.Meta, Balances: s.Balances})
+	case "csv":
+		w := csv.NewWriter(os.Stdout)
+		w.Write([]string{"currency", "uid", "balance"})
+		for _, currency := range sortedKeys(s.Balances) {
+			byUID := s.Balances[currency]
+			for _, uid := range sortedKeys(byUID) {
+				w.Write([]string{currency, uid, formatFloat(byUID[uid])})
+			}
+		}
+		w.Flush()
+		return w.Error()
+	default:
+		return fmt.Errorf("dump: unknown format %q", *format)
+	}
+}
+
+// runDiff 比較兩個 snapshot 的設定、帳戶、幣別註冊表與餘額，回傳是否完全相同
+// 只比較狀態內容，格式版本或 index 不同但狀態相同仍視為相同
+func runDiff(args []string) (bool, error) {
+	dirs, err := parseArgs(flag.NewFlagSet("diff", flag.ExitOnError), args, 2)
+	if err != nil {
+		return false, err
+	}
+	a, err := load(dirs[0])
+	if err != nil {
+		return false, err
+	}
+	b, err := load(dirs[1])
+	if err != nil {
+		return false, err
+	}
+
+	same := true
+	report := func(format string, args ...any) {
+		same = false
+		fmt.Printf(format+"\n", args...)
+	}
+	if a.Index != b.Index || a.Version != b.Version {
+		fmt.Printf("# index %d -> %d, format v%d -> v%d\n", a.Index, b.Index, a.Version, b.Version)
+	}
+	if !reflect.DeepEqual(a.Meta.Settings, b.Meta.Settings) {
+		report("settings: %+v -> %+v", deref(a.Meta.Settings), deref(b.Meta.Settings))
+	}
+	if !reflect.DeepEqual(a.Meta.Currencies, b.Meta.Currencies) {
+		report("registry: %d -> %d currencies", len(a.Meta.Currencies), len(b.Meta.Currencies))
+	}
+	accountsA, accountsB := make(map[string]any), make(map[string]any)
+	for _, acc := range a.Meta.Accounts {
+		accountsA[acc.UID] = acc
+	}
+	for _, acc := range b.Meta.Accounts {
+		accountsB[acc.UID] = acc
+	}
+	diffMaps(accountsA, accountsB, func(uid, change string) { report("account %s: %s", uid, change) })
+	if !reflect.DeepEqual(a.Meta.Limits, b.Meta.Limits) {
+		report("limits differ")
+	}
+
+	currencies := append(sortedKeys(a.Balances), sortedKeys(b.Balances)...)
+	slices.Sort(currencies)
+	for _, currency := range slices.Compact(currencies) {
+		byA, byB := make(map[string]any), make(map[string]any)
+		for uid, v := range a.Balances[currency] {
+			byA[uid] = v
+		}
+		for uid, v := range b.Balances[currency] {
+			byB[uid] = v
+		}
+		diffMaps(byA, byB, func(uid, change string) { report("balance %s/%s: %s", currency, uid, change) })
+	}
+	return same, nil
+}
+
+// diffMaps 依 key 排序回報兩個 map 之間新增、移除與變更的項目
+func diffMaps(a, b map[string]any, report func(key, change string)) {
+	keys := append(sortedKeys(a), sortedKeys(b)...)
+	slices.Sort(keys)
+	for _, key := range slices.Compact(keys) {
+		va, inA := a[key]
+		vb, inB := b[key]
+		switch {
+		case !inB:
+			report(key, fmt.Sprintf("removed (was %v)", va))
+		case !inA:
+			report(key, fmt.Sprintf("added %v", vb))
+		case !reflect.DeepEqual(va, vb):
+			report(key, fmt.Sprintf("%v -> %v", va, vb))
+		}
+	}
+}
+
+// runConvert 以指定的格式版本重新寫出 snapshot，輸出目錄可直接以 dragonboat tools.ImportSnapshot 匯入
+func runConvert(args []string) error {
+	fs := flag.NewFlagSet("convert", flag.ExitOnError)
+	version := fs.Uint64("version", configs.DefaultSnapshotVersion, "target snapshot format version")
+	out := fs.String("out", "", "parent directory of the converted snapshot")
+	dirs, err := parseArgs(fs, args, 1)
+	if err != nil {
+		return err
+	}
+	if *out == "" {
+		return errors.New("convert: -out is required")
+	}
+	if !configs.IsSupportedSnapshotVersion(*version) {
+		return fmt.Errorf("convert: unsupported snapshot version %d", *version)
+	}
+	s, err := load(dirs[0])
+	if err != nil {
+		return err
+	}
+
+	// 外部檔案先寫到輸出目錄下的暫存目錄，Create 再以 hard link 放入 snapshot 目錄
+	if err := os.MkdirAll(*out, 0o755); err != nil {
+		return err
+	}
+	tmp, err := os.MkdirTemp(*out, ".snapctl-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmp)
+	cs, err := s.recover(tmp)
+	if err != nil {
+		return err
+	}
+
+	record := pb.Snapshot{Index: s.Index, Term: s.Term, Type: pb.ConcurrentStateMachine}
+	if s.Record != nil {
+		record = *s.Record
+	} else {
+		warnf("%s has no %s, term and membership are left empty", s.Dir, gbsnap.MetadataFilename)
+	}
+	converted, err := gbsnap.Create(*out, record, s.sessions, func(w io.Writer, fss statemachine.ISnapshotFileCollection) error {
+		return cs.SaveSnapshot(*version, w, fss, nil)
+	})
+	if err != nil {
+		return err
+	}
+
+	// 重新讀取輸出，確認內容與來源相同；v2 以 %f 字串儲存餘額，只回報捨入的筆數
+	check, err := load(converted.Dir)
+	if err != nil {
+		return err
+	}
+	var rounded int
+	for currency, byUID := range s.Balances {
+		for uid, balance := range byUID {
+			got, ok := check.Balances[currency][uid]
+			switch {
+			case !ok:
+				return fmt.Errorf("convert: %s/%s missing from %s", currency, uid, converted.Dir)
+			case got == balance:
+			case *version == 2 && formatFloat(got) == strconv.FormatFloat(balance, 'f', 6, 64):
+				rounded++
+			default:
+				return fmt.Errorf("convert: %s/%s is %v in %s, source %v", currency, uid, got, converted.Dir, balance)
+			}
+		}
+	}
+	if rounded > 0 {
+		warnf("%d balances rounded to 6 decimal places by the v2 format", rounded)
+	}
+	fmt.Printf("%s: v%d -> v%d, %d files\n", converted.Dir, s.Version, check.Version, len(converted.Files))
+	return nil
+}
+
+func formatFloat(v float64) string {
+	if math.IsInf(v, 0) || math.IsNaN(v) {
+		return fmt.Sprint(v)
+	}
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
+
+func deref[T any](p *T) T {
+	var zero T
+	if p == nil {
+		return zero
+	}
+	return *p
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	return keys
+}
@@ -0,0 +1,73 @@
+// snapctl 離線檢視與轉換 dragonboat snapshot 目錄，並匯出、還原不依賴 dragonboat 目錄結構的帳本封存檔
+//
+//	snapctl inspect <dir>                         顯示 index、格式版本、設定與各幣別摘要
+//	snapctl dump [-format json|csv] <dir>         輸出所有餘額
+//	snapctl diff <dirA> <dirB>                    比較兩個 snapshot，有差異時結束碼為 1
+//	snapctl convert -version N -out <parent> <dir> 以指定格式版本寫出新的 snapshot 目錄
+//	snapctl export -url <api> -out <file>          從節點下載帳本封存檔
+//	snapctl archive -out <file> <dir>              將 snapshot 轉成帳本封存檔
+//	snapctl restore -archive <file> -dir <dir> -raft-address <addr> -node N -cluster ID -members 1=addr,...
+//	                                               以封存檔建立全新 shard，需在節點啟動前於每個成員執行
+//	snapctl checksum -urls <api>,<api>... [-index N] 比較各節點在 checksum index 的餘額，有差異時結束碼為 1
+//
+// dir 可以是單一 snapshot 目錄（含 .gbsnap），或節點的 snapshot 根目錄（取 index 最大者）
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+)
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+	}
+	var err error
+	switch args := os.Args[2:]; os.Args[1] {
+	case "inspect":
+		err = runInspect(args)
+	case "dump":
+		err = runDump(args)
+	case "diff":
+		var same bool
+		if same, err = runDiff(args); err == nil && !same {
+			os.Exit(1)
+		}
+	case "convert":
+		err = runConvert(args)
+	case "export":
+		err = runExport(args)
+	case "archive":
+		err = runArchive(args)
+	case "restore":
+		err = runRestore(args)
+	case "checksum":
+		var same bool
+		if same, err = runChecksum(args); err == nil && !same {
+			os.Exit(1)
+		}
+	default:
+		usage()
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "snapctl:", err)
+		os.Exit(2)
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: snapctl inspect|dump|diff|convert|export|archive|restore|checksum [flags] [<dir>...]")
+	os.Exit(2)
+}
+
+// parseArgs 解析子命令的 flag，並檢查剩餘的目錄參數數量
+func parseArgs(fs *flag.FlagSet, args []string, dirs int) ([]string, error) {
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	if fs.NArg() != dirs {
+		return nil, fmt.Errorf("%s: expected %d snapshot directories, got %d", fs.Name(), dirs, fs.NArg())
+	}
+	return fs.Args(), nil
+}
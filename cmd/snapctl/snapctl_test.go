@@ -0,0 +1,245 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"go-raft/internal/domain"
+	"go-raft/internal/gbsnap"
+	"go-raft/internal/store"
+
+	pb "github.com/lni/dragonboat/v4/raftpb"
+	"github.com/lni/dragonboat/v4/statemachine"
+)
+
+// captureStdout 執行 fn 並回傳其寫到 os.Stdout 的內容
+func captureStdout(t *testing.T, fn func() error) (string, error) {
+	t.Helper()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	out := make(chan string)
+	go func() {
+		data, _ := io.ReadAll(r)
+		out <- string(data)
+	}()
+	err = fn()
+	os.Stdout = stdout
+	w.Close()
+	return <-out, err
+}
+
+// seedLedger 寫入幣別、帳戶、限額與 20 筆餘額變動，記憶體與 Pebble 上的帳本寫入後狀態相同
+func seedLedger(t *testing.T, l store.Ledger) {
+	t.Helper()
+	for i := 1; i <= 20; i++ {
+		index := uint64(i)
+		now := l.Advance(index, int64(i)*1000, true)
+		switch i {
+		case 1:
+			l.Registry().Put(domain.Currency{Code: "USD", Precision: 2, Enabled: true})
+		case 2:
+			if err := l.Accounts().Open(index, now, "user-1", "vip", map[string]string{"k": "v"}); err != nil {
+				t.Fatal(err)
+			}
+		case 3:
+			l.Limits().Set(domain.LimitRule{Scope: domain.LimitScopeCurrency, Currency: "USD", Policy: domain.LimitPolicy{MaxSingleAmount: 100}})
+		}
+		l.UpdateAt(index, fmt.Sprintf("user-%d", i%5), []string{"USD", "BTC"}[i%2], float64(i)+0.5)
+	}
+}
+
+// diskSnapshot 以 Pebble 帳本寫出 OnDisk 模式的 snapshot 目錄
+func diskSnapshot(t *testing.T) string {
+	t.Helper()
+	ds, err := store.OpenDiskStore(t.TempDir(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ds.Close()
+	l, err := ds.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	seedLedger(t, l)
+	err = l.Commit()
+	l.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	view, err := ds.View()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer view.Close()
+	record := pb.Snapshot{Index: 20, Term: 1, ShardID: 1, Type: pb.OnDiskStateMachine}
+	s, err := gbsnap.Create(t.TempDir(), record, nil, func(w io.Writer, _ statemachine.ISnapshotFileCollection) error {
+		return view.WriteSnapshot(w, nil)
+	})
+	if err != nil {
+		t.Fatalf("create disk snapshot: %v", err)
+	}
+	return s.Dir
+}
+
+// memorySnapshot 以記憶體帳本寫出指定格式版本的 snapshot 目錄
+func memorySnapshot(t *testing.T, version uint64) string {
+	t.Helper()
+	cs := store.NewCurrencyStore(1, 1, t.TempDir(), 0)
+	seedLedger(t, cs)
+	return saveSnapshot(t, cs, version)
+}
+
+// saveSnapshot 將記憶體帳本目前的狀態寫成指定格式版本的 snapshot 目錄，index 為帳本已套用的 index
+func saveSnapshot(t *testing.T, cs *store.CurrencyStore, version uint64) string {
+	t.Helper()
+	record := pb.Snapshot{Index: cs.Applied(), Term: 1, ShardID: 1, Type: pb.ConcurrentStateMachine}
+	s, err := gbsnap.Create(t.TempDir(), record, nil, func(w io.Writer, fss statemachine.ISnapshotFileCollection) error {
+		return cs.SaveSnapshot(version, w, fss, nil)
+	})
+	if err != nil {
+		t.Fatalf("create v%d snapshot: %v", version, err)
+	}
+	return s.Dir
+}
+
+// TestDiskModeSnapshot OnDisk 模式的 snapshot 可以檢視、比較、轉換格式與封存
+func TestDiskModeSnapshot(t *testing.T) {
+	disk, memory := diskSnapshot(t), memorySnapshot(t, 3)
+
+	out, err := captureStdout(t, func() error { return runInspect([]string{disk}) })
+	if err != nil {
+		t.Fatalf("inspect: %v", err)
+	}
+	if !strings.Contains(out, "accounts  1") || !strings.Contains(out, "USD") {
+		t.Fatalf("unexpected inspect output:\n%s", out)
+	}
+	if _, err := captureStdout(t, func() error { return runDump([]string{"-format", "csv", disk}) }); err != nil {
+		t.Fatalf("dump: %v", err)
+	}
+
+	var same bool
+	out, err = captureStdout(t, func() error {
+		same, err = runDiff([]string{memory, disk})
+		return err
+	})
+	if err != nil || !same {
+		t.Fatalf("diff against memory snapshot: %v\n%s", err, out)
+	}
+
+	converted := t.TempDir()
+	if _, err := captureStdout(t, func() error { return runConvert([]string{"-version", "3", "-out", converted, disk}) }); err != nil {
+		t.Fatalf("convert: %v", err)
+	}
+	archive := filepath.Join(t.TempDir(), "ledger.archive")
+	if _, err := captureStdout(t, func() error { return runArchive([]string{"-out", archive, disk}) }); err != nil {
+		t.Fatalf("archive: %v", err)
+	}
+	a, err := readArchive(archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.Index != 20 || len(a.Content.Meta.Accounts) != 1 {
+		t.Fatalf("unexpected archive: index %d, %d accounts", a.Index, len(a.Content.Meta.Accounts))
+	}
+}
+
+var update = flag.Bool("update", false, "rewrite the golden files under testdata")
+
+// assertGolden 比對輸出與 testdata/name，-update 時改為寫入目前的輸出
+func assertGolden(t *testing.T, name, got string) {
+	t.Helper()
+	path := filepath.Join("testdata", name)
+	if *update {
+		if err := os.WriteFile(path, []byte(got), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal([]byte(got), want) {
+		t.Fatalf("output differs from %s:\n--- got\n%s--- want\n%s", path, got, want)
+	}
+}
+
+// TestDiffGolden 各舊版格式與 v3 比較時只回報格式不同，帳本變動依排序逐項列出
+func TestDiffGolden(t *testing.T) {
+	v3 := memorySnapshot(t, 3)
+	for _, version := range []uint64{1, 2, 3} {
+		var same bool
+		out, err := captureStdout(t, func() (err error) {
+			same, err = runDiff([]string{memorySnapshot(t, version), v3})
+			return err
+		})
+		if err != nil || !same {
+			t.Fatalf("diff v%d against v3: %v\n%s", version, err, out)
+		}
+		assertGolden(t, fmt.Sprintf("diff_v%d_v3.golden", version), out)
+	}
+
+	cs := store.NewCurrencyStore(1, 1, t.TempDir(), 0)
+	seedLedger(t, cs)
+	now := cs.Advance(21, 21000, true)
+	cs.UpdateAt(21, "user-1", "USD", 2)
+	cs.UpdateAt(21, "user-9", "BTC", 1)
+	if err := cs.Accounts().Open(21, now, "user-9", "basic", nil); err != nil {
+		t.Fatal(err)
+	}
+	var same bool
+	out, err := captureStdout(t, func() (err error) {
+		same, err = runDiff([]string{memorySnapshot(t, 1), saveSnapshot(t, cs, 3)})
+		return err
+	})
+	if err != nil || same {
+		t.Fatalf("diff changed ledger: same %v, %v\n%s", same, err, out)
+	}
+	assertGolden(t, "diff_changed.golden", out)
+}
+
+// TestConvertGolden v1 / v2 轉換為 v3 後內容不變，轉換的輸出（路徑以 $OUT 取代）與轉換後的 dump 需與 golden 檔相同
+func TestConvertGolden(t *testing.T) {
+	for _, version := range []uint64{1, 2, 3} {
+		src, out := memorySnapshot(t, version), t.TempDir()
+		got, err := captureStdout(t, func() error {
+			return runConvert([]string{"-version", "3", "-out", out, src})
+		})
+		if err != nil {
+			t.Fatalf("convert v%d: %v", version, err)
+		}
+		dirs, err := filepath.Glob(filepath.Join(out, "snapshot-*"))
+		if err != nil || len(dirs) != 1 {
+			t.Fatalf("converted snapshot: %v, %v", dirs, err)
+		}
+		dump, err := captureStdout(t, func() error { return runDump([]string{"-format", "csv", dirs[0]}) })
+		if err != nil {
+			t.Fatalf("dump converted v%d: %v", version, err)
+		}
+		assertGolden(t, fmt.Sprintf("convert_v%d_v3.golden", version), strings.ReplaceAll(got, out, "$OUT")+dump)
+		var same bool
+		if diff, err := captureStdout(t, func() (err error) {
+			same, err = runDiff([]string{src, dirs[0]})
+			return err
+		}); err != nil || !same {
+			t.Fatalf("converted v%d differs from source: %v\n%s", version, err, diff)
+		}
+		s, err := load(dirs[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if s.Version != 3 || len(s.Files) != 0 {
+			t.Fatalf("converted v%d: format v%d with %d files", version, s.Version, len(s.Files))
+		}
+	}
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"go-raft/internal/gbsnap"
+	"go-raft/internal/store"
+)
+
+// snapshot 為開啟並解碼後的 snapshot 目錄
+type snapshot struct {
+	*gbsnap.Snapshot
+	*store.SnapshotContent
+	sessions []byte
+}
+
+// load 開啟 snapshot 目錄，驗證 .gbsnap 的 checksum 並依格式版本解碼狀態機資料
+func load(dir string) (*snapshot, error) {
+	s, err := gbsnap.Open(dir)
+	if err != nil {
+		return nil, err
+	}
+	sessions, r, err := s.Read()
+	if err != nil {
+		return nil, err
+	}
+	content, err := store.DecodeSnapshot(r, s.Files, nil)
+	if err = errors.Join(err, r.Close()); err != nil {
+		return nil, fmt.Errorf("%s: %w", s.Dir, err)
+	}
+	return &snapshot{Snapshot: s, SnapshotContent: content, sessions: sessions}, nil
+}
+
+// recover 將 snapshot 還原到暫存的 store，供轉換格式時重新寫出
+func (s *snapshot) recover(fileDir string) (*store.CurrencyStore, error) {
+	_, r, err := s.Read()
+	if err != nil {
+		return nil, err
+	}
+	cs := store.NewCurrencyStore(0, 0, fileDir, 0)
+	err = cs.RecoverFromSnapshot(r, s.Files, nil)
+	if err = errors.Join(err, r.Close()); err != nil {
+		return nil, err
+	}
+	return cs, nil
+}
+
+func warnf(format string, args ...any) {
+	fmt.Fprintf(os.Stderr, "snapctl: "+format+"\n", args...)
+}
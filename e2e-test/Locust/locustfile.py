@@ -7,6 +7,8 @@ class AssetUser(HttpUser):
 
     def on_start(self):
         self.token = ""
+        # 未註冊的幣別寫入會被狀態機拒絕，重複註冊不影響結果
+        self.client.post("/currency", json={"code": "USD", "precision": 2, "enabled": True})
 
     def random_uid(self):
         return ''.join(random.choices(string.ascii_letters + string.digits, k=9))
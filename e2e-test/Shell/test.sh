@@ -37,6 +37,13 @@ shuffle() {
 
 shuffle
 
+# 先註冊測試用幣別，未註冊的幣別寫入會被狀態機拒絕
+for currency in USDT USDC BTC ETH; do
+  curl -s -o /dev/null -X POST "$BASE_URL/currency" \
+    -H "Content-Type: application/json" \
+    -d "{\"code\": \"$currency\", \"precision\": 2, \"enabled\": true}"
+done
+
 echo "開始發送 $(( REQUESTS_PER_API * ${#API_PATHS[@]} )) 筆請求，併發數 $CONCURRENT ..."
 
 request_counter=0
@@ -0,0 +1,104 @@
+package account
+
+import (
+	"errors"
+	"go-raft/internal/adapters/http/proposal"
+	"go-raft/internal/domain"
+	"go-raft/internal/store"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lni/dragonboat/v4"
+)
+
+type Handler struct {
+	nh        *dragonboat.NodeHost
+	clusterID uint64
+}
+
+func NewHanlder(nh *dragonboat.NodeHost, clusterID uint64) *Handler {
+	return &Handler{nh: nh, clusterID: clusterID}
+}
+
+// OpenAccount 開立帳戶
+func (h *Handler) OpenAccount(c *gin.Context) {
+	var req RequestOpen
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	h.propose(c, &domain.AccountCommand{UID: req.UID, Action: domain.AccountOpen, Tier: req.Tier, Metadata: req.Metadata})
+}
+
+// SetTier 變更帳戶等級，影響套用的限額政策
+func (h *Handler) SetTier(c *gin.Context) {
+	var req RequestTier
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	h.propose(c, &domain.AccountCommand{UID: req.UID, Action: domain.AccountSetTier, Tier: req.Tier})
+}
+
+// FreezeAccount 凍結帳戶（合規用途），凍結後所有寫入都會被狀態機拒絕
+func (h *Handler) FreezeAccount(c *gin.Context) {
+	h.transition(c, domain.AccountFreeze)
+}
+
+// UnfreezeAccount 解凍帳戶
+func (h *Handler) UnfreezeAccount(c *gin.Context) {
+	h.transition(c, domain.AccountUnfreeze)
+}
+
+// CloseAccount 關閉帳戶，所有幣別餘額需為零
+func (h *Handler) CloseAccount(c *gin.Context) {
+	h.transition(c, domain.AccountClose)
+}
+
+// GetAccount 查詢帳戶狀態與各幣別餘額
+func (h *Handler) GetAccount(c *gin.Context) {
+	uid := c.Query("uid")
+	if uid == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "uid required"})
+		return
+	}
+
+	result, err := h.nh.SyncRead(c.Request.Context(), h.clusterID, domain.AccountQuery{UID: uid})
+	if errors.Is(err, store.ErrAccountNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "raft read failed: " + err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": result})
+}
+
+func (h *Handler) transition(c *gin.Context, action domain.AccountAction) {
+	var req RequestStatus
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	h.propose(c, &domain.AccountCommand{UID: req.UID, Action: action, Reason: req.Reason})
+}
+
+func (h *Handler) propose(c *gin.Context, account *domain.AccountCommand) {
+	cmd := domain.Command{
+		Type:    domain.CommandAccount,
+		Account: account,
+	}
+	code, err := proposal.Propose(c.Request.Context(), h.nh, h.clusterID, cmd)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if code != domain.ResultOK {
+		c.JSON(proposal.Status(code), gin.H{"error": code.String(), "code": code})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "account " + string(account.Action), "uid": account.UID})
+}
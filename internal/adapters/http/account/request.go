@@ -0,0 +1,17 @@
+package account
+
+type RequestOpen struct {
+	UID      string            `json:"uid" binding:"required"`
+	Tier     string            `json:"tier"`
+	Metadata map[string]string `json:"metadata"`
+}
+
+type RequestTier struct {
+	UID  string `json:"uid" binding:"required"`
+	Tier string `json:"tier"`
+}
+
+type RequestStatus struct {
+	UID    string `json:"uid" binding:"required"`
+	Reason string `json:"reason"`
+}
@@ -1,14 +1,18 @@
 package asset
 
 import (
-	"bytes"
-	"encoding/gob"
+	"errors"
+	"go-raft/internal/adapters/http/proposal"
 	"go-raft/internal/domain"
-	"log"
+	"go-raft/internal/logging"
+	"go-raft/internal/store"
 	"net/http"
+	"strconv"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/lni/dragonboat/v4"
+	"github.com/sirupsen/logrus"
 )
 
 type Handler struct {
@@ -27,16 +31,19 @@ func (h *Handler) AddAsset(c *gin.Context) {
 		return
 	}
 
-	cmd := domain.Asset(req)
-	var buf bytes.Buffer
-	if err := gob.NewEncoder(&buf).Encode(&cmd); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "encode failed"})
+	asset := domain.Asset(req)
+	cmd := domain.Command{
+		Type:  domain.CommandAsset,
+		Asset: &asset,
+	}
+	logging.FromContext(c.Request.Context()).WithFields(logrus.Fields{"ClusterID": h.clusterID, "uid": asset.UID, "currency": asset.Currency, "amount": asset.Amount}).Info("AddAsset")
+	code, err := proposal.Propose(c.Request.Context(), h.nh, h.clusterID, cmd)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
-	session := h.nh.GetNoOPSession(h.clusterID)
-	log.Printf("AddAsset: %d, %+v", h.clusterID, session)
-	if _, err := h.nh.SyncPropose(c.Request.Context(), session, buf.Bytes()); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "raft propose failed: " + err.Error()})
+	if code != domain.ResultOK {
+		c.JSON(proposal.Status(code), gin.H{"error": code.String(), "code": code})
 		return
 	}
 
@@ -51,11 +58,38 @@ func (h *Handler) GetBalance(c *gin.Context) {
 		return
 	}
 
-	query := domain.Asset{
+	if c.Query("as_of_index") != "" && c.Query("as_of_time") != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "as_of_index and as_of_time are mutually exclusive"})
+		return
+	}
+
+	var query any = domain.Asset{
 		UID:      uid,
 		Currency: currency,
 	}
+	// 帶 as_of_index 時查詢該 raft index 套用後的歷史餘額
+	if asOf := c.Query("as_of_index"); asOf != "" {
+		index, err := strconv.ParseUint(asOf, 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid as_of_index"})
+			return
+		}
+		query = domain.BalanceQuery{UID: uid, Currency: currency, AsOfIndex: index}
+	}
+	// 帶 as_of_time（RFC3339）時查詢該提交時間當下的歷史餘額
+	if asOf := c.Query("as_of_time"); asOf != "" {
+		t, err := time.Parse(time.RFC3339Nano, asOf)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid as_of_time, RFC3339 expected"})
+			return
+		}
+		query = domain.BalanceQuery{UID: uid, Currency: currency, AsOfTime: t.UnixNano()}
+	}
 	value, err := h.nh.SyncRead(c.Request.Context(), h.clusterID, query)
+	if errors.Is(err, store.ErrHistoryPruned) || errors.Is(err, store.ErrIndexNotApplied) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "raft read failed: " + err.Error()})
 		return
@@ -81,6 +115,29 @@ func (h *Handler) GetBalances(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "invalid data format from raft"})
 		return
 	}
-	// log.Printf("GetBalances: %d, result: %+v, data: %+v", h.clusterID, result, data)
 	c.JSON(http.StatusOK, gin.H{"data": data})
 }
+
+func (h *Handler) GetStats(c *gin.Context) {
+	var req RequestStats
+	if err := c.ShouldBindQuery(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.Top == 0 {
+		req.Top = defaultTopHolders
+	}
+
+	query := domain.StatsQuery{
+		Currency: req.Currency,
+		TopN:     req.Top,
+		Verify:   req.Verify,
+	}
+	result, err := h.nh.SyncRead(c.Request.Context(), h.clusterID, query)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "raft read failed: " + err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": result})
+}
@@ -5,3 +5,12 @@ type RequestAdd struct {
 	Currency string  `json:"currency" binding:"required"`
 	Amount   float64 `json:"amount" binding:"required"`
 }
+
+// defaultTopHolders 未指定 top 時回傳的最大持有者數量
+const defaultTopHolders = 10
+
+type RequestStats struct {
+	Currency string `form:"currency"`
+	Top      int    `form:"top" binding:"omitempty,min=1,max=100"`
+	Verify   bool   `form:"verify"`
+}
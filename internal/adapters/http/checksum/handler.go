@@ -0,0 +1,70 @@
+package checksum
+
+import (
+	"errors"
+	"go-raft/internal/adapters/http/proposal"
+	"go-raft/internal/domain"
+	"go-raft/internal/raft"
+	"go-raft/internal/store"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lni/dragonboat/v4"
+)
+
+type Handler struct {
+	monitor *raft.ChecksumMonitor
+}
+
+func NewHanlder(nh *dragonboat.NodeHost, clusterID uint64) *Handler {
+	return &Handler{monitor: raft.NewChecksumMonitor(nh, clusterID)}
+}
+
+// GetChecksums 回傳最近的 checksum 結果，diverged 為最近一次有節點結果不一致的 index
+func (h *Handler) GetChecksums(c *gin.Context) {
+	status, err := h.monitor.Status(c.Request.Context())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	diverged := make(map[uint64][]string)
+	for _, round := range status.Rounds {
+		if round.Diverged {
+			diverged[round.Index] = round.Sections()
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "ok", "checksum": status, "sections": diverged})
+}
+
+// RequestChecksum 立即要求所有副本計算狀態摘要，結果於各節點回報後出現在 GetChecksums
+func (h *Handler) RequestChecksum(c *gin.Context) {
+	code, err := h.monitor.Request(c.Request.Context())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if code != domain.ResultOK {
+		c.JSON(proposal.Status(code), gin.H{"error": code.String(), "code": code})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "ok"})
+}
+
+// GetBalances 回傳本節點上幣別在 checksum index 的所有非零餘額，snapctl checksum 向各節點查詢後比對
+func (h *Handler) GetBalances(c *gin.Context) {
+	var req RequestBalances
+	if err := c.ShouldBindQuery(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	balances, err := h.monitor.Balances(req.Currency, req.Index)
+	if errors.Is(err, store.ErrIndexNotApplied) || errors.Is(err, store.ErrHistoryPruned) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "ok", "index": req.Index, "currency": req.Currency, "balances": balances})
+}
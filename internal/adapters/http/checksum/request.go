@@ -0,0 +1,6 @@
+package checksum
+
+type RequestBalances struct {
+	Currency string `form:"currency" binding:"required"`
+	Index    uint64 `form:"index" binding:"required"` // checksum 的 raft index
+}
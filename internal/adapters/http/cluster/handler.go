@@ -0,0 +1,57 @@
+package cluster
+
+import (
+	"errors"
+	"go-raft/internal/configs"
+	"go-raft/internal/raft"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lni/dragonboat/v4"
+)
+
+type Handler struct {
+	monitor *raft.ClusterMonitor
+}
+
+func NewHanlder(nh *dragonboat.NodeHost, clusterID uint64) *Handler {
+	return &Handler{monitor: raft.NewClusterMonitor(nh, clusterID)}
+}
+
+// Healthz 只表示行程仍在執行並能處理 HTTP 請求，不檢查 raft 狀態，供 liveness probe 使用
+func (h *Handler) Healthz(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"status": "ok"})
+}
+
+// Readyz 於 shard 已載入、已知 leader 且套用進度追上 commit 時回傳 200，否則回傳 503 與原因，供 readiness probe 使用
+func (h *Handler) Readyz(c *gin.Context) {
+	var req RequestReady
+	if err := c.ShouldBindQuery(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	maxLag := uint64(configs.ReadyMaxLag)
+	if req.MaxLag != nil {
+		maxLag = *req.MaxLag
+	}
+	status, err := h.monitor.Ready(maxLag)
+	if err != nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"status": "not ready", "error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "ok", "leaderId": status.LeaderID, "lag": status.Lag()})
+}
+
+// GetStatus 回傳本節點看到的 leader、term、角色、成員與套用 / snapshot 進度
+func (h *Handler) GetStatus(c *gin.Context) {
+	status, err := h.monitor.Status(c.Request.Context())
+	if errors.Is(err, raft.ErrNotReady) {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "ok", "cluster": status, "lag": status.Lag()})
+}
@@ -0,0 +1,5 @@
+package cluster
+
+type RequestReady struct {
+	MaxLag *uint64 `form:"max_lag"` // 套用進度最多可落後 commit 的筆數，未指定時使用 configs.ReadyMaxLag
+}
@@ -0,0 +1,68 @@
+package currency
+
+import (
+	"errors"
+	"go-raft/internal/adapters/http/proposal"
+	"go-raft/internal/domain"
+	"go-raft/internal/store"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lni/dragonboat/v4"
+)
+
+type Handler struct {
+	nh        *dragonboat.NodeHost
+	clusterID uint64
+}
+
+func NewHanlder(nh *dragonboat.NodeHost, clusterID uint64) *Handler {
+	return &Handler{nh: nh, clusterID: clusterID}
+}
+
+// PutCurrency 新增或更新幣別註冊資料，經由 raft 複製到所有節點
+func (h *Handler) PutCurrency(c *gin.Context) {
+	var req RequestPut
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	currency := domain.Currency(req)
+	if err := currency.Validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	cmd := domain.Command{
+		Type:     domain.CommandCurrency,
+		Currency: &currency,
+	}
+	code, err := proposal.Propose(c.Request.Context(), h.nh, h.clusterID, cmd)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if code != domain.ResultOK {
+		c.JSON(proposal.Status(code), gin.H{"error": code.String(), "code": code})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "currency updated", "currency": currency})
+}
+
+// GetCurrencies 查詢幣別註冊資料，帶 code 時只回傳單一幣別
+func (h *Handler) GetCurrencies(c *gin.Context) {
+	query := domain.CurrencyQuery{Code: c.Query("code")}
+	result, err := h.nh.SyncRead(c.Request.Context(), h.clusterID, query)
+	if errors.Is(err, store.ErrUnknownCurrency) {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "raft read failed: " + err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": result})
+}
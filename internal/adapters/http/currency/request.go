@@ -0,0 +1,9 @@
+package currency
+
+type RequestPut struct {
+	Code      string  `json:"code" binding:"required"`
+	Precision int     `json:"precision" binding:"min=0"`
+	Enabled   bool    `json:"enabled"`
+	MinAmount float64 `json:"minAmount" binding:"min=0"`
+	MaxAmount float64 `json:"maxAmount" binding:"min=0"`
+}
@@ -0,0 +1,5 @@
+package ledger
+
+type RequestExport struct {
+	MinApplied uint64 `form:"min_applied"` // 下限：備份至少包含到此 raft index，實際匯出的 index 可能更大，0 代表不限制
+}
@@ -0,0 +1,94 @@
+package limit
+
+import (
+	"go-raft/internal/adapters/http/proposal"
+	"go-raft/internal/domain"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lni/dragonboat/v4"
+)
+
+type Handler struct {
+	nh        *dragonboat.NodeHost
+	clusterID uint64
+}
+
+func NewHanlder(nh *dragonboat.NodeHost, clusterID uint64) *Handler {
+	return &Handler{nh: nh, clusterID: clusterID}
+}
+
+// SetLimit 設定幣別、帳戶等級或使用者的限額政策
+func (h *Handler) SetLimit(c *gin.Context) {
+	var req RequestSet
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	rule := domain.LimitRule{
+		Scope:    domain.LimitScope(req.Scope),
+		Currency: req.Currency,
+		Tier:     req.Tier,
+		UID:      req.UID,
+		Policy: domain.LimitPolicy{
+			MaxSingleAmount:  req.MaxSingleAmount,
+			DailyWithdrawCap: req.DailyWithdrawCap,
+		},
+	}
+	h.propose(c, &domain.LimitCommand{Rule: rule})
+}
+
+// RemoveLimit 移除限額政策
+func (h *Handler) RemoveLimit(c *gin.Context) {
+	var req RequestRemove
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	rule := domain.LimitRule{
+		Scope:    domain.LimitScope(req.Scope),
+		Currency: req.Currency,
+		Tier:     req.Tier,
+		UID:      req.UID,
+	}
+	h.propose(c, &domain.LimitCommand{Rule: rule, Remove: true})
+}
+
+// GetLimits 列出所有限額政策，帶 uid 與 currency 時回傳該使用者生效的限額與目前用量
+func (h *Handler) GetLimits(c *gin.Context) {
+	query := domain.LimitQuery{UID: c.Query("uid"), Currency: c.Query("currency")}
+	if query.UID != "" && query.Currency == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "currency required with uid"})
+		return
+	}
+
+	result, err := h.nh.SyncRead(c.Request.Context(), h.clusterID, query)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "raft read failed: " + err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": result})
+}
+
+func (h *Handler) propose(c *gin.Context, limit *domain.LimitCommand) {
+	if !limit.Rule.Valid() {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "scope requires matching tier or uid"})
+		return
+	}
+	cmd := domain.Command{
+		Type:  domain.CommandLimit,
+		Limit: limit,
+	}
+	code, err := proposal.Propose(c.Request.Context(), h.nh, h.clusterID, cmd)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if code != domain.ResultOK {
+		c.JSON(proposal.Status(code), gin.H{"error": code.String(), "code": code})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "limit updated", "rule": limit.Rule})
+}
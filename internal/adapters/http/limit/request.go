@@ -0,0 +1,17 @@
+package limit
+
+type RequestSet struct {
+	Scope            string  `json:"scope" binding:"required,oneof=currency tier user"`
+	Currency         string  `json:"currency" binding:"required"`
+	Tier             string  `json:"tier"`
+	UID              string  `json:"uid"`
+	MaxSingleAmount  float64 `json:"maxSingleAmount" binding:"min=0"`
+	DailyWithdrawCap float64 `json:"dailyWithdrawCap" binding:"min=0"`
+}
+
+type RequestRemove struct {
+	Scope    string `json:"scope" binding:"required,oneof=currency tier user"`
+	Currency string `json:"currency" binding:"required"`
+	Tier     string `json:"tier"`
+	UID      string `json:"uid"`
+}
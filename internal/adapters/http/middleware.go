@@ -2,30 +2,97 @@ package http
 
 import (
 	"context"
-	"os"
+	"fmt"
+	"go-raft/internal/logging"
+	"go-raft/internal/tracing"
+	"net/http"
+	"regexp"
+	"strconv"
 	"time"
 
+	"github.com/VictoriaMetrics/metrics"
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 )
 
 const TraceIDKey = "X-Trace-ID"
 
+// traceIDPattern 上游傳入的 trace ID 只允許英數與 . _ -，最長 128 字元，不符合時改用新產生的 ID
+// 避免換行或控制字元寫入 log 與 response header
+var traceIDPattern = regexp.MustCompile(`^[A-Za-z0-9._-]{1,128}$`)
+
+// NewTraceID 沿用上游傳入的 X-Trace-ID，沒有或格式不符時產生新的 ID，並放入請求的 context 供 log 與提案使用
 func NewTraceID() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		traceID := uuid.New().String()
+		traceID := c.GetHeader(TraceIDKey)
+		if !traceIDPattern.MatchString(traceID) {
+			traceID = uuid.New().String()
+		}
 		c.Set(TraceIDKey, traceID)
 		c.Writer.Header().Set(TraceIDKey, traceID)
+		c.Request = c.Request.WithContext(logging.WithTraceID(c.Request.Context(), traceID))
+		c.Next()
+	}
+}
+
+// NewTracing 為每個請求建立 server span，沿用上游的 traceparent，span 名稱使用路由樣板
+// 需放在 NewTraceID 之後，span 帶有 X-Trace-ID 以便與 log 對應
+func NewTracing() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		route := c.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+		ctx := tracing.ExtractHTTP(c.Request.Context(), c.Request.Header)
+		ctx, span := tracing.Tracer().Start(ctx, c.Request.Method+" "+route,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				attribute.String("http.request.method", c.Request.Method),
+				attribute.String("http.route", route),
+				attribute.String("app.trace_id", logging.TraceID(ctx)),
+			))
+		defer span.End()
+		c.Request = c.Request.WithContext(ctx)
 		c.Next()
+		status := c.Writer.Status()
+		span.SetAttributes(attribute.Int("http.response.status_code", status))
+		if status >= http.StatusInternalServerError {
+			span.SetStatus(codes.Error, http.StatusText(status))
+		}
 	}
 }
 
-func NewLogger() *os.File {
-	logFile, err := os.OpenFile("server.log", os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666)
-	if err != nil {
-		panic("Failed to open log file: " + err.Error())
+// quietPaths 為探測與指標抓取的路徑，頻繁且無業務意義，成功時只在 Debug 等級記錄
+var quietPaths = map[string]bool{"/healthz": true, "/readyz": true, "/metrics": true}
+
+// NewRequestLog 以結構化 log 記錄每個請求，需放在 NewTraceID 之後才會帶有 trace ID
+func NewRequestLog() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+		entry := logging.FromContext(c.Request.Context()).WithFields(logrus.Fields{
+			"method":  c.Request.Method,
+			"path":    c.Request.URL.Path,
+			"status":  c.Writer.Status(),
+			"latency": time.Since(start),
+			"client":  c.ClientIP(),
+		})
+		if len(c.Errors) > 0 {
+			entry = entry.WithField("error", c.Errors.String())
+		}
+		switch {
+		case c.Writer.Status() >= http.StatusInternalServerError:
+			entry.Warn("request")
+		case quietPaths[c.Request.URL.Path] && c.Writer.Status() < http.StatusBadRequest:
+			entry.Debug("request")
+		default:
+			entry.Info("request")
+		}
 	}
-	return logFile
 }
 
 func NewRequestTimeout(timeout time.Duration) gin.HandlerFunc {
@@ -36,3 +103,33 @@ func NewRequestTimeout(timeout time.Duration) gin.HandlerFunc {
 		c.Next()
 	}
 }
+
+// metricMethods 為指標中保留原名的 HTTP method，其他 method 合併為 other，避免任意 method 產生無限多的時間序列
+var metricMethods = map[string]bool{
+	http.MethodGet: true, http.MethodHead: true, http.MethodPost: true, http.MethodPut: true, http.MethodPatch: true,
+	http.MethodDelete: true, http.MethodConnect: true, http.MethodOptions: true, http.MethodTrace: true,
+}
+
+// NewMetrics 以路由樣板（而非實際路徑）紀錄每個請求的延遲，未匹配的路徑合併為 unmatched，非標準的 method 合併為 other
+func NewMetrics() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+		route := c.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+		method := c.Request.Method
+		if !metricMethods[method] {
+			method = "other"
+		}
+		metrics.GetOrCreatePrometheusHistogram(fmt.Sprintf(`http_request_duration_seconds{method=%q,route=%q,code="%s"}`,
+			method, route, strconv.Itoa(c.Writer.Status()))).UpdateDuration(start)
+	}
+}
+
+// Metrics 以 Prometheus 文字格式輸出所有指標，包含 dragonboat 的 raft 指標與行程指標
+func Metrics(c *gin.Context) {
+	c.Header("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	metrics.WritePrometheus(c.Writer, true)
+}
@@ -0,0 +1,39 @@
+package proposal
+
+import (
+	"context"
+	"go-raft/internal/domain"
+	"go-raft/internal/raft"
+	"net/http"
+
+	"github.com/lni/dragonboat/v4"
+)
+
+// Propose 為命令蓋上提交時間後同步提案，回傳狀態機套用後的結果碼
+// 時間由提案端指定並寫入 entry，狀態機會確保其單調遞增
+func Propose(ctx context.Context, nh *dragonboat.NodeHost, clusterID uint64, cmd domain.Command) (domain.ResultCode, error) {
+	return raft.Propose(ctx, nh, clusterID, cmd)
+}
+
+// Status 將狀態機結果碼轉成對應的 HTTP 狀態碼
+func Status(code domain.ResultCode) int {
+	switch code {
+	case domain.ResultOK:
+		return http.StatusOK
+	case domain.ResultUnknownCurrency, domain.ResultInvalidAmount, domain.ResultInvalidCurrency,
+		domain.ResultInvalidCommand, domain.ResultInvalidLimit, domain.ResultUnsupportedVersion:
+		return http.StatusUnprocessableEntity
+	case domain.ResultLimitExceeded:
+		return http.StatusTooManyRequests
+	case domain.ResultCurrencyDisabled, domain.ResultAccountFrozen, domain.ResultAccountClosed:
+		return http.StatusForbidden
+	case domain.ResultAccountNotFound:
+		return http.StatusNotFound
+	case domain.ResultAccountExists, domain.ResultAccountNotEmpty, domain.ResultInvalidTransition,
+		domain.ResultDowngradeBlocked, domain.ResultUpgradeNotReady:
+		return http.StatusConflict
+	case domain.ResultChecksumExpired:
+		return http.StatusGone
+	}
+	return http.StatusInternalServerError
+}
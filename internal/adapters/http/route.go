@@ -2,63 +2,141 @@ package http
 
 import (
 	"fmt"
+	"go-raft/internal/adapters/http/account"
 	"go-raft/internal/adapters/http/asset"
+	"go-raft/internal/adapters/http/checksum"
+	"go-raft/internal/adapters/http/cluster"
+	"go-raft/internal/adapters/http/currency"
+	"go-raft/internal/adapters/http/ledger"
+	"go-raft/internal/adapters/http/limit"
 	"go-raft/internal/adapters/http/snapshot"
-	"log"
-	"time"
+	"go-raft/internal/adapters/http/upgrade"
+	"go-raft/internal/configs"
 
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
 )
 
 type HttpServer struct {
 	Addr            []string
 	assethandler    *asset.Handler
 	snapshothandler *snapshot.Handler
+	currencyhandler *currency.Handler
+	accounthandler  *account.Handler
+	limithandler    *limit.Handler
+	upgradehandler  *upgrade.Handler
+	ledgerhandler   *ledger.Handler
+	checksumhandler *checksum.Handler
+	clusterhandler  *cluster.Handler
 }
 
-func New(addr []string, assethandler *asset.Handler, snapshothandler *snapshot.Handler) *HttpServer {
+func New(
+	addr []string,
+	assethandler *asset.Handler,
+	snapshothandler *snapshot.Handler,
+	currencyhandler *currency.Handler,
+	accounthandler *account.Handler,
+	limithandler *limit.Handler,
+	upgradehandler *upgrade.Handler,
+	ledgerhandler *ledger.Handler,
+	checksumhandler *checksum.Handler,
+	clusterhandler *cluster.Handler,
+) *HttpServer {
 	return &HttpServer{
 		Addr:            addr,
 		assethandler:    assethandler,
 		snapshothandler: snapshothandler,
+		currencyhandler: currencyhandler,
+		accounthandler:  accounthandler,
+		limithandler:    limithandler,
+		upgradehandler:  upgradehandler,
+		ledgerhandler:   ledgerhandler,
+		checksumhandler: checksumhandler,
+		clusterhandler:  clusterhandler,
 	}
 }
 
 func (hs *HttpServer) Start() error {
-	r := gin.Default()
+	// 不使用 gin.Default 的 Logger，請求 log 由 NewRequestLog 以 logrus 輸出
+	r := gin.New()
+	r.Use(gin.Recovery())
 
 	// CORS middleware (放在最前面)
 	r.Use(cors.New(cors.Config{
 		AllowOrigins:     []string{"*"}, // 本地測試用，正式環境要限定
 		AllowMethods:     []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
-		AllowHeaders:     []string{"Origin", "Content-Type", "Accept", "Authorization", "X-CSRF-Token"},
+		AllowHeaders:     []string{"Origin", "Content-Type", "Accept", "Authorization", "X-CSRF-Token", TraceIDKey, "traceparent", "tracestate"},
+		ExposeHeaders:    []string{TraceIDKey},
 		AllowCredentials: true,
 	}))
 
 	// 設置靜態文件服務
 	r.Static("/static", "./static")
 
-	// 設置日誌格式
-	gin.DefaultWriter = NewLogger()
-
-	// 設置全局中間件
-	r.Use(NewRequestTimeout(5 * time.Second))
+	// 設置全局中間件，逾時依路由分組設定
 	r.Use(NewTraceID()) // 添加Trace ID中間件
+	r.Use(NewRequestLog())
+	r.Use(NewTracing())
+	r.Use(NewMetrics())
+
+	// 建立 snapshot 與匯出封存檔的時間隨帳本大小增加，使用較長的逾時
+	admin := r.Group("", NewRequestTimeout(configs.AdminRequestTimeout))
+	api := r.Group("", NewRequestTimeout(configs.RequestTimeout))
 
 	// Asset相關路由
-	r.POST("/asset/add", hs.assethandler.AddAsset)
-	r.GET("/asset/balance", hs.assethandler.GetBalance)
-	r.GET("/asset/balances", hs.assethandler.GetBalances)
+	api.POST("/asset/add", hs.assethandler.AddAsset)
+	api.GET("/asset/balance", hs.assethandler.GetBalance)
+	api.GET("/asset/balances", hs.assethandler.GetBalances)
+	api.GET("/asset/stats", hs.assethandler.GetStats)
 
-	// 切換 snapshot 版本號，滾動更新用
-	r.GET("/snapshot/version", hs.snapshothandler.GetSnapshotVersion)
-	r.POST("/snapshot/version", hs.snapshothandler.SetSnapshotVersion)
+	// 幣別註冊表
+	api.GET("/currency", hs.currencyhandler.GetCurrencies)
+	api.POST("/currency", hs.currencyhandler.PutCurrency)
 
-	// 啟動HTTP服務器
-	r.Use(gin.Recovery())
-	r.Use(gin.Logger())
+	// 帳戶生命週期，凍結/解凍/關閉供合規管理使用
+	api.GET("/account", hs.accounthandler.GetAccount)
+	api.POST("/account/open", hs.accounthandler.OpenAccount)
+	api.POST("/account/freeze", hs.accounthandler.FreezeAccount)
+	api.POST("/account/unfreeze", hs.accounthandler.UnfreezeAccount)
+	api.POST("/account/close", hs.accounthandler.CloseAccount)
+	api.POST("/account/tier", hs.accounthandler.SetTier)
+
+	// 限額政策（幣別 / 帳戶等級 / 使用者覆寫）
+	api.GET("/limit", hs.limithandler.GetLimits)
+	api.POST("/limit", hs.limithandler.SetLimit)
+	api.POST("/limit/remove", hs.limithandler.RemoveLimit)
+
+	// 手動建立（可匯出、壓縮 log）與列出本節點的 snapshot
+	api.GET("/snapshot", hs.snapshothandler.ListSnapshots)
+	admin.POST("/snapshot", hs.snapshothandler.RequestSnapshot)
 
+	// 目前生效的 snapshot 格式版本，切換改經 /admin/upgrade 啟用
+	api.GET("/snapshot/version", hs.snapshothandler.GetSnapshotVersion)
+
+	// 滾動升級狀態與格式啟用，所有成員回報支援後才可啟用，啟用後不可降級
+	api.GET("/admin/upgrade", hs.upgradehandler.GetUpgrade)
+	api.POST("/admin/upgrade", hs.upgradehandler.Activate)
+	// 加入 shard 成員須經此處先登記，避免啟用時讀到的成員清單遺漏剛加入、尚未回報的節點
+	api.POST("/admin/upgrade/members", hs.upgradehandler.AddMember)
+
+	// 匯出完整帳本封存檔，供災難復原時以 snapctl restore 建立新的 shard
+	admin.GET("/admin/ledger/export", hs.ledgerhandler.Export)
+
+	// 各副本狀態摘要比對，結果不一致時以 snapctl checksum 找出不同的 key
+	api.GET("/admin/checksum", hs.checksumhandler.GetChecksums)
+	api.POST("/admin/checksum", hs.checksumhandler.RequestChecksum)
+	api.GET("/admin/checksum/balances", hs.checksumhandler.GetBalances)
+
+	// Kubernetes liveness / readiness probe 與叢集狀態
+	api.GET("/healthz", hs.clusterhandler.Healthz)
+	api.GET("/readyz", hs.clusterhandler.Readyz)
+	api.GET("/cluster/status", hs.clusterhandler.GetStatus)
+
+	// Prometheus 指標
+	api.GET("/metrics", Metrics)
+
+	// 啟動HTTP服務器
 	if len(hs.Addr) == 0 {
 		return fmt.Errorf("no address provided")
 	}
@@ -66,7 +144,6 @@ func (hs *HttpServer) Start() error {
 		return fmt.Errorf("not support multiple address")
 	}
 
-	r.Run(hs.Addr[0])
-	log.Printf("HTTP server started on %s", hs.Addr)
-	return nil
+	logrus.WithFields(logrus.Fields{"addr": hs.Addr[0]}).Info("HTTP server starting")
+	return r.Run(hs.Addr[0])
 }
@@ -1,30 +1,83 @@
 package snapshot
 
 import (
+	"errors"
+	"fmt"
 	"go-raft/internal/configs"
+	"go-raft/internal/domain"
+	"go-raft/internal/raft"
+	"io"
 	"net/http"
+	"path/filepath"
 
 	"github.com/gin-gonic/gin"
+	"github.com/lni/dragonboat/v4"
 )
 
 type Handler struct {
+	nh        *dragonboat.NodeHost
+	clusterID uint64
+	snapshots *raft.SnapshotController
 }
 
-func NewHanlder() *Handler {
-	return &Handler{}
+func NewHanlder(nh *dragonboat.NodeHost, clusterID uint64) *Handler {
+	return &Handler{
+		nh:        nh,
+		clusterID: clusterID,
+		snapshots: raft.NewSnapshotController(nh, clusterID),
+	}
 }
 
-func (h *Handler) SetSnapshotVersion(c *gin.Context) {
-	var req RequestSetSnapshot
-	if err := c.ShouldBindJSON(&req); err != nil {
+// RequestSnapshot 立即建立 snapshot，可選擇匯出到 configs.SnapshotExportDir 下的目錄，並強制壓縮 log
+// 沒有 request body 時以預設選項建立
+func (h *Handler) RequestSnapshot(c *gin.Context) {
+	var req RequestSnapshot
+	if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
+	opt := raft.SnapshotRequest{Compact: req.Compact}
+	if req.Export != "" {
+		if !filepath.IsLocal(req.Export) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("export must be a relative path, got %q", req.Export)})
+			return
+		}
+		opt.ExportPath = filepath.Join(configs.SnapshotExportDir, req.Export)
+	}
 
-	configs.SetSnapshotVersion(req.NodeID, req.ShardID, req.Version)
-	c.JSON(http.StatusOK, gin.H{"status": "ok", "versionInfo": req})
+	result, err := h.snapshots.Request(c.Request.Context(), opt)
+	if errors.Is(err, raft.ErrSnapshotUpToDate) {
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "ok", "snapshot": result})
+}
+
+// ListSnapshots 列出本節點磁碟上的 snapshot 與其 index、term、大小與格式版本
+func (h *Handler) ListSnapshots(c *gin.Context) {
+	infos, err := h.snapshots.List()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "ok", "snapshots": infos})
 }
 
 func (h *Handler) GetSnapshotVersion(c *gin.Context) {
-	c.JSON(http.StatusOK, gin.H{"status": "ok", "version": configs.GetSnapshotVersions()})
+	result, err := h.nh.SyncRead(c.Request.Context(), h.clusterID, domain.SettingsQuery{})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "raft read failed: " + err.Error()})
+		return
+	}
+	settings, ok := result.(domain.Settings)
+	if !ok {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "invalid data format from raft"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"status": "ok", "version": settings.SnapshotVersion})
 }
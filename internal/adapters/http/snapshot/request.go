@@ -1,7 +1,6 @@
 package snapshot
 
-type RequestSetSnapshot struct {
-	Version uint64 `json:"version" binding:"required"`
-	NodeID  uint64 `json:"nodeID" binding:"required"`
-	ShardID uint64 `json:"ShardID" binding:"required"`
+type RequestSnapshot struct {
+	Export  string `json:"export"`  // 匯出目錄，相對於 configs.SnapshotExportDir，空白代表不匯出
+	Compact bool   `json:"compact"` // 建立後壓縮到 snapshot index 為止的 log
 }
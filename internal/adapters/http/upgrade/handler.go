@@ -0,0 +1,67 @@
+package upgrade
+
+import (
+	"go-raft/internal/adapters/http/proposal"
+	"go-raft/internal/domain"
+	"go-raft/internal/raft"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lni/dragonboat/v4"
+)
+
+type Handler struct {
+	controller *raft.UpgradeController
+}
+
+func NewHanlder(nh *dragonboat.NodeHost, clusterID uint64) *Handler {
+	return &Handler{controller: raft.NewUpgradeController(nh, clusterID)}
+}
+
+// GetUpgrade 回傳已啟用版本、各節點回報的支援版本與目前可啟用的版本
+func (h *Handler) GetUpgrade(c *gin.Context) {
+	status, err := h.controller.Status(c.Request.Context())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "ok", "upgrade": status})
+}
+
+// Activate 在所有成員都回報支援後啟用格式版本，啟用後不可降級
+func (h *Handler) Activate(c *gin.Context) {
+	var req RequestActivate
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	code, err := h.controller.Activate(c.Request.Context(), domain.Feature(req.Feature), req.Version)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if code != domain.ResultOK {
+		c.JSON(proposal.Status(code), gin.H{"error": code.String(), "code": code})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "ok", "activated": req})
+}
+
+// AddMember 先於狀態機登記節點再加入 shard，新節點回報支援版本前不會啟用新格式
+func (h *Handler) AddMember(c *gin.Context) {
+	var req RequestAddMember
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	code, err := h.controller.AddMember(c.Request.Context(), req.NodeID, req.Address, req.NonVoting)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if code != domain.ResultOK {
+		c.JSON(proposal.Status(code), gin.H{"error": code.String(), "code": code})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "ok", "member": req})
+}
@@ -0,0 +1,12 @@
+package upgrade
+
+type RequestActivate struct {
+	Feature string `json:"feature" binding:"required,oneof=snapshot command"`
+	Version uint64 `json:"version" binding:"required"`
+}
+
+type RequestAddMember struct {
+	NodeID    uint64 `json:"nodeID" binding:"required"`
+	Address   string `json:"address" binding:"required"` // 新節點的 Raft 傳輸位址 (host:port)
+	NonVoting bool   `json:"nonVoting"`
+}
@@ -0,0 +1,43 @@
+// Package blobstore 定義備份上傳使用的物件儲存介面，提供本機檔案系統與 S3 相容服務兩種實作
+package blobstore
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"time"
+)
+
+// ErrNotFound 物件不存在
+var ErrNotFound = errors.New("blob not found")
+
+// Blob 為 List 回傳的物件資訊
+type Blob struct {
+	Key      string
+	Size     int64
+	Modified time.Time
+}
+
+// BlobStore 以 "/" 分隔的 key 存取物件，Put 完成後物件才會出現在 List 結果中
+type BlobStore interface {
+	Put(ctx context.Context, key string, r io.Reader, size int64) error
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	// List 依 key 排序回傳所有以 prefix 開頭的物件
+	List(ctx context.Context, prefix string) ([]Blob, error)
+	// Delete 刪除物件，物件不存在時不視為錯誤
+	Delete(ctx context.Context, key string) error
+}
+
+// validKey 拒絕空白、絕對路徑與含有 "." 或 ".." 區段的 key，避免寫出儲存根目錄之外
+func validKey(key string) bool {
+	if key == "" || strings.HasPrefix(key, "/") || strings.HasSuffix(key, "/") {
+		return false
+	}
+	for _, part := range strings.Split(key, "/") {
+		if part == "" || part == "." || part == ".." {
+			return false
+		}
+	}
+	return true
+}
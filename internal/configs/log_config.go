@@ -0,0 +1,8 @@
+package configs
+
+const (
+	// LogLevel 預設的 log 等級，可由環境變數 LOG_LEVEL 覆寫（debug、info、warn、error）
+	LogLevel = "info"
+	// LogFormat 預設的 log 格式，可由環境變數 LOG_FORMAT 覆寫（text、json）
+	LogFormat = "text"
+)
@@ -1,9 +1,61 @@
 package configs
 
+import "time"
+
 const (
 	// public
 	FileDir = "raft-snapshots"
 
+	// HistoryRetention 歷史餘額保留的 raft index 數量，超過的版本會被清除
+	HistoryRetention = 100000
+
+	// OnDiskStateMachine 為 true 時帳本存放在 Pebble（FileDir/sm-pebble），不受記憶體大小限制
+	// 已有資料的 shard 切換時需從 snapshot 或封存檔重新建立，兩種狀態機的本機資料不互通
+	OnDiskStateMachine = false
+
+	// 預設的 snapshot 與 log 壓縮策略，個別 shard 可由 raft.NodeConfig.SnapshotPolicy 覆寫
+	// SnapshotEntries 每套用幾筆 entry 建立一次 snapshot
+	SnapshotEntries = 10000
+	// CompactionOverhead 建立 snapshot 後保留的 log 筆數，落後不多的 follower 可直接補 log 而不需傳送 snapshot
+	CompactionOverhead = 1000
+	// SnapshotInterval 距上次 snapshot 超過此時間且有新的 entry 時建立 snapshot
+	SnapshotInterval = 10 * time.Minute
+	// SnapshotLogSize 自上次 snapshot 後套用的 entry 總大小超過此值（bytes）時建立 snapshot
+	SnapshotLogSize = 64 << 20
+	// DeltaSnapshotChain v4 snapshot 在一個 base 之後最多延續的 delta 數，超過或 delta 累計紀錄數多於 base 時重新寫出 base
+	DeltaSnapshotChain = 16
+
+	// SnapshotExportDir 手動匯出 snapshot 的根目錄，POST /snapshot 的 export 相對於此目錄
+	SnapshotExportDir = "raft-exports"
+
+	// BackupDir 未設定 S3 時，定期備份上傳的本機目錄
+	BackupDir = "raft-backups"
+	// BackupInterval 定期備份的間隔
+	BackupInterval = time.Hour
+	// BackupKeep 保留最近幾份完整備份
+	BackupKeep = 24
+
+	// ChecksumInterval leader 要求所有副本計算狀態摘要的間隔
+	ChecksumInterval = 10 * time.Minute
+	// ChecksumRounds 狀態機保留最近幾次 checksum 的結果
+	ChecksumRounds = 16
+
+	// StoreMetricsInterval 更新各幣別帳戶數與總額指標的間隔
+	StoreMetricsInterval = 30 * time.Second
+
+	// MaxClockSkew 非 leader 提案的時間最多超前最後一個 leader 提案的時間多少，超過時以此為上限
+	MaxClockSkew = 2 * time.Second
+	// ClockTick 有非 leader 提案套用且 leader 的時間落後超過此間隔時，leader 寫入目前時間
+	ClockTick = time.Second
+
+	// RequestTimeout 一般 HTTP 請求的逾時
+	RequestTimeout = 5 * time.Second
+	// AdminRequestTimeout 手動 snapshot（含匯出與等待 log 壓縮）與帳本封存匯出的逾時，這些請求的時間隨帳本大小增加
+	AdminRequestTimeout = 10 * time.Minute
+
+	// ReadyMaxLag 已 commit 但尚未套用的 entry 超過此筆數時 /readyz 回報未就緒
+	ReadyMaxLag = 1000
+
 	// private
 	ClusterID   = 99
 	NodeID      = 1
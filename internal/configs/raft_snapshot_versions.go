@@ -1,27 +1,20 @@
 package configs
 
-import "fmt"
+import "slices"
 
-var Versions = map[string]uint64{}
+// DefaultSnapshotVersion 尚未透過 raft 命令設定時使用的 snapshot 格式版本
+const DefaultSnapshotVersion uint64 = 1
 
-func getKey(nodeID, shardID uint64) string {
-	return fmt.Sprintf("%d_%d", nodeID, shardID)
-}
+// DefaultCommandVersion 尚未透過升級流程啟用時的命令版本
+const DefaultCommandVersion uint64 = 1
 
-func GetSnapshotVersions() map[string]uint64 {
-	return Versions
-}
+// SupportedSnapshotVersions 本版程式可寫入與讀取的 snapshot 格式版本
+var SupportedSnapshotVersions = []uint64{1, 2, 3, 4}
 
-func GetSnapshotVersion(nodeID, shardID uint64) uint64 {
-	key := getKey(nodeID, shardID)
-	if v, ok := Versions[key]; ok {
-		return v
-	}
-	return 1 // 預設為 snapshot version 1
-}
+// SupportedCommandVersions 本版程式可套用的命令版本
+var SupportedCommandVersions = []uint64{1}
 
-func SetSnapshotVersion(nodeID, shardID, version uint64) uint64 {
-	key := getKey(nodeID, shardID)
-	Versions[key] = version
-	return version
+// IsSupportedSnapshotVersion 判斷本版程式是否支援指定的 snapshot 格式版本
+func IsSupportedSnapshotVersion(version uint64) bool {
+	return slices.Contains(SupportedSnapshotVersions, version)
 }
@@ -0,0 +1,53 @@
+package domain
+
+// AccountStatus 為帳戶狀態
+type AccountStatus string
+
+const (
+	AccountActive AccountStatus = "active" // 可正常存取
+	AccountFrozen AccountStatus = "frozen" // 凍結，拒絕所有寫入，可解凍
+	AccountClosed AccountStatus = "closed" // 已關閉，拒絕所有寫入且不可重新開啟
+)
+
+// Account 為複製於狀態機中的帳戶資料
+type Account struct {
+	UID          string            `json:"uid"`
+	Status       AccountStatus     `json:"status"`
+	Tier         string            `json:"tier,omitempty"` // 帳戶等級，決定套用的限額政策
+	CreatedIndex uint64            `json:"createdIndex"`   // 開戶時的 raft index
+	CreatedAt    int64             `json:"createdAt"`      // 開戶時的提交時間（Unix 奈秒）
+	UpdatedIndex uint64            `json:"updatedIndex"`   // 最後一次變更狀態的 raft index
+	Reason       string            `json:"reason,omitempty"`
+	Metadata     map[string]string `json:"metadata,omitempty"`
+}
+
+// AccountAction 為帳戶生命週期操作
+type AccountAction string
+
+const (
+	AccountOpen     AccountAction = "open"
+	AccountFreeze   AccountAction = "freeze"
+	AccountUnfreeze AccountAction = "unfreeze"
+	AccountClose    AccountAction = "close"
+	AccountSetTier  AccountAction = "set_tier"
+)
+
+// AccountCommand 為帳戶生命週期命令
+type AccountCommand struct {
+	UID      string
+	Action   AccountAction
+	Reason   string            // 凍結或關閉的原因，供稽核使用
+	Tier     string            // 開戶或 set_tier 時指定的帳戶等級
+	Metadata map[string]string // 開戶時寫入的附加資料
+}
+
+// AccountQuery 查詢單一帳戶
+type AccountQuery struct {
+	UID string
+}
+
+// AccountView 為帳戶資料與其各幣別餘額
+type AccountView struct {
+	Account
+	Balances map[string]float64 `json:"balances"`
+}
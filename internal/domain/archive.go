@@ -0,0 +1,8 @@
+package domain
+
+// ExportQuery 匯出完整帳本，取得單一 raft index 的一致狀態
+// MinApplied 為下限而非指定的 index：非零時本節點必須已套用到該 index，確保備份包含先前已提交的寫入，
+// 匯出的是讀取當下最後套用的 index，可能大於 MinApplied；只有餘額保留歷史版本，無法還原其他狀態在較早 index 的內容
+type ExportQuery struct {
+	MinApplied uint64
+}
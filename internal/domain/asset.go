@@ -5,3 +5,12 @@ type Asset struct {
 	Currency string
 	Amount   float64
 }
+
+// BalanceQuery 查詢使用者幣別的歷史餘額
+// AsOfTime 非零時以提交時間（Unix 奈秒）查詢，否則查詢 AsOfIndex 套用後的餘額
+type BalanceQuery struct {
+	UID       string
+	Currency  string
+	AsOfIndex uint64
+	AsOfTime  int64
+}
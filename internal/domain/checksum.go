@@ -0,0 +1,62 @@
+package domain
+
+import "slices"
+
+// ChecksumReport 為節點在 checksum 命令的 raft index 套用後計算的狀態摘要
+// Sections 為各區段的 sha256，餘額依幣別分為 balance/<currency>，其餘為 accounts、currencies、limits、settings
+type ChecksumReport struct {
+	NodeID   uint64            `json:"nodeID"`
+	Index    uint64            `json:"index"`
+	Digest   string            `json:"digest"` // 所有區段依名稱排序後的 sha256
+	Sections map[string]string `json:"sections"`
+}
+
+// ChecksumRound 為一次 checksum 命令與各節點回報的結果，複製於狀態機中
+type ChecksumRound struct {
+	Index    uint64           `json:"index"`
+	Reports  []ChecksumReport `json:"reports"` // 依節點 ID 排序
+	Diverged bool             `json:"diverged"`
+}
+
+// ChecksumQuery 查詢最近的 checksum 結果
+type ChecksumQuery struct{}
+
+// ChecksumBalancesQuery 查詢幣別在指定 raft index 的所有非零餘額，用於找出不一致的 key
+type ChecksumBalancesQuery struct {
+	Currency string
+	Index    uint64
+}
+
+// ChecksumStatus 為 checksum 監控依目前成員計算的狀態
+type ChecksumStatus struct {
+	Rounds  []ChecksumRound `json:"rounds"`
+	Members []uint64        `json:"members"`
+	// Diverged 為最近一次有節點結果不一致的 index，0 代表保留的結果中沒有不一致
+	Diverged uint64 `json:"diverged"`
+}
+
+// Sections 回傳 round 中結果與其他節點不同的區段名稱，依名稱排序
+func (r ChecksumRound) Sections() []string {
+	var diff []string
+	if len(r.Reports) == 0 {
+		return diff
+	}
+	names := make(map[string]struct{})
+	for _, report := range r.Reports {
+		for name := range report.Sections {
+			names[name] = struct{}{}
+		}
+	}
+	for name := range names {
+		first, ok := r.Reports[0].Sections[name]
+		for _, report := range r.Reports[1:] {
+			digest, has := report.Sections[name]
+			if has != ok || digest != first {
+				diff = append(diff, name)
+				break
+			}
+		}
+	}
+	slices.Sort(diff)
+	return diff
+}
@@ -0,0 +1,44 @@
+package domain
+
+// AppliedQuery 查詢本節點狀態機最後套用的 raft index
+type AppliedQuery struct{}
+
+// ClockQuery 查詢本節點狀態機的提交時間
+type ClockQuery struct{}
+
+// Clock 為狀態機最後套用的 index 與提交時間
+type Clock struct {
+	Applied uint64
+	Now     int64 // 最後套用 entry 的提交時間
+	Leader  int64 // leader 提案的最大時間，非 leader 提案超前的時間不計入
+}
+
+// 節點在 shard 中的角色
+const (
+	RoleLeader    = "leader"
+	RoleFollower  = "follower"
+	RoleNonVoting = "non-voting"
+	RoleWitness   = "witness"
+)
+
+// ClusterStatus 為本節點看到的 shard 狀態
+// Committed 為本節點持久化的 commit index，Applied 落後 Committed 的筆數即為尚未套用的 entry
+type ClusterStatus struct {
+	ShardID   uint64            `json:"shardId"`
+	NodeID    uint64            `json:"nodeId"`
+	Role      string            `json:"role"`
+	LeaderID  uint64            `json:"leaderId"` // 0 代表目前不知道 leader
+	Term      uint64            `json:"term"`
+	Members   map[uint64]string `json:"members"`
+	Committed uint64            `json:"committed"`
+	Applied   uint64            `json:"applied"`
+	Snapshot  uint64            `json:"snapshot"` // 最近一次 snapshot 的 index
+}
+
+// Lag 回傳已 commit 但尚未套用的 entry 筆數
+func (s ClusterStatus) Lag() uint64 {
+	if s.Committed <= s.Applied {
+		return 0
+	}
+	return s.Committed - s.Applied
+}
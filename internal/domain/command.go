@@ -0,0 +1,135 @@
+package domain
+
+import (
+	"bytes"
+	"encoding/gob"
+	"fmt"
+)
+
+// CommandType 為 raft entry 中命令的種類
+type CommandType uint8
+
+const (
+	CommandAsset          CommandType = iota + 1 // 資產增減
+	CommandCurrency                              // 新增或更新幣別註冊資料
+	CommandAccount                               // 帳戶開立、凍結、解凍與關閉
+	CommandLimit                                 // 設定或移除限額政策
+	_                                            // 保留編號：曾用於直接切換 snapshot 格式版本，已由 CommandActivate 取代
+	CommandNodeSupport                           // 節點回報支援的格式版本
+	CommandActivate                              // 所有成員支援後啟用格式版本
+	CommandChecksum                              // 要求所有副本計算套用此 entry 後的狀態摘要
+	CommandChecksumReport                        // 節點回報計算出的狀態摘要
+	CommandClock                                 // leader 寫入目前時間，讓非 leader 提案的時間上限跟上 leader 時鐘
+	CommandMember                                // 加入 shard 前登記新成員，或撤銷加入失敗的登記
+)
+
+// CommandVersion 為目前提案使用的命令版本
+// 版本 0 為舊版直接編碼 Asset 的 entry，套用時不做幣別檢查以維持既有結果
+const CommandVersion = 1
+
+// Command 是寫入 raft entry 的命令封包
+// Timestamp 由提案端指定並隨 entry 複製，狀態機只使用此時間以保證各副本結果一致
+// 非 leader 提案的時間最多超前最後一個 leader 提案的時間 configs.MaxClockSkew，時鐘過快的 follower 不會推進所有副本的時間
+type Command struct {
+	Type        CommandType
+	Version     uint32
+	Timestamp   int64 // Unix 奈秒
+	Leader      bool  // 提案時提案端為 leader，Timestamp 為 leader 的時鐘
+	Asset       *Asset
+	Currency    *Currency
+	Account     *AccountCommand
+	Limit       *LimitCommand
+	NodeSupport *NodeSupport
+	Activation  *Activation
+	Member      *MemberRegistration
+	Checksum    *ChecksumReport
+	// TraceID 為提案請求的 trace ID，各副本套用時記錄在 log 中；舊版 entry 沒有此欄位
+	TraceID string
+	// Trace 為提案 span 的 trace context，未啟用 tracing 或請求沒有 span 時為 nil
+	Trace *TraceContext
+}
+
+// TraceContext 為提案 span 的 OpenTelemetry trace context，各副本套用時以此建立關聯的 span
+type TraceContext struct {
+	Carrier map[string]string // W3C traceparent / tracestate
+	// Origin 為提案節點的 NodeHost ID，該節點套用的 span 為提案 span 的子 span，其他副本以 link 關聯
+	Origin string
+}
+
+// ResultCode 為狀態機套用命令後回傳的 statemachine.Result.Value
+type ResultCode uint64
+
+const (
+	ResultOK                 ResultCode = iota // 成功
+	ResultInvalidCommand                       // 命令無法解析或種類不支援
+	ResultUnknownCurrency                      // 幣別未註冊
+	ResultCurrencyDisabled                     // 幣別已停用
+	ResultInvalidAmount                        // 金額不符合幣別精度或上下限
+	ResultInvalidCurrency                      // 幣別註冊資料不合法
+	ResultAccountNotFound                      // 帳戶不存在
+	ResultAccountExists                        // 帳戶已存在
+	ResultAccountFrozen                        // 帳戶已凍結
+	ResultAccountClosed                        // 帳戶已關閉
+	ResultAccountNotEmpty                      // 帳戶仍有餘額，不可關閉
+	ResultInvalidTransition                    // 帳戶狀態不允許此操作
+	ResultLimitExceeded                        // 超過單筆或滾動視窗提領上限
+	ResultInvalidLimit                         // 限額政策不合法
+	ResultUnsupportedVersion                   // 不支援的格式版本
+	ResultDowngradeBlocked                     // 版本已啟用，不可降級
+	ResultUpgradeNotReady                      // 尚有成員未回報支援指定版本
+	ResultChecksumExpired                      // 回報的 checksum round 已不在保留的結果中，回報被捨棄
+)
+
+var resultMessages = map[ResultCode]string{
+	ResultOK:                 "ok",
+	ResultInvalidCommand:     "invalid command",
+	ResultUnknownCurrency:    "unknown currency",
+	ResultCurrencyDisabled:   "currency disabled",
+	ResultInvalidAmount:      "invalid amount",
+	ResultInvalidCurrency:    "invalid currency definition",
+	ResultAccountNotFound:    "account not found",
+	ResultAccountExists:      "account already exists",
+	ResultAccountFrozen:      "account frozen",
+	ResultAccountClosed:      "account closed",
+	ResultAccountNotEmpty:    "account balance not empty",
+	ResultInvalidTransition:  "invalid account status transition",
+	ResultLimitExceeded:      "limit exceeded",
+	ResultInvalidLimit:       "invalid limit rule",
+	ResultUnsupportedVersion: "unsupported version",
+	ResultDowngradeBlocked:   "downgrade blocked after activation",
+	ResultUpgradeNotReady:    "not all members support version",
+	ResultChecksumExpired:    "checksum round expired",
+}
+
+func (r ResultCode) String() string {
+	if msg, ok := resultMessages[r]; ok {
+		return msg
+	}
+	return fmt.Sprintf("result %d", uint64(r))
+}
+
+// Encode 將命令以 gob 編碼成 raft entry 內容，未指定版本時使用 CommandVersion
+func (c Command) Encode() ([]byte, error) {
+	if c.Version == 0 {
+		c.Version = CommandVersion
+	}
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(&c); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// DecodeCommand 解析 raft entry 內容
+// 相容舊版直接以 gob 編碼 Asset 的 entry，這類 entry 沒有時間戳記
+func DecodeCommand(data []byte) (Command, error) {
+	var cmd Command
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&cmd); err == nil && cmd.Type != 0 {
+		return cmd, nil
+	}
+	var asset Asset
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&asset); err != nil {
+		return Command{}, fmt.Errorf("decode command: %w", err)
+	}
+	return Command{Type: CommandAsset, Asset: &asset}, nil
+}
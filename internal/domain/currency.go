@@ -0,0 +1,49 @@
+package domain
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+)
+
+// currencyCodePattern 幣別代碼只允許大寫英數、底線與連字號，避免大小寫不同產生重複帳本
+var currencyCodePattern = regexp.MustCompile(`^[A-Z0-9][A-Z0-9_-]{1,31}$`)
+
+// MaxCurrencyPrecision 幣別允許的最大小數位數
+const MaxCurrencyPrecision = 18
+
+// Currency 為幣別註冊資料
+type Currency struct {
+	Code      string  `json:"code"`
+	Precision int     `json:"precision"` // 金額允許的小數位數
+	Enabled   bool    `json:"enabled"`
+	MinAmount float64 `json:"minAmount"` // 單筆金額絕對值下限，0 代表不限制
+	MaxAmount float64 `json:"maxAmount"` // 單筆金額絕對值上限，0 代表不限制
+}
+
+// CurrencyQuery 查詢幣別註冊資料，Code 為空代表列出所有幣別
+type CurrencyQuery struct {
+	Code string
+}
+
+// Validate 檢查幣別註冊資料本身是否合法
+func (c Currency) Validate() error {
+	if !currencyCodePattern.MatchString(c.Code) {
+		return fmt.Errorf("invalid currency code %q", c.Code)
+	}
+	if c.Precision < 0 || c.Precision > MaxCurrencyPrecision {
+		return fmt.Errorf("precision must be between 0 and %d", MaxCurrencyPrecision)
+	}
+	if c.MinAmount < 0 || c.MaxAmount < 0 {
+		return fmt.Errorf("amount limits must not be negative")
+	}
+	if c.MaxAmount > 0 && c.MinAmount > c.MaxAmount {
+		return fmt.Errorf("minAmount %v greater than maxAmount %v", c.MinAmount, c.MaxAmount)
+	}
+	return nil
+}
+
+// IsZero 回傳餘額在幣別精度下是否為零，浮點運算殘留的微小餘額視為零
+func (c Currency) IsZero(balance float64) bool {
+	return math.Abs(balance) < 0.5*math.Pow10(-c.Precision)
+}
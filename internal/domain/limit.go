@@ -0,0 +1,67 @@
+package domain
+
+import "time"
+
+// LimitWindow 提領累計上限的滾動視窗長度，所有副本必須一致
+const LimitWindow = 24 * time.Hour
+
+// LimitScope 為限額政策的適用範圍，越具體的範圍優先
+type LimitScope string
+
+const (
+	LimitScopeCurrency LimitScope = "currency" // 幣別預設
+	LimitScopeTier     LimitScope = "tier"     // 幣別 + 帳戶等級
+	LimitScopeUser     LimitScope = "user"     // 幣別 + 使用者覆寫
+)
+
+// LimitPolicy 為限額政策，欄位為 0 代表不限制
+type LimitPolicy struct {
+	MaxSingleAmount  float64 `json:"maxSingleAmount"`  // 單筆金額絕對值上限
+	DailyWithdrawCap float64 `json:"dailyWithdrawCap"` // 滾動視窗內提領（負數金額）絕對值累計上限
+}
+
+// LimitRule 為設定在某個範圍的限額政策
+type LimitRule struct {
+	Scope    LimitScope  `json:"scope"`
+	Currency string      `json:"currency"`
+	Tier     string      `json:"tier,omitempty"`
+	UID      string      `json:"uid,omitempty"`
+	Policy   LimitPolicy `json:"policy"`
+}
+
+// LimitCommand 設定或移除限額政策
+type LimitCommand struct {
+	Rule   LimitRule
+	Remove bool
+}
+
+// LimitQuery 查詢限額，UID 為空時列出所有政策
+type LimitQuery struct {
+	UID      string
+	Currency string
+}
+
+// LimitView 為使用者在某幣別實際生效的限額與目前用量
+type LimitView struct {
+	UID       string      `json:"uid"`
+	Currency  string      `json:"currency"`
+	Scope     LimitScope  `json:"scope,omitempty"` // 生效政策的來源範圍，空代表沒有政策
+	Policy    LimitPolicy `json:"policy"`
+	Withdrawn float64     `json:"withdrawn"` // 滾動視窗內已提領金額，只計入生效政策設有每日上限時的提領
+}
+
+// Valid 檢查規則的範圍與欄位是否相符
+func (r LimitRule) Valid() bool {
+	if r.Currency == "" || r.Policy.MaxSingleAmount < 0 || r.Policy.DailyWithdrawCap < 0 {
+		return false
+	}
+	switch r.Scope {
+	case LimitScopeCurrency:
+		return r.Tier == "" && r.UID == ""
+	case LimitScopeTier:
+		return r.Tier != "" && r.UID == ""
+	case LimitScopeUser:
+		return r.UID != "" && r.Tier == ""
+	}
+	return false
+}
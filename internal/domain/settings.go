@@ -0,0 +1,11 @@
+package domain
+
+// Settings 為複製於狀態機中的叢集設定，所有副本一致
+type Settings struct {
+	SnapshotVersion uint64 `json:"snapshotVersion"` // 目前寫入 snapshot 使用的格式版本
+	SnapshotFloor   uint64 `json:"snapshotFloor"`   // 透過升級流程啟用過的最高版本，之後不可降級到此版本以下
+	CommandVersion  uint64 `json:"commandVersion"`  // 已啟用的命令版本，高於此版本的命令會被拒絕
+}
+
+// SettingsQuery 查詢叢集設定
+type SettingsQuery struct{}
@@ -0,0 +1,25 @@
+package domain
+
+// StatsQuery 查詢幣別彙總統計，Currency 為空代表查詢所有幣別
+type StatsQuery struct {
+	Currency string
+	TopN     int  // 回傳的最大持有者數量
+	Verify   bool // 是否以全表掃描驗證增量統計
+}
+
+// CurrencyStats 單一幣別的彙總統計
+// Min / Max / TopHolders 只計算餘額非零的帳戶
+type CurrencyStats struct {
+	Currency   string   `json:"currency"`
+	Total      float64  `json:"total"`
+	Accounts   int      `json:"accounts"` // 餘額非零的帳戶數
+	Min        float64  `json:"min"`
+	Max        float64  `json:"max"`
+	TopHolders []Holder `json:"topHolders"`
+}
+
+// Holder 為持有者與其餘額
+type Holder struct {
+	UID     string  `json:"uid"`
+	Balance float64 `json:"balance"`
+}
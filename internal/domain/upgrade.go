@@ -0,0 +1,70 @@
+package domain
+
+import "slices"
+
+// Feature 為可透過升級流程切換版本的格式
+type Feature string
+
+const (
+	FeatureSnapshot Feature = "snapshot" // snapshot 寫入格式
+	FeatureCommand  Feature = "command"  // raft 命令格式
+)
+
+// NodeSupport 為節點啟動後透過 raft 回報自身支援的格式版本
+type NodeSupport struct {
+	NodeID           uint64   `json:"nodeID"`
+	SnapshotVersions []uint64 `json:"snapshotVersions"`
+	CommandVersions  []uint64 `json:"commandVersions"`
+	ReportedIndex    uint64   `json:"reportedIndex"` // 回報被套用時的 raft index
+}
+
+// Activation 要求啟用某個格式版本
+// Members 與 Removed 為提案當下的成員與已移除的節點；狀態機確認所有成員都已回報支援，
+// 且每個回報過或已登記的節點都在其中一個清單，避免過期的成員清單遺漏節點，判斷結果在各副本一致
+type Activation struct {
+	Feature Feature
+	Version uint64
+	Members []uint64
+	Removed []uint64
+}
+
+// MemberRegistration 在節點加入 shard 前登記於狀態機
+// 登記後節點列在升級狀態中但尚未回報支援版本，提案時讀到的成員清單過期而遺漏此節點，或節點尚未回報時，啟用都會被拒絕
+// Cancel 撤銷尚未回報的登記，用於加入 shard 失敗時；已回報的節點不受影響
+type MemberRegistration struct {
+	NodeID uint64
+	Cancel bool
+}
+
+// UpgradeQuery 查詢升級狀態
+type UpgradeQuery struct{}
+
+// UpgradeState 為複製於狀態機中的升級狀態
+type UpgradeState struct {
+	Settings Settings      `json:"settings"`
+	Nodes    []NodeSupport `json:"nodes"`
+}
+
+// Reported 判斷節點是否已回報支援版本，只經 MemberRegistration 登記的節點尚未回報
+func (n NodeSupport) Reported() bool {
+	return n.ReportedIndex != 0
+}
+
+// Supports 判斷節點是否支援指定格式版本
+func (n NodeSupport) Supports(feature Feature, version uint64) bool {
+	switch feature {
+	case FeatureSnapshot:
+		return slices.Contains(n.SnapshotVersions, version)
+	case FeatureCommand:
+		return slices.Contains(n.CommandVersions, version)
+	}
+	return false
+}
+
+// UpgradeStatus 為升級控制器依目前成員計算的狀態
+type UpgradeStatus struct {
+	UpgradeState
+	Members []uint64             `json:"members"`
+	Pending []uint64             `json:"pending"` // 尚未回報支援版本的成員，以及已登記、尚未加入 shard 的節點
+	Ready   map[Feature][]uint64 `json:"ready"`   // 所有成員皆支援、可以啟用的版本
+}
@@ -0,0 +1,386 @@
+// Package gbsnap 讀寫磁碟上的 dragonboat snapshot 目錄，供離線工具使用，不需要啟動 NodeHost
+//
+// 目錄內容為 snapshot-<index>.gbsnap、external-file-<id> 外部檔案，匯出的 snapshot 另有 snapshot.metadata
+// .gbsnap 格式（dragonboat v2）：
+//
+//	header   1024 bytes：[u64 len][pb.SnapshotHeader][crc32 4B，可為 0][補 0]
+//	blocks   每 2 MiB 一個 block，之後接 block 的 crc32 IEEE
+//	tail     [u64 blocks 與 crc 的總長][magic 8B]
+//
+// blocks 串起來是（依 header 的 CompressionType 壓縮的）[client sessions][狀態機 SaveSnapshot 寫出的內容]
+package gbsnap
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/md5"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/golang/snappy"
+	pb "github.com/lni/dragonboat/v4/raftpb"
+	"github.com/lni/dragonboat/v4/statemachine"
+)
+
+const (
+	// MetadataFilename 為匯出 snapshot 的元資料檔名，內容為 pb.Snapshot
+	MetadataFilename = "snapshot.metadata"
+
+	headerSize         = 1024
+	blockSize          = 2 << 20
+	checksumSize       = 4
+	tailSize           = 16
+	fileSuffix         = ".gbsnap"
+	externalFilePrefix = "external-file-"
+)
+
+var magicNumber = []byte{0x3F, 0x5B, 0xCB, 0xF1, 0xFA, 0xBA, 0x81, 0x9F}
+
+// ErrInvalidSnapshot snapshot 目錄或 .gbsnap 檔案格式不正確、checksum 不符
+var ErrInvalidSnapshot = errors.New("invalid dragonboat snapshot")
+
+// Snapshot 為磁碟上一個 dragonboat snapshot 目錄
+type Snapshot struct {
+	Dir    string
+	Path   string // .gbsnap 檔案路徑
+	Index  uint64
+	Term   uint64 // 沒有 snapshot.metadata 時為 0
+	Size   int64
+	Header pb.SnapshotHeader
+	Record *pb.Snapshot // snapshot.metadata 的內容，節點目錄內的 snapshot 沒有此檔時為 nil
+	Files  []statemachine.SnapshotFile
+}
+
+// Open 開啟 snapshot 目錄並讀取 header 與外部檔案清單
+// dir 不含 .gbsnap 時視為節點的 snapshot 根目錄，開啟其中 index 最大的 snapshot
+func Open(dir string) (*Snapshot, error) {
+	path, err := findSnapshotFile(dir)
+	if err != nil {
+		return nil, err
+	}
+	s := &Snapshot{Dir: filepath.Dir(path), Path: path}
+	if s.Index, err = parseIndex(filepath.Base(path)); err != nil {
+		return nil, err
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	st, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	s.Size = st.Size()
+	if s.Header, err = readHeader(f); err != nil {
+		return nil, err
+	}
+	if s.Header.Version != 2 {
+		return nil, fmt.Errorf("%w: unsupported format version %d", ErrInvalidSnapshot, s.Header.Version)
+	}
+
+	record, err := readRecord(s.Dir)
+	if err != nil {
+		return nil, err
+	}
+	if record != nil {
+		s.Record, s.Term = record, record.Term
+		for _, file := range record.Files {
+			s.Files = append(s.Files, statemachine.SnapshotFile{
+				FileID:   file.FileId,
+				Filepath: filepath.Join(s.Dir, filepath.Base(file.Filepath)),
+				Metadata: file.Metadata,
+			})
+		}
+		return s, nil
+	}
+	// 節點目錄內的 snapshot 沒有元資料檔，外部檔案的 FileID 由檔名解析，幣別由狀態機的 manifest 對應
+	if s.Files, err = listExternalFiles(s.Dir); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Read 驗證 .gbsnap 所有 block 的 checksum 並解壓，回傳 client sessions 的原始內容與狀態機資料的 reader
+// 狀態機不一定讀完整個串流，payload checksum 在 Close 讀完剩餘內容時核對，呼叫端必須檢查 Close 的錯誤
+func (s *Snapshot) Read() ([]byte, io.ReadCloser, error) {
+	sessions, r, f, err := s.open()
+	if err != nil {
+		return nil, nil, err
+	}
+	return sessions, &payloadReader{Reader: r, f: f}, nil
+}
+
+// Head 回傳狀態機資料的 reader，只驗證實際讀到的 block，Close 時不讀完剩餘內容也不核對 payload checksum
+// 供列出 snapshot 等只需要開頭元資料的用途，還原或匯出必須使用 Read
+func (s *Snapshot) Head() (io.ReadCloser, error) {
+	_, r, f, err := s.open()
+	if err != nil {
+		return nil, err
+	}
+	return &headReader{Reader: r, f: f}, nil
+}
+
+// open 開啟 .gbsnap 檔案、驗證 header 與 tail 並讀出 client sessions，回傳的 reader 位於狀態機資料開頭
+func (s *Snapshot) open() ([]byte, io.Reader, *os.File, error) {
+	f, err := os.Open(s.Path)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if _, err := readHeader(f); err != nil {
+		f.Close()
+		return nil, nil, nil, err
+	}
+	tail := make([]byte, tailSize)
+	if _, err := f.ReadAt(tail, s.Size-tailSize); err != nil {
+		f.Close()
+		return nil, nil, nil, fmt.Errorf("%w: read tail: %v", ErrInvalidSnapshot, err)
+	}
+	total := s.Size - headerSize - tailSize
+	if !bytes.Equal(tail[8:], magicNumber) || int64(binary.LittleEndian.Uint64(tail)) != total {
+		f.Close()
+		return nil, nil, nil, fmt.Errorf("%w: bad tail", ErrInvalidSnapshot)
+	}
+
+	br := &blockReader{r: bufio.NewReader(io.LimitReader(f, total)), want: s.Header.PayloadChecksum, sums: crc32.NewIEEE()}
+	var r io.Reader = br
+	switch s.Header.CompressionType {
+	case pb.NoCompression:
+	case pb.Snappy:
+		r = snappy.NewReader(br)
+	default:
+		f.Close()
+		return nil, nil, nil, fmt.Errorf("%w: unknown compression %d", ErrInvalidSnapshot, s.Header.CompressionType)
+	}
+	sessions, err := readSessions(r)
+	if err != nil {
+		f.Close()
+		return nil, nil, nil, err
+	}
+	return sessions, r, f, nil
+}
+
+type payloadReader struct {
+	io.Reader
+	f *os.File
+}
+
+func (p *payloadReader) Close() error {
+	_, err := io.Copy(io.Discard, p.Reader)
+	return errors.Join(err, p.f.Close())
+}
+
+type headReader struct {
+	io.Reader
+	f *os.File
+}
+
+func (h *headReader) Close() error {
+	return h.f.Close()
+}
+
+// blockReader 逐一讀取 block 並驗證 crc，讀完所有 block 後以 crc 串列核對 payload checksum
+type blockReader struct {
+	r     io.Reader
+	block []byte
+	sums  hash.Hash32 // 各 block crc 串起來的 crc32，即 header 中的 PayloadChecksum
+	want  []byte
+	eof   bool
+}
+
+func (br *blockReader) Read(p []byte) (int, error) {
+	for len(br.block) == 0 {
+		if br.eof {
+			return 0, io.EOF
+		}
+		if err := br.next(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, br.block)
+	br.block = br.block[n:]
+	return n, nil
+}
+
+func (br *blockReader) next() error {
+	buf := make([]byte, blockSize+checksumSize)
+	n, err := io.ReadFull(br.r, buf)
+	switch {
+	case err == io.EOF:
+		br.eof = true
+		if !bytes.Equal(br.sums.Sum(nil), br.want) {
+			return fmt.Errorf("%w: payload checksum mismatch", ErrInvalidSnapshot)
+		}
+		return nil
+	case err != nil && err != io.ErrUnexpectedEOF:
+		return err
+	case n <= checksumSize:
+		return fmt.Errorf("%w: truncated block", ErrInvalidSnapshot)
+	}
+	data, sum := buf[:n-checksumSize], buf[n-checksumSize:n]
+	if !bytes.Equal(blockSum(data), sum) {
+		return fmt.Errorf("%w: block checksum mismatch", ErrInvalidSnapshot)
+	}
+	br.sums.Write(sum)
+	br.block = data
+	return nil
+}
+
+// blockSum 回傳與 hash.Hash.Sum 相同位元組順序（big endian）的 crc32 IEEE
+func blockSum(data []byte) []byte {
+	return binary.BigEndian.AppendUint32(nil, crc32.ChecksumIEEE(data))
+}
+
+// readHeader 讀取並驗證 1024 bytes 的 header，讀取後 f 位於 payload 開頭
+func readHeader(f io.Reader) (pb.SnapshotHeader, error) {
+	var header pb.SnapshotHeader
+	buf := make([]byte, headerSize)
+	if _, err := io.ReadFull(f, buf); err != nil {
+		return header, fmt.Errorf("%w: read header: %v", ErrInvalidSnapshot, err)
+	}
+	sz := binary.LittleEndian.Uint64(buf)
+	if sz > headerSize-8-checksumSize {
+		return header, fmt.Errorf("%w: header size %d", ErrInvalidSnapshot, sz)
+	}
+	data := buf[8 : 8+sz]
+	if err := header.Unmarshal(data); err != nil {
+		return header, fmt.Errorf("%w: %v", ErrInvalidSnapshot, err)
+	}
+	crc := buf[8+sz : 8+sz+checksumSize]
+	if !bytes.Equal(crc, make([]byte, checksumSize)) && !bytes.Equal(blockSum(data), crc) {
+		return header, fmt.Errorf("%w: header checksum mismatch", ErrInvalidSnapshot)
+	}
+	// HeaderChecksum 為不含該欄位時 header 的 crc32
+	if len(header.HeaderChecksum) > 0 {
+		unsigned := header
+		unsigned.HeaderChecksum = nil
+		if !bytes.Equal(blockSum(pb.MustMarshal(&unsigned)), header.HeaderChecksum) {
+			return header, fmt.Errorf("%w: header checksum mismatch", ErrInvalidSnapshot)
+		}
+	}
+	return header, nil
+}
+
+// readSessions 讀取 client sessions 區段：[u64 容量][u64 筆數]，每筆為 [u64 len][json]
+func readSessions(r io.Reader) ([]byte, error) {
+	head := make([]byte, 16)
+	if _, err := io.ReadFull(r, head); err != nil {
+		return nil, fmt.Errorf("%w: read sessions: %v", ErrInvalidSnapshot, err)
+	}
+	sessions := head
+	for i := binary.LittleEndian.Uint64(head[8:]); i > 0; i-- {
+		l := make([]byte, 8)
+		if _, err := io.ReadFull(r, l); err != nil {
+			return nil, fmt.Errorf("%w: read session: %v", ErrInvalidSnapshot, err)
+		}
+		n := binary.LittleEndian.Uint64(l)
+		if n > blockSize {
+			return nil, fmt.Errorf("%w: session size %d", ErrInvalidSnapshot, n)
+		}
+		data := make([]byte, n)
+		if _, err := io.ReadFull(r, data); err != nil {
+			return nil, fmt.Errorf("%w: read session: %v", ErrInvalidSnapshot, err)
+		}
+		sessions = append(append(sessions, l...), data...)
+	}
+	return sessions, nil
+}
+
+// readRecord 讀取 snapshot.metadata：[md5 後 8 bytes][pb.Snapshot]，檔案不存在時回傳 nil
+func readRecord(dir string) (*pb.Snapshot, error) {
+	data, err := os.ReadFile(filepath.Join(dir, MetadataFilename))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < 8 || !bytes.Equal(data[:8], recordHash(data[8:])) {
+		return nil, fmt.Errorf("%w: corrupted %s", ErrInvalidSnapshot, MetadataFilename)
+	}
+	record := &pb.Snapshot{}
+	if err := record.Unmarshal(data[8:]); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidSnapshot, err)
+	}
+	return record, nil
+}
+
+func recordHash(data []byte) []byte {
+	sum := md5.Sum(data)
+	return sum[8:]
+}
+
+// findSnapshotFile 回傳 dir 之下 index 最大的 .gbsnap，dir 之下有多個 shard 的 snapshot 時回傳錯誤
+// 產生中與接收中的 snapshot 位於 .generating / .receiving 目錄，不列入
+func findSnapshotFile(dir string) (string, error) {
+	var found []string
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() && (strings.HasSuffix(d.Name(), ".generating") || strings.HasSuffix(d.Name(), ".receiving")) {
+			return filepath.SkipDir
+		}
+		if !d.IsDir() && strings.HasSuffix(d.Name(), fileSuffix) {
+			found = append(found, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	if len(found) == 0 {
+		return "", fmt.Errorf("%w: no snapshot found in %s", ErrInvalidSnapshot, dir)
+	}
+	// snapshot 目錄位於各 shard 的目錄下，檔名為固定長度的 16 進位 index，字串排序即為 index 排序
+	shard := filepath.Dir(filepath.Dir(found[0]))
+	for _, path := range found[1:] {
+		if filepath.Dir(filepath.Dir(path)) != shard {
+			return "", fmt.Errorf("%w: %s contains snapshots of more than one shard", ErrInvalidSnapshot, dir)
+		}
+	}
+	sort.Slice(found, func(i, j int) bool { return filepath.Base(found[i]) < filepath.Base(found[j]) })
+	return found[len(found)-1], nil
+}
+
+func parseIndex(name string) (uint64, error) {
+	hex := strings.TrimSuffix(strings.TrimPrefix(name, "snapshot-"), fileSuffix)
+	index, err := strconv.ParseUint(hex, 16, 64)
+	if err != nil {
+		return 0, fmt.Errorf("%w: bad file name %s", ErrInvalidSnapshot, name)
+	}
+	return index, nil
+}
+
+// listExternalFiles 依 FileID 排序列出 external-file-<id>
+func listExternalFiles(dir string) ([]statemachine.SnapshotFile, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var files []statemachine.SnapshotFile
+	for _, e := range entries {
+		id, found := strings.CutPrefix(e.Name(), externalFilePrefix)
+		if !found || e.IsDir() {
+			continue
+		}
+		fileID, err := strconv.ParseUint(id, 10, 64)
+		if err != nil {
+			continue
+		}
+		files = append(files, statemachine.SnapshotFile{FileID: fileID, Filepath: filepath.Join(dir, e.Name())})
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].FileID < files[j].FileID })
+	return files, nil
+}
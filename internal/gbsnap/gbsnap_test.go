@@ -0,0 +1,81 @@
+package gbsnap_test
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"testing"
+
+	"go-raft/internal/gbsnap"
+	"go-raft/internal/store"
+
+	pb "github.com/lni/dragonboat/v4/raftpb"
+	"github.com/lni/dragonboat/v4/statemachine"
+)
+
+func TestCreateAndReadSnapshot(t *testing.T) {
+	src := store.NewCurrencyStore(1, 1, t.TempDir(), 0)
+	// 超過一個 2 MiB block，確認跨 block 讀取
+	for i := 0; i < 60000; i++ {
+		src.UpdateAt(uint64(i+1), fmt.Sprintf("user-%08d", i), []string{"USD", "BTC"}[i%2], float64(i)+0.5)
+	}
+
+	for _, version := range []uint64{2, 3} {
+		t.Run(fmt.Sprintf("v%d", version), func(t *testing.T) {
+			record := pb.Snapshot{Index: 42, Term: 3, ShardID: 1, Type: pb.ConcurrentStateMachine}
+			s, err := gbsnap.Create(t.TempDir(), record, nil, func(w io.Writer, fss statemachine.ISnapshotFileCollection) error {
+				return src.SaveSnapshot(version, w, fss, nil)
+			})
+			if err != nil {
+				t.Fatalf("create: %v", err)
+			}
+			if s.Index != 42 || s.Term != 3 || s.Record == nil || len(s.Record.Checksum) == 0 {
+				t.Fatalf("unexpected snapshot %+v", s)
+			}
+
+			_, r, err := s.Read()
+			if err != nil {
+				t.Fatalf("read: %v", err)
+			}
+			dst := store.NewCurrencyStore(1, 2, t.TempDir(), 0)
+			if err := dst.RecoverFromSnapshot(r, s.Files, nil); err != nil {
+				t.Fatalf("recover: %v", err)
+			}
+			if err := r.Close(); err != nil {
+				t.Fatalf("close: %v", err)
+			}
+			if !reflect.DeepEqual(src.List(), dst.List()) {
+				t.Fatal("recovered balances differ from source")
+			}
+
+			head, err := s.Head()
+			if err != nil {
+				t.Fatalf("head: %v", err)
+			}
+			got, err := store.SnapshotVersion(head)
+			if err := errors.Join(err, head.Close()); err != nil || got != version {
+				t.Fatalf("head version: got %d, %v, want %d", got, err, version)
+			}
+
+			// 翻轉 payload 中的一個位元，block checksum 必須偵測到
+			data, err := os.ReadFile(s.Path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			data[len(data)/2] ^= 0xff
+			if err := os.WriteFile(s.Path, data, 0o644); err != nil {
+				t.Fatal(err)
+			}
+			_, r, err = s.Read()
+			if err == nil {
+				_, err = store.DecodeSnapshot(r, s.Files, nil)
+				err = errors.Join(err, r.Close())
+			}
+			if !errors.Is(err, gbsnap.ErrInvalidSnapshot) {
+				t.Fatalf("expected ErrInvalidSnapshot, got %v", err)
+			}
+		})
+	}
+}
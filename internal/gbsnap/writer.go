@@ -0,0 +1,194 @@
+package gbsnap
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	pb "github.com/lni/dragonboat/v4/raftpb"
+	"github.com/lni/dragonboat/v4/statemachine"
+)
+
+// lruMaxSessionCount 為 dragonboat 預設的 client session 容量，寫在空的 sessions 區段開頭
+const lruMaxSessionCount = 4096
+
+// fileCollection 收集狀態機在 SaveSnapshot 中加入的外部檔案
+type fileCollection struct {
+	files []statemachine.SnapshotFile
+}
+
+func (fc *fileCollection) AddFile(fileID uint64, path string, metadata []byte) {
+	fc.files = append(fc.files, statemachine.SnapshotFile{FileID: fileID, Filepath: path, Metadata: metadata})
+}
+
+// Create 在 parent 下建立 snapshot-<index> 目錄，寫出 .gbsnap、外部檔案與 snapshot.metadata，格式與 dragonboat 匯出的 snapshot 相同
+// record 提供 index、term 與成員等 raft 資訊，檔案路徑、大小與 checksum 依寫出的內容重新計算
+// sessions 為 nil 時寫入空的 client sessions；save 寫出狀態機資料，外部檔案以 hard link 或複製放入 snapshot 目錄
+func Create(parent string, record pb.Snapshot, sessions []byte, save func(w io.Writer, fss statemachine.ISnapshotFileCollection) error) (*Snapshot, error) {
+	dir := filepath.Join(parent, fmt.Sprintf("snapshot-%016X", record.Index))
+	if err := os.MkdirAll(parent, 0o755); err != nil {
+		return nil, err
+	}
+	if err := os.Mkdir(dir, 0o755); err != nil {
+		return nil, err
+	}
+	path := filepath.Join(dir, fmt.Sprintf("snapshot-%016X%s", record.Index, fileSuffix))
+	if sessions == nil {
+		sessions = binary.LittleEndian.AppendUint64(nil, lruMaxSessionCount)
+		sessions = binary.LittleEndian.AppendUint64(sessions, 0)
+	}
+
+	fc := &fileCollection{}
+	checksum, size, err := writeSnapshotFile(path, func(w io.Writer) error {
+		if _, err := w.Write(sessions); err != nil {
+			return err
+		}
+		return save(w, fc)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	record.Filepath = path
+	record.FileSize = uint64(size)
+	record.Checksum = checksum
+	record.Files = nil
+	record.Dummy = false
+	record.Imported = false
+	for _, file := range fc.files {
+		dst := filepath.Join(dir, fmt.Sprintf("%s%d", externalFilePrefix, file.FileID))
+		if err := linkOrCopy(file.Filepath, dst); err != nil {
+			return nil, err
+		}
+		st, err := os.Stat(dst)
+		if err != nil {
+			return nil, err
+		}
+		record.Files = append(record.Files, &pb.SnapshotFile{
+			Filepath: dst,
+			FileSize: uint64(st.Size()),
+			FileId:   file.FileID,
+			Metadata: file.Metadata,
+		})
+	}
+	data := pb.MustMarshal(&record)
+	if err := os.WriteFile(filepath.Join(dir, MetadataFilename), append(recordHash(data), data...), 0o644); err != nil {
+		return nil, err
+	}
+	return Open(dir)
+}
+
+// writeSnapshotFile 寫出未壓縮的 .gbsnap，回傳 payload checksum 與檔案大小
+func writeSnapshotFile(path string, write func(w io.Writer) error) ([]byte, int64, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o644)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer f.Close()
+	// header 需要 payload checksum，先保留空間，寫完 payload 後再回填
+	if _, err := f.Write(make([]byte, headerSize)); err != nil {
+		return nil, 0, err
+	}
+	bw := &blockWriter{w: f, sums: crc32.NewIEEE()}
+	if err := write(bw); err != nil {
+		return nil, 0, err
+	}
+	if err := bw.Close(); err != nil {
+		return nil, 0, err
+	}
+
+	header := pb.SnapshotHeader{
+		UnreliableTime:  uint64(time.Now().UnixNano()),
+		PayloadChecksum: bw.sums.Sum(nil),
+		ChecksumType:    pb.CRC32IEEE,
+		Version:         2,
+		CompressionType: pb.NoCompression,
+	}
+	header.HeaderChecksum = blockSum(pb.MustMarshal(&header))
+	data := pb.MustMarshal(&header)
+	if _, err := f.WriteAt(binary.LittleEndian.AppendUint64(nil, uint64(len(data))), 0); err != nil {
+		return nil, 0, err
+	}
+	if _, err := f.WriteAt(data, 8); err != nil {
+		return nil, 0, err
+	}
+	if err := f.Sync(); err != nil {
+		return nil, 0, err
+	}
+	return header.PayloadChecksum, headerSize + bw.total + tailSize, f.Close()
+}
+
+// blockWriter 將內容切成 2 MiB 的 block，每個 block 之後寫入 crc32，Close 時寫出 tail
+type blockWriter struct {
+	w     io.Writer
+	block []byte
+	sums  hash.Hash32
+	total int64
+}
+
+func (bw *blockWriter) Write(p []byte) (int, error) {
+	n := len(p)
+	for len(p) > 0 {
+		l := min(blockSize-len(bw.block), len(p))
+		bw.block = append(bw.block, p[:l]...)
+		p = p[l:]
+		if len(bw.block) == blockSize {
+			if err := bw.flush(); err != nil {
+				return n - len(p), err
+			}
+		}
+	}
+	return n, nil
+}
+
+func (bw *blockWriter) flush() error {
+	sum := blockSum(bw.block)
+	if _, err := bw.w.Write(append(bw.block, sum...)); err != nil {
+		return err
+	}
+	bw.sums.Write(sum)
+	bw.total += int64(len(bw.block) + checksumSize)
+	bw.block = bw.block[:0]
+	return nil
+}
+
+func (bw *blockWriter) Close() error {
+	if len(bw.block) > 0 {
+		if err := bw.flush(); err != nil {
+			return err
+		}
+	}
+	tail := binary.LittleEndian.AppendUint64(nil, uint64(bw.total))
+	_, err := bw.w.Write(append(tail, magicNumber...))
+	return err
+}
+
+// linkOrCopy 以 hard link 放入外部檔案，跨檔案系統時改為複製
+func linkOrCopy(src, dst string) error {
+	if err := os.Link(src, dst); err == nil {
+		return nil
+	}
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Sync(); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
@@ -0,0 +1,85 @@
+// Package logging 設定全域的 logrus logger，並讓 dragonboat 的 log 使用相同的格式與等級
+package logging
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	dblogger "github.com/lni/dragonboat/v4/logger"
+	"github.com/sirupsen/logrus"
+)
+
+// Setup 設定 log 等級（debug、info、warn、error）與格式（text、json），並將 dragonboat 的 log 轉到 logrus
+// 需在建立 NodeHost 前呼叫；dragonboat 只允許設定一次 logger，重複呼叫時只更新等級與格式
+func Setup(level, format string) error {
+	lvl, err := logrus.ParseLevel(level)
+	if err != nil {
+		return fmt.Errorf("invalid log level %q: %w", level, err)
+	}
+	switch strings.ToLower(format) {
+	case "", "text":
+		logrus.SetFormatter(&logrus.TextFormatter{FullTimestamp: true})
+	case "json":
+		logrus.SetFormatter(&logrus.JSONFormatter{})
+	default:
+		return fmt.Errorf("invalid log format %q", format)
+	}
+	logrus.SetLevel(lvl)
+	setupDragonboat.Do(func() {
+		dblogger.SetLoggerFactory(func(pkgName string) dblogger.ILogger {
+			return &dragonboatLogger{entry: logrus.WithField("pkg", pkgName), level: dblogger.INFO}
+		})
+	})
+	return nil
+}
+
+var setupDragonboat sync.Once
+
+// dragonboatLogger 將 dragonboat 各套件的 log 轉成 logrus 的 log，pkg 欄位為 dragonboat 的套件名稱
+// dragonboat 的 SetLevel 只針對單一套件，與 logrus 的全域等級同時生效
+type dragonboatLogger struct {
+	entry *logrus.Entry
+	mu    sync.RWMutex
+	level dblogger.LogLevel
+}
+
+func (l *dragonboatLogger) SetLevel(level dblogger.LogLevel) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.level = level
+}
+
+func (l *dragonboatLogger) enabled(level dblogger.LogLevel) bool {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return level <= l.level
+}
+
+func (l *dragonboatLogger) Debugf(format string, args ...any) {
+	if l.enabled(dblogger.DEBUG) {
+		l.entry.Debugf(format, args...)
+	}
+}
+
+func (l *dragonboatLogger) Infof(format string, args ...any) {
+	if l.enabled(dblogger.INFO) {
+		l.entry.Infof(format, args...)
+	}
+}
+
+func (l *dragonboatLogger) Warningf(format string, args ...any) {
+	if l.enabled(dblogger.WARNING) {
+		l.entry.Warnf(format, args...)
+	}
+}
+
+func (l *dragonboatLogger) Errorf(format string, args ...any) {
+	if l.enabled(dblogger.ERROR) {
+		l.entry.Errorf(format, args...)
+	}
+}
+
+func (l *dragonboatLogger) Panicf(format string, args ...any) {
+	l.entry.Panicf(format, args...)
+}
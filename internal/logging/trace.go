@@ -0,0 +1,31 @@
+package logging
+
+import (
+	"context"
+
+	"github.com/sirupsen/logrus"
+)
+
+// TraceIDField 為 log 中 trace ID 的欄位名稱，HTTP 請求與其提案在各副本套用時的 log 使用相同欄位
+const TraceIDField = "TraceID"
+
+type traceIDKey struct{}
+
+// WithTraceID 回傳帶有 trace ID 的 context
+func WithTraceID(ctx context.Context, traceID string) context.Context {
+	return context.WithValue(ctx, traceIDKey{}, traceID)
+}
+
+// TraceID 回傳 context 中的 trace ID，沒有時回傳空字串
+func TraceID(ctx context.Context) string {
+	traceID, _ := ctx.Value(traceIDKey{}).(string)
+	return traceID
+}
+
+// FromContext 回傳帶有 context 中 trace ID 的 logger
+func FromContext(ctx context.Context) *logrus.Entry {
+	if traceID := TraceID(ctx); traceID != "" {
+		return logrus.WithField(TraceIDField, traceID)
+	}
+	return logrus.NewEntry(logrus.StandardLogger())
+}
@@ -0,0 +1,79 @@
+package raft
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"go-raft/internal/domain"
+	"go-raft/internal/gbsnap"
+	"go-raft/internal/store"
+	"io"
+	"maps"
+	"os"
+
+	"github.com/lni/dragonboat/v4"
+	pb "github.com/lni/dragonboat/v4/raftpb"
+	"github.com/lni/dragonboat/v4/statemachine"
+	"github.com/lni/dragonboat/v4/tools"
+)
+
+// ErrShardExists 節點上已有該 shard 的資料，不可從封存檔建立
+var ErrShardExists = errors.New("shard already exists on this node")
+
+// CaptureLedger 以線性一致讀取取得完整帳本在單一 raft index 的一致狀態
+// minApplied 為下限：非零時本節點需已套用到該 index，否則回傳 store.ErrIndexNotApplied；實際的 index 為 Meta.History.Applied，可能大於 minApplied
+func CaptureLedger(ctx context.Context, nh *dragonboat.NodeHost, clusterID, minApplied uint64) (*store.SnapshotContent, error) {
+	result, err := nh.SyncRead(ctx, clusterID, domain.ExportQuery{MinApplied: minApplied})
+	if err != nil {
+		return nil, err
+	}
+	content, ok := result.(*store.SnapshotContent)
+	if !ok {
+		return nil, fmt.Errorf("unexpected export result %T", result)
+	}
+	return content, nil
+}
+
+// ImportArchive 以封存檔建立全新 shard 的第一個 snapshot，須在該節點的 NodeHost 啟動前執行
+// 每個初始成員都要以相同的封存檔與 members 各自匯入，之後以 Join=false、不帶 InitialMembers 啟動
+// snapshot 的 index 沿用封存檔的 index，新 shard 的 raft log 從下一個 index 開始，歷史餘額查詢保持連續
+func ImportArchive(nc NodeConfig, archive *store.Archive, members map[uint64]string) error {
+	if _, ok := members[nc.NodeID]; !ok {
+		return fmt.Errorf("node %d is not in members", nc.NodeID)
+	}
+	nh, err := dragonboat.NewNodeHost(nodeHostConfig(nc))
+	if err != nil {
+		return err
+	}
+	exists := nh.HasNodeInfo(nc.ClusterID, nc.NodeID)
+	nh.Close()
+	if exists {
+		return fmt.Errorf("%w: shard %d replica %d", ErrShardExists, nc.ClusterID, nc.NodeID)
+	}
+
+	tmp, err := os.MkdirTemp(nc.FileDir, "import-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmp)
+	// on-disk 狀態機啟動時同樣以 RecoverFromSnapshot 讀取 v3 主串流
+	smType := pb.ConcurrentStateMachine
+	if nc.OnDisk {
+		smType = pb.OnDiskStateMachine
+	}
+	record := pb.Snapshot{
+		Index:      archive.Index,
+		Term:       1,
+		ShardID:    nc.ClusterID,
+		Type:       smType,
+		Membership: pb.Membership{ConfigChangeId: archive.Index, Addresses: maps.Clone(members)},
+	}
+	// 封存的內容以 v3 snapshot 主串流寫入，作為狀態機資料
+	s, err := gbsnap.Create(tmp, record, nil, func(w io.Writer, _ statemachine.ISnapshotFileCollection) error {
+		return archive.WritePayload(w)
+	})
+	if err != nil {
+		return err
+	}
+	return tools.ImportSnapshot(nodeHostConfig(nc), s.Dir, members, nc.NodeID)
+}
@@ -0,0 +1,112 @@
+package raft_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"go-raft/internal/domain"
+	"go-raft/internal/raft"
+	"go-raft/internal/store"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestLedgerArchiveBootstrapsNewShard(t *testing.T) {
+	const srcCluster, dstCluster = uint64(201), uint64(202)
+	addr := "localhost:24100"
+	members := map[uint64]string{1: addr}
+
+	src, err := raft.New(raft.NodeConfig{FileDir: filepath.Join(t.TempDir(), "src"), RaftAddress: addr, NodeID: 1, ClusterID: srcCluster, InitialMembers: members})
+	if err != nil {
+		t.Fatalf("create source: %v", err)
+	}
+	if err := src.Start(); err != nil {
+		t.Fatalf("start source: %v", err)
+	}
+	defer func() {
+		if src != nil {
+			src.NodeHost.Close()
+		}
+	}()
+	waitForShardReady(t, src, srcCluster)
+
+	mustPropose(t, src, srcCluster, domain.Command{Type: domain.CommandCurrency, Currency: &domain.Currency{Code: "USD", Precision: 2, Enabled: true}})
+	for i := 0; i < 5; i++ {
+		mustPropose(t, src, srcCluster, domain.Command{Type: domain.CommandAccount, Account: &domain.AccountCommand{UID: fmt.Sprintf("user-%d", i), Action: domain.AccountOpen}})
+		mustPropose(t, src, srcCluster, domain.Command{Type: domain.CommandAsset, Asset: &domain.Asset{UID: fmt.Sprintf("user-%d", i), Currency: "USD", Amount: float64(i + 1)}})
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	if _, err := raft.CaptureLedger(ctx, src.NodeHost, srcCluster, 1<<40); !errors.Is(err, store.ErrIndexNotApplied) {
+		t.Fatalf("expected ErrIndexNotApplied for a future index, got %v", err)
+	}
+	content, err := raft.CaptureLedger(ctx, src.NodeHost, srcCluster, 0)
+	if err != nil {
+		t.Fatalf("capture: %v", err)
+	}
+	var buf bytes.Buffer
+	if err := store.WriteArchive(&buf, srcCluster, content); err != nil {
+		t.Fatalf("write archive: %v", err)
+	}
+	corrupted := bytes.Clone(buf.Bytes())
+	corrupted[len(corrupted)/2] ^= 0xff
+	if _, err := store.ReadArchive(bytes.NewReader(corrupted)); !errors.Is(err, store.ErrArchiveCorrupted) {
+		t.Fatalf("expected ErrArchiveCorrupted, got %v", err)
+	}
+	archive, err := store.ReadArchive(&buf)
+	if err != nil {
+		t.Fatalf("read archive: %v", err)
+	}
+	src.NodeHost.Close()
+	src = nil
+
+	// 以封存檔在新的目錄建立另一個 shard，啟動時不帶 InitialMembers
+	nc := raft.NodeConfig{FileDir: filepath.Join(t.TempDir(), "dst"), RaftAddress: addr, NodeID: 1, ClusterID: dstCluster}
+	if err := raft.ImportArchive(nc, archive, members); err != nil {
+		t.Fatalf("import: %v", err)
+	}
+	if err := raft.ImportArchive(nc, archive, members); !errors.Is(err, raft.ErrShardExists) {
+		t.Fatalf("expected ErrShardExists on second import, got %v", err)
+	}
+	dst, err := raft.New(nc)
+	if err != nil {
+		t.Fatalf("create restored node: %v", err)
+	}
+	if err := dst.Start(); err != nil {
+		t.Fatalf("start restored node: %v", err)
+	}
+	defer dst.NodeHost.Close()
+	waitForShardReady(t, dst, dstCluster)
+
+	// 新 shard 的 log 接在封存檔的 index 之後，帳戶、餘額與歷史查詢都延續
+	mustPropose(t, dst, dstCluster, domain.Command{Type: domain.CommandAsset, Asset: &domain.Asset{UID: "user-4", Currency: "USD", Amount: 10}})
+	ctx, cancel = context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	view, err := dst.NodeHost.SyncRead(ctx, dstCluster, domain.AccountQuery{UID: "user-4"})
+	if err != nil {
+		t.Fatalf("read account: %v", err)
+	}
+	if got := view.(domain.AccountView).Balances["USD"]; got != 15 {
+		t.Fatalf("user-4 USD balance %v, want 15", got)
+	}
+	before, err := dst.NodeHost.SyncRead(ctx, dstCluster, domain.BalanceQuery{UID: "user-4", Currency: "USD", AsOfIndex: archive.Index})
+	if err != nil {
+		t.Fatalf("read history: %v", err)
+	}
+	if before.(float64) != 5 {
+		t.Fatalf("user-4 USD balance at archive index %v, want 5", before)
+	}
+}
+
+func mustPropose(t *testing.T, rs *raft.RaftStore, clusterID uint64, cmd domain.Command) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	code, err := raft.Propose(ctx, rs.NodeHost, clusterID, cmd)
+	if err != nil || code != domain.ResultOK {
+		t.Fatalf("propose %v: code %v, err %v", cmd.Type, code, err)
+	}
+}
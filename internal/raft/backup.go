@@ -0,0 +1,285 @@
+package raft
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go-raft/internal/blobstore"
+	"go-raft/internal/gbsnap"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/lni/dragonboat/v4"
+	"github.com/sirupsen/logrus"
+)
+
+// BackupManifestName 為每份備份最後上傳的 manifest，存在代表該備份已完整上傳
+const BackupManifestName = "backup.json"
+
+// BackupConfig 為定期備份的設定
+type BackupConfig struct {
+	Interval  time.Duration // 備份間隔
+	Timeout   time.Duration // 單次備份（匯出、上傳與清除）的逾時，未設定時使用 Interval
+	ExportDir string        // 匯出 snapshot 的本機暫存目錄，上傳後即刪除
+	Prefix    string        // object key 前綴，實際 key 為 <Prefix>/<cluster>/snapshot-<index>/<file>
+	Keep      int           // 保留最近幾份完整備份，0 代表不依數量清除
+	MaxAge    time.Duration // 清除早於此時間的備份，0 代表不依時間清除；最新一份完整備份永遠保留
+}
+
+// BackupFile 為備份中單一檔案的資訊，路徑相對於 snapshot 目錄
+type BackupFile struct {
+	Name   string `json:"name"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// BackupManifest 描述一份已上傳的備份
+type BackupManifest struct {
+	ClusterID uint64       `json:"cluster_id"`
+	NodeID    uint64       `json:"node_id"`
+	Index     uint64       `json:"index"`
+	CreatedAt time.Time    `json:"created_at"`
+	Files     []BackupFile `json:"files"`
+}
+
+// BackupJob 定期以 SyncRequestSnapshot 匯出 snapshot 並上傳到 BlobStore，再依保留策略清除舊備份
+// 只有 leader 會執行備份，避免每個成員重複上傳同一個 shard
+type BackupJob struct {
+	nh        *dragonboat.NodeHost
+	clusterID uint64
+	nodeID    uint64
+	store     blobstore.BlobStore
+	cfg       BackupConfig
+	now       func() time.Time
+}
+
+func NewBackupJob(nh *dragonboat.NodeHost, clusterID, nodeID uint64, store blobstore.BlobStore, cfg BackupConfig) *BackupJob {
+	if cfg.Timeout == 0 {
+		cfg.Timeout = cfg.Interval
+	}
+	return &BackupJob{nh: nh, clusterID: clusterID, nodeID: nodeID, store: store, cfg: cfg, now: time.Now}
+}
+
+// Run 每隔 Interval 執行一次備份，直到 ctx 結束
+func (j *BackupJob) Run(ctx context.Context) {
+	ticker := time.NewTicker(j.cfg.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		runCtx, cancel := context.WithTimeout(ctx, j.cfg.Timeout)
+		m, err := j.RunOnce(runCtx)
+		cancel()
+		switch {
+		case err != nil:
+			logrus.WithFields(logrus.Fields{"ClusterID": j.clusterID, "error": err}).Warn("backup failed")
+		case m != nil:
+			logrus.WithFields(logrus.Fields{"ClusterID": j.clusterID, "index": m.Index, "files": len(m.Files)}).Info("backup uploaded")
+		}
+	}
+}
+
+// RunOnce 執行一次備份與清除，本節點不是 leader 或該 index 已備份過時回傳 nil manifest
+func (j *BackupJob) RunOnce(ctx context.Context) (*BackupManifest, error) {
+	leaderID, _, valid, err := j.nh.GetLeaderID(j.clusterID)
+	if err != nil {
+		return nil, err
+	}
+	if !valid || leaderID != j.nodeID {
+		return nil, nil
+	}
+
+	if err := os.MkdirAll(j.cfg.ExportDir, 0o755); err != nil {
+		return nil, err
+	}
+	tmp, err := os.MkdirTemp(j.cfg.ExportDir, "backup-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(tmp)
+	index, err := j.nh.SyncRequestSnapshot(ctx, j.clusterID, dragonboat.SnapshotOption{ExportPath: tmp, Exported: true})
+	if err != nil {
+		return nil, fmt.Errorf("export snapshot: %w", err)
+	}
+	s, err := gbsnap.Open(tmp)
+	if err != nil {
+		return nil, err
+	}
+	if s.Index != index {
+		return nil, fmt.Errorf("exported snapshot index %d, expected %d", s.Index, index)
+	}
+
+	backups, err := j.list(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var m *BackupManifest
+	if b, ok := backups[index]; !ok || !b.complete {
+		if m, err = j.upload(ctx, s); err != nil {
+			return nil, err
+		}
+		backups[index] = &backup{index: index, keys: m.keys(j.dir(index)), complete: true, modified: m.CreatedAt}
+	}
+	return m, j.prune(ctx, backups)
+}
+
+// dir 回傳某個 index 的備份在 BlobStore 中的目錄
+func (j *BackupJob) dir(index uint64) string {
+	return path.Join(j.root(), fmt.Sprintf("snapshot-%016X", index))
+}
+
+func (j *BackupJob) root() string {
+	return path.Join(j.cfg.Prefix, fmt.Sprint(j.clusterID))
+}
+
+// upload 依序上傳 snapshot 目錄中的所有檔案，最後才上傳 manifest
+func (j *BackupJob) upload(ctx context.Context, s *gbsnap.Snapshot) (*BackupManifest, error) {
+	m := &BackupManifest{ClusterID: j.clusterID, NodeID: j.nodeID, Index: s.Index, CreatedAt: j.now().UTC()}
+	dir := j.dir(s.Index)
+	err := filepath.WalkDir(s.Dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(s.Dir, p)
+		if err != nil {
+			return err
+		}
+		f, err := os.Open(p)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		info, err := f.Stat()
+		if err != nil {
+			return err
+		}
+		h := sha256.New()
+		name := filepath.ToSlash(rel)
+		if err := j.store.Put(ctx, path.Join(dir, name), io.TeeReader(f, h), info.Size()); err != nil {
+			return fmt.Errorf("upload %s: %w", name, err)
+		}
+		m.Files = append(m.Files, BackupFile{Name: name, Size: info.Size(), SHA256: hex.EncodeToString(h.Sum(nil))})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	if err := j.store.Put(ctx, path.Join(dir, BackupManifestName), bytes.NewReader(data), int64(len(data))); err != nil {
+		return nil, fmt.Errorf("upload manifest: %w", err)
+	}
+	return m, nil
+}
+
+func (m *BackupManifest) keys(dir string) []string {
+	keys := []string{path.Join(dir, BackupManifestName)}
+	for _, f := range m.Files {
+		keys = append(keys, path.Join(dir, f.Name))
+	}
+	return keys
+}
+
+// backup 為 BlobStore 中某個 index 的備份，manifest 不存在代表上傳中斷或仍在進行
+type backup struct {
+	index    uint64
+	keys     []string
+	complete bool
+	modified time.Time // manifest 的上傳時間
+}
+
+// list 依 snapshot 目錄分組列出本 shard 的所有備份
+func (j *BackupJob) list(ctx context.Context) (map[uint64]*backup, error) {
+	blobs, err := j.store.List(ctx, j.root()+"/")
+	if err != nil {
+		return nil, err
+	}
+	backups := make(map[uint64]*backup)
+	for _, blob := range blobs {
+		dir, name, ok := strings.Cut(strings.TrimPrefix(blob.Key, j.root()+"/"), "/")
+		if !ok {
+			continue
+		}
+		var index uint64
+		if _, err := fmt.Sscanf(dir, "snapshot-%X", &index); err != nil || dir != fmt.Sprintf("snapshot-%016X", index) {
+			continue
+		}
+		b, ok := backups[index]
+		if !ok {
+			b = &backup{index: index}
+			backups[index] = b
+		}
+		b.keys = append(b.keys, blob.Key)
+		if name == BackupManifestName {
+			b.complete, b.modified = true, blob.Modified
+		}
+	}
+	return backups, nil
+}
+
+// prune 保留最新的 Keep 份且未超過 MaxAge 的完整備份，最新一份完整備份永遠保留
+// 比最新完整備份更舊的未完成備份視為中斷的上傳，一併清除
+func (j *BackupJob) prune(ctx context.Context, backups map[uint64]*backup) error {
+	var complete []*backup
+	for _, b := range backups {
+		if b.complete {
+			complete = append(complete, b)
+		}
+	}
+	if len(complete) == 0 {
+		return nil
+	}
+	slices.SortFunc(complete, func(a, b *backup) int { return cmp.Compare(b.index, a.index) })
+	latest := complete[0].index
+
+	var expired []*backup
+	for i, b := range complete[1:] {
+		if (j.cfg.Keep > 0 && i+1 >= j.cfg.Keep) || (j.cfg.MaxAge > 0 && j.now().Sub(b.modified) > j.cfg.MaxAge) {
+			expired = append(expired, b)
+		}
+	}
+	for _, b := range backups {
+		if !b.complete && b.index < latest {
+			expired = append(expired, b)
+		}
+	}
+
+	var errs []error
+	for _, b := range expired {
+		// manifest 先刪除，刪除中途失敗時剩下的檔案會被視為未完成的備份，下次再清除
+		slices.SortStableFunc(b.keys, func(x, y string) int {
+			return cmp.Compare(manifestFirst(x), manifestFirst(y))
+		})
+		for _, key := range b.keys {
+			if err := j.store.Delete(ctx, key); err != nil {
+				errs = append(errs, fmt.Errorf("delete %s: %w", key, err))
+				break
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// manifestFirst 讓 manifest 排在同一份備份的其他檔案之前
+func manifestFirst(key string) int {
+	if path.Base(key) == BackupManifestName {
+		return 0
+	}
+	return 1
+}
@@ -0,0 +1,136 @@
+package raft_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go-raft/internal/blobstore"
+	"go-raft/internal/domain"
+	"go-raft/internal/gbsnap"
+	"go-raft/internal/raft"
+	"go-raft/internal/store"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBackupJobUploadsAndPrunes(t *testing.T) {
+	const clusterID = uint64(203)
+	addr := "localhost:24110"
+	rs, err := raft.New(raft.NodeConfig{FileDir: t.TempDir(), RaftAddress: addr, NodeID: 1, ClusterID: clusterID, InitialMembers: map[uint64]string{1: addr}})
+	if err != nil {
+		t.Fatalf("create node: %v", err)
+	}
+	if err := rs.Start(); err != nil {
+		t.Fatalf("start node: %v", err)
+	}
+	defer rs.NodeHost.Close()
+	waitForShardReady(t, rs, clusterID)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	bs, err := blobstore.NewLocalStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 較舊 index 的未完成備份（沒有 manifest）應在清除時一併移除
+	stray := "backups/203/snapshot-0000000000000001/snapshot-0000000000000001.gbsnap"
+	if err := bs.Put(ctx, stray, strings.NewReader("partial"), 7); err != nil {
+		t.Fatal(err)
+	}
+
+	job := raft.NewBackupJob(rs.NodeHost, clusterID, rs.NodeID, bs, raft.BackupConfig{
+		Interval:  time.Hour,
+		ExportDir: t.TempDir(),
+		Prefix:    "backups",
+		Keep:      2,
+	})
+	var manifests []*raft.BackupManifest
+	for i := 0; i < 3; i++ {
+		mustPropose(t, rs, clusterID, domain.Command{Type: domain.CommandCurrency, Currency: &domain.Currency{Code: fmt.Sprintf("C%d", i), Precision: 2, Enabled: true}})
+		m, err := job.RunOnce(ctx)
+		if err != nil {
+			t.Fatalf("backup %d: %v", i, err)
+		}
+		if m == nil {
+			t.Fatalf("backup %d skipped", i)
+		}
+		manifests = append(manifests, m)
+	}
+	// index 沒有前進時不重複上傳
+	if m, err := job.RunOnce(ctx); err != nil || m != nil {
+		t.Fatalf("repeat backup: manifest %v, err %v", m, err)
+	}
+
+	blobs, err := bs.List(ctx, "backups/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dirs := make(map[string]bool)
+	for _, b := range blobs {
+		dirs[filepath.Dir(b.Key)] = true
+	}
+	want := map[string]bool{
+		fmt.Sprintf("backups/203/snapshot-%016X", manifests[1].Index): true,
+		fmt.Sprintf("backups/203/snapshot-%016X", manifests[2].Index): true,
+	}
+	if fmt.Sprint(dirs) != fmt.Sprint(want) {
+		t.Fatalf("remaining backups %v, want %v", dirs, want)
+	}
+
+	// 下載最新的備份並以 manifest 核對後，應能還原出最後註冊的幣別
+	latest := manifests[2]
+	dir := filepath.Join(t.TempDir(), fmt.Sprintf("snapshot-%016X", latest.Index))
+	r, err := bs.Get(ctx, fmt.Sprintf("backups/203/snapshot-%016X/%s", latest.Index, raft.BackupManifestName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var uploaded raft.BackupManifest
+	err = json.NewDecoder(r).Decode(&uploaded)
+	r.Close()
+	if err != nil || uploaded.Index != latest.Index || len(uploaded.Files) != len(latest.Files) {
+		t.Fatalf("uploaded manifest %+v, err %v", uploaded, err)
+	}
+	for _, f := range uploaded.Files {
+		r, err := bs.Get(ctx, fmt.Sprintf("backups/203/snapshot-%016X/%s", latest.Index, f.Name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := io.ReadAll(r)
+		r.Close()
+		if err != nil || int64(len(data)) != f.Size {
+			t.Fatalf("download %s: %d bytes, err %v", f.Name, len(data), err)
+		}
+		p := filepath.Join(dir, filepath.FromSlash(f.Name))
+		if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, data, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s, err := gbsnap.Open(dir)
+	if err != nil {
+		t.Fatalf("open downloaded backup: %v", err)
+	}
+	_, pr, err := s.Read()
+	if err != nil {
+		t.Fatal(err)
+	}
+	content, err := store.DecodeSnapshot(pr, s.Files, nil)
+	if err = errors.Join(err, pr.Close()); err != nil {
+		t.Fatalf("decode downloaded backup: %v", err)
+	}
+	var codes bytes.Buffer
+	for _, c := range content.Meta.Currencies {
+		codes.WriteString(c.Code)
+	}
+	if codes.String() != "C0C1C2" {
+		t.Fatalf("restored currencies %q", codes.String())
+	}
+}
@@ -0,0 +1,172 @@
+package raft
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"go-raft/internal/domain"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/VictoriaMetrics/metrics"
+	"github.com/lni/dragonboat/v4"
+	"github.com/sirupsen/logrus"
+)
+
+// checksumReportBuffer 為等待回報的本機 checksum 結果數量，回報迴圈忙碌時多出的結果會被捨棄
+const checksumReportBuffer = 4
+
+// ChecksumMonitor 查詢各副本回報的狀態摘要，找出結果不一致的 index 與 key
+type ChecksumMonitor struct {
+	nh        *dragonboat.NodeHost
+	clusterID uint64
+}
+
+func NewChecksumMonitor(nh *dragonboat.NodeHost, clusterID uint64) *ChecksumMonitor {
+	return &ChecksumMonitor{nh: nh, clusterID: clusterID}
+}
+
+// Request 提案一次 checksum，所有副本於套用後計算狀態摘要並回報
+func (m *ChecksumMonitor) Request(ctx context.Context) (domain.ResultCode, error) {
+	return Propose(ctx, m.nh, m.clusterID, domain.Command{Type: domain.CommandChecksum})
+}
+
+// Status 回傳保留的 checksum 結果與目前成員
+func (m *ChecksumMonitor) Status(ctx context.Context) (domain.ChecksumStatus, error) {
+	members, err := shardMembers(ctx, m.nh, m.clusterID)
+	if err != nil {
+		return domain.ChecksumStatus{}, err
+	}
+	result, err := m.nh.SyncRead(ctx, m.clusterID, domain.ChecksumQuery{})
+	if err != nil {
+		return domain.ChecksumStatus{}, fmt.Errorf("raft read failed: %w", err)
+	}
+	rounds, ok := result.([]domain.ChecksumRound)
+	if !ok {
+		return domain.ChecksumStatus{}, errors.New("invalid data format from raft")
+	}
+	if rounds == nil {
+		rounds = []domain.ChecksumRound{}
+	}
+	return domain.ChecksumStatus{Rounds: rounds, Members: members, Diverged: lastDiverged(rounds)}, nil
+}
+
+// Balances 回傳本節點上幣別在 index 套用後的所有非零餘額，index 需仍在歷史餘額的保留範圍內
+// 以本機狀態回答，比對時需分別向各節點查詢
+func (m *ChecksumMonitor) Balances(currency string, index uint64) (map[string]float64, error) {
+	result, err := m.nh.StaleRead(m.clusterID, domain.ChecksumBalancesQuery{Currency: currency, Index: index})
+	if err != nil {
+		return nil, err
+	}
+	balances, ok := result.(map[string]float64)
+	if !ok {
+		return nil, errors.New("invalid data format from raft")
+	}
+	return balances, nil
+}
+
+// lastDiverged 回傳最近一次結果不一致的 index，沒有時回傳 0
+func lastDiverged(rounds []domain.ChecksumRound) uint64 {
+	for i := len(rounds) - 1; i >= 0; i-- {
+		if rounds[i].Diverged {
+			return rounds[i].Index
+		}
+	}
+	return 0
+}
+
+// runChecksums 定期由 leader 提案 checksum，並將本節點的計算結果提案回報；每次都以本機狀態更新不一致的指標
+// NodeHost 關閉或 shard 移除後結束
+func (rs *RaftStore) runChecksums() {
+	ticker := time.NewTicker(rs.checksumInterval)
+	defer ticker.Stop()
+	monitor := NewChecksumMonitor(rs.NodeHost, rs.ClusterID)
+	for {
+		select {
+		case r := <-rs.checksums:
+			if !rs.reportChecksum(r) {
+				return
+			}
+		case <-ticker.C:
+			leaderID, _, valid, err := rs.NodeHost.GetLeaderID(rs.ClusterID)
+			if err != nil {
+				return
+			}
+			if valid && leaderID == rs.NodeID {
+				ctx, cancel := context.WithTimeout(context.Background(), advertiseTimeout)
+				_, err := monitor.Request(ctx)
+				cancel()
+				if err != nil {
+					logrus.WithFields(logrus.Fields{"ClusterID": rs.ClusterID, "error": err}).Warn("checksum request failed")
+				}
+			}
+		}
+		rs.observeChecksums()
+	}
+}
+
+// reportChecksum 提案本節點的計算結果，回傳 false 代表 NodeHost 已關閉
+// 提案失敗時捨棄此結果，下一次 checksum 會再回報
+func (rs *RaftStore) reportChecksum(r domain.ChecksumReport) bool {
+	ctx, cancel := context.WithTimeout(context.Background(), advertiseTimeout)
+	code, err := Propose(ctx, rs.NodeHost, rs.ClusterID, domain.Command{Type: domain.CommandChecksumReport, Checksum: &r})
+	cancel()
+	switch {
+	case errors.Is(err, dragonboat.ErrClosed), errors.Is(err, dragonboat.ErrShardNotFound):
+		return false
+	case err != nil:
+		logrus.WithFields(logrus.Fields{"ClusterID": rs.ClusterID, "index": r.Index, "error": err}).Warn("checksum report failed")
+	case code == domain.ResultChecksumExpired:
+		// 計算或提案耗時過久，round 已被較新的 checksum 擠出保留範圍
+		logrus.WithFields(logrus.Fields{"ClusterID": rs.ClusterID, "index": r.Index}).Info("checksum round expired, report dropped")
+	case code != domain.ResultOK:
+		logrus.WithFields(logrus.Fields{"ClusterID": rs.ClusterID, "index": r.Index, "code": code}).Warn("checksum report rejected")
+	}
+	return true
+}
+
+// observeChecksums 以本機狀態檢查最近的 checksum 結果，發現新的不一致時紀錄錯誤並更新指標
+func (rs *RaftStore) observeChecksums() {
+	result, err := rs.NodeHost.StaleRead(rs.ClusterID, domain.ChecksumQuery{})
+	if err != nil {
+		return
+	}
+	rounds, _ := result.([]domain.ChecksumRound)
+	index := lastDiverged(rounds)
+	diverged := checksumDiverged(rs.ClusterID)
+	if index <= diverged.Load() {
+		return
+	}
+	diverged.Store(index)
+	metrics.GetOrCreateCounter(fmt.Sprintf(`raft_checksum_divergences_total{shard="%d"}`, rs.ClusterID)).Inc()
+	for _, round := range rounds {
+		if round.Index == index {
+			logrus.WithFields(logrus.Fields{"ClusterID": rs.ClusterID, "index": index, "sections": round.Sections()}).Error("replica state diverged")
+		}
+	}
+}
+
+// checksumShards 為各 shard 最近一次結果不一致的 index
+var checksumShards sync.Map
+
+// checksumDiverged 回傳 shard 最近一次結果不一致的 index，第一次取得時註冊 raft_checksum_diverged_index 指標
+func checksumDiverged(clusterID uint64) *atomic.Uint64 {
+	v, loaded := checksumShards.LoadOrStore(clusterID, new(atomic.Uint64))
+	index := v.(*atomic.Uint64)
+	if !loaded {
+		metrics.GetOrCreateGauge(fmt.Sprintf(`raft_checksum_diverged_index{shard="%d"}`, clusterID), func() float64 {
+			return float64(index.Load())
+		})
+	}
+	return index
+}
+
+// sendChecksum 將本節點的計算結果交給回報迴圈，迴圈忙碌時捨棄
+func sendChecksum(reports chan<- domain.ChecksumReport, r domain.ChecksumReport) {
+	select {
+	case reports <- r:
+	default:
+		logrus.WithFields(logrus.Fields{"NodeID": r.NodeID, "index": r.Index}).Warn("checksum report dropped")
+	}
+}
@@ -0,0 +1,171 @@
+package raft_test
+
+import (
+	"context"
+	"fmt"
+	"go-raft/internal/configs"
+	"go-raft/internal/domain"
+	"go-raft/internal/raft"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"testing"
+	"time"
+)
+
+// TestChecksumDetectsDivergence 記憶體與 Pebble 上的副本對相同狀態回報相同的摘要，不同的回報會標記為不一致並指出區段
+func TestChecksumDetectsDivergence(t *testing.T) {
+	const clusterID = uint64(206)
+	members := map[uint64]string{1: "localhost:24140", 2: "localhost:24141"}
+	var nodes []*raft.RaftStore
+	for nodeID := uint64(1); nodeID <= 2; nodeID++ {
+		rs, err := raft.New(raft.NodeConfig{
+			FileDir:          filepath.Join(t.TempDir(), fmt.Sprint(nodeID)),
+			RaftAddress:      members[nodeID],
+			NodeID:           nodeID,
+			ClusterID:        clusterID,
+			InitialMembers:   members,
+			OnDisk:           nodeID == 2,
+			ChecksumInterval: time.Hour,
+		})
+		if err != nil {
+			t.Fatalf("create node %d: %v", nodeID, err)
+		}
+		if err := rs.Start(); err != nil {
+			t.Fatalf("start node %d: %v", nodeID, err)
+		}
+		defer rs.NodeHost.Close()
+		nodes = append(nodes, rs)
+	}
+	for _, rs := range nodes {
+		waitForShardReady(t, rs, clusterID)
+	}
+	leader, err := findLeaderWithRaftStore(nodes, clusterID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mustPropose(t, leader, clusterID, domain.Command{Type: domain.CommandCurrency, Currency: &domain.Currency{Code: "USD", Precision: 2, Enabled: true}})
+	for i := 0; i < 4; i++ {
+		uid := fmt.Sprintf("user-%d", i)
+		mustPropose(t, leader, clusterID, domain.Command{Type: domain.CommandAccount, Account: &domain.AccountCommand{UID: uid, Action: domain.AccountOpen}})
+		mustPropose(t, leader, clusterID, domain.Command{Type: domain.CommandAsset, Asset: &domain.Asset{UID: uid, Currency: "USD", Amount: float64(i)}})
+	}
+
+	monitor := raft.NewChecksumMonitor(leader.NodeHost, clusterID)
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	if code, err := monitor.Request(ctx); err != nil || code != domain.ResultOK {
+		t.Fatalf("request checksum: %v, %v", code, err)
+	}
+	round := waitForChecksumReports(t, monitor, 2)
+	if round.Diverged || round.Reports[0].Digest != round.Reports[1].Digest {
+		t.Fatalf("memory and disk replicas disagree: %+v", round)
+	}
+
+	// 以不存在的節點回報不同的餘額摘要，模擬副本不一致
+	bad := round.Reports[0]
+	bad.NodeID, bad.Digest = 3, "bad"
+	bad.Sections = map[string]string{}
+	for name, digest := range round.Reports[0].Sections {
+		bad.Sections[name] = digest
+	}
+	bad.Sections["balance/USD"] = "bad"
+	mustPropose(t, leader, clusterID, domain.Command{Type: domain.CommandChecksumReport, Checksum: &bad})
+	status, err := monitor.Status(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.Diverged != round.Index {
+		t.Fatalf("diverged index %d, want %d", status.Diverged, round.Index)
+	}
+	last := status.Rounds[len(status.Rounds)-1]
+	if sections := last.Sections(); !reflect.DeepEqual(sections, []string{"balance/USD"}) {
+		t.Fatalf("diverged sections %v", sections)
+	}
+
+	// 各節點在 checksum index 的餘額供比對 key，零餘額不列入
+	want := map[string]float64{"user-1": 1, "user-2": 2, "user-3": 3}
+	for _, rs := range nodes {
+		balances, err := raft.NewChecksumMonitor(rs.NodeHost, clusterID).Balances("USD", round.Index)
+		if err != nil || !reflect.DeepEqual(balances, want) {
+			t.Fatalf("node %d balances at %d: %v, %v", rs.NodeID, round.Index, balances, err)
+		}
+	}
+}
+
+// TestChecksumReportToExpiredRound 回報已被擠出保留範圍的 round 時回傳 ResultChecksumExpired，保留的結果不變
+func TestChecksumReportToExpiredRound(t *testing.T) {
+	const clusterID = uint64(213)
+	addr := "localhost:24200"
+	rs, err := raft.New(raft.NodeConfig{FileDir: t.TempDir(), RaftAddress: addr, NodeID: 1, ClusterID: clusterID, InitialMembers: map[uint64]string{1: addr}, ChecksumInterval: time.Hour})
+	if err != nil {
+		t.Fatalf("create node: %v", err)
+	}
+	if err := rs.Start(); err != nil {
+		t.Fatalf("start node: %v", err)
+	}
+	defer rs.NodeHost.Close()
+	waitForShardReady(t, rs, clusterID)
+
+	monitor := raft.NewChecksumMonitor(rs.NodeHost, clusterID)
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	var first uint64
+	for i := range configs.ChecksumRounds + 1 {
+		if code, err := monitor.Request(ctx); err != nil || code != domain.ResultOK {
+			t.Fatalf("request checksum: %v, %v", code, err)
+		}
+		if i == 0 {
+			status, err := monitor.Status(ctx)
+			if err != nil || len(status.Rounds) != 1 {
+				t.Fatalf("first round: %+v, %v", status, err)
+			}
+			first = status.Rounds[0].Index
+		}
+	}
+	before, err := monitor.Status(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(before.Rounds) != configs.ChecksumRounds {
+		t.Fatalf("kept %d rounds, want %d", len(before.Rounds), configs.ChecksumRounds)
+	}
+
+	// 第一次 checksum 已被較新的 round 擠出
+	if before.Rounds[0].Index <= first {
+		t.Fatalf("round %d still kept: %+v", first, before.Rounds[0])
+	}
+	late := domain.ChecksumReport{NodeID: 1, Index: first, Digest: "late"}
+	code, err := raft.Propose(ctx, rs.NodeHost, clusterID, domain.Command{Type: domain.CommandChecksumReport, Checksum: &late})
+	if err != nil || code != domain.ResultChecksumExpired {
+		t.Fatalf("late report: %v, %v, want %v", code, err, domain.ResultChecksumExpired)
+	}
+	after, err := monitor.Status(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, round := range after.Rounds {
+		if round.Index != before.Rounds[i].Index || slices.ContainsFunc(round.Reports, func(r domain.ChecksumReport) bool { return r.Digest == "late" }) {
+			t.Fatalf("round %d changed by expired report: %+v", i, round)
+		}
+	}
+}
+
+// waitForChecksumReports 等待最近一次 checksum 收到 n 個節點的回報
+func waitForChecksumReports(t *testing.T, monitor *raft.ChecksumMonitor, n int) domain.ChecksumRound {
+	t.Helper()
+	for range 20 {
+		ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+		status, err := monitor.Status(ctx)
+		cancel()
+		if err == nil && len(status.Rounds) > 0 {
+			if round := status.Rounds[len(status.Rounds)-1]; len(round.Reports) == n {
+				return round
+			}
+		}
+		time.Sleep(500 * time.Millisecond)
+	}
+	t.Fatalf("checksum did not receive %d reports", n)
+	return domain.ChecksumRound{}
+}
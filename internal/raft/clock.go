@@ -0,0 +1,61 @@
+package raft
+
+import (
+	"context"
+	"errors"
+	"go-raft/internal/configs"
+	"go-raft/internal/domain"
+	"time"
+
+	"github.com/lni/dragonboat/v4"
+	"github.com/sirupsen/logrus"
+)
+
+// runClock 由 leader 維持狀態機中的 leader 時間：上次寫入後有 entry 套用且 leader 時間落後本機時鐘超過 ClockTick 時寫入目前時間
+// 非 leader 提案的時間以 leader 時間加上 MaxClockSkew 為上限，只有 follower 接受請求時也不會停在舊的時間；沒有新的 entry 時不寫入
+// NodeHost 關閉或 shard 移除後結束
+func (rs *RaftStore) runClock() {
+	ticker := time.NewTicker(configs.ClockTick)
+	defer ticker.Stop()
+	var seen uint64
+	for range ticker.C {
+		leaderID, _, valid, err := rs.NodeHost.GetLeaderID(rs.ClusterID)
+		if err != nil {
+			return
+		}
+		if !valid || leaderID != rs.NodeID {
+			continue
+		}
+		clock, err := rs.clock()
+		if err != nil || clock.Applied == seen || time.Now().UnixNano()-clock.Leader < int64(configs.ClockTick) {
+			continue
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), advertiseTimeout)
+		_, err = Propose(ctx, rs.NodeHost, rs.ClusterID, domain.Command{Type: domain.CommandClock})
+		cancel()
+		switch {
+		case errors.Is(err, dragonboat.ErrClosed), errors.Is(err, dragonboat.ErrShardNotFound):
+			return
+		case err != nil:
+			logrus.WithFields(logrus.Fields{"ClusterID": rs.ClusterID, "error": err}).Warn("clock proposal failed")
+			continue
+		}
+		// 本次寫入的 entry 之後沒有新的 entry 時不再寫入
+		if clock, err = rs.clock(); err == nil {
+			seen = clock.Applied
+		}
+	}
+}
+
+// clock 以本機狀態機回傳最後套用的 index 與提交時間
+func (rs *RaftStore) clock() (domain.Clock, error) {
+	result, err := rs.NodeHost.StaleRead(rs.ClusterID, domain.ClockQuery{})
+	if err != nil {
+		return domain.Clock{}, err
+	}
+	clock, ok := result.(domain.Clock)
+	if !ok {
+		return domain.Clock{}, errors.New("invalid data format from raft")
+	}
+	return clock, nil
+}
@@ -0,0 +1,117 @@
+package raft
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"go-raft/internal/domain"
+	"time"
+
+	"github.com/lni/dragonboat/v4"
+)
+
+// membershipTimeout 查詢成員清單需經 leader 確認，叢集失去多數時不等待過久
+const membershipTimeout = time.Second
+
+// ErrNotReady 節點尚未可以服務請求
+var ErrNotReady = errors.New("node not ready")
+
+// ClusterMonitor 查詢本節點在 shard 中的狀態，供健康檢查與叢集狀態 API 使用
+type ClusterMonitor struct {
+	nh        *dragonboat.NodeHost
+	clusterID uint64
+}
+
+func NewClusterMonitor(nh *dragonboat.NodeHost, clusterID uint64) *ClusterMonitor {
+	return &ClusterMonitor{nh: nh, clusterID: clusterID}
+}
+
+// Status 回傳本節點看到的 shard 狀態，除成員清單外只讀取本機資料，叢集失去多數時仍可回應
+// 成員清單無法經 leader 確認時改用本機目前的設定
+func (m *ClusterMonitor) Status(ctx context.Context) (domain.ClusterStatus, error) {
+	status, err := m.local()
+	if err != nil {
+		return status, err
+	}
+	ctx, cancel := context.WithTimeout(ctx, membershipTimeout)
+	defer cancel()
+	if membership, err := m.nh.SyncGetShardMembership(ctx, m.clusterID); err == nil {
+		status.Members = make(map[uint64]string, len(membership.Nodes)+len(membership.NonVotings)+len(membership.Witnesses))
+		for _, nodes := range []map[uint64]string{membership.Nodes, membership.NonVotings, membership.Witnesses} {
+			for id, addr := range nodes {
+				status.Members[id] = addr
+			}
+		}
+	}
+	return status, nil
+}
+
+// Ready 檢查 shard 已載入、已知 leader 且套用進度落後 commit 不超過 maxLag 筆，未就緒時回傳 ErrNotReady 與原因
+// 只讀取本機資料，不經 leader 確認成員清單，Members 為本機目前的設定
+func (m *ClusterMonitor) Ready(maxLag uint64) (domain.ClusterStatus, error) {
+	status, err := m.local()
+	if err != nil {
+		return status, err
+	}
+	if status.LeaderID == 0 {
+		return status, fmt.Errorf("%w: leader unknown", ErrNotReady)
+	}
+	if lag := status.Lag(); lag > maxLag {
+		return status, fmt.Errorf("%w: applied %d is %d entries behind commit %d", ErrNotReady, status.Applied, lag, status.Committed)
+	}
+	return status, nil
+}
+
+// local 以本機資料組出 shard 狀態，成員清單取自本機目前的設定
+func (m *ClusterMonitor) local() (domain.ClusterStatus, error) {
+	info := m.nh.GetNodeHostInfo(dragonboat.NodeHostInfoOption{SkipLogInfo: true})
+	if info == nil {
+		return domain.ClusterStatus{}, dragonboat.ErrClosed
+	}
+	var shard *dragonboat.ShardInfo
+	for i := range info.ShardInfoList {
+		if info.ShardInfoList[i].ShardID == m.clusterID {
+			shard = &info.ShardInfoList[i]
+		}
+	}
+	if shard == nil {
+		return domain.ClusterStatus{}, fmt.Errorf("%w: shard %d not loaded", ErrNotReady, m.clusterID)
+	}
+	status := domain.ClusterStatus{
+		ShardID: m.clusterID,
+		NodeID:  shard.ReplicaID,
+		Role:    domain.RoleFollower,
+		Members: shard.Replicas,
+	}
+	leaderID, term, valid, err := m.nh.GetLeaderID(m.clusterID)
+	if err != nil {
+		return domain.ClusterStatus{}, err
+	}
+	if valid {
+		status.LeaderID = leaderID
+	}
+	status.Term = term
+	switch {
+	case shard.IsWitness:
+		status.Role = domain.RoleWitness
+	case shard.IsNonVoting:
+		status.Role = domain.RoleNonVoting
+	case valid && leaderID == shard.ReplicaID:
+		status.Role = domain.RoleLeader
+	}
+
+	reader, err := m.nh.GetLogReader(m.clusterID)
+	if err != nil {
+		return domain.ClusterStatus{}, err
+	}
+	state, _ := reader.NodeState()
+	status.Committed = state.Commit
+	status.Snapshot = reader.Snapshot().Index
+
+	result, err := m.nh.StaleRead(m.clusterID, domain.AppliedQuery{})
+	if err != nil {
+		return domain.ClusterStatus{}, fmt.Errorf("read applied index failed: %w", err)
+	}
+	status.Applied, _ = result.(uint64)
+	return status, nil
+}
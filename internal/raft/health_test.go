@@ -0,0 +1,55 @@
+package raft_test
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"go-raft/internal/domain"
+	"go-raft/internal/raft"
+)
+
+// TestClusterMonitorStatusAndReady 單節點 shard 選出 leader 並套用完 entry 後就緒，未載入的 shard 回報未就緒
+func TestClusterMonitorStatusAndReady(t *testing.T) {
+	const clusterID = uint64(208)
+	addr := "localhost:24150"
+	rs, err := raft.New(raft.NodeConfig{FileDir: t.TempDir(), RaftAddress: addr, NodeID: 1, ClusterID: clusterID, InitialMembers: map[uint64]string{1: addr}})
+	if err != nil {
+		t.Fatalf("create node: %v", err)
+	}
+	if err := rs.Start(); err != nil {
+		t.Fatalf("start node: %v", err)
+	}
+	defer rs.NodeHost.Close()
+	waitForShardReady(t, rs, clusterID)
+	mustPropose(t, rs, clusterID, domain.Command{Type: domain.CommandCurrency, Currency: &domain.Currency{Code: "USD", Precision: 2, Enabled: true}})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	monitor := raft.NewClusterMonitor(rs.NodeHost, clusterID)
+	// 背景的支援版本回報可能剛 commit 尚未套用，容許少量落後
+	status, err := monitor.Ready(10)
+	if err != nil {
+		t.Fatalf("ready: %+v, %v", status, err)
+	}
+	if status.Role != domain.RoleLeader || status.LeaderID != 1 || status.Term == 0 || status.Applied == 0 {
+		t.Fatalf("unexpected status %+v", status)
+	}
+	if !reflect.DeepEqual(status.Members, map[uint64]string{1: addr}) {
+		t.Fatalf("local members %v", status.Members)
+	}
+	// Status 的成員清單經 leader 確認
+	status, err = monitor.Status(ctx)
+	if err != nil {
+		t.Fatalf("status: %v", err)
+	}
+	if !reflect.DeepEqual(status.Members, map[uint64]string{1: addr}) || status.Applied == 0 {
+		t.Fatalf("unexpected status %+v", status)
+	}
+
+	if _, err := raft.NewClusterMonitor(rs.NodeHost, 999).Ready(0); !errors.Is(err, raft.ErrNotReady) {
+		t.Fatalf("expected ErrNotReady for unknown shard, got %v", err)
+	}
+}
@@ -1,7 +1,11 @@
 package raft
 
 import (
-	"log"
+	"fmt"
+	"go-raft/internal/configs"
+	"go-raft/internal/domain"
+	"path/filepath"
+	"time"
 
 	"github.com/lni/dragonboat/v4"
 	"github.com/lni/dragonboat/v4/config"
@@ -18,6 +22,12 @@ type RaftStore struct {
 	RaftAddress    string               // Raft 傳輸的位址
 	Join           bool                 //
 	initialMembers map[uint64]string
+	retention      uint64 // 歷史餘額保留的 raft index 數量
+	snapshotPolicy SnapshotPolicy
+	onDisk         bool // 使用 Pebble 上的 AssetDiskStateMachine
+	// checksumInterval 為 leader 提案 checksum 的間隔，checksums 接收狀態機計算出的本節點結果
+	checksumInterval time.Duration
+	checksums        chan domain.ChecksumReport
 }
 
 // Config 定義啟動 NodeHost 的參數
@@ -29,6 +39,14 @@ type NodeConfig struct {
 	ClusterID      uint64 // 集群 ID
 	Join           bool   //
 	InitialMembers map[uint64]string
+	// HistoryRetention 歷史餘額保留的 raft index 數量，未設定時使用 configs.HistoryRetention
+	HistoryRetention uint64
+	// SnapshotPolicy 本 shard 的 snapshot 與 log 壓縮策略，未設定的欄位使用 configs 中的預設值
+	SnapshotPolicy SnapshotPolicy
+	// OnDisk 帳本存放在 Pebble 而非記憶體，查詢 API 相同；同一個 FileDir 不可在兩種模式間切換
+	OnDisk bool
+	// ChecksumInterval 比對各副本狀態摘要的間隔，未設定時使用 configs.ChecksumInterval
+	ChecksumInterval time.Duration
 }
 
 // New 建立 RaftStore 實例，支援多節點參數傳入
@@ -44,18 +62,21 @@ type NodeConfig struct {
 func New(nc NodeConfig) (*RaftStore, error) {
 	logger.GetLogger("raft").SetLevel(logger.DEBUG)
 
-	nh, err := dragonboat.NewNodeHost(config.NodeHostConfig{
-		WALDir:         nc.FileDir,
-		NodeHostDir:    nc.FileDir,
-		RaftAddress:    nc.RaftAddress,
-		RTTMillisecond: 200,
-		Expert:         config.ExpertConfig{ /*你的設定*/ },
-	})
+	nh, err := dragonboat.NewNodeHost(nodeHostConfig(nc))
 	if err != nil {
 		return nil, err
 	}
 
-	log.Printf("NodeHost started at %s (NodeID %d, ClusterID %d)\n", nc.RaftAddress, nc.NodeID, nc.ClusterID)
+	logrus.WithFields(logrus.Fields{"addr": nc.RaftAddress, "NodeID": nc.NodeID, "ClusterID": nc.ClusterID}).Info("NodeHost started")
+
+	retention := nc.HistoryRetention
+	if retention == 0 {
+		retention = configs.HistoryRetention
+	}
+	checksumInterval := nc.ChecksumInterval
+	if checksumInterval == 0 {
+		checksumInterval = configs.ChecksumInterval
+	}
 
 	return &RaftStore{
 		NodeHost:       nh,
@@ -65,9 +86,30 @@ func New(nc NodeConfig) (*RaftStore, error) {
 		ClusterID:      nc.ClusterID,
 		Join:           nc.Join,
 		initialMembers: nc.InitialMembers,
+		retention:      retention,
+		snapshotPolicy: nc.SnapshotPolicy.withDefaults(),
+		onDisk:         nc.OnDisk,
+
+		checksumInterval: checksumInterval,
+		checksums:        make(chan domain.ChecksumReport, checksumReportBuffer),
 	}, nil
 }
 
+// nodeHostConfig 回傳 NodeHost 設定，離線匯入 snapshot 時也必須使用相同設定
+func nodeHostConfig(nc NodeConfig) config.NodeHostConfig {
+	return config.NodeHostConfig{
+		WALDir:         nc.FileDir,
+		NodeHostDir:    nc.FileDir,
+		RaftAddress:    nc.RaftAddress,
+		RTTMillisecond: 200,
+		// EnableMetrics 讓 dragonboat 將 raft 內部指標寫入預設的指標集合，與 /metrics 一同輸出
+		EnableMetrics:       true,
+		RaftEventListener:   raftEvents{},
+		SystemEventListener: raftEvents{},
+		Expert:              config.ExpertConfig{ /*你的設定*/ },
+	}
+}
+
 func (rs *RaftStore) Start() error {
 	logrus.WithFields(logrus.Fields{"Join": rs.Join}).Info("Start")
 
@@ -79,20 +121,69 @@ func (rs *RaftStore) Start() error {
 		initialMembers = nil // Join 模式不需要
 	}
 
-	return rs.NodeHost.StartConcurrentReplica(
-		initialMembers, // ✅ 正確傳入 cluster 成員
-		rs.Join,
-		func(clusterID, nodeID uint64) statemachine.IConcurrentStateMachine {
-			return NewAssetRaftConcurrentMachine(clusterID, nodeID)
-		},
-		config.Config{
-			ElectionRTT:        10,
-			HeartbeatRTT:       1,
-			ReplicaID:          rs.NodeID,
-			ShardID:            rs.ClusterID,
-			CheckQuorum:        true,
-			SnapshotEntries:    10,
-			CompactionOverhead: 5,
-		},
-	)
+	cfg := config.Config{
+		ElectionRTT:        10,
+		HeartbeatRTT:       1,
+		ReplicaID:          rs.NodeID,
+		ShardID:            rs.ClusterID,
+		CheckQuorum:        true,
+		SnapshotEntries:    rs.snapshotPolicy.Entries,
+		CompactionOverhead: rs.snapshotPolicy.CompactionOverhead,
+	}
+	machines := make(chan progressSource, 1)
+	var err error
+	if rs.onDisk {
+		err = rs.NodeHost.StartOnDiskReplica(
+			initialMembers,
+			rs.Join,
+			func(clusterID, nodeID uint64) statemachine.IOnDiskStateMachine {
+				sm := NewAssetDiskStateMachine(clusterID, nodeID, rs.pebbleDir(clusterID, nodeID), rs.retention).(*AssetDiskStateMachine)
+				sm.checksums, sm.host = rs.checksums, rs.NodeHost.ID()
+				machines <- sm
+				return sm
+			},
+			cfg,
+		)
+	} else {
+		err = rs.NodeHost.StartConcurrentReplica(
+			initialMembers, // ✅ 正確傳入 cluster 成員
+			rs.Join,
+			func(clusterID, nodeID uint64) statemachine.IConcurrentStateMachine {
+				sm := NewAssetRaftConcurrentMachine(clusterID, nodeID, rs.snapshotFileDir(clusterID, nodeID), rs.retention).(*AssetConcurrentStateMachine)
+				sm.checksums, sm.host = rs.checksums, rs.NodeHost.ID()
+				machines <- sm
+				return sm
+			},
+			cfg,
+		)
+	}
+	if err != nil {
+		return err
+	}
+	// 筆數條件由 dragonboat 處理，時間與 log 大小條件由背景工作檢查
+	go func() { rs.runSnapshotPolicy(<-machines) }()
+	// 回報本節點支援的格式版本，供升級控制器判斷是否可以啟用新格式
+	go rs.advertiseSupport()
+	// 定期比對各副本的狀態摘要
+	go rs.runChecksums()
+	// 有非 leader 提案時由 leader 寫入目前時間
+	go rs.runClock()
+	// 定期更新各幣別的帳戶數與總額指標
+	go rs.runStoreMetrics()
+	return nil
+}
+
+// pebbleDir 回傳 AssetDiskStateMachine 的 Pebble 資料目錄
+func (rs *RaftStore) pebbleDir(clusterID, nodeID uint64) string {
+	return pebbleDir(rs.FileDir, clusterID, nodeID)
+}
+
+func pebbleDir(fileDir string, clusterID, nodeID uint64) string {
+	return filepath.Join(fileDir, "sm-pebble", fmt.Sprintf("%d-%d", clusterID, nodeID))
+}
+
+// snapshotFileDir 回傳狀態機寫出 snapshot 外部檔案的目錄
+// 位於 FileDir（即 NodeHostDir）之下，確保 dragonboat 可以建立 hard link
+func (rs *RaftStore) snapshotFileDir(clusterID, nodeID uint64) string {
+	return filepath.Join(rs.FileDir, "sm-files", fmt.Sprintf("%d-%d", clusterID, nodeID))
 }
@@ -5,19 +5,23 @@ import (
 	"context"
 	"encoding/gob"
 	"fmt"
-	"go-raft/internal/configs"
 	"go-raft/internal/domain"
 	"go-raft/internal/raft"
+	"path/filepath"
+	"slices"
 	"testing"
 	"time"
 
 	"github.com/sirupsen/logrus"
 )
 
+// testSnapshotPolicy 讓少量寫入就觸發 snapshot，重啟時才會從 snapshot 還原
+var testSnapshotPolicy = raft.SnapshotPolicy{Entries: 10, CompactionOverhead: 5}
+
 func TestRaftRollingUpgradeAndSnapshotSwitch(t *testing.T) {
 	clusterID := uint64(101)
 	basePort := 24000
-	baseDir := "tmp/raft-node"
+	baseDir := filepath.Join(t.TempDir(), "raft-node")
 	host := "localhost"
 
 	var nodes []*raft.RaftStore
@@ -29,30 +33,38 @@ func TestRaftRollingUpgradeAndSnapshotSwitch(t *testing.T) {
 		3: fmt.Sprintf("%s:%d", host, basePort+2),
 	}
 
-	// Step 1：啟動 Leader（Join=false 並傳 initialMembers）
-	leaderNodeID := uint64(1)
-	leaderAddr := initialMembers[leaderNodeID]
-	configs.SetSnapshotVersion(clusterID, leaderNodeID, 1)
-
-	leader, err := raft.New(raft.NodeConfig{
-		FileDir:        fmt.Sprintf("%s-%d", baseDir, leaderNodeID),
-		RaftAddress:    leaderAddr,
-		NodeID:         leaderNodeID,
-		ClusterID:      clusterID,
-		Join:           false,
-		InitialMembers: initialMembers,
-	})
-	if err != nil {
-		t.Fatalf("Failed to create leader node: %v", err)
+	// Step 1：以相同的 initialMembers 啟動所有初始成員（Join=false），才能形成多數派
+	for nodeID := uint64(1); nodeID <= uint64(len(initialMembers)); nodeID++ {
+		node, err := raft.New(raft.NodeConfig{
+			FileDir:        fmt.Sprintf("%s-%d", baseDir, nodeID),
+			RaftAddress:    initialMembers[nodeID],
+			NodeID:         nodeID,
+			ClusterID:      clusterID,
+			Join:           false,
+			InitialMembers: initialMembers,
+			SnapshotPolicy: testSnapshotPolicy,
+		})
+		if err != nil {
+			t.Fatalf("Failed to create node %d: %v", nodeID, err)
+		}
+		if err := node.Start(); err != nil {
+			t.Fatalf("Failed to start node %d: %v", nodeID, err)
+		}
+		nodes = append(nodes, node)
 	}
-	if err := leader.Start(); err != nil {
-		t.Fatalf("Failed to start leader node: %v", err)
+	defer func() {
+		for _, node := range nodes {
+			if node != nil {
+				node.NodeHost.Close()
+			}
+		}
+	}()
+	for _, node := range nodes {
+		waitForShardReady(t, node, clusterID)
 	}
-	waitForShardReady(t, leader, clusterID)
-	nodes = append(nodes, leader)
 
 	// 找出 Leader
-	leader, err = findLeaderWithRaftStore(nodes, clusterID)
+	leader, err := findLeaderWithRaftStore(nodes, clusterID)
 	if err != nil {
 		t.Fatal("Leader not found")
 	}
@@ -68,8 +80,11 @@ func TestRaftRollingUpgradeAndSnapshotSwitch(t *testing.T) {
 		if err := gob.NewEncoder(&buf).Encode(cmd); err != nil {
 			t.Fatalf("Encode error: %v", err)
 		}
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 		session := leader.NodeHost.GetNoOPSession(clusterID)
-		if _, err := leader.NodeHost.SyncPropose(context.Background(), session, buf.Bytes()); err != nil {
+		_, err := leader.NodeHost.SyncPropose(ctx, session, buf.Bytes())
+		cancel()
+		if err != nil {
 			t.Fatalf("Propose failed: %v", err)
 		}
 	}
@@ -79,22 +94,27 @@ func TestRaftRollingUpgradeAndSnapshotSwitch(t *testing.T) {
 		waitForCompleteData(t, node, clusterID, 5)
 	}
 
-	// 模擬 snapshot version 切換到 v2
+	// 所有成員回報支援後，透過升級控制器將 snapshot version 啟用到 v3（主串流格式），所有副本一致套用
+	controller := raft.NewUpgradeController(leader.NodeHost, clusterID)
+	waitForUpgradeReady(t, controller, domain.FeatureSnapshot, 3)
+	activate(t, controller, domain.FeatureSnapshot, 3, domain.ResultOK)
 	for _, node := range nodes {
-		configs.SetSnapshotVersion(clusterID, node.NodeID, 2)
+		waitForSnapshotVersion(t, node, clusterID, 3)
 	}
 
 	// 滾動重啟節點
+	// 已存在資料的節點重啟時 Join=false 且不需要 initialMembers
 	for i, node := range nodes {
 		node.NodeHost.Close()
+		nodes[i] = nil
 		time.Sleep(2 * time.Second)
 
 		newNode, err := raft.New(raft.NodeConfig{
-			FileDir:     node.FileDir,
-			RaftAddress: node.RaftAddress,
-			NodeID:      node.NodeID,
-			ClusterID:   clusterID,
-			Join:        true,
+			FileDir:        node.FileDir,
+			RaftAddress:    node.RaftAddress,
+			NodeID:         node.NodeID,
+			ClusterID:      clusterID,
+			SnapshotPolicy: testSnapshotPolicy,
 		})
 		if err != nil {
 			t.Fatalf("Failed to recreate node %d: %v", node.NodeID, err)
@@ -106,6 +126,12 @@ func TestRaftRollingUpgradeAndSnapshotSwitch(t *testing.T) {
 		nodes[i] = newNode
 	}
 
+	// 重啟後原本的 Leader 實例已關閉，重新找出 Leader
+	leader, err = findLeaderWithRaftStore(nodes, clusterID)
+	if err != nil {
+		t.Fatal("Leader not found after rolling restart")
+	}
+
 	// 再寫入五筆資料
 	for i := 5; i < 10; i++ {
 		cmd := domain.Asset{
@@ -117,8 +143,11 @@ func TestRaftRollingUpgradeAndSnapshotSwitch(t *testing.T) {
 		if err := gob.NewEncoder(&buf).Encode(cmd); err != nil {
 			t.Fatalf("Encode error: %v", err)
 		}
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 		session := leader.NodeHost.GetNoOPSession(clusterID)
-		if _, err := leader.NodeHost.SyncPropose(context.Background(), session, buf.Bytes()); err != nil {
+		_, err := leader.NodeHost.SyncPropose(ctx, session, buf.Bytes())
+		cancel()
+		if err != nil {
 			t.Fatalf("Propose failed: %v", err)
 		}
 	}
@@ -127,6 +156,59 @@ func TestRaftRollingUpgradeAndSnapshotSwitch(t *testing.T) {
 	for _, node := range nodes {
 		waitForCompleteData(t, node, clusterID, 10)
 	}
+
+	// 啟用後不可降級，重啟後依然生效
+	controller = raft.NewUpgradeController(leader.NodeHost, clusterID)
+	activate(t, controller, domain.FeatureSnapshot, 1, domain.ResultDowngradeBlocked)
+	// 沒有成員支援的版本不可啟用
+	activate(t, controller, domain.FeatureSnapshot, 99, domain.ResultUpgradeNotReady)
+	for _, node := range nodes {
+		waitForSnapshotVersion(t, node, clusterID, 3)
+	}
+}
+
+func activate(t *testing.T, u *raft.UpgradeController, feature domain.Feature, version uint64, expected domain.ResultCode) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	code, err := u.Activate(ctx, feature, version)
+	if err != nil {
+		t.Fatalf("Activate %s v%d failed: %v", feature, version, err)
+	}
+	if code != expected {
+		t.Fatalf("Activate %s v%d: expected %v, got %v", feature, version, expected, code)
+	}
+}
+
+func waitForUpgradeReady(t *testing.T, u *raft.UpgradeController, feature domain.Feature, version uint64) {
+	const maxAttempts = 10
+	const interval = time.Second
+
+	for range maxAttempts {
+		ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+		status, err := u.Status(ctx)
+		cancel()
+		if err == nil && slices.Contains(status.Ready[feature], version) {
+			return
+		}
+		time.Sleep(interval)
+	}
+	t.Fatalf("Members did not all report support for %s v%d", feature, version)
+}
+
+func waitForSnapshotVersion(t *testing.T, rs *raft.RaftStore, clusterID uint64, expected uint64) {
+	const maxAttempts = 10
+	const interval = time.Second
+
+	for range maxAttempts {
+		ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+		result, err := rs.NodeHost.SyncRead(ctx, clusterID, domain.SettingsQuery{})
+		cancel()
+		if err == nil && result.(domain.Settings).SnapshotVersion == expected {
+			return
+		}
+		time.Sleep(interval)
+	}
+	t.Fatalf("Node %d snapshot version did not become %d", rs.NodeID, expected)
 }
 
 func waitForShardReady(t *testing.T, rs *raft.RaftStore, clusterID uint64) {
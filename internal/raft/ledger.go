@@ -0,0 +1,357 @@
+package raft
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"go-raft/internal/configs"
+	"go-raft/internal/domain"
+	"go-raft/internal/logging"
+	"go-raft/internal/store"
+	"go-raft/internal/tracing"
+
+	"github.com/lni/dragonboat/v4/statemachine"
+	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// applyEnv 為狀態機套用 entry 時的環境
+type applyEnv struct {
+	// log 為狀態機的 logger，被拒絕的命令以 Info 記錄，成功的命令只在 Debug 等級記錄
+	log *logrus.Entry
+	// host 為本節點的 NodeHost ID，與命令的提案節點相同時套用的 span 為提案 span 的子 span
+	host string
+	// checksum 於套用 checksum 命令後呼叫，由狀態機計算本節點的狀態摘要
+	checksum func()
+}
+
+// applyEntry 於帳本套用單一 entry 並回傳結果碼，記憶體與 Pebble 兩種狀態機共用相同規則
+func applyEntry(l store.Ledger, entry statemachine.Entry, env applyEnv) domain.ResultCode {
+	cmd, err := domain.DecodeCommand(entry.Cmd)
+	if err != nil {
+		l.Advance(entry.Index, 0, false)
+		env.log.WithFields(logrus.Fields{"index": entry.Index, "error": err}).Warn("decode command failed")
+		return domain.ResultInvalidCommand
+	}
+	span := startApplySpan(env.host, entry.Index, cmd)
+	defer span.End()
+	// 一律使用 entry 內的時間，不讀取本機時鐘
+	now := l.Advance(entry.Index, commitTime(l, cmd), cmd.Leader)
+	// 高於已啟用版本的命令可能來自已升級但尚未啟用新格式的節點，所有副本一致拒絕
+	code := domain.ResultUnsupportedVersion
+	if uint64(cmd.Version) <= l.Settings().CommandVersion {
+		code = apply(l, entry.Index, now, cmd)
+	}
+	if cmd.Type == domain.CommandChecksum && code == domain.ResultOK {
+		env.checksum()
+	}
+	span.SetAttributes(attribute.String("raft.result", code.String()))
+	logApplied(env.log, entry.Index, cmd, code)
+	return code
+}
+
+// commitTime 回傳命令可採用的提案時間：leader 提案的時間直接採用，其他節點提案的時間最多超前 leader 的時間 MaxClockSkew
+// 尚未套用過 leader 提案時沒有基準，直接採用提案時間
+func commitTime(l store.Ledger, cmd domain.Command) int64 {
+	if cmd.Leader {
+		return cmd.Timestamp
+	}
+	leader := l.LeaderNow()
+	if limit := leader + int64(configs.MaxClockSkew); leader > 0 && cmd.Timestamp > limit {
+		return limit
+	}
+	return cmd.Timestamp
+}
+
+// startApplySpan 依命令的 trace context 建立套用的 span，命令沒有 trace context 時回傳 no-op span
+// 提案節點上為提案 span 的子 span；其他副本套用的時間與提案請求無關，建立新的 trace 並以 link 關聯提案 span
+func startApplySpan(host string, index uint64, cmd domain.Command) trace.Span {
+	if cmd.Trace == nil {
+		return trace.SpanFromContext(context.Background())
+	}
+	proposal := tracing.Extract(cmd.Trace.Carrier)
+	if !proposal.IsValid() {
+		return trace.SpanFromContext(context.Background())
+	}
+	attrs := trace.WithAttributes(
+		attribute.Int64("raft.index", int64(index)),
+		attribute.Int("raft.command", int(cmd.Type)),
+		attribute.String("raft.host", host),
+	)
+	if host != "" && host == cmd.Trace.Origin {
+		_, span := tracing.Tracer().Start(trace.ContextWithRemoteSpanContext(context.Background(), proposal), "raft.apply", attrs)
+		return span
+	}
+	_, span := tracing.Tracer().Start(context.Background(), "raft.apply", attrs, trace.WithLinks(trace.Link{SpanContext: proposal}))
+	return span
+}
+
+// smLogger 回傳狀態機的 logger，每筆 log 帶有 shard 與節點
+func smLogger(clusterID, nodeID uint64) *logrus.Entry {
+	return logrus.WithFields(logrus.Fields{"ClusterID": clusterID, "NodeID": nodeID})
+}
+
+// logApplied 記錄套用結果，帶有提案請求的 trace ID，可與提案節點上該請求的 log 對應
+func logApplied(log *logrus.Entry, index uint64, cmd domain.Command, code domain.ResultCode) {
+	level := logrus.DebugLevel
+	if code != domain.ResultOK {
+		level = logrus.InfoLevel
+	}
+	if !log.Logger.IsLevelEnabled(level) {
+		return
+	}
+	fields := logrus.Fields{"index": index, "type": cmd.Type, "code": code}
+	if cmd.TraceID != "" {
+		fields[logging.TraceIDField] = cmd.TraceID
+	}
+	log.WithFields(fields).Log(level, "command applied")
+}
+
+// apply 依命令種類套用單一命令，now 為經單調修正後的提交時間
+func apply(l store.Ledger, index uint64, now int64, cmd domain.Command) domain.ResultCode {
+	switch cmd.Type {
+	case domain.CommandAsset:
+		if cmd.Asset == nil || !store.ValidKey(cmd.Asset.UID, cmd.Asset.Currency) {
+			return domain.ResultInvalidCommand
+		}
+		return resultOf(applyAsset(l, index, now, cmd.Version, cmd.Asset))
+	case domain.CommandCurrency:
+		if cmd.Currency == nil || cmd.Currency.Validate() != nil {
+			return domain.ResultInvalidCurrency
+		}
+		l.Registry().Put(*cmd.Currency)
+		return domain.ResultOK
+	case domain.CommandAccount:
+		if cmd.Account == nil || cmd.Account.UID == "" || !store.ValidKey(cmd.Account.UID, cmd.Account.Tier) {
+			return domain.ResultInvalidCommand
+		}
+		return resultOf(applyAccount(l, index, now, cmd.Account))
+	case domain.CommandLimit:
+		if cmd.Limit == nil || !cmd.Limit.Rule.Valid() || !store.ValidKey(cmd.Limit.Rule.Currency, cmd.Limit.Rule.Tier, cmd.Limit.Rule.UID) {
+			return domain.ResultInvalidLimit
+		}
+		if cmd.Limit.Remove {
+			l.Limits().Remove(cmd.Limit.Rule)
+		} else {
+			l.Limits().Set(cmd.Limit.Rule)
+		}
+		return domain.ResultOK
+	case domain.CommandNodeSupport:
+		if cmd.NodeSupport == nil || cmd.NodeSupport.NodeID == 0 {
+			return domain.ResultInvalidCommand
+		}
+		l.ReportSupport(index, *cmd.NodeSupport)
+		return domain.ResultOK
+	case domain.CommandMember:
+		if cmd.Member == nil || cmd.Member.NodeID == 0 {
+			return domain.ResultInvalidCommand
+		}
+		l.RegisterMember(*cmd.Member)
+		return domain.ResultOK
+	case domain.CommandActivate:
+		if cmd.Activation == nil || cmd.Activation.Version == 0 {
+			return domain.ResultUnsupportedVersion
+		}
+		return resultOf(l.Activate(*cmd.Activation))
+	case domain.CommandChecksum:
+		l.OpenChecksum(index)
+		return domain.ResultOK
+	case domain.CommandChecksumReport:
+		if cmd.Checksum == nil || cmd.Checksum.NodeID == 0 {
+			return domain.ResultInvalidCommand
+		}
+		return resultOf(l.ReportChecksum(*cmd.Checksum))
+	case domain.CommandClock:
+		// 提交時間已由 applyEntry 推進
+		return domain.ResultOK
+	}
+	return domain.ResultInvalidCommand
+}
+
+// applyAsset 依序檢查幣別、帳戶狀態與限額後套用資產增減，全部通過後才隱式開立帳戶，被拒絕的命令不留下任何狀態
+func applyAsset(l store.Ledger, index uint64, now int64, version uint32, asset *domain.Asset) error {
+	// 舊版命令在幣別註冊表、帳戶狀態與限額出現前提案，略過檢查以重現當時的結果
+	if version == 0 {
+		l.Accounts().Ensure(index, now, asset.UID)
+		l.UpdateAt(index, asset.UID, asset.Currency, asset.Amount)
+		return nil
+	}
+	if err := l.Registry().Validate(asset.Currency, asset.Amount); err != nil {
+		return err
+	}
+	acc, err := l.Accounts().Writable(asset.UID)
+	if err != nil {
+		return err
+	}
+	if err := l.Limits().Consume(now, asset.UID, asset.Currency, acc.Tier, asset.Amount); err != nil {
+		return err
+	}
+	l.Accounts().Ensure(index, now, asset.UID)
+	l.UpdateAt(index, asset.UID, asset.Currency, asset.Amount)
+	return nil
+}
+
+// applyAccount 套用帳戶生命週期命令，關閉帳戶前需所有幣別餘額在該幣別精度下為零
+// 未註冊的幣別（舊版命令寫入）無法得知精度，餘額需完全為零
+func applyAccount(l store.Ledger, index uint64, now int64, cmd *domain.AccountCommand) error {
+	switch cmd.Action {
+	case domain.AccountOpen:
+		return l.Accounts().Open(index, now, cmd.UID, cmd.Tier, cmd.Metadata)
+	case domain.AccountSetTier:
+		return l.Accounts().SetTier(index, cmd.UID, cmd.Tier)
+	case domain.AccountClose:
+		for currency, balance := range l.Holdings(cmd.UID) {
+			if balance == 0 {
+				continue
+			}
+			if c, ok := l.Registry().Get(currency); !ok || !c.IsZero(balance) {
+				return fmt.Errorf("%w: %s holds %v %s", store.ErrAccountNotEmpty, cmd.UID, balance, currency)
+			}
+		}
+	}
+	return l.Accounts().Transition(index, cmd.UID, cmd.Action, cmd.Reason)
+}
+
+// resultOf 將 store 回傳的錯誤轉成結果碼
+func resultOf(err error) domain.ResultCode {
+	switch {
+	case err == nil:
+		return domain.ResultOK
+	case errors.Is(err, store.ErrUnknownCurrency):
+		return domain.ResultUnknownCurrency
+	case errors.Is(err, store.ErrCurrencyDisabled):
+		return domain.ResultCurrencyDisabled
+	case errors.Is(err, store.ErrInvalidAmount):
+		return domain.ResultInvalidAmount
+	case errors.Is(err, store.ErrAccountNotFound):
+		return domain.ResultAccountNotFound
+	case errors.Is(err, store.ErrAccountExists):
+		return domain.ResultAccountExists
+	case errors.Is(err, store.ErrAccountFrozen):
+		return domain.ResultAccountFrozen
+	case errors.Is(err, store.ErrAccountClosed):
+		return domain.ResultAccountClosed
+	case errors.Is(err, store.ErrAccountNotEmpty):
+		return domain.ResultAccountNotEmpty
+	case errors.Is(err, store.ErrInvalidTransition):
+		return domain.ResultInvalidTransition
+	case errors.Is(err, store.ErrLimitExceeded):
+		return domain.ResultLimitExceeded
+	case errors.Is(err, store.ErrDowngradeBlocked):
+		return domain.ResultDowngradeBlocked
+	case errors.Is(err, store.ErrUpgradeNotReady):
+		return domain.ResultUpgradeNotReady
+	case errors.Is(err, store.ErrUnknownFeature):
+		return domain.ResultUnsupportedVersion
+	case errors.Is(err, store.ErrChecksumExpired):
+		return domain.ResultChecksumExpired
+	}
+	return domain.ResultInvalidCommand
+}
+
+// lookup 以 cs 回答匯出以外的查詢，Lookup 的參數與回傳值由 HTTP handler 依型別解讀
+func lookup(cs store.Ledger, query any) (any, error) {
+	switch q := query.(type) {
+	case domain.Asset:
+		// 查單一使用者幣別餘額
+		return cs.Get(q.UID, q.Currency), nil
+	case domain.BalanceQuery:
+		// 查單一使用者幣別在指定 raft index 的歷史餘額
+		if q.AsOfTime != 0 {
+			return cs.GetAsOf(q.UID, q.Currency, q.AsOfTime)
+		}
+		return cs.GetAt(q.UID, q.Currency, q.AsOfIndex)
+	case domain.StatsQuery:
+		// 查詢幣別彙總統計，Verify 時先以全表掃描比對增量結果
+		if q.Verify {
+			if err := cs.VerifyStats(q.Currency, q.TopN); err != nil {
+				return nil, err
+			}
+		}
+		if q.Currency == "" {
+			return cs.AllStats(q.TopN), nil
+		}
+		return cs.Stats(q.Currency, q.TopN), nil
+	case domain.AccountQuery:
+		// 查詢帳戶資料與各幣別餘額
+		acc, ok := cs.Accounts().Get(q.UID)
+		if !ok {
+			return nil, store.ErrAccountNotFound
+		}
+		return domain.AccountView{Account: acc, Balances: cs.Holdings(q.UID)}, nil
+	case domain.LimitQuery:
+		// 查詢限額政策，帶 UID 時回傳該使用者實際生效的限額與目前用量
+		if q.UID == "" {
+			return cs.Limits().Rules(), nil
+		}
+		acc, _ := cs.Accounts().Get(q.UID)
+		return cs.Limits().View(cs.Now(), q.UID, q.Currency, acc.Tier), nil
+	case domain.SettingsQuery:
+		return cs.Settings(), nil
+	case domain.AppliedQuery:
+		// 查詢最後套用的 raft index，判斷節點是否已追上 commit
+		return cs.Applied(), nil
+	case domain.ClockQuery:
+		// 查詢提交時間，leader 判斷是否需要寫入目前時間
+		return domain.Clock{Applied: cs.Applied(), Now: cs.Now(), Leader: cs.LeaderNow()}, nil
+	case domain.UpgradeQuery:
+		// 查詢已啟用版本與各節點回報的支援版本
+		return cs.UpgradeState(), nil
+	case domain.ChecksumQuery:
+		// 查詢最近的 checksum 與各節點回報的結果
+		return cs.Checksums(), nil
+	case domain.ChecksumBalancesQuery:
+		// 查詢幣別在 checksum index 的所有非零餘額，用於找出不一致的 key
+		return balancesAt(cs, q.Currency, q.Index)
+	case domain.CurrencyQuery:
+		// 查詢幣別註冊資料
+		if q.Code == "" {
+			return cs.Registry().List(), nil
+		}
+		c, ok := cs.Registry().Get(q.Code)
+		if !ok {
+			return nil, store.ErrUnknownCurrency
+		}
+		return c, nil
+	case string:
+		if q == "list" {
+			return cs.List(), nil
+		}
+	}
+	return nil, fmt.Errorf("unknown query")
+}
+
+// balancesAt 以歷史餘額回傳幣別在 raft index 套用後的所有非零餘額，與 checksum 相同不列入零餘額
+func balancesAt(cs store.Ledger, currency string, index uint64) (map[string]float64, error) {
+	result := make(map[string]float64)
+	var err error
+	cs.RangeBalances(currency, func(uid string, _ float64) bool {
+		var balance float64
+		if balance, err = cs.GetAt(uid, currency, index); err != nil {
+			return false
+		}
+		if balance != 0 {
+			result[uid] = balance
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// snapshotSource 為可匯出完整狀態的帳本或其不可變檢視
+type snapshotSource interface {
+	Applied() uint64
+	Capture() *store.SnapshotContent
+}
+
+// export 複製帳本的完整狀態，cs 為帳本時呼叫端需確保複製期間沒有 Update
+func export(cs snapshotSource, q domain.ExportQuery) (any, error) {
+	if applied := cs.Applied(); applied < q.MinApplied {
+		return nil, fmt.Errorf("%w: requested %d, applied %d", store.ErrIndexNotApplied, q.MinApplied, applied)
+	}
+	return cs.Capture(), nil
+}
@@ -0,0 +1,78 @@
+package raft_test
+
+import (
+	"testing"
+
+	"go-raft/internal/domain"
+	"go-raft/internal/raft"
+
+	"github.com/lni/dragonboat/v4/statemachine"
+)
+
+// TestAccountLifecycle 帳戶開立、凍結、解凍與關閉，凍結或關閉的帳戶拒絕寫入，兩種狀態機結果相同
+func TestAccountLifecycle(t *testing.T) {
+	account := func(action domain.AccountAction) domain.Command {
+		return domain.Command{Type: domain.CommandAccount, Account: &domain.AccountCommand{UID: "alice", Action: action, Reason: "test"}}
+	}
+	asset := func(amount float64) domain.Command {
+		return domain.Command{Type: domain.CommandAsset, Asset: &domain.Asset{UID: "alice", Currency: "USD", Amount: amount}}
+	}
+	steps := []struct {
+		name string
+		cmd  domain.Command
+		want domain.ResultCode
+	}{
+		{"register USD", domain.Command{Type: domain.CommandCurrency, Currency: &domain.Currency{Code: "USD", Precision: 2, Enabled: true}}, domain.ResultOK},
+		{"open", account(domain.AccountOpen), domain.ResultOK},
+		{"open twice", account(domain.AccountOpen), domain.ResultAccountExists},
+		{"deposit", asset(10), domain.ResultOK},
+		{"freeze", account(domain.AccountFreeze), domain.ResultOK},
+		{"deposit while frozen", asset(1), domain.ResultAccountFrozen},
+		{"withdraw while frozen", asset(-1), domain.ResultAccountFrozen},
+		{"close while frozen with balance", account(domain.AccountClose), domain.ResultAccountNotEmpty},
+		{"unfreeze", account(domain.AccountUnfreeze), domain.ResultOK},
+		{"withdraw", asset(-9.99), domain.ResultOK},
+		{"close with balance", account(domain.AccountClose), domain.ResultAccountNotEmpty},
+		// 10 - 9.99 - 0.01 留下約 -2e-16 的浮點殘值，在 USD 精度下為零
+		{"withdraw rest", asset(-0.01), domain.ResultOK},
+		{"close", account(domain.AccountClose), domain.ResultOK},
+		{"deposit after close", asset(1), domain.ResultAccountClosed},
+		{"unfreeze after close", account(domain.AccountUnfreeze), domain.ResultInvalidTransition},
+	}
+	var entries []statemachine.Entry
+	for i, s := range steps {
+		s.cmd.Version = domain.CommandVersion
+		data, err := s.cmd.Encode()
+		if err != nil {
+			t.Fatal(err)
+		}
+		entries = append(entries, statemachine.Entry{Index: uint64(i + 1), Cmd: data})
+	}
+
+	mem := raft.NewAssetRaftConcurrentMachine(1, 1, t.TempDir(), 0)
+	memResults, err := mem.Update(cloneEntries(entries))
+	if err != nil {
+		t.Fatalf("memory update: %v", err)
+	}
+	disk := openDisk(t, t.TempDir(), 0, 0)
+	diskResults, err := disk.Update(cloneEntries(entries))
+	if err != nil {
+		t.Fatalf("disk update: %v", err)
+	}
+	for name, results := range map[string][]statemachine.Entry{"memory": memResults, "disk": diskResults} {
+		for i, s := range steps {
+			if got := domain.ResultCode(results[i].Result.Value); got != s.want {
+				t.Errorf("%s: %s: got %v, want %v", name, s.name, got, s.want)
+			}
+		}
+	}
+	for name, sm := range map[string]lookuper{"memory": mem, "disk": disk} {
+		v, err := sm.Lookup(domain.AccountQuery{UID: "alice"})
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if acc := v.(domain.AccountView).Account; acc.Status != domain.AccountClosed {
+			t.Fatalf("%s: status %v, want closed", name, acc.Status)
+		}
+	}
+}
@@ -0,0 +1,196 @@
+package raft
+
+import (
+	"fmt"
+	"go-raft/internal/configs"
+	"go-raft/internal/domain"
+	"math"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/VictoriaMetrics/metrics"
+	"github.com/lni/dragonboat/v4/raftio"
+	"github.com/lni/dragonboat/v4/statemachine"
+	"github.com/sirupsen/logrus"
+)
+
+// raftEvents 將 dragonboat 的 raft 與系統事件轉成指標，dragonboat 在自己的 goroutine 中依序呼叫，不可阻塞
+type raftEvents struct{}
+
+var (
+	_ raftio.IRaftEventListener   = raftEvents{}
+	_ raftio.ISystemEventListener = raftEvents{}
+)
+
+// LeaderUpdated 紀錄 leader 變更次數與本節點看到的 leader；term 由 dragonboat 的 EnableMetrics 匯出
+func (raftEvents) LeaderUpdated(info raftio.LeaderInfo) {
+	metrics.GetOrCreateCounter(fmt.Sprintf(`raft_leader_changes_total{shard="%d",replica="%d"}`, info.ShardID, info.ReplicaID)).Inc()
+	replicaLeader(info.ShardID, info.ReplicaID).Store(info.LeaderID)
+	logrus.WithFields(logrus.Fields{"ClusterID": info.ShardID, "NodeID": info.ReplicaID, "leader": info.LeaderID, "term": info.Term}).Info("leader updated")
+}
+
+func (raftEvents) NodeHostShuttingDown() {}
+
+func (raftEvents) NodeUnloaded(info raftio.NodeInfo) { nodeEvent(info, "node_unloaded") }
+
+func (raftEvents) NodeDeleted(info raftio.NodeInfo) { nodeEvent(info, "node_deleted") }
+
+func (raftEvents) NodeReady(info raftio.NodeInfo) { nodeEvent(info, "node_ready") }
+
+func (raftEvents) MembershipChanged(info raftio.NodeInfo) { nodeEvent(info, "membership_changed") }
+
+func (raftEvents) ConnectionEstablished(info raftio.ConnectionInfo) {
+	connectionEvent(info, "established")
+}
+
+func (raftEvents) ConnectionFailed(info raftio.ConnectionInfo) { connectionEvent(info, "failed") }
+
+func (raftEvents) SendSnapshotStarted(info raftio.SnapshotInfo) {
+	snapshotEvent(info, "send_snapshot_started")
+}
+
+func (raftEvents) SendSnapshotCompleted(info raftio.SnapshotInfo) {
+	snapshotEvent(info, "send_snapshot_completed")
+}
+
+func (raftEvents) SendSnapshotAborted(info raftio.SnapshotInfo) {
+	snapshotEvent(info, "send_snapshot_aborted")
+}
+
+func (raftEvents) SnapshotReceived(info raftio.SnapshotInfo) {
+	snapshotEvent(info, "snapshot_received")
+}
+
+func (raftEvents) SnapshotRecovered(info raftio.SnapshotInfo) {
+	snapshotEvent(info, "snapshot_recovered")
+}
+
+func (raftEvents) SnapshotCreated(info raftio.SnapshotInfo) { snapshotEvent(info, "snapshot_created") }
+
+func (raftEvents) SnapshotCompacted(info raftio.SnapshotInfo) {
+	snapshotEvent(info, "snapshot_compacted")
+}
+
+func (raftEvents) LogCompacted(info raftio.EntryInfo) { entryEvent(info, "log_compacted") }
+
+func (raftEvents) LogDBCompacted(info raftio.EntryInfo) { entryEvent(info, "logdb_compacted") }
+
+func nodeEvent(info raftio.NodeInfo, event string) {
+	raftEventCounter(info.ShardID, info.ReplicaID, event).Inc()
+}
+
+func snapshotEvent(info raftio.SnapshotInfo, event string) {
+	raftEventCounter(info.ShardID, info.ReplicaID, event).Inc()
+}
+
+func entryEvent(info raftio.EntryInfo, event string) {
+	raftEventCounter(info.ShardID, info.ReplicaID, event).Inc()
+}
+
+func connectionEvent(info raftio.ConnectionInfo, result string) {
+	metrics.GetOrCreateCounter(fmt.Sprintf(`raft_connection_events_total{result="%s",snapshot="%t"}`, result, info.SnapshotConnection)).Inc()
+}
+
+// raftEventCounter 為 raft_events_total，event 為 dragonboat 系統事件的名稱
+func raftEventCounter(shardID, replicaID uint64, event string) *metrics.Counter {
+	return metrics.GetOrCreateCounter(fmt.Sprintf(`raft_events_total{shard="%d",replica="%d",event="%s"}`, shardID, replicaID, event))
+}
+
+// replicaLeaders 為各 replica 最近看到的 leader ID
+var replicaLeaders sync.Map
+
+// replicaLeader 回傳 replica 最近看到的 leader ID，第一次取得時註冊 raft_leader_id 指標
+func replicaLeader(shardID, replicaID uint64) *atomic.Uint64 {
+	v, loaded := replicaLeaders.LoadOrStore([2]uint64{shardID, replicaID}, new(atomic.Uint64))
+	leader := v.(*atomic.Uint64)
+	if !loaded {
+		metrics.GetOrCreateGauge(fmt.Sprintf(`raft_leader_id{shard="%d",replica="%d"}`, shardID, replicaID), func() float64 {
+			return float64(leader.Load())
+		})
+	}
+	return leader
+}
+
+// applyMetrics 為狀態機套用 entry 的指標，只在 Update 中使用，不需加鎖
+type applyMetrics struct {
+	clusterID uint64
+	entries   *metrics.Counter
+	duration  *metrics.PrometheusHistogram
+	results   map[domain.ResultCode]*metrics.Counter
+}
+
+func newApplyMetrics(clusterID uint64) *applyMetrics {
+	return &applyMetrics{
+		clusterID: clusterID,
+		entries:   metrics.GetOrCreateCounter(fmt.Sprintf(`raft_apply_entries_total{shard="%d"}`, clusterID)),
+		duration:  metrics.GetOrCreatePrometheusHistogram(fmt.Sprintf(`raft_apply_batch_duration_seconds{shard="%d"}`, clusterID)),
+		results:   make(map[domain.ResultCode]*metrics.Counter),
+	}
+}
+
+// observe 紀錄一批自 start 開始套用的 entry 與各結果碼的次數
+func (m *applyMetrics) observe(start time.Time, entries []statemachine.Entry) {
+	m.duration.UpdateDuration(start)
+	m.entries.Add(len(entries))
+	for _, entry := range entries {
+		code := domain.ResultCode(entry.Result.Value)
+		c, ok := m.results[code]
+		if !ok {
+			c = metrics.GetOrCreateCounter(fmt.Sprintf(`raft_apply_results_total{shard="%d",result=%q}`, m.clusterID, code.String()))
+			m.results[code] = c
+		}
+		c.Inc()
+	}
+}
+
+// observePropose 紀錄提案到套用完成的延遲，失敗的提案另外計數
+func observePropose(clusterID uint64, start time.Time, err error) {
+	if err != nil {
+		metrics.GetOrCreateCounter(fmt.Sprintf(`raft_propose_errors_total{shard="%d"}`, clusterID)).Inc()
+		return
+	}
+	metrics.GetOrCreatePrometheusHistogram(fmt.Sprintf(`raft_propose_duration_seconds{shard="%d"}`, clusterID)).UpdateDuration(start)
+}
+
+// currencySizes 為各 shard 與幣別最近一次統計的非零帳戶數與總額
+var currencySizes sync.Map
+
+type currencySize struct {
+	accounts atomic.Int64
+	total    atomic.Uint64 // float64 bits
+}
+
+// runStoreMetrics 定期以本機狀態更新各幣別的帳戶數與總額指標，NodeHost 關閉或 shard 移除後結束
+// Pebble 上的帳本每次統計都需掃描所有餘額，因此不在每次抓取指標時計算
+func (rs *RaftStore) runStoreMetrics() {
+	ticker := time.NewTicker(configs.StoreMetricsInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		if _, _, _, err := rs.NodeHost.GetLeaderID(rs.ClusterID); err != nil {
+			return
+		}
+		result, err := rs.NodeHost.StaleRead(rs.ClusterID, domain.StatsQuery{})
+		if err != nil {
+			continue
+		}
+		stats, _ := result.([]domain.CurrencyStats)
+		for _, s := range stats {
+			size := storeSize(rs.ClusterID, s.Currency)
+			size.accounts.Store(int64(s.Accounts))
+			size.total.Store(math.Float64bits(s.Total))
+		}
+	}
+}
+
+// storeSize 回傳幣別的統計，第一次取得時註冊 ledger_accounts 與 ledger_total 指標
+func storeSize(clusterID uint64, currency string) *currencySize {
+	v, loaded := currencySizes.LoadOrStore(fmt.Sprintf("%d/%s", clusterID, currency), new(currencySize))
+	size := v.(*currencySize)
+	if !loaded {
+		labels := fmt.Sprintf(`shard="%d",currency=%q`, clusterID, currency)
+		metrics.GetOrCreateGauge(`ledger_accounts{`+labels+`}`, func() float64 { return float64(size.accounts.Load()) })
+		metrics.GetOrCreateGauge(`ledger_total{`+labels+`}`, func() float64 { return math.Float64frombits(size.total.Load()) })
+	}
+	return size
+}
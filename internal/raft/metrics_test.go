@@ -0,0 +1,37 @@
+package raft_test
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"go-raft/internal/domain"
+	"go-raft/internal/raft"
+
+	"github.com/VictoriaMetrics/metrics"
+)
+
+// TestApplyMetrics 套用的 entry 筆數與各結果碼都會出現在 /metrics 的輸出中
+func TestApplyMetrics(t *testing.T) {
+	entries := diskTestEntries(t, 20)
+	sm := raft.NewAssetRaftConcurrentMachine(207, 1, t.TempDir(), 40)
+	results, err := sm.Update(cloneEntries(entries))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	metrics.WritePrometheus(&buf, false)
+	out := buf.String()
+	for _, want := range []string{
+		`raft_apply_entries_total{shard="207"} 20`,
+		`raft_apply_batch_duration_seconds_count{shard="207"} 1`,
+		// Prometheus 的 histogram 以 le 標示累計 bucket 的上限
+		`raft_apply_batch_duration_seconds_bucket{shard="207",le="+Inf"} 1`,
+		`raft_apply_results_total{shard="207",result="` + domain.ResultCode(results[0].Result.Value).String() + `"}`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("metrics missing %s", want)
+		}
+	}
+}
@@ -0,0 +1,69 @@
+package raft
+
+import (
+	"context"
+	"fmt"
+	"go-raft/internal/domain"
+	"go-raft/internal/logging"
+	"go-raft/internal/tracing"
+	"time"
+
+	"github.com/lni/dragonboat/v4"
+	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Propose 為命令蓋上提交時間後同步提案，回傳狀態機套用後的結果碼
+// 時間由提案端指定並寫入 entry，狀態機會確保其單調遞增；本節點不是 leader 時，狀態機以 leader 的時間限制其上限
+// ctx 中的 trace ID 與提案 span 的 trace context 會寫入命令，各副本套用時的 log 與 span 可與此請求對應
+func Propose(ctx context.Context, nh *dragonboat.NodeHost, clusterID uint64, cmd domain.Command) (domain.ResultCode, error) {
+	ctx, span := tracing.Tracer().Start(ctx, "raft.propose", trace.WithAttributes(
+		attribute.Int64("raft.shard", int64(clusterID)),
+		attribute.Int("raft.command", int(cmd.Type)),
+	))
+	defer span.End()
+
+	if cmd.Timestamp == 0 {
+		cmd.Timestamp = time.Now().UnixNano()
+	}
+	cmd.Leader = isLeader(nh, clusterID)
+	if cmd.TraceID == "" {
+		cmd.TraceID = logging.TraceID(ctx)
+	}
+	if carrier := tracing.Inject(ctx); carrier != nil {
+		cmd.Trace = &domain.TraceContext{Carrier: carrier, Origin: nh.ID()}
+	}
+	_, encodeSpan := tracing.Tracer().Start(ctx, "command.encode")
+	data, err := cmd.Encode()
+	encodeSpan.End()
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		return 0, fmt.Errorf("encode failed: %w", err)
+	}
+	session := nh.GetNoOPSession(clusterID)
+	start := time.Now()
+	proposeCtx, proposeSpan := tracing.Tracer().Start(ctx, "raft.SyncPropose", trace.WithAttributes(attribute.Int("raft.entry_bytes", len(data))))
+	result, err := nh.SyncPropose(proposeCtx, session, data)
+	proposeSpan.End()
+	observePropose(clusterID, start, err)
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		logging.FromContext(ctx).WithFields(logrus.Fields{"ClusterID": clusterID, "type": cmd.Type, "error": err}).Warn("propose failed")
+		return 0, fmt.Errorf("raft propose failed: %w", err)
+	}
+	code := domain.ResultCode(result.Value)
+	span.SetAttributes(attribute.String("raft.result", code.String()))
+	return code, nil
+}
+
+// isLeader 判斷本節點目前是否為 shard 的 leader，提案送出前 leader 可能更換，狀態機只依提案當下的判斷限制時間
+func isLeader(nh *dragonboat.NodeHost, clusterID uint64) bool {
+	leaderID, _, valid, err := nh.GetLeaderID(clusterID)
+	if err != nil || !valid {
+		return false
+	}
+	user, err := nh.GetNodeUser(clusterID)
+	return err == nil && user.ReplicaID() == leaderID
+}
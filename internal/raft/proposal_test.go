@@ -0,0 +1,152 @@
+package raft_test
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"go-raft/internal/configs"
+	"go-raft/internal/domain"
+	"go-raft/internal/logging"
+	"go-raft/internal/raft"
+
+	"github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
+)
+
+// TestProposeCarriesTraceID 提案請求的 trace ID 寫入命令，每個副本套用時的 log 都帶有相同的 trace ID
+func TestProposeCarriesTraceID(t *testing.T) {
+	const clusterID = uint64(209)
+	members := map[uint64]string{1: "localhost:24160", 2: "localhost:24161"}
+	var nodes []*raft.RaftStore
+	for nodeID := uint64(1); nodeID <= 2; nodeID++ {
+		rs, err := raft.New(raft.NodeConfig{
+			FileDir:        filepath.Join(t.TempDir(), fmt.Sprint(nodeID)),
+			RaftAddress:    members[nodeID],
+			NodeID:         nodeID,
+			ClusterID:      clusterID,
+			InitialMembers: members,
+		})
+		if err != nil {
+			t.Fatalf("create node %d: %v", nodeID, err)
+		}
+		if err := rs.Start(); err != nil {
+			t.Fatalf("start node %d: %v", nodeID, err)
+		}
+		defer rs.NodeHost.Close()
+		nodes = append(nodes, rs)
+	}
+	for _, rs := range nodes {
+		waitForShardReady(t, rs, clusterID)
+	}
+	leader, err := findLeaderWithRaftStore(nodes, clusterID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hook := test.NewLocal(logrus.StandardLogger())
+	defer hook.Reset()
+	level := logrus.GetLevel()
+	logrus.SetLevel(logrus.DebugLevel)
+	defer logrus.SetLevel(level)
+
+	ctx, cancel := context.WithTimeout(logging.WithTraceID(context.Background(), "trace-209"), 10*time.Second)
+	defer cancel()
+	cmd := domain.Command{Type: domain.CommandCurrency, Currency: &domain.Currency{Code: "USD", Precision: 2, Enabled: true}}
+	if code, err := raft.Propose(ctx, leader.NodeHost, clusterID, cmd); err != nil || code != domain.ResultOK {
+		t.Fatalf("propose: %v, %v", code, err)
+	}
+
+	for range 20 {
+		applied := map[any]bool{}
+		for _, entry := range hook.AllEntries() {
+			if entry.Message == "command applied" && entry.Data[logging.TraceIDField] == "trace-209" {
+				applied[entry.Data["NodeID"]] = true
+			}
+		}
+		if len(applied) == len(nodes) {
+			return
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	t.Fatal("trace ID not logged when applying on every replica")
+}
+
+// TestFollowerClockSkew 時鐘過快的 follower 提案時，提交時間最多超前 leader 時間 MaxClockSkew，之後 leader 寫入目前時間
+func TestFollowerClockSkew(t *testing.T) {
+	const clusterID = uint64(211)
+	members := map[uint64]string{1: "localhost:24180", 2: "localhost:24181"}
+	var nodes []*raft.RaftStore
+	for nodeID := uint64(1); nodeID <= 2; nodeID++ {
+		rs, err := raft.New(raft.NodeConfig{
+			FileDir:        filepath.Join(t.TempDir(), fmt.Sprint(nodeID)),
+			RaftAddress:    members[nodeID],
+			NodeID:         nodeID,
+			ClusterID:      clusterID,
+			InitialMembers: members,
+		})
+		if err != nil {
+			t.Fatalf("create node %d: %v", nodeID, err)
+		}
+		if err := rs.Start(); err != nil {
+			t.Fatalf("start node %d: %v", nodeID, err)
+		}
+		defer rs.NodeHost.Close()
+		nodes = append(nodes, rs)
+	}
+	for _, rs := range nodes {
+		waitForShardReady(t, rs, clusterID)
+	}
+	leader, err := findLeaderWithRaftStore(nodes, clusterID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	follower := nodes[0]
+	if follower == leader {
+		follower = nodes[1]
+	}
+	clock := func() domain.Clock {
+		t.Helper()
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		result, err := leader.NodeHost.SyncRead(ctx, clusterID, domain.ClockQuery{})
+		if err != nil {
+			t.Fatalf("read clock: %v", err)
+		}
+		return result.(domain.Clock)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	cmd := domain.Command{Type: domain.CommandCurrency, Currency: &domain.Currency{Code: "USD", Precision: 2, Enabled: true}}
+	if code, err := raft.Propose(ctx, leader.NodeHost, clusterID, cmd); err != nil || code != domain.ResultOK {
+		t.Fatalf("leader propose: %v, %v", code, err)
+	}
+	before := clock()
+	if before.Leader == 0 || before.Now != before.Leader {
+		t.Fatalf("leader proposal should set the leader time: %+v", before)
+	}
+
+	cmd.Timestamp = time.Now().Add(time.Hour).UnixNano()
+	if code, err := raft.Propose(ctx, follower.NodeHost, clusterID, cmd); err != nil || code != domain.ResultOK {
+		t.Fatalf("follower propose: %v, %v", code, err)
+	}
+	after := clock()
+	if after.Now > after.Leader+int64(configs.MaxClockSkew) || after.Now >= cmd.Timestamp {
+		t.Fatalf("follower time not capped: now %d, leader %d, proposed %d", after.Now, after.Leader, cmd.Timestamp)
+	}
+
+	// 有非 leader 提案套用後，leader 於 ClockTick 內寫入目前時間
+	for range 30 {
+		if c := clock(); c.Leader > before.Leader {
+			if c.Leader > time.Now().UnixNano() {
+				t.Fatalf("leader time %d ahead of the local clock", c.Leader)
+			}
+			return
+		}
+		time.Sleep(200 * time.Millisecond)
+	}
+	t.Fatal("leader did not stamp its clock after a follower proposal")
+}
@@ -0,0 +1,250 @@
+package raft
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"go-raft/internal/domain"
+	"go-raft/internal/gbsnap"
+	"go-raft/internal/store"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/lni/dragonboat/v4"
+)
+
+// compactPollInterval 等待 log 壓縮完成的輪詢間隔
+const compactPollInterval = 10 * time.Millisecond
+
+// ErrSnapshotUpToDate 自上一個 snapshot 後沒有新的 log，dragonboat 拒絕建立新的 snapshot
+var ErrSnapshotUpToDate = errors.New("no new entries since the last snapshot")
+
+// SnapshotRequest 為手動建立 snapshot 的選項
+type SnapshotRequest struct {
+	ExportPath string // 非空時匯出到此目錄，匯出的 snapshot 由呼叫端管理，不會觸發 log 壓縮
+	Compact    bool   // 建立 snapshot 後壓縮到 snapshot index 為止的所有 log，並立即回收 LogDB 空間
+}
+
+// SnapshotResult 為手動建立 snapshot 的結果
+type SnapshotResult struct {
+	Index     uint64 `json:"index"`
+	Exported  string `json:"exported,omitempty"` // 匯出的 snapshot 目錄
+	Compacted bool   `json:"compacted"`          // log 已壓縮到 snapshot 的 index 為止
+}
+
+// SnapshotInfo 為節點上一個 snapshot 的摘要
+type SnapshotInfo struct {
+	Index   uint64 `json:"index"`
+	Term    uint64 `json:"term"` // log 已壓縮且不是最新的 snapshot 時無法取得，為 0
+	Size    int64  `json:"size"`
+	Version uint64 `json:"version"` // 狀態機的 snapshot 格式版本
+	Path    string `json:"path"`
+	Error   string `json:"error,omitempty"` // 讀取或驗證失敗的原因
+}
+
+// SnapshotController 手動建立、匯出與列出本節點的 snapshot
+type SnapshotController struct {
+	nh        *dragonboat.NodeHost
+	clusterID uint64
+}
+
+func NewSnapshotController(nh *dragonboat.NodeHost, clusterID uint64) *SnapshotController {
+	return &SnapshotController{nh: nh, clusterID: clusterID}
+}
+
+// Request 立即建立 snapshot；匯出與壓縮同時指定時，先建立壓縮 log 的 snapshot 再匯出同一個 index
+// 自上一個 snapshot 後沒有新的 log 時回傳 ErrSnapshotUpToDate；有指定匯出時仍會匯出，Compacted 為 false
+func (s *SnapshotController) Request(ctx context.Context, req SnapshotRequest) (SnapshotResult, error) {
+	result, err := s.request(ctx, req)
+	if err == nil && result.Index != 0 {
+		snapshotRequests(s.clusterID, "manual").Inc()
+	}
+	return result, err
+}
+
+func (s *SnapshotController) request(ctx context.Context, req SnapshotRequest) (SnapshotResult, error) {
+	var result SnapshotResult
+	if req.Compact {
+		// 匯出會更新狀態機最後一次 snapshot 的 index，壓縮用的 snapshot 必須先建立
+		index, err := s.nh.SyncRequestSnapshot(ctx, s.clusterID, dragonboat.SnapshotOption{OverrideCompactionOverhead: true})
+		switch {
+		case snapshotSkipped(index, err) && req.ExportPath != "":
+			// 沒有新的 log 可壓縮，仍然繼續匯出
+		case snapshotSkipped(index, err):
+			return result, ErrSnapshotUpToDate
+		case err != nil:
+			return result, err
+		default:
+			if err := s.compact(ctx, index); err != nil {
+				return result, err
+			}
+			result.Index, result.Compacted = index, true
+		}
+	}
+	if req.ExportPath != "" {
+		if err := os.MkdirAll(req.ExportPath, 0o755); err != nil {
+			return result, err
+		}
+		index, err := s.nh.SyncRequestSnapshot(ctx, s.clusterID, dragonboat.SnapshotOption{ExportPath: req.ExportPath, Exported: true})
+		if err != nil {
+			return result, fmt.Errorf("export snapshot: %w", err)
+		}
+		result.Index = index
+		result.Exported = filepath.Join(req.ExportPath, fmt.Sprintf("snapshot-%016X", index))
+	}
+	if req.Compact || req.ExportPath != "" {
+		return result, nil
+	}
+
+	index, err := s.nh.SyncRequestSnapshot(ctx, s.clusterID, dragonboat.SnapshotOption{})
+	if snapshotSkipped(index, err) {
+		return result, ErrSnapshotUpToDate
+	}
+	if err != nil {
+		return result, err
+	}
+	result.Index = index
+	return result, nil
+}
+
+// snapshotSkipped 判斷 dragonboat 是否因沒有新的 log 而未建立 snapshot
+// 已有相同 index 的 snapshot 時回傳 ErrRejected，狀態機沒有進度時則回傳 index 0
+func snapshotSkipped(index uint64, err error) bool {
+	return errors.Is(err, dragonboat.ErrRejected) || err == nil && index == 0
+}
+
+// compact 等待 log 壓縮到 index 為止，並要求 LogDB 立即回收空間
+// dragonboat 只在處理下一個 raft update 時移除 log，閒置的 shard 以 ReadIndex 觸發
+func (s *SnapshotController) compact(ctx context.Context, index uint64) error {
+	reader, err := s.nh.GetLogReader(s.clusterID)
+	if err != nil {
+		return err
+	}
+	for {
+		if first, _ := reader.GetRange(); first > index {
+			break
+		}
+		if _, err := s.nh.SyncRead(ctx, s.clusterID, domain.SettingsQuery{}); err != nil {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(compactPollInterval):
+		}
+	}
+
+	replicaID, err := s.replicaID()
+	if err != nil {
+		return err
+	}
+	// 未停用自動壓縮時移除 log 後已經要求過回收，dragonboat 會以 ErrRejected 表示沒有可回收的 log
+	op, err := s.nh.RequestCompaction(s.clusterID, replicaID)
+	if errors.Is(err, dragonboat.ErrRejected) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	select {
+	case <-op.ResultC():
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (s *SnapshotController) replicaID() (uint64, error) {
+	info := s.nh.GetNodeHostInfo(dragonboat.NodeHostInfoOption{SkipLogInfo: true})
+	for _, shard := range info.ShardInfoList {
+		if shard.ShardID == s.clusterID {
+			return shard.ReplicaID, nil
+		}
+	}
+	return 0, dragonboat.ErrShardNotFound
+}
+
+// List 依 index 由新到舊列出本節點磁碟上的 snapshot，並讀取各 snapshot 的格式版本
+// 讀取格式版本時會核對整個 .gbsnap 的 checksum，無法讀取的 snapshot 以 Error 回報而不中斷列表
+func (s *SnapshotController) List() ([]SnapshotInfo, error) {
+	replicaID, err := s.replicaID()
+	if err != nil {
+		return nil, err
+	}
+	dir, err := s.snapshotDir(replicaID)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	reader, err := s.nh.GetLogReader(s.clusterID)
+	if err != nil {
+		return nil, err
+	}
+	latest := reader.Snapshot()
+
+	infos := []SnapshotInfo{}
+	for _, entry := range entries {
+		var index uint64
+		if _, err := fmt.Sscanf(entry.Name(), "snapshot-%X", &index); err != nil || entry.Name() != fmt.Sprintf("snapshot-%016X", index) || !entry.IsDir() {
+			// .generating、.receiving 等尚未完成的目錄不列出
+			continue
+		}
+		info := SnapshotInfo{Index: index, Path: filepath.Join(dir, entry.Name())}
+		if index == latest.Index {
+			info.Term = latest.Term
+		} else if term, err := reader.Term(index); err == nil {
+			info.Term = term
+		}
+		if err := readSnapshotInfo(&info); err != nil {
+			info.Error = err.Error()
+		}
+		infos = append(infos, info)
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Index > infos[j].Index })
+	return infos, nil
+}
+
+// snapshotDir 在 NodeHostDir 中尋找本 replica 的 snapshot 目錄（snapshot-<shard>-<replica>）
+// 目錄位於 dragonboat 依主機名稱與 deployment ID 建立的子目錄下，因此以名稱搜尋
+func (s *SnapshotController) snapshotDir(replicaID uint64) (string, error) {
+	name := fmt.Sprintf("snapshot-%d-%d", s.clusterID, replicaID)
+	var found string
+	err := filepath.WalkDir(s.nh.NodeHostConfig().NodeHostDir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() && d.Name() == name {
+			found = p
+			return filepath.SkipAll
+		}
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	if found == "" {
+		return "", fmt.Errorf("snapshot directory %s not found", name)
+	}
+	return found, nil
+}
+
+func readSnapshotInfo(info *SnapshotInfo) error {
+	s, err := gbsnap.Open(info.Path)
+	if err != nil {
+		return err
+	}
+	info.Size = s.Size
+	// 列出時只讀取開頭的格式版本，不讀完整個檔案核對 payload checksum
+	r, err := s.Head()
+	if err != nil {
+		return err
+	}
+	info.Version, err = store.SnapshotVersion(r)
+	return errors.Join(err, r.Close())
+}
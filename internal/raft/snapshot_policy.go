@@ -0,0 +1,171 @@
+package raft
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"go-raft/internal/configs"
+	"io"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"github.com/VictoriaMetrics/metrics"
+	"github.com/lni/dragonboat/v4"
+	"github.com/lni/dragonboat/v4/statemachine"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	snapshotPolicyTick    = time.Second      // 檢查時間與 log 大小觸發條件的間隔
+	snapshotPolicyTimeout = 30 * time.Second // 由策略觸發的單次 snapshot 逾時
+)
+
+// SnapshotPolicy 為 shard 的 snapshot 與 log 壓縮策略，任一條件達成即建立 snapshot
+// 欄位為 0 時使用 configs 中的預設值；要停用某個條件時設為極大值（Interval 可設為負值）
+type SnapshotPolicy struct {
+	Entries            uint64        // 每套用幾筆 entry 由 dragonboat 建立 snapshot
+	CompactionOverhead uint64        // 建立 snapshot 後保留的 log 筆數
+	Interval           time.Duration // 距上次 snapshot 超過此時間且有新的 entry 時建立 snapshot，負值代表停用
+	LogSize            uint64        // 自上次 snapshot 後套用的 entry 總大小（bytes）超過此值時建立 snapshot
+}
+
+// withDefaults 以 configs 中的預設值補上未設定的欄位
+func (p SnapshotPolicy) withDefaults() SnapshotPolicy {
+	if p.Entries == 0 {
+		p.Entries = configs.SnapshotEntries
+	}
+	if p.CompactionOverhead == 0 {
+		p.CompactionOverhead = configs.CompactionOverhead
+	}
+	if p.Interval == 0 {
+		p.Interval = configs.SnapshotInterval
+	}
+	if p.LogSize == 0 {
+		p.LogSize = configs.SnapshotLogSize
+	}
+	return p
+}
+
+// trigger 依狀態機自上次 snapshot 後的進度判斷是否需要建立 snapshot，回傳觸發原因
+func (p SnapshotPolicy) trigger(entries, bytes uint64, since time.Duration) (string, bool) {
+	switch {
+	case entries == 0:
+		return "", false
+	case bytes >= p.LogSize:
+		return "log_size", true
+	case p.Interval > 0 && since >= p.Interval:
+		return "interval", true
+	}
+	return "", false
+}
+
+// snapshotProgress 紀錄自上次 snapshot 或還原後套用的 entry 筆數與大小，以及該次 snapshot 的時間，供 snapshot 策略判斷
+type snapshotProgress struct {
+	entries atomic.Uint64
+	bytes   atomic.Uint64
+	last    atomic.Int64
+}
+
+// record 累計一批已套用的 entry
+func (p *snapshotProgress) record(entries []statemachine.Entry) {
+	var size uint64
+	for _, entry := range entries {
+		size += uint64(len(entry.Cmd))
+	}
+	p.entries.Add(uint64(len(entries)))
+	p.bytes.Add(size)
+}
+
+// reset 於建立 snapshot 或還原後歸零
+func (p *snapshotProgress) reset() {
+	p.entries.Store(0)
+	p.bytes.Store(0)
+	p.last.Store(time.Now().UnixNano())
+}
+
+func (p *snapshotProgress) since() (uint64, uint64, time.Time) {
+	return p.entries.Load(), p.bytes.Load(), time.Unix(0, p.last.Load())
+}
+
+// progressSource 為可回報 snapshot 進度的狀態機
+type progressSource interface {
+	sinceSnapshot() (uint64, uint64, time.Time)
+}
+
+// runSnapshotPolicy 定期檢查時間與 log 大小的觸發條件，筆數條件由 dragonboat 的 SnapshotEntries 處理
+// NodeHost 關閉或 shard 移除後結束
+func (rs *RaftStore) runSnapshotPolicy(sm progressSource) {
+	ticker := time.NewTicker(snapshotPolicyTick)
+	defer ticker.Stop()
+	for range ticker.C {
+		if _, _, _, err := rs.NodeHost.GetLeaderID(rs.ClusterID); err != nil {
+			return
+		}
+		entries, bytes, last := sm.sinceSnapshot()
+		reason, ok := rs.snapshotPolicy.trigger(entries, bytes, time.Since(last))
+		if !ok {
+			continue
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), snapshotPolicyTimeout)
+		index, err := rs.NodeHost.SyncRequestSnapshot(ctx, rs.ClusterID, dragonboat.SnapshotOption{})
+		cancel()
+		switch {
+		case errors.Is(err, dragonboat.ErrClosed), errors.Is(err, dragonboat.ErrShardNotFound):
+			return
+		case snapshotSkipped(index, err):
+			// 其他觸發條件已經建立了 snapshot
+		case err != nil:
+			logrus.WithFields(logrus.Fields{"ClusterID": rs.ClusterID, "trigger": reason, "error": err}).Warn("snapshot request failed")
+		default:
+			snapshotRequests(rs.ClusterID, reason).Inc()
+			logrus.WithFields(logrus.Fields{"ClusterID": rs.ClusterID, "trigger": reason, "index": index, "entries": entries, "bytes": bytes}).Info("snapshot created by policy")
+		}
+	}
+}
+
+// snapshotRequests 為非 dragonboat 筆數條件觸發的 snapshot 次數，trigger 為 interval、log_size 或 manual
+func snapshotRequests(clusterID uint64, trigger string) *metrics.Counter {
+	return metrics.GetOrCreateCounter(fmt.Sprintf(`raft_snapshot_requests_total{shard="%d",trigger="%s"}`, clusterID, trigger))
+}
+
+// snapshot 的耗時與大小遠大於一般請求，不使用預設的 bucket
+var (
+	snapshotDurationBuckets = metrics.ExponentialBuckets(0.01, 2, 16)  // 10ms ~ 約 5.5 分鐘
+	snapshotSizeBuckets     = metrics.ExponentialBuckets(1<<10, 4, 13) // 1 KiB ~ 16 GiB
+)
+
+// observeSnapshot 記錄狀態機寫出 snapshot 的耗時與大小（含外部檔案），失敗時只計入錯誤次數
+func observeSnapshot(clusterID uint64, start time.Time, size int64, err error) {
+	if err != nil {
+		metrics.GetOrCreateCounter(fmt.Sprintf(`raft_snapshot_errors_total{shard="%d"}`, clusterID)).Inc()
+		return
+	}
+	metrics.GetOrCreatePrometheusHistogramExt(fmt.Sprintf(`raft_snapshot_duration_seconds{shard="%d"}`, clusterID), snapshotDurationBuckets).UpdateDuration(start)
+	metrics.GetOrCreatePrometheusHistogramExt(fmt.Sprintf(`raft_snapshot_size_bytes{shard="%d"}`, clusterID), snapshotSizeBuckets).Update(float64(size))
+}
+
+// countingWriter 計算寫入 snapshot 串流的 bytes
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// countingFiles 計算加入 snapshot 的外部檔案大小
+type countingFiles struct {
+	statemachine.ISnapshotFileCollection
+	n int64
+}
+
+func (c *countingFiles) AddFile(fileID uint64, path string, metadata []byte) {
+	if info, err := os.Stat(path); err == nil {
+		c.n += info.Size()
+	}
+	c.ISnapshotFileCollection.AddFile(fileID, path, metadata)
+}
@@ -0,0 +1,139 @@
+package raft_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"go-raft/internal/configs"
+	"go-raft/internal/domain"
+	"go-raft/internal/gbsnap"
+	"go-raft/internal/raft"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/VictoriaMetrics/metrics"
+)
+
+func TestSnapshotControllerRequestAndList(t *testing.T) {
+	const clusterID = uint64(204)
+	addr := "localhost:24120"
+	rs, err := raft.New(raft.NodeConfig{FileDir: t.TempDir(), RaftAddress: addr, NodeID: 1, ClusterID: clusterID, InitialMembers: map[uint64]string{1: addr}})
+	if err != nil {
+		t.Fatalf("create node: %v", err)
+	}
+	if err := rs.Start(); err != nil {
+		t.Fatalf("start node: %v", err)
+	}
+	defer rs.NodeHost.Close()
+	waitForShardReady(t, rs, clusterID)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	sc := raft.NewSnapshotController(rs.NodeHost, clusterID)
+	mustPropose(t, rs, clusterID, domain.Command{Type: domain.CommandCurrency, Currency: &domain.Currency{Code: "USD", Precision: 2, Enabled: true}})
+	first, err := sc.Request(ctx, raft.SnapshotRequest{})
+	if err != nil || first.Index == 0 {
+		t.Fatalf("request snapshot: %+v, %v", first, err)
+	}
+	// 節點啟動時回報支援版本的提案可能在第一個 snapshot 之後才套用，重試直到沒有新的 log
+	for i := 0; ; i++ {
+		next, err := sc.Request(ctx, raft.SnapshotRequest{})
+		if errors.Is(err, raft.ErrSnapshotUpToDate) {
+			break
+		}
+		if err != nil || i == 3 {
+			t.Fatalf("expected ErrSnapshotUpToDate, got %+v, %v", next, err)
+		}
+		first = next
+	}
+
+	for i := 0; i < 3; i++ {
+		mustPropose(t, rs, clusterID, domain.Command{Type: domain.CommandAccount, Account: &domain.AccountCommand{UID: fmt.Sprintf("user-%d", i), Action: domain.AccountOpen}})
+	}
+	exportDir := t.TempDir()
+	second, err := sc.Request(ctx, raft.SnapshotRequest{ExportPath: exportDir, Compact: true})
+	if err != nil {
+		t.Fatalf("export and compact: %v", err)
+	}
+	if second.Index <= first.Index || !second.Compacted {
+		t.Fatalf("export and compact result %+v, first index %d", second, first.Index)
+	}
+	if second.Exported != filepath.Join(exportDir, fmt.Sprintf("snapshot-%016X", second.Index)) {
+		t.Fatalf("exported to %s", second.Exported)
+	}
+	if s, err := gbsnap.Open(second.Exported); err != nil || s.Index != second.Index {
+		t.Fatalf("open exported snapshot: %v", err)
+	}
+	reader, err := rs.NodeHost.GetLogReader(clusterID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first, _ := reader.GetRange(); first <= second.Index {
+		t.Fatalf("log not compacted: first index %d, snapshot index %d", first, second.Index)
+	}
+
+	infos, err := sc.List()
+	if err != nil {
+		t.Fatalf("list: %v", err)
+	}
+	if len(infos) == 0 || infos[0].Index != second.Index {
+		t.Fatalf("snapshots %+v, want latest index %d", infos, second.Index)
+	}
+	latest := infos[0]
+	if latest.Error != "" || latest.Term == 0 || latest.Size == 0 || latest.Version != configs.DefaultSnapshotVersion {
+		t.Fatalf("latest snapshot %+v", latest)
+	}
+}
+
+func TestSnapshotPolicyLogSizeTrigger(t *testing.T) {
+	const clusterID = uint64(205)
+	addr := "localhost:24130"
+	rs, err := raft.New(raft.NodeConfig{
+		FileDir:        t.TempDir(),
+		RaftAddress:    addr,
+		NodeID:         1,
+		ClusterID:      clusterID,
+		InitialMembers: map[uint64]string{1: addr},
+		// 筆數與時間條件都不會達成，只有 log 大小能觸發 snapshot
+		SnapshotPolicy: raft.SnapshotPolicy{Entries: 1 << 40, Interval: -1, LogSize: 2048},
+	})
+	if err != nil {
+		t.Fatalf("create node: %v", err)
+	}
+	if err := rs.Start(); err != nil {
+		t.Fatalf("start node: %v", err)
+	}
+	defer rs.NodeHost.Close()
+	waitForShardReady(t, rs, clusterID)
+
+	reader, err := rs.NodeHost.GetLogReader(clusterID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if index := reader.Snapshot().Index; index != 0 {
+		t.Fatalf("unexpected snapshot at index %d before writes", index)
+	}
+	var last uint64
+	for i := 0; i < 40; i++ {
+		mustPropose(t, rs, clusterID, domain.Command{Type: domain.CommandAccount, Account: &domain.AccountCommand{UID: fmt.Sprintf("user-%03d", i), Action: domain.AccountOpen}})
+	}
+	for range 10 {
+		if last = reader.Snapshot().Index; last != 0 {
+			break
+		}
+		time.Sleep(500 * time.Millisecond)
+	}
+	if last == 0 {
+		t.Fatal("log size trigger did not create a snapshot")
+	}
+	var out bytes.Buffer
+	metrics.WritePrometheus(&out, false)
+	for _, name := range []string{`raft_snapshot_requests_total{shard="205",trigger="log_size"}`, `raft_snapshot_size_bytes_count{shard="205"}`, `raft_snapshot_duration_seconds_count{shard="205"}`} {
+		if !strings.Contains(out.String(), name) {
+			t.Fatalf("metric %s not exported", name)
+		}
+	}
+}
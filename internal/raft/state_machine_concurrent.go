@@ -1,21 +1,36 @@
 package raft
 
 import (
-	"bytes"
-	"encoding/gob"
 	"fmt"
-	"go-raft/internal/configs"
 	"go-raft/internal/domain"
 	"go-raft/internal/store"
 	"io"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/lni/dragonboat/v4/statemachine"
+	"github.com/sirupsen/logrus"
 )
 
 type AssetConcurrentStateMachine struct {
 	nodeID    uint64
 	clusterID uint64
-	store     *store.CurrencyStore
+	// current 為目前的狀態，還原 snapshot 時整個替換，Lookup 不會看到還原到一半的狀態
+	current atomic.Pointer[store.CurrencyStore]
+	// applyMu 於套用每批 entry 時持有，匯出帳本時持有以取得單一 index 的檢視
+	applyMu sync.Mutex
+
+	// progress 為自上次 snapshot 後的套用進度，匯出的 snapshot 也會經過 PrepareSnapshot，因此同樣會重置
+	progress snapshotProgress
+
+	// checksums 接收套用 checksum 命令後計算的本節點結果，nil 時不計算
+	checksums chan<- domain.ChecksumReport
+	// host 為本節點的 NodeHost ID，由 RaftStore 設定，用於判斷命令是否由本節點提案
+	host string
+
+	metrics *applyMetrics
+	log     *logrus.Entry
 }
 
 var _ statemachine.IConcurrentStateMachine = (*AssetConcurrentStateMachine)(nil)
@@ -23,9 +38,18 @@ var _ statemachine.IConcurrentStateMachine = (*AssetConcurrentStateMachine)(nil)
 func NewAssetRaftConcurrentMachine(
 	clusterID uint64,
 	nodeID uint64,
+	fileDir string,
+	historyRetention uint64,
 ) statemachine.IConcurrentStateMachine {
-	cs := store.NewCurrencyStore(clusterID, nodeID)
-	return &AssetConcurrentStateMachine{store: cs, clusterID: clusterID, nodeID: nodeID}
+	sm := &AssetConcurrentStateMachine{clusterID: clusterID, nodeID: nodeID, metrics: newApplyMetrics(clusterID), log: smLogger(clusterID, nodeID)}
+	sm.current.Store(store.NewCurrencyStore(clusterID, nodeID, fileDir, historyRetention))
+	sm.progress.reset()
+	return sm
+}
+
+// store 回傳目前的狀態
+func (a *AssetConcurrentStateMachine) store() *store.CurrencyStore {
+	return a.current.Load()
 }
 
 // 批次更新
@@ -36,44 +60,63 @@ func (a *AssetConcurrentStateMachine) Update(entries []statemachine.Entry) ([]st
 			// 例如 logrus.Errorf("Recovered in Update: %v", r)
 		}
 	}()
+	a.applyMu.Lock()
+	defer a.applyMu.Unlock()
+	start := time.Now()
+	env := applyEnv{log: a.log, host: a.host, checksum: a.checksum}
 	for i, entry := range entries {
-		var cmd domain.Asset
-		if err := gob.NewDecoder(bytes.NewReader(entry.Cmd)).Decode(&cmd); err != nil {
-			entries[i].Result = statemachine.Result{Value: 1}
-			continue
-		}
-		a.store.Update(cmd.UID, cmd.Currency, cmd.Amount)
-		entries[i].Result = statemachine.Result{Value: 0}
+		entries[i].Result = statemachine.Result{Value: uint64(applyEntry(a.store(), entry, env))}
 	}
+	a.progress.record(entries)
+	a.metrics.observe(start, entries)
 	return entries, nil
 }
 
+// checksum 於套用 checksum 命令時取得當下的檢視，狀態摘要在背景計算，不暫停之後的 Update
+func (a *AssetConcurrentStateMachine) checksum() {
+	if a.checksums == nil {
+		return
+	}
+	view := a.store().View()
+	go func() { sendChecksum(a.checksums, view.Checksum(a.nodeID)) }()
+}
+
 // 查詢
 func (a *AssetConcurrentStateMachine) Lookup(query any) (any, error) {
-	switch q := query.(type) {
-	case domain.Asset:
-		// 查單一使用者幣別餘額
-		return a.store.Get(q.UID, q.Currency), nil
-	case string:
-		if q == "list" {
-			result := a.store.List()
-			// log.Printf("Returning list data: %+v", result) // 添加日誌
-			return result, nil
-		}
+	if q, ok := query.(domain.ExportQuery); ok {
+		// 只在取得檢視時暫停套用 entry，複製與編碼都與之後的 Update 並行
+		a.applyMu.Lock()
+		view := a.store().View()
+		a.applyMu.Unlock()
+		return export(view, q)
 	}
-	return nil, fmt.Errorf("unknown query")
+	// 整個查詢使用同一份狀態，避免與 snapshot 還原交錯
+	return lookup(a.store(), query)
 }
 
-// 快照儲存
-func (a *AssetConcurrentStateMachine) SaveSnapshot(_ any, w io.Writer, fss statemachine.ISnapshotFileCollection, done <-chan struct{}) error {
-	err := a.store.SaveSnapshot(w, fss, done)
+// 快照儲存，寫出 PrepareSnapshot 當下的檢視，與 Update 並行
+func (a *AssetConcurrentStateMachine) SaveSnapshot(ctx any, w io.Writer, fss statemachine.ISnapshotFileCollection, done <-chan struct{}) error {
+	prepared, ok := ctx.(*store.PreparedSnapshot)
+	if !ok {
+		return fmt.Errorf("unexpected snapshot context %T", ctx)
+	}
+	start := time.Now()
+	cw, cf := &countingWriter{w: w}, &countingFiles{ISnapshotFileCollection: fss}
+	err := prepared.Save(cw, cf, done)
+	observeSnapshot(a.clusterID, start, cw.n+cf.n, err)
 	return err
 }
 
 // 快照回復
+// 先還原到全新的 store，成功後才替換目前狀態；還原失敗或中止時保留原本的狀態
 func (a *AssetConcurrentStateMachine) RecoverFromSnapshot(r io.Reader, files []statemachine.SnapshotFile, done <-chan struct{}) error {
-	err := a.store.RecoverFromSnapshot(r, files, done)
-	return err
+	fresh := a.store().Fresh()
+	if err := fresh.RecoverFromSnapshot(r, files, done); err != nil {
+		return err
+	}
+	a.current.Store(fresh)
+	a.progress.reset()
+	return nil
 }
 
 // Close 關閉 IConcurrentStateMachine 實例，釋放資源。
@@ -86,11 +129,17 @@ func (a *AssetConcurrentStateMachine) Close() error {
 }
 
 // PrepareSnapshot 準備快照，回傳代表狀態識別符的介面值。
-// 一般回傳一個描述目前狀態版本的標識，如版本號或序列號。
 // PrepareSnapshot 與 Update 互斥調用，可安全讀取狀態。
 func (a *AssetConcurrentStateMachine) PrepareSnapshot() (any, error) {
-	// 假設 store 有版本號 (Version)，用來標識目前狀態
-	// 如果沒有版本控制，可以回傳 nil，表示無特殊標識
-	version := configs.GetSnapshotVersion(a.nodeID, a.clusterID)
-	return version, nil
+	// 取得目前狀態的不可變檢視與複製設定中的 snapshot 格式版本，SaveSnapshot 依此寫入
+	// 檢視以 copy-on-write 共用資料，成本與帳戶數量無關，之後的 Update 不會改變 snapshot 內容
+	cs := a.store()
+	prepared := cs.PrepareSnapshot(cs.Settings().SnapshotVersion)
+	a.progress.reset()
+	return prepared, nil
+}
+
+// sinceSnapshot 回傳自上次 snapshot 後套用的 entry 筆數、大小與上次 snapshot 的時間
+func (a *AssetConcurrentStateMachine) sinceSnapshot() (uint64, uint64, time.Time) {
+	return a.progress.since()
 }
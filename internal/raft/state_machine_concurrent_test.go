@@ -0,0 +1,129 @@
+package raft_test
+
+import (
+	"bytes"
+	"encoding/gob"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"go-raft/internal/domain"
+	"go-raft/internal/raft"
+
+	"github.com/lni/dragonboat/v4/statemachine"
+)
+
+func TestRecoverFailureKeepsState(t *testing.T) {
+	sm := raft.NewAssetRaftConcurrentMachine(1, 1, t.TempDir(), 0)
+	var entries []statemachine.Entry
+	for i := 0; i < 20; i++ {
+		asset := domain.Asset{UID: fmt.Sprintf("user-%d", i%4), Currency: "USD", Amount: float64(i)}
+		// 舊版 entry 不檢查幣別註冊，方便直接寫入
+		data, err := encodeLegacyAsset(asset)
+		if err != nil {
+			t.Fatal(err)
+		}
+		entries = append(entries, statemachine.Entry{Index: uint64(i + 1), Cmd: data})
+	}
+	if _, err := sm.Update(entries); err != nil {
+		t.Fatalf("update: %v", err)
+	}
+	want, _ := sm.Lookup("list")
+
+	ctx, err := sm.PrepareSnapshot()
+	if err != nil {
+		t.Fatalf("prepare: %v", err)
+	}
+	var buf bytes.Buffer
+	fss := &fileCollection{}
+	if err := sm.SaveSnapshot(ctx, &buf, fss, nil); err != nil {
+		t.Fatalf("save: %v", err)
+	}
+
+	// 成功還原兩次，狀態都與 snapshot 相同
+	for i := 0; i < 2; i++ {
+		if err := sm.RecoverFromSnapshot(bytes.NewReader(buf.Bytes()), fss.files, nil); err != nil {
+			t.Fatalf("recover #%d: %v", i+1, err)
+		}
+		if got, _ := sm.Lookup("list"); !reflect.DeepEqual(got, want) {
+			t.Fatalf("recover #%d: got %v, want %v", i+1, got, want)
+		}
+	}
+
+	// 中止的還原不可改動目前狀態
+	done := make(chan struct{})
+	close(done)
+	if err := sm.RecoverFromSnapshot(bytes.NewReader(buf.Bytes()), fss.files, done); err == nil {
+		t.Fatal("expected aborted recovery to fail")
+	}
+	// 截斷的 snapshot 不可改動目前狀態
+	if err := sm.RecoverFromSnapshot(bytes.NewReader(buf.Bytes()[:buf.Len()/2]), fss.files, nil); err == nil {
+		t.Fatal("expected truncated snapshot to fail")
+	}
+	if got, _ := sm.Lookup("list"); !reflect.DeepEqual(got, want) {
+		t.Fatalf("failed recovery changed state: got %v, want %v", got, want)
+	}
+}
+
+// TestReplicasShareClock 各副本只使用 entry 內的提案時間，時間倒退的提案在所有副本上修正為相同的 now
+func TestReplicasShareClock(t *testing.T) {
+	proposed := []int64{1000, 5000, 3000, 0, 6000}
+	var entries []statemachine.Entry
+	for i, ts := range proposed {
+		cmd := domain.Command{
+			Type:      domain.CommandAccount,
+			Version:   domain.CommandVersion,
+			Timestamp: ts,
+			Account:   &domain.AccountCommand{UID: fmt.Sprintf("user-%d", i), Action: domain.AccountOpen},
+		}
+		data, err := cmd.Encode()
+		if err != nil {
+			t.Fatal(err)
+		}
+		entries = append(entries, statemachine.Entry{Index: uint64(i + 1), Cmd: data})
+	}
+
+	replicas := map[string]lookuper{
+		"node-1": raft.NewAssetRaftConcurrentMachine(1, 1, t.TempDir(), 0),
+		"node-2": raft.NewAssetRaftConcurrentMachine(1, 2, t.TempDir(), 0),
+	}
+	for _, sm := range replicas {
+		if _, err := sm.(statemachine.IConcurrentStateMachine).Update(cloneEntries(entries)); err != nil {
+			t.Fatalf("update: %v", err)
+		}
+	}
+	disk := openDisk(t, t.TempDir(), 0, 0)
+	if _, err := disk.Update(cloneEntries(entries)); err != nil {
+		t.Fatalf("disk update: %v", err)
+	}
+	replicas["disk"] = disk
+
+	want := []int64{1000, 5000, 5000, 5000, 6000}
+	for name, sm := range replicas {
+		for i, now := range want {
+			v, err := sm.Lookup(domain.AccountQuery{UID: fmt.Sprintf("user-%d", i)})
+			if err != nil {
+				t.Fatalf("%s: lookup user-%d: %v", name, i, err)
+			}
+			if got := v.(domain.AccountView).CreatedAt; got != now {
+				t.Fatalf("%s: user-%d created at %d, want %d", name, i, got, now)
+			}
+		}
+	}
+}
+
+// encodeLegacyAsset 以舊版格式直接編碼 Asset
+func encodeLegacyAsset(asset domain.Asset) ([]byte, error) {
+	var buf bytes.Buffer
+	err := gob.NewEncoder(&buf).Encode(asset)
+	return buf.Bytes(), err
+}
+
+// fileCollection 模擬 dragonboat 收集外部檔案，還原時直接交回相同路徑
+type fileCollection struct {
+	files []statemachine.SnapshotFile
+}
+
+func (f *fileCollection) AddFile(fileID uint64, path string, metadata []byte) {
+	f.files = append(f.files, statemachine.SnapshotFile{FileID: fileID, Filepath: path, Metadata: metadata})
+}
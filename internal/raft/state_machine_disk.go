@@ -0,0 +1,203 @@
+package raft
+
+import (
+	"errors"
+	"fmt"
+	"go-raft/internal/domain"
+	"go-raft/internal/store"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/lni/dragonboat/v4/statemachine"
+	"github.com/sirupsen/logrus"
+)
+
+// AssetDiskStateMachine 將帳本存放在 Pebble 的 IOnDiskStateMachine，命令與查詢規則與 AssetConcurrentStateMachine 相同
+// 套用進度與資料在同一個 batch 寫入，重新啟動時 dragonboat 只重播 Open 回傳的 index 之後的 entry
+type AssetDiskStateMachine struct {
+	nodeID    uint64
+	clusterID uint64
+	dir       string
+	retention uint64
+	db        *store.DiskStore
+
+	// progress 為自上次 snapshot 後的套用進度；本機的 snapshot 不經過 PrepareSnapshot，於 Sync 時重置
+	progress snapshotProgress
+
+	// checksums 接收套用 checksum 命令後計算的本節點結果，nil 時不計算
+	checksums chan<- domain.ChecksumReport
+	// pending 為背景計算中的 checksum，持有 Pebble snapshot，關閉資料庫前需等待完成
+	pending sync.WaitGroup
+	// host 為本節點的 NodeHost ID，由 RaftStore 設定，用於判斷命令是否由本節點提案
+	host string
+
+	metrics *applyMetrics
+	log     *logrus.Entry
+}
+
+var _ statemachine.IOnDiskStateMachine = (*AssetDiskStateMachine)(nil)
+
+// NewAssetDiskStateMachine 建立存放在 dir 的狀態機，資料庫在 Open 時才開啟
+func NewAssetDiskStateMachine(
+	clusterID uint64,
+	nodeID uint64,
+	dir string,
+	historyRetention uint64,
+) statemachine.IOnDiskStateMachine {
+	sm := &AssetDiskStateMachine{clusterID: clusterID, nodeID: nodeID, dir: dir, retention: historyRetention, metrics: newApplyMetrics(clusterID), log: smLogger(clusterID, nodeID)}
+	sm.progress.reset()
+	return sm
+}
+
+// Open 開啟 Pebble 並回傳已持久化的最後套用 index
+func (a *AssetDiskStateMachine) Open(stopc <-chan struct{}) (uint64, error) {
+	if err := os.MkdirAll(a.dir, 0o755); err != nil {
+		return 0, err
+	}
+	db, err := store.OpenDiskStore(a.dir, a.retention)
+	if err != nil {
+		return 0, err
+	}
+	applied, err := db.Applied()
+	if err != nil {
+		db.Close()
+		return 0, err
+	}
+	a.db = db
+	return applied, nil
+}
+
+// 批次更新，整批 entry 與套用進度一起寫入，寫入失敗時 dragonboat 會停止此 replica
+// 套用 checksum 命令後先寫入已套用的 entry 並取得 Pebble snapshot，狀態摘要在背景計算，其餘 entry 以新的 batch 套用
+func (a *AssetDiskStateMachine) Update(entries []statemachine.Entry) ([]statemachine.Entry, error) {
+	start := time.Now()
+	ledger, err := a.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if ledger != nil {
+			ledger.Close()
+		}
+	}()
+	var checksum bool
+	env := applyEnv{log: a.log, host: a.host, checksum: func() { checksum = a.checksums != nil }}
+	first := 0
+	for i, entry := range entries {
+		entries[i].Result = statemachine.Result{Value: uint64(applyEntry(ledger, entry, env))}
+		if !checksum {
+			continue
+		}
+		checksum = false
+		if err := ledger.Commit(); err != nil {
+			return nil, fmt.Errorf("commit entries %d-%d: %w", entries[first].Index, entry.Index, err)
+		}
+		ledger.Close()
+		ledger = nil
+		a.checksum()
+		if ledger, err = a.db.Begin(); err != nil {
+			return nil, err
+		}
+		first = i + 1
+	}
+	if first < len(entries) {
+		if err := ledger.Commit(); err != nil {
+			return nil, fmt.Errorf("commit entries %d-%d: %w", entries[first].Index, entries[len(entries)-1].Index, err)
+		}
+	}
+	a.progress.record(entries)
+	a.metrics.observe(start, entries)
+	return entries, nil
+}
+
+// checksum 取得已寫入狀態的 Pebble snapshot，狀態摘要在背景掃描計算，不暫停之後的 Update
+func (a *AssetDiskStateMachine) checksum() {
+	view, err := a.db.View()
+	if err != nil {
+		a.log.WithError(err).Error("open checksum view failed")
+		return
+	}
+	a.pending.Add(1)
+	go func() {
+		defer a.pending.Done()
+		defer view.Close()
+		report := view.Checksum(a.nodeID)
+		if err := view.Err(); err != nil {
+			a.log.WithError(err).Error("compute checksum failed")
+			return
+		}
+		sendChecksum(a.checksums, report)
+	}()
+}
+
+// 查詢，每次查詢使用一個 Pebble snapshot，匯出也能取得單一 index 的一致狀態而不需暫停 Update
+func (a *AssetDiskStateMachine) Lookup(query any) (any, error) {
+	view, err := a.db.View()
+	if err != nil {
+		return nil, err
+	}
+	defer view.Close()
+	var result any
+	if q, ok := query.(domain.ExportQuery); ok {
+		result, err = export(view, q)
+	} else {
+		result, err = lookup(view, query)
+	}
+	return result, errors.Join(err, view.Err())
+}
+
+// Sync 將已套用的 entry fsync 到磁碟，dragonboat 於每次建立 snapshot 時呼叫，之後才可壓縮 log
+func (a *AssetDiskStateMachine) Sync() error {
+	if err := a.db.Sync(); err != nil {
+		return err
+	}
+	a.progress.reset()
+	return nil
+}
+
+// PrepareSnapshot 取得目前狀態的 Pebble snapshot，與 Update 互斥調用
+// 只有傳送給 follower 與匯出時呼叫，取得的 snapshot 由 SaveSnapshot 釋放
+func (a *AssetDiskStateMachine) PrepareSnapshot() (any, error) {
+	return a.db.View()
+}
+
+// 快照儲存，只在傳送給 follower 或匯出時呼叫；本機的 snapshot 只紀錄 index
+func (a *AssetDiskStateMachine) SaveSnapshot(ctx any, w io.Writer, done <-chan struct{}) error {
+	view, ok := ctx.(*store.DiskLedger)
+	if !ok {
+		return fmt.Errorf("unexpected snapshot context %T", ctx)
+	}
+	defer view.Close()
+	start := time.Now()
+	cw := &countingWriter{w: w}
+	err := view.WriteSnapshot(cw, done)
+	observeSnapshot(a.clusterID, start, cw.n, err)
+	return err
+}
+
+// 快照回復，以 snapshot 取代 Pebble 中的所有資料
+func (a *AssetDiskStateMachine) RecoverFromSnapshot(r io.Reader, done <-chan struct{}) error {
+	if err := a.db.Restore(r, done); err != nil {
+		return err
+	}
+	a.progress.reset()
+	return nil
+}
+
+// Close 關閉 Pebble，尚未 Sync 的寫入在 WAL 中，程序結束後仍會保留，只有主機斷電時可能遺失並由 raft log 重播
+func (a *AssetDiskStateMachine) Close() error {
+	if a.db == nil {
+		return nil
+	}
+	a.pending.Wait()
+	db := a.db
+	a.db = nil
+	return db.Close()
+}
+
+// sinceSnapshot 回傳自上次 snapshot 後套用的 entry 筆數、大小與上次 snapshot 的時間
+func (a *AssetDiskStateMachine) sinceSnapshot() (uint64, uint64, time.Time) {
+	return a.progress.since()
+}
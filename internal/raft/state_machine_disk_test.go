@@ -0,0 +1,229 @@
+package raft_test
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"go-raft/internal/domain"
+	"go-raft/internal/raft"
+	"go-raft/internal/store"
+
+	"github.com/lni/dragonboat/v4/statemachine"
+)
+
+// TestDiskStateMachineMatchesMemory 以相同的 entry 套用到兩種狀態機，查詢結果、重新啟動與 snapshot 還原後都必須一致
+func TestDiskStateMachineMatchesMemory(t *testing.T) {
+	const retention = 40
+	entries := diskTestEntries(t, 300)
+
+	mem := raft.NewAssetRaftConcurrentMachine(1, 1, t.TempDir(), retention)
+	memResults, err := mem.Update(cloneEntries(entries))
+	if err != nil {
+		t.Fatalf("memory update: %v", err)
+	}
+
+	dir := t.TempDir()
+	disk := openDisk(t, dir, retention, 0)
+	var diskResults []statemachine.Entry
+	for i := 0; i < len(entries); i += 7 {
+		batch, err := disk.Update(cloneEntries(entries[i:min(i+7, len(entries))]))
+		if err != nil {
+			t.Fatalf("disk update: %v", err)
+		}
+		diskResults = append(diskResults, batch...)
+	}
+	for i := range memResults {
+		if memResults[i].Result.Value != diskResults[i].Result.Value {
+			t.Fatalf("entry %d: memory result %d, disk result %d", entries[i].Index, memResults[i].Result.Value, diskResults[i].Result.Value)
+		}
+	}
+	if code := domain.ResultCode(diskResults[6].Result.Value); code != domain.ResultOK {
+		t.Fatalf("activate snapshot v3: result %v", code)
+	}
+	if code := domain.ResultCode(diskResults[7].Result.Value); code != domain.ResultInvalidCommand {
+		t.Fatalf("uid containing \\x00: result %v, want %v", code, domain.ResultInvalidCommand)
+	}
+	compareLookups(t, "after update", mem, disk, len(entries))
+
+	// 重新開啟後從 Pebble 讀回套用進度與資料，不需要 snapshot
+	if err := disk.Close(); err != nil {
+		t.Fatal(err)
+	}
+	disk = openDisk(t, dir, retention, uint64(len(entries)))
+	compareLookups(t, "after reopen", mem, disk, len(entries))
+
+	// Pebble 格式的 snapshot 還原到另一個狀態機
+	ctx, err := disk.PrepareSnapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := disk.SaveSnapshot(ctx, &buf, nil); err != nil {
+		t.Fatalf("save: %v", err)
+	}
+	restored := openDisk(t, t.TempDir(), retention, 0)
+	if err := restored.RecoverFromSnapshot(bytes.NewReader(buf.Bytes()), nil); err != nil {
+		t.Fatalf("recover: %v", err)
+	}
+	compareLookups(t, "after disk snapshot", mem, restored, len(entries))
+	// Pebble 格式的 snapshot 也可以不經資料庫直接解碼，內容與匯出相同
+	decoded, err := store.DecodeSnapshot(bytes.NewReader(buf.Bytes()), nil, nil)
+	if err != nil {
+		t.Fatalf("decode disk snapshot: %v", err)
+	}
+	exported, err := disk.Lookup(domain.ExportQuery{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(decoded, exported) {
+		t.Fatalf("decoded disk snapshot differs from export:\n%+v\n%+v", decoded.Meta, exported.(*store.SnapshotContent).Meta)
+	}
+	if err := restored.RecoverFromSnapshot(bytes.NewReader(buf.Bytes()[:buf.Len()/2]), nil); err == nil {
+		t.Fatal("expected truncated snapshot to fail")
+	}
+
+	// 記憶體狀態機寫出的 v3 snapshot 也可以還原到 Pebble
+	ctx, err = mem.PrepareSnapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf.Reset()
+	if err := mem.SaveSnapshot(ctx, &buf, &fileCollection{}, nil); err != nil {
+		t.Fatalf("save memory snapshot: %v", err)
+	}
+	fromMemory := openDisk(t, t.TempDir(), retention, 0)
+	if err := fromMemory.RecoverFromSnapshot(bytes.NewReader(buf.Bytes()), nil); err != nil {
+		t.Fatalf("recover memory snapshot: %v", err)
+	}
+	compareLookups(t, "after memory snapshot", mem, fromMemory, len(entries))
+}
+
+func openDisk(t *testing.T, dir string, retention, wantApplied uint64) statemachine.IOnDiskStateMachine {
+	t.Helper()
+	sm := raft.NewAssetDiskStateMachine(1, 1, dir, retention)
+	applied, err := sm.Open(nil)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	if applied != wantApplied {
+		t.Fatalf("open: applied %d, want %d", applied, wantApplied)
+	}
+	t.Cleanup(func() { sm.Close() })
+	return sm
+}
+
+// diskTestEntries 產生涵蓋幣別、帳戶、限額、舊版與無法解析命令的 entry，金額皆為 0.5 的倍數使總和沒有浮點誤差
+func diskTestEntries(t *testing.T, n int) []statemachine.Entry {
+	t.Helper()
+	base := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	users := []string{"user-0", "user-1", "user-2", "user-3", "user-4", "user-5"}
+	var entries []statemachine.Entry
+	for i := 1; i <= n; i++ {
+		ts := base.Add(time.Duration(i) * 30 * time.Minute)
+		if i%10 == 0 {
+			// 時間倒退的提案沿用上一個時間
+			ts = ts.Add(-2 * time.Hour)
+		}
+		cmd := domain.Command{Version: domain.CommandVersion, Timestamp: ts.UnixNano()}
+		uid := users[i%len(users)]
+		switch {
+		case i == 1:
+			cmd.Type, cmd.Currency = domain.CommandCurrency, &domain.Currency{Code: "USD", Precision: 2, Enabled: true}
+		case i == 2:
+			cmd.Type, cmd.Currency = domain.CommandCurrency, &domain.Currency{Code: "BTC", Precision: 8, Enabled: true, MaxAmount: 15}
+		case i == 3:
+			cmd.Type, cmd.Limit = domain.CommandLimit, &domain.LimitCommand{Rule: domain.LimitRule{Scope: domain.LimitScopeCurrency, Currency: "USD", Policy: domain.LimitPolicy{DailyWithdrawCap: 40}}}
+		case i == 4:
+			cmd.Type, cmd.Account = domain.CommandAccount, &domain.AccountCommand{UID: "user-0", Action: domain.AccountOpen, Tier: "vip", Metadata: map[string]string{"k": "v"}}
+		case i == 5:
+			cmd.Type, cmd.Limit = domain.CommandLimit, &domain.LimitCommand{Rule: domain.LimitRule{Scope: domain.LimitScopeTier, Currency: "USD", Tier: "vip", Policy: domain.LimitPolicy{MaxSingleAmount: 12}}}
+		case i == 6:
+			cmd.Type, cmd.NodeSupport = domain.CommandNodeSupport, &domain.NodeSupport{NodeID: 1, SnapshotVersions: []uint64{1, 2, 3}, CommandVersions: []uint64{0, 1}}
+		case i == 7:
+			cmd.Type, cmd.Activation = domain.CommandActivate, &domain.Activation{Feature: domain.FeatureSnapshot, Version: 3, Members: []uint64{1}}
+		case i == 8:
+			// Pebble 的 key 以 \x00 分隔欄位，兩種狀態機都必須拒絕
+			cmd.Type, cmd.Asset = domain.CommandAsset, &domain.Asset{UID: "user-1\x00BTC", Currency: "USD", Amount: 1}
+		case i%37 == 0:
+			cmd.Type, cmd.Account = domain.CommandAccount, &domain.AccountCommand{UID: "user-2", Action: domain.AccountFreeze, Reason: "review"}
+		case i%53 == 0:
+			cmd.Type, cmd.Account = domain.CommandAccount, &domain.AccountCommand{UID: "user-2", Action: domain.AccountUnfreeze}
+		case i%71 == 0:
+			cmd.Type, cmd.Account = domain.CommandAccount, &domain.AccountCommand{UID: uid, Action: domain.AccountClose}
+		case i%29 == 0:
+			data, err := encodeLegacyAsset(domain.Asset{UID: uid, Currency: "LEGACY", Amount: float64(i) / 2})
+			if err != nil {
+				t.Fatal(err)
+			}
+			entries = append(entries, statemachine.Entry{Index: uint64(i), Cmd: data})
+			continue
+		case i%31 == 0:
+			entries = append(entries, statemachine.Entry{Index: uint64(i), Cmd: []byte("not a command")})
+			continue
+		default:
+			currency := []string{"USD", "BTC"}[i%2]
+			cmd.Type, cmd.Asset = domain.CommandAsset, &domain.Asset{UID: uid, Currency: currency, Amount: float64(i%17-6) * 2.5}
+		}
+		data, err := cmd.Encode()
+		if err != nil {
+			t.Fatal(err)
+		}
+		entries = append(entries, statemachine.Entry{Index: uint64(i), Cmd: data})
+	}
+	return entries
+}
+
+func cloneEntries(entries []statemachine.Entry) []statemachine.Entry {
+	return append([]statemachine.Entry(nil), entries...)
+}
+
+type lookuper interface {
+	Lookup(query any) (any, error)
+}
+
+func compareLookups(t *testing.T, stage string, want, got lookuper, applied int) {
+	t.Helper()
+	base := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	queries := []any{
+		"list",
+		domain.StatsQuery{TopN: 3},
+		domain.StatsQuery{Currency: "USD", TopN: 2, Verify: true},
+		domain.LimitQuery{},
+		domain.SettingsQuery{},
+		domain.UpgradeQuery{},
+		domain.CurrencyQuery{},
+		domain.CurrencyQuery{Code: "EUR"},
+	}
+	for _, uid := range []string{"user-0", "user-1", "user-2", "user-5", "nobody"} {
+		queries = append(queries,
+			domain.Asset{UID: uid, Currency: "USD"},
+			domain.AccountQuery{UID: uid},
+			domain.LimitQuery{UID: uid, Currency: "USD"},
+		)
+		for index := 0; index <= applied+1; index += 13 {
+			queries = append(queries, domain.BalanceQuery{UID: uid, Currency: "BTC", AsOfIndex: uint64(index)})
+		}
+		for hours := -1; hours <= applied/2+1; hours += 7 {
+			queries = append(queries, domain.BalanceQuery{UID: uid, Currency: "USD", AsOfTime: base.Add(time.Duration(hours) * time.Hour).UnixNano()})
+		}
+	}
+	for _, q := range queries {
+		wantResult, wantErr := want.Lookup(q)
+		gotResult, gotErr := got.Lookup(q)
+		if fmt.Sprint(wantErr) != fmt.Sprint(gotErr) || !reflect.DeepEqual(wantResult, gotResult) {
+			t.Fatalf("%s: %#v: memory (%v, %v), disk (%v, %v)", stage, q, wantResult, wantErr, gotResult, gotErr)
+		}
+	}
+
+	wantExport, _ := want.Lookup(domain.ExportQuery{})
+	gotExport, err := got.Lookup(domain.ExportQuery{})
+	if err != nil {
+		t.Fatalf("%s: export: %v", stage, err)
+	}
+	if !reflect.DeepEqual(wantExport.(*store.SnapshotContent).Balances, gotExport.(*store.SnapshotContent).Balances) {
+		t.Fatalf("%s: exported balances differ", stage)
+	}
+}
@@ -0,0 +1,133 @@
+package raft_test
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"go-raft/internal/domain"
+	"go-raft/internal/raft"
+	"go-raft/internal/tracing"
+
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+// TestProposeSpans 提案節點的 encode、SyncPropose 與套用 span 都在請求的 trace 中，其他副本的套用 span 以 link 關聯提案 span
+func TestProposeSpans(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	previous := otel.GetTracerProvider()
+	otel.SetTracerProvider(provider)
+	defer otel.SetTracerProvider(previous)
+
+	const clusterID = uint64(210)
+	members := map[uint64]string{1: "localhost:24170", 2: "localhost:24171"}
+	var nodes []*raft.RaftStore
+	for nodeID := uint64(1); nodeID <= 2; nodeID++ {
+		rs, err := raft.New(raft.NodeConfig{
+			FileDir:        filepath.Join(t.TempDir(), fmt.Sprint(nodeID)),
+			RaftAddress:    members[nodeID],
+			NodeID:         nodeID,
+			ClusterID:      clusterID,
+			InitialMembers: members,
+		})
+		if err != nil {
+			t.Fatalf("create node %d: %v", nodeID, err)
+		}
+		if err := rs.Start(); err != nil {
+			t.Fatalf("start node %d: %v", nodeID, err)
+		}
+		defer rs.NodeHost.Close()
+		nodes = append(nodes, rs)
+	}
+	for _, rs := range nodes {
+		waitForShardReady(t, rs, clusterID)
+	}
+	leader, err := findLeaderWithRaftStore(nodes, clusterID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	ctx, request := tracing.Tracer().Start(ctx, "request")
+	cmd := domain.Command{Type: domain.CommandCurrency, Currency: &domain.Currency{Code: "USD", Precision: 2, Enabled: true}}
+	if code, err := raft.Propose(ctx, leader.NodeHost, clusterID, cmd); err != nil || code != domain.ResultOK {
+		t.Fatalf("propose: %v, %v", code, err)
+	}
+	request.End()
+	traceID := request.SpanContext().TraceID()
+
+	// 節點啟動時回報支援版本等背景提案也有各自的 span，只檢查與此請求相關的 span
+	var byName map[string]int
+	var children, linked int
+	for range 20 {
+		var propose tracetest.SpanStub
+		byName = map[string]int{}
+		spans := exporter.GetSpans()
+		for _, span := range spans {
+			if span.SpanContext.TraceID() == traceID && span.Name != "raft.apply" {
+				byName[span.Name]++
+				if span.Name == "raft.propose" {
+					propose = span
+				}
+			}
+		}
+		children, linked = 0, 0
+		for _, span := range spans {
+			if span.Name != "raft.apply" {
+				continue
+			}
+			switch {
+			case span.SpanContext.TraceID() == traceID && span.Parent.SpanID() == propose.SpanContext.SpanID():
+				children++
+			case len(span.Links) == 1 && span.Links[0].SpanContext.SpanID() == propose.SpanContext.SpanID():
+				linked++
+			}
+		}
+		if children+linked == len(nodes) {
+			break
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	if byName["raft.propose"] != 1 || byName["command.encode"] != 1 || byName["raft.SyncPropose"] != 1 {
+		t.Fatalf("proposal spans %v", byName)
+	}
+	if children != 1 || linked != len(nodes)-1 {
+		t.Fatalf("apply spans: %d children, %d linked, want 1 and %d", children, linked, len(nodes)-1)
+	}
+}
+
+// TestInjectOnlyRecordedSpans 未啟用 tracing 或 span 未被取樣時，上游的 traceparent 不寫入命令
+func TestInjectOnlyRecordedSpans(t *testing.T) {
+	header := http.Header{}
+	header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	upstream := tracing.ExtractHTTP(context.Background(), header)
+
+	ctx, span := noop.NewTracerProvider().Tracer("test").Start(upstream, "raft.propose")
+	if carrier := tracing.Inject(ctx); carrier != nil {
+		t.Fatalf("no-op tracer injected %v", carrier)
+	}
+	span.End()
+
+	never := sdktrace.NewTracerProvider(sdktrace.WithSampler(sdktrace.NeverSample()))
+	ctx, span = never.Tracer("test").Start(context.Background(), "raft.propose")
+	if carrier := tracing.Inject(ctx); carrier != nil {
+		t.Fatalf("unsampled span injected %v", carrier)
+	}
+	span.End()
+
+	always := sdktrace.NewTracerProvider(sdktrace.WithSampler(sdktrace.AlwaysSample()))
+	ctx, span = always.Tracer("test").Start(upstream, "raft.propose")
+	defer span.End()
+	carrier := tracing.Inject(ctx)
+	if got := tracing.Extract(carrier); got.TraceID() != span.SpanContext().TraceID() || got.SpanID() != span.SpanContext().SpanID() {
+		t.Fatalf("recorded span not injected: %v", carrier)
+	}
+}
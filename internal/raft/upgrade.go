@@ -0,0 +1,229 @@
+package raft
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"go-raft/internal/configs"
+	"go-raft/internal/domain"
+	"slices"
+	"time"
+
+	"github.com/lni/dragonboat/v4"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	advertiseTimeout = 5 * time.Second // 單次回報支援版本的提案逾時
+	advertiseBackoff = time.Second     // 回報失敗（例如尚未選出 leader）後的重試間隔
+)
+
+// UpgradeController 協調滾動升級：只有所有成員都回報支援時才允許啟用新格式，啟用後不可降級
+type UpgradeController struct {
+	nh        *dragonboat.NodeHost
+	clusterID uint64
+}
+
+func NewUpgradeController(nh *dragonboat.NodeHost, clusterID uint64) *UpgradeController {
+	return &UpgradeController{nh: nh, clusterID: clusterID}
+}
+
+// Status 回傳已啟用版本、各節點回報的支援版本，以及依目前成員可啟用的版本
+func (u *UpgradeController) Status(ctx context.Context) (domain.UpgradeStatus, error) {
+	status, _, err := u.status(ctx)
+	return status, err
+}
+
+// status 回傳升級狀態與已從 shard 移除的節點
+func (u *UpgradeController) status(ctx context.Context) (domain.UpgradeStatus, []uint64, error) {
+	members, removed, err := shardMembership(ctx, u.nh, u.clusterID)
+	if err != nil {
+		return domain.UpgradeStatus{}, nil, err
+	}
+	result, err := u.nh.SyncRead(ctx, u.clusterID, domain.UpgradeQuery{})
+	if err != nil {
+		return domain.UpgradeStatus{}, nil, fmt.Errorf("raft read failed: %w", err)
+	}
+	state, ok := result.(domain.UpgradeState)
+	if !ok {
+		return domain.UpgradeStatus{}, nil, errors.New("invalid data format from raft")
+	}
+
+	reported := make(map[uint64]domain.NodeSupport, len(state.Nodes))
+	status := domain.UpgradeStatus{
+		UpgradeState: state,
+		Members:      members,
+		Pending:      []uint64{},
+		Ready:        map[domain.Feature][]uint64{domain.FeatureSnapshot: {}, domain.FeatureCommand: {}},
+	}
+	for _, ns := range state.Nodes {
+		if ns.Reported() {
+			reported[ns.NodeID] = ns
+		} else if !slices.Contains(members, ns.NodeID) && !slices.Contains(removed, ns.NodeID) {
+			// 已登記但尚未加入 shard，加入並回報前狀態機會拒絕啟用
+			status.Pending = append(status.Pending, ns.NodeID)
+		}
+	}
+	for _, id := range members {
+		if _, ok := reported[id]; !ok {
+			status.Pending = append(status.Pending, id)
+		}
+	}
+	slices.Sort(status.Pending)
+	if len(status.Pending) == 0 {
+		status.Ready[domain.FeatureSnapshot] = readyVersions(members, reported, domain.FeatureSnapshot)
+		status.Ready[domain.FeatureCommand] = readyVersions(members, reported, domain.FeatureCommand)
+	}
+	return status, removed, nil
+}
+
+// Activate 啟用格式版本
+// 成員尚未全部支援或要求降級時不會提案；狀態機會再依提案中的成員清單與已回報的節點檢查一次
+func (u *UpgradeController) Activate(ctx context.Context, feature domain.Feature, version uint64) (domain.ResultCode, error) {
+	status, removed, err := u.status(ctx)
+	if err != nil {
+		return 0, err
+	}
+	active, floor := status.Settings.SnapshotVersion, status.Settings.SnapshotFloor
+	switch feature {
+	case domain.FeatureSnapshot:
+	case domain.FeatureCommand:
+		active, floor = status.Settings.CommandVersion, status.Settings.CommandVersion
+	default:
+		return domain.ResultUnsupportedVersion, nil
+	}
+	if version < floor {
+		return domain.ResultDowngradeBlocked, nil
+	}
+	if version == active {
+		return domain.ResultOK, nil
+	}
+	if !slices.Contains(status.Ready[feature], version) {
+		return domain.ResultUpgradeNotReady, nil
+	}
+
+	cmd := domain.Command{
+		Type:       domain.CommandActivate,
+		Activation: &domain.Activation{Feature: feature, Version: version, Members: status.Members, Removed: removed},
+	}
+	return Propose(ctx, u.nh, u.clusterID, cmd)
+}
+
+// AddMember 先經 raft 登記節點再將其加入 shard
+// 登記套用後，成員清單在登記前讀取的啟用提案會因遺漏此節點被拒絕，節點回報支援前的啟用也會被拒絕；加入失敗時撤銷登記，避免未加入的節點一直阻擋啟用
+func (u *UpgradeController) AddMember(ctx context.Context, nodeID uint64, address string, nonVoting bool) (domain.ResultCode, error) {
+	register := domain.Command{Type: domain.CommandMember, Member: &domain.MemberRegistration{NodeID: nodeID}}
+	code, err := Propose(ctx, u.nh, u.clusterID, register)
+	if err != nil || code != domain.ResultOK {
+		return code, err
+	}
+
+	m, err := u.nh.SyncGetShardMembership(ctx, u.clusterID)
+	if err == nil {
+		if nonVoting {
+			err = u.nh.SyncRequestAddNonVoting(ctx, u.clusterID, nodeID, address, m.ConfigChangeID)
+		} else {
+			err = u.nh.SyncRequestAddReplica(ctx, u.clusterID, nodeID, address, m.ConfigChangeID)
+		}
+	}
+	if err != nil {
+		// 原請求可能已逾時，撤銷使用獨立的逾時
+		cancelCtx, cancel := context.WithTimeout(context.Background(), advertiseTimeout)
+		defer cancel()
+		register.Member.Cancel = true
+		if _, cerr := Propose(cancelCtx, u.nh, u.clusterID, register); cerr != nil {
+			logrus.WithFields(logrus.Fields{"NodeID": nodeID, "error": cerr}).Warn("cancel member registration failed")
+		}
+		return 0, fmt.Errorf("add member failed: %w", err)
+	}
+	logrus.WithFields(logrus.Fields{"NodeID": nodeID, "addr": address, "nonVoting": nonVoting}).Info("member added")
+	return domain.ResultOK, nil
+}
+
+// shardMembers 回傳會套用 entry 的成員（投票節點與 non-voting），witness 沒有狀態機因此不列入
+func shardMembers(ctx context.Context, nh *dragonboat.NodeHost, clusterID uint64) ([]uint64, error) {
+	members, _, err := shardMembership(ctx, nh, clusterID)
+	return members, err
+}
+
+// shardMembership 回傳經 leader 確認的成員與已移除的節點，皆依節點 ID 排序
+func shardMembership(ctx context.Context, nh *dragonboat.NodeHost, clusterID uint64) ([]uint64, []uint64, error) {
+	m, err := nh.SyncGetShardMembership(ctx, clusterID)
+	if err != nil {
+		return nil, nil, fmt.Errorf("get membership failed: %w", err)
+	}
+	members := make([]uint64, 0, len(m.Nodes)+len(m.NonVotings))
+	for id := range m.Nodes {
+		members = append(members, id)
+	}
+	for id := range m.NonVotings {
+		members = append(members, id)
+	}
+	removed := make([]uint64, 0, len(m.Removed))
+	for id := range m.Removed {
+		removed = append(removed, id)
+	}
+	slices.Sort(members)
+	slices.Sort(removed)
+	return members, removed, nil
+}
+
+// readyVersions 回傳所有成員都回報支援的版本，有成員未回報時回傳空清單
+func readyVersions(members []uint64, reported map[uint64]domain.NodeSupport, feature domain.Feature) []uint64 {
+	result := []uint64{}
+	if len(members) == 0 {
+		return result
+	}
+	first, ok := reported[members[0]]
+	if !ok {
+		return result
+	}
+	candidates := first.SnapshotVersions
+	if feature == domain.FeatureCommand {
+		candidates = first.CommandVersions
+	}
+	for _, v := range candidates {
+		all := true
+		for _, id := range members[1:] {
+			ns, ok := reported[id]
+			if !ok || !ns.Supports(feature, v) {
+				all = false
+				break
+			}
+		}
+		if all {
+			result = append(result, v)
+		}
+	}
+	slices.Sort(result)
+	return result
+}
+
+// advertiseSupport 透過 raft 回報本節點支援的格式版本，直到成功或 NodeHost 關閉
+// 每次啟動都會重新回報，升級後的節點因此會更新自己的支援清單
+func (rs *RaftStore) advertiseSupport() {
+	cmd := domain.Command{
+		Type: domain.CommandNodeSupport,
+		NodeSupport: &domain.NodeSupport{
+			NodeID:           rs.NodeID,
+			SnapshotVersions: configs.SupportedSnapshotVersions,
+			CommandVersions:  configs.SupportedCommandVersions,
+		},
+	}
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), advertiseTimeout)
+		code, err := Propose(ctx, rs.NodeHost, rs.ClusterID, cmd)
+		cancel()
+		switch {
+		case err == nil && code == domain.ResultOK:
+			logrus.WithFields(logrus.Fields{"NodeID": rs.NodeID}).Info("advertised supported versions")
+			return
+		case err == nil:
+			logrus.WithFields(logrus.Fields{"NodeID": rs.NodeID, "code": code}).Warn("advertise supported versions rejected")
+			return
+		case errors.Is(err, dragonboat.ErrClosed), errors.Is(err, dragonboat.ErrShardNotFound):
+			return
+		}
+		time.Sleep(advertiseBackoff)
+	}
+}
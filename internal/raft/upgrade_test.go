@@ -0,0 +1,60 @@
+package raft_test
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+
+	"go-raft/internal/domain"
+	"go-raft/internal/raft"
+)
+
+// TestAddMemberBlocksActivation 經 AddMember 加入的節點回報支援前，啟用會被拒絕，包含成員清單在加入前讀取的提案
+func TestAddMemberBlocksActivation(t *testing.T) {
+	const clusterID = uint64(212)
+	addr := "localhost:24190"
+	rs, err := raft.New(raft.NodeConfig{FileDir: t.TempDir(), RaftAddress: addr, NodeID: 1, ClusterID: clusterID, InitialMembers: map[uint64]string{1: addr}})
+	if err != nil {
+		t.Fatalf("create node: %v", err)
+	}
+	if err := rs.Start(); err != nil {
+		t.Fatalf("start node: %v", err)
+	}
+	defer rs.NodeHost.Close()
+	waitForShardReady(t, rs, clusterID)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	controller := raft.NewUpgradeController(rs.NodeHost, clusterID)
+	// 等待背景的支援版本回報套用
+	var status domain.UpgradeStatus
+	for deadline := time.Now().Add(10 * time.Second); ; time.Sleep(100 * time.Millisecond) {
+		if status, err = controller.Status(ctx); err == nil && slices.Contains(status.Ready[domain.FeatureSnapshot], 4) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("node 1 never reported: %+v, %v", status, err)
+		}
+	}
+
+	// 以加入前讀到的成員清單提案；節點 2 是不會啟動的 non-voting 成員，不影響 quorum
+	stale := domain.Command{Type: domain.CommandActivate, Activation: &domain.Activation{Feature: domain.FeatureSnapshot, Version: 4, Members: status.Members}}
+	if code, err := controller.AddMember(ctx, 2, "localhost:24191", true); err != nil || code != domain.ResultOK {
+		t.Fatalf("add member: %v, %v", code, err)
+	}
+	if code, err := raft.Propose(ctx, rs.NodeHost, clusterID, stale); err != nil || code != domain.ResultUpgradeNotReady {
+		t.Fatalf("stale activation: %v, %v, want %v", code, err, domain.ResultUpgradeNotReady)
+	}
+
+	status, err = controller.Status(ctx)
+	if err != nil {
+		t.Fatalf("status: %v", err)
+	}
+	if !slices.Equal(status.Members, []uint64{1, 2}) || !slices.Equal(status.Pending, []uint64{2}) || len(status.Ready[domain.FeatureSnapshot]) != 0 {
+		t.Fatalf("status after add %+v", status)
+	}
+	if code, err := controller.Activate(ctx, domain.FeatureSnapshot, 4); err != nil || code != domain.ResultUpgradeNotReady {
+		t.Fatalf("activate: %v, %v, want %v", code, err, domain.ResultUpgradeNotReady)
+	}
+}
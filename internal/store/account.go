@@ -0,0 +1,196 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	maps0 "maps"
+	"sort"
+	"sync"
+
+	"go-raft/internal/domain"
+	"go-raft/pkg/maps"
+)
+
+var (
+	// ErrAccountNotFound 帳戶不存在
+	ErrAccountNotFound = errors.New("account not found")
+	// ErrAccountExists 帳戶已存在
+	ErrAccountExists = errors.New("account already exists")
+	// ErrAccountFrozen 帳戶已凍結
+	ErrAccountFrozen = errors.New("account frozen")
+	// ErrAccountClosed 帳戶已關閉
+	ErrAccountClosed = errors.New("account closed")
+	// ErrAccountNotEmpty 帳戶仍有餘額，不可關閉
+	ErrAccountNotEmpty = errors.New("account balance not empty")
+	// ErrInvalidTransition 帳戶狀態不允許此操作
+	ErrInvalidTransition = errors.New("invalid account status transition")
+)
+
+// Accounts 為複製於狀態機中的帳戶表
+type Accounts struct {
+	mu       sync.RWMutex
+	accounts *maps.COWMap[domain.Account]
+}
+
+func NewAccounts() *Accounts {
+	return &Accounts{accounts: maps.NewCOWMap[domain.Account]()}
+}
+
+// Get 取得帳戶資料
+func (a *Accounts) Get(uid string) (domain.Account, bool) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	acc, ok := a.accounts.Get(uid)
+	if ok {
+		acc.Metadata = maps0.Clone(acc.Metadata)
+	}
+	return acc, ok
+}
+
+// Open 於 raft index 開立新帳戶
+func (a *Accounts) Open(index uint64, now int64, uid, tier string, metadata map[string]string) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if _, ok := a.accounts.Get(uid); ok {
+		return fmt.Errorf("%w: %s", ErrAccountExists, uid)
+	}
+	a.accounts.Set(uid, newAccount(index, now, uid, tier, metadata))
+	return nil
+}
+
+// SetTier 變更帳戶等級，已關閉的帳戶不可變更
+func (a *Accounts) SetTier(index uint64, uid, tier string) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	acc, ok := a.accounts.Get(uid)
+	acc, err := setTier(acc, ok, index, uid, tier)
+	if err != nil {
+		return err
+	}
+	a.accounts.Set(uid, acc)
+	return nil
+}
+
+// Writable 檢查帳戶是否允許寫入，不建立帳戶；不存在的帳戶視為可寫入並回傳零值
+func (a *Accounts) Writable(uid string) (domain.Account, error) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	acc, ok := a.accounts.Get(uid)
+	if !ok {
+		return domain.Account{}, nil
+	}
+	return acc, writable(acc)
+}
+
+// Ensure 於 raft index 隱式開立不存在的帳戶，已存在時不變更
+func (a *Accounts) Ensure(index uint64, now int64, uid string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if _, ok := a.accounts.Get(uid); !ok {
+		a.accounts.Set(uid, newAccount(index, now, uid, "", nil))
+	}
+}
+
+// Transition 依 action 變更帳戶狀態，只允許 active <-> frozen 以及 active/frozen -> closed
+func (a *Accounts) Transition(index uint64, uid string, action domain.AccountAction, reason string) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	acc, ok := a.accounts.Get(uid)
+	acc, err := transition(acc, ok, index, uid, action, reason)
+	if err != nil {
+		return err
+	}
+	a.accounts.Set(uid, acc)
+	return nil
+}
+
+// List 回傳所有帳戶，依 uid 排序
+func (a *Accounts) List() []domain.Account {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return listAccounts(a.accounts.Range, a.accounts.Len())
+}
+
+// freeze 回傳帳戶表的不可變檢視
+func (a *Accounts) freeze() *maps.FrozenMap[domain.Account] {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.accounts.Freeze()
+}
+
+// LoadData 以 snapshot 中的帳戶清單取代目前內容
+func (a *Accounts) LoadData(accounts []domain.Account) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.accounts = maps.NewCOWMap[domain.Account]()
+	for _, acc := range accounts {
+		a.accounts.Set(acc.UID, acc)
+	}
+}
+
+// listAccounts 複製 each 走訪到的帳戶，依 uid 排序
+func listAccounts(each func(func(string, domain.Account) bool), n int) []domain.Account {
+	result := make([]domain.Account, 0, n)
+	each(func(_ string, acc domain.Account) bool {
+		acc.Metadata = maps0.Clone(acc.Metadata)
+		result = append(result, acc)
+		return true
+	})
+	sort.Slice(result, func(i, j int) bool { return result[i].UID < result[j].UID })
+	return result
+}
+
+// newAccount 回傳於 raft index 開立的 active 帳戶
+func newAccount(index uint64, now int64, uid, tier string, metadata map[string]string) domain.Account {
+	return domain.Account{
+		UID:          uid,
+		Status:       domain.AccountActive,
+		Tier:         tier,
+		CreatedIndex: index,
+		CreatedAt:    now,
+		UpdatedIndex: index,
+		Metadata:     maps0.Clone(metadata),
+	}
+}
+
+// setTier 回傳變更等級後的帳戶，ok 為帳戶是否存在
+func setTier(acc domain.Account, ok bool, index uint64, uid, tier string) (domain.Account, error) {
+	if !ok {
+		return acc, fmt.Errorf("%w: %s", ErrAccountNotFound, uid)
+	}
+	if acc.Status == domain.AccountClosed {
+		return acc, fmt.Errorf("%w: %s", ErrAccountClosed, uid)
+	}
+	acc.Tier, acc.UpdatedIndex = tier, index
+	return acc, nil
+}
+
+// transition 回傳依 action 變更狀態後的帳戶，ok 為帳戶是否存在
+func transition(acc domain.Account, ok bool, index uint64, uid string, action domain.AccountAction, reason string) (domain.Account, error) {
+	if !ok {
+		return acc, fmt.Errorf("%w: %s", ErrAccountNotFound, uid)
+	}
+	var next domain.AccountStatus
+	switch {
+	case action == domain.AccountFreeze && acc.Status == domain.AccountActive:
+		next = domain.AccountFrozen
+	case action == domain.AccountUnfreeze && acc.Status == domain.AccountFrozen:
+		next = domain.AccountActive
+	case action == domain.AccountClose && acc.Status != domain.AccountClosed:
+		next = domain.AccountClosed
+	default:
+		return acc, fmt.Errorf("%w: %s %s account %s", ErrInvalidTransition, action, acc.Status, uid)
+	}
+	acc.Status, acc.Reason, acc.UpdatedIndex = next, reason, index
+	return acc, nil
+}
+
+func writable(acc domain.Account) error {
+	switch acc.Status {
+	case domain.AccountFrozen:
+		return fmt.Errorf("%w: %s", ErrAccountFrozen, acc.UID)
+	case domain.AccountClosed:
+		return fmt.Errorf("%w: %s", ErrAccountClosed, acc.UID)
+	}
+	return nil
+}
@@ -0,0 +1,189 @@
+package store
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// 帳本封存檔格式，不依賴 dragonboat 的目錄結構：
+//
+//	magic "GRLEDGER"
+//	header  [u32 archive version][u64 cluster id][u64 index][i64 提交時間]
+//	payload v3 snapshot 主串流（元資料 + 餘額），以 [u32 長度][資料] 分段，長度 0 的分段代表結束
+//	sha256  magic、header 與 payload（含分段長度）的 sha256
+//
+// payload 邊編碼邊寫出並計算 sha256，寫入與讀取都不需要在記憶體中保留整個 payload
+const (
+	archiveMagic      = "GRLEDGER"
+	archiveHeaderSize = 28
+	// ArchiveVersion 為目前寫入的封存檔格式版本
+	ArchiveVersion uint32 = 1
+	// archiveChunkSize 寫入時每個 payload 分段的最大長度，讀取時超過此長度的分段視為損毀
+	archiveChunkSize = 1 << 20
+)
+
+// ErrArchiveCorrupted 封存檔格式不正確或 checksum 不符
+var ErrArchiveCorrupted = errors.New("ledger archive corrupted")
+
+// Archive 為某個 raft index 套用後的完整帳本備份
+type Archive struct {
+	Version   uint32
+	ClusterID uint64 // 匯出來源的 shard
+	Index     uint64 // 匯出時已套用的 raft index
+	Timestamp int64  // 該 index 的提交時間（Unix 奈秒）
+	Content   *SnapshotContent
+}
+
+// WritePayload 將封存的內容寫成 v3 snapshot 主串流，可直接作為新 shard 第一個 snapshot 的內容
+func (a *Archive) WritePayload(w io.Writer) error {
+	return encodeSnapshot(w, 3, a.Content.Meta, tablesOf(a.Content.Balances), nil)
+}
+
+// WriteArchive 將 content 寫成封存檔，index 與提交時間取自 content 的歷史資料
+// 各節點回報的支援版本與 checksum 結果只對來源叢集有意義，不寫入封存檔
+func WriteArchive(w io.Writer, clusterID uint64, content *SnapshotContent) error {
+	meta := *content.Meta
+	meta.Nodes, meta.Checksums = nil, nil
+	balances := tablesOf(content.Balances)
+	meta.Manifest = v3Manifest(balances)
+
+	var index uint64
+	var ts int64
+	if meta.History != nil {
+		index, ts = meta.History.Applied, meta.History.Now
+	}
+	header := make([]byte, 0, len(archiveMagic)+archiveHeaderSize)
+	header = append(header, archiveMagic...)
+	header = binary.LittleEndian.AppendUint32(header, ArchiveVersion)
+	header = binary.LittleEndian.AppendUint64(header, clusterID)
+	header = binary.LittleEndian.AppendUint64(header, index)
+	header = binary.LittleEndian.AppendUint64(header, uint64(ts))
+
+	h := sha256.New()
+	mw := io.MultiWriter(w, h)
+	if _, err := mw.Write(header); err != nil {
+		return err
+	}
+	bw := bufio.NewWriterSize(&chunkWriter{w: mw}, archiveChunkSize)
+	if err := encodeSnapshot(bw, 3, &meta, balances, nil); err != nil {
+		return err
+	}
+	if err := bw.Flush(); err != nil {
+		return err
+	}
+	// 長度 0 的分段代表 payload 結束
+	if _, err := mw.Write(make([]byte, 4)); err != nil {
+		return err
+	}
+	_, err := w.Write(h.Sum(nil))
+	return err
+}
+
+// ReadArchive 讀取封存檔並核對 sha256 與 manifest，回傳解碼後的內容
+// payload 邊讀取邊解碼，解碼完成後才核對 sha256，任何錯誤都以 ErrArchiveCorrupted 回傳
+func ReadArchive(r io.Reader) (*Archive, error) {
+	h := sha256.New()
+	br := bufio.NewReader(r)
+	tr := io.TeeReader(br, h)
+	header := make([]byte, len(archiveMagic)+archiveHeaderSize)
+	if _, err := io.ReadFull(tr, header); err != nil {
+		return nil, fmt.Errorf("%w: read header: %v", ErrArchiveCorrupted, err)
+	}
+	if string(header[:len(archiveMagic)]) != archiveMagic {
+		return nil, fmt.Errorf("%w: bad magic", ErrArchiveCorrupted)
+	}
+	fields := header[len(archiveMagic):]
+	a := &Archive{
+		Version:   binary.LittleEndian.Uint32(fields),
+		ClusterID: binary.LittleEndian.Uint64(fields[4:]),
+		Index:     binary.LittleEndian.Uint64(fields[12:]),
+		Timestamp: int64(binary.LittleEndian.Uint64(fields[20:])),
+	}
+
+	if a.Version != ArchiveVersion {
+		return nil, fmt.Errorf("%w: unsupported archive version %d", ErrArchiveCorrupted, a.Version)
+	}
+	cr := &chunkReader{r: tr}
+	content, err := DecodeSnapshot(cr, nil, nil)
+	if err == nil {
+		// 讀到結束分段，確保 sha256 涵蓋整個 payload
+		_, err = io.Copy(io.Discard, cr)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrArchiveCorrupted, err)
+	}
+	want := h.Sum(nil)
+	sum := make([]byte, sha256.Size)
+	if _, err := io.ReadFull(br, sum); err != nil {
+		return nil, fmt.Errorf("%w: read checksum: %v", ErrArchiveCorrupted, err)
+	}
+	if !bytes.Equal(sum, want) {
+		return nil, fmt.Errorf("%w: checksum mismatch", ErrArchiveCorrupted)
+	}
+	if content.Meta.History == nil || content.Meta.History.Applied != a.Index {
+		return nil, fmt.Errorf("%w: payload does not match index %d", ErrArchiveCorrupted, a.Index)
+	}
+	a.Content = content
+	return a, nil
+}
+
+// chunkWriter 將每次寫入以 [u32 長度][資料] 分段寫出，單一分段不超過 archiveChunkSize
+type chunkWriter struct {
+	w io.Writer
+}
+
+func (cw *chunkWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		chunk := p[:min(len(p), archiveChunkSize)]
+		if _, err := cw.w.Write(binary.LittleEndian.AppendUint32(nil, uint32(len(chunk)))); err != nil {
+			return written, err
+		}
+		if _, err := cw.w.Write(chunk); err != nil {
+			return written, err
+		}
+		written += len(chunk)
+		p = p[len(chunk):]
+	}
+	return written, nil
+}
+
+// chunkReader 讀取 chunkWriter 寫出的分段，讀到長度 0 的分段後回傳 io.EOF，不會讀超過結束分段
+type chunkReader struct {
+	r    io.Reader
+	left uint32 // 目前分段尚未讀取的長度
+	done bool
+}
+
+func (cr *chunkReader) Read(p []byte) (int, error) {
+	if cr.left == 0 && !cr.done {
+		var size [4]byte
+		if _, err := io.ReadFull(cr.r, size[:]); err != nil {
+			return 0, fmt.Errorf("read chunk size: %w", noEOF(err))
+		}
+		cr.left = binary.LittleEndian.Uint32(size[:])
+		if cr.left > archiveChunkSize {
+			return 0, fmt.Errorf("chunk size %d too large", cr.left)
+		}
+		cr.done = cr.left == 0
+	}
+	if cr.done {
+		return 0, io.EOF
+	}
+	n, err := cr.r.Read(p[:min(len(p), int(cr.left))])
+	cr.left -= uint32(n)
+	return n, noEOF(err)
+}
+
+// noEOF 將 io.EOF 轉為 io.ErrUnexpectedEOF，payload 在結束分段前結束代表檔案被截斷
+func noEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
@@ -0,0 +1,72 @@
+package store_test
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"go-raft/internal/store"
+)
+
+// archiveSource 回傳套用 n 筆變動後的帳本，餘額超過一個 payload 分段
+func archiveSource(t *testing.T, n int) *store.CurrencyStore {
+	t.Helper()
+	cs := store.NewCurrencyStore(1, 1, t.TempDir(), 0)
+	for i := 1; i <= n; i++ {
+		cs.Advance(uint64(i), int64(i)*1000, true)
+		cs.UpdateAt(uint64(i), fmt.Sprintf("user-%08d", i), []string{"USD", "BTC"}[i%2], float64(i)+0.5)
+	}
+	return cs
+}
+
+func TestArchiveRoundTrip(t *testing.T) {
+	src := archiveSource(t, 100000)
+	var buf bytes.Buffer
+	if err := store.WriteArchive(&buf, 7, src.Capture()); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	data := buf.Bytes()
+
+	a, err := store.ReadArchive(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if a.Version != store.ArchiveVersion || a.ClusterID != 7 || a.Index != 100000 || a.Timestamp != 100000*1000 {
+		t.Fatalf("unexpected archive header %+v", a)
+	}
+	if !reflect.DeepEqual(a.Content.Balances, src.Capture().Balances) {
+		t.Fatal("archived balances differ from source")
+	}
+
+	// 以 WritePayload 重新寫出的主串流可直接還原
+	var payload bytes.Buffer
+	if err := a.WritePayload(&payload); err != nil {
+		t.Fatalf("write payload: %v", err)
+	}
+	dst := store.NewCurrencyStore(1, 2, t.TempDir(), 0)
+	if err := dst.RecoverFromSnapshot(&payload, nil, nil); err != nil {
+		t.Fatalf("recover: %v", err)
+	}
+	if !reflect.DeepEqual(dst.List(), src.List()) || dst.Applied() != 100000 {
+		t.Fatal("recovered ledger differs from source")
+	}
+
+	corrupted := bytes.Clone(data)
+	corrupted[len(corrupted)/2] ^= 0xff
+	unknown := bytes.Clone(data)
+	binary.LittleEndian.PutUint32(unknown[len("GRLEDGER"):], store.ArchiveVersion+1)
+	for name, b := range map[string][]byte{
+		"corrupted":        corrupted,
+		"unknown version":  unknown,
+		"truncated":        data[:len(data)/2],
+		"missing checksum": data[:len(data)-sha256.Size],
+	} {
+		if _, err := store.ReadArchive(bytes.NewReader(b)); !errors.Is(err, store.ErrArchiveCorrupted) {
+			t.Fatalf("%s: expected ErrArchiveCorrupted, got %v", name, err)
+		}
+	}
+}
@@ -0,0 +1,196 @@
+package store
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+	"sort"
+
+	"go-raft/internal/configs"
+	"go-raft/internal/domain"
+)
+
+// ErrChecksumExpired 回報的 checksum 不在保留的結果中
+var ErrChecksumExpired = errors.New("checksum round expired")
+
+// OpenChecksum 於 raft index 開始一次 checksum，之後各節點回報的結果紀錄在此 round
+func (cs *CurrencyStore) OpenChecksum(index uint64) {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	cs.checksums = openChecksum(cs.checksums, index)
+}
+
+// ReportChecksum 紀錄節點回報的 checksum，同一節點重複回報時以最新的為準
+func (cs *CurrencyStore) ReportChecksum(r domain.ChecksumReport) error {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	rounds, err := reportChecksum(cs.checksums, r)
+	if err != nil {
+		return err
+	}
+	cs.checksums = rounds
+	return nil
+}
+
+// Checksums 回傳保留的 checksum 結果，依 index 排序
+func (cs *CurrencyStore) Checksums() []domain.ChecksumRound {
+	cs.mu.RLock()
+	defer cs.mu.RUnlock()
+	return slices.Clone(cs.checksums)
+}
+
+// openChecksum 回傳加入 index 的 round 後的結果，只保留最近 configs.ChecksumRounds 次
+// round 建立後不再原地修改，檢視與查詢可以直接共用
+func openChecksum(rounds []domain.ChecksumRound, index uint64) []domain.ChecksumRound {
+	if n := len(rounds) + 1 - configs.ChecksumRounds; n > 0 {
+		rounds = rounds[n:]
+	}
+	result := make([]domain.ChecksumRound, 0, len(rounds)+1)
+	result = append(result, rounds...)
+	return append(result, domain.ChecksumRound{Index: index, Reports: []domain.ChecksumReport{}})
+}
+
+// reportChecksum 回傳加入 r 後的結果，任一節點的 digest 與其他節點不同時該 round 標記為不一致
+func reportChecksum(rounds []domain.ChecksumRound, r domain.ChecksumReport) ([]domain.ChecksumRound, error) {
+	i := slices.IndexFunc(rounds, func(round domain.ChecksumRound) bool { return round.Index == r.Index })
+	if i < 0 {
+		return nil, fmt.Errorf("%w: index %d", ErrChecksumExpired, r.Index)
+	}
+	round := rounds[i]
+	reports := make([]domain.ChecksumReport, 0, len(round.Reports)+1)
+	for _, report := range round.Reports {
+		if report.NodeID != r.NodeID {
+			reports = append(reports, report)
+		}
+	}
+	reports = append(reports, r)
+	sort.Slice(reports, func(i, j int) bool { return reports[i].NodeID < reports[j].NodeID })
+	round.Reports, round.Diverged = reports, false
+	for _, report := range reports[1:] {
+		if report.Digest != reports[0].Digest {
+			round.Diverged = true
+		}
+	}
+	result := slices.Clone(rounds)
+	result[i] = round
+	return result, nil
+}
+
+// Checksum 計算檢視的狀態摘要，可與 Update 並行呼叫
+func (v *View) Checksum(nodeID uint64) domain.ChecksumReport {
+	balances := make(map[string]map[string]float64, len(v.balances))
+	for currency, byUID := range v.balances {
+		balances[currency] = byUID.Clone()
+	}
+	return checksum(nodeID, v.Applied(), v.history.now, balances, &StoreMeta{
+		Currencies: v.currencies,
+		Accounts:   listAccounts(v.accounts.Range, v.accounts.Len()),
+		Limits:     v.limits.data(),
+		Settings:   &v.settings,
+	})
+}
+
+// checksum 以與實作無關的順序計算狀態摘要，記憶體與 Pebble 上的帳本對相同狀態必須產生相同結果
+// nil 與空的清單視為相同，餘額只計入非零的紀錄，歷史餘額、節點回報的支援版本與 checksum 結果本身不列入
+func checksum(nodeID, index uint64, now int64, balances map[string]map[string]float64, meta *StoreMeta) domain.ChecksumReport {
+	sections := make(map[string]string)
+	for currency, byUID := range balances {
+		uids := make([]string, 0, len(byUID))
+		for uid, balance := range byUID {
+			if balance != 0 {
+				uids = append(uids, uid)
+			}
+		}
+		if len(uids) == 0 {
+			continue
+		}
+		slices.Sort(uids)
+		h := sha256.New()
+		var bits [8]byte
+		for _, uid := range uids {
+			h.Write([]byte(uid))
+			h.Write([]byte{0})
+			binary.BigEndian.PutUint64(bits[:], math.Float64bits(byUID[uid]))
+			h.Write(bits[:])
+		}
+		sections["balance/"+currency] = hex.EncodeToString(h.Sum(nil))
+	}
+
+	accounts := append([]domain.Account{}, meta.Accounts...)
+	sort.Slice(accounts, func(i, j int) bool { return accounts[i].UID < accounts[j].UID })
+	sections["accounts"] = digestJSON(accounts)
+	currencies := append([]domain.Currency{}, meta.Currencies...)
+	sort.Slice(currencies, func(i, j int) bool { return currencies[i].Code < currencies[j].Code })
+	sections["currencies"] = digestJSON(currencies)
+	sections["limits"] = digestJSON(canonicalLimits(meta.Limits, now))
+	settings := defaultSettings()
+	if meta.Settings != nil {
+		settings = *meta.Settings
+	}
+	sections["settings"] = digestJSON(settings)
+
+	names := make([]string, 0, len(sections))
+	for name := range sections {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	h := sha256.New()
+	for _, name := range names {
+		fmt.Fprintf(h, "%s\x00%s\n", name, sections[name])
+	}
+	return domain.ChecksumReport{NodeID: nodeID, Index: index, Digest: hex.EncodeToString(h.Sum(nil)), Sections: sections}
+}
+
+// limitWindow 為 canonicalLimits 中單一 uid 的提領紀錄
+type limitWindow struct {
+	Currency    string
+	UID         string
+	Withdrawals []Withdrawal
+}
+
+// canonicalLimits 將限額資料整理成固定順序，只列入 now 當下仍在滾動視窗內的提領紀錄
+// 過期紀錄的清除時間點依副本而異，不列入才能讓狀態相同的副本產生相同結果
+func canonicalLimits(data *LimitsData, now int64) any {
+	if data == nil {
+		data = &LimitsData{}
+	}
+	rules := append([]domain.LimitRule{}, data.Rules...)
+	sort.Slice(rules, func(i, j int) bool {
+		return ruleKey(rules[i].Scope, rules[i].Currency, rules[i].Tier, rules[i].UID) <
+			ruleKey(rules[j].Scope, rules[j].Currency, rules[j].Tier, rules[j].UID)
+	})
+	windows := make([]limitWindow, 0)
+	for currency, byUID := range data.Windows {
+		for uid, ws := range byUID {
+			i, _ := window(ws, now)
+			if ws = ws[i:]; len(ws) > 0 {
+				windows = append(windows, limitWindow{Currency: currency, UID: uid, Withdrawals: ws})
+			}
+		}
+	}
+	sort.Slice(windows, func(i, j int) bool {
+		if windows[i].Currency != windows[j].Currency {
+			return windows[i].Currency < windows[j].Currency
+		}
+		return windows[i].UID < windows[j].UID
+	})
+	return struct {
+		Rules   []domain.LimitRule
+		Windows []limitWindow
+	}{rules, windows}
+}
+
+// digestJSON 回傳 v 的 JSON 編碼的 sha256，map 的 key 由 encoding/json 排序
+func digestJSON(v any) string {
+	h := sha256.New()
+	if err := json.NewEncoder(h).Encode(v); err != nil {
+		// 內容皆為可編碼的型別，不會發生；仍以錯誤內容計算，使結果與正常節點不同
+		fmt.Fprint(h, err)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
@@ -1,22 +1,18 @@
 package store
 
 import (
-	"bytes"
+	"bufio"
 	"encoding/gob"
-	"errors"
 	"fmt"
 	"io"
-	"os"
-	"path/filepath"
 	"strconv"
-	"strings"
 	"sync"
 
 	"go-raft/internal/configs"
+	"go-raft/internal/domain"
 	"go-raft/pkg/maps"
 	maps0 "maps"
 
-	"github.com/golang/snappy"
 	"github.com/lni/dragonboat/v4/statemachine"
 )
 
@@ -24,6 +20,7 @@ import (
 func init() {
 	gob.Register(&StoreV1{})
 	gob.Register(&StoreV2{})
+	gob.Register(&StoreMeta{})
 	gob.Register(map[string]float64{})
 }
 
@@ -41,6 +38,18 @@ type StoreV2 struct {
 	}
 }
 
+// StoreMeta 是寫在 snapshot 主串流中的元資料本體，保存分檔以外的狀態
+type StoreMeta struct {
+	History    *HistoryData
+	Currencies []domain.Currency
+	Accounts   []domain.Account
+	Limits     *LimitsData
+	Settings   *domain.Settings
+	Nodes      []domain.NodeSupport
+	Manifest   *Manifest // 各幣別資料摘要，舊版 snapshot 為 nil
+	Checksums  []domain.ChecksumRound
+}
+
 // SnapshotFile 用於封裝版本與資料本體
 type SnapshotFile struct {
 	SnapshotVersion uint64
@@ -51,19 +60,76 @@ type SnapshotFile struct {
 type CurrencyStore struct {
 	clusterID uint64
 	nodeID    uint64
-	store     sync.Map // key=currency string, value=*maps.SafeFloatMap
+	store     sync.Map  // key=currency string, value=*maps.SafeFloatMap
+	fileDir   string    // SaveSnapshot 寫出外部檔案的目錄，需與 NodeHostDir 位於同一檔案系統
+	history   *History  // 以 raft index 為版本的歷史餘額
+	registry  *Registry // 幣別註冊表
+	accounts  *Accounts // 帳戶表
+	limits    *Limits   // 限額政策與提領計數器
+
+	mu        sync.RWMutex
+	settings  domain.Settings               // 叢集設定
+	nodes     map[uint64]domain.NodeSupport // 各節點回報的支援版本
+	checksums []domain.ChecksumRound        // 最近的 checksum 結果，依 index 排序
+
+	dirtyMu   sync.Mutex
+	dirty     map[string]map[string]struct{} // 自上次 snapshot 後寫入過的 currency -> uid
+	snapshots uint64                         // PrepareSnapshot 的次數
+	chain     *deltaChain                    // 最近一次 v4 snapshot 的檔案，只在 Save 中存取，nil 代表下次寫出 base
 }
 
 // NewCurrencyStore 建構並回傳 CurrencyStore 實例，預設版本 1
+// fileDir 為 snapshot 外部檔案的暫存目錄，historyRetention 為歷史餘額保留的 raft index 數量，0 代表不清除
 func NewCurrencyStore(
 	clusterID uint64,
 	nodeID uint64,
+	fileDir string,
+	historyRetention uint64,
 ) *CurrencyStore {
-	return &CurrencyStore{clusterID: clusterID, nodeID: nodeID}
+	return &CurrencyStore{
+		clusterID: clusterID,
+		nodeID:    nodeID,
+		fileDir:   fileDir,
+		history:   NewHistory(historyRetention),
+		registry:  NewRegistry(),
+		accounts:  NewAccounts(),
+		limits:    NewLimits(),
+		settings:  defaultSettings(),
+		nodes:     make(map[uint64]domain.NodeSupport),
+		dirty:     make(map[string]map[string]struct{}),
+	}
+}
+
+// Fresh 回傳設定相同但沒有任何資料的 store，供還原 snapshot 使用
+func (cs *CurrencyStore) Fresh() *CurrencyStore {
+	return NewCurrencyStore(cs.clusterID, cs.nodeID, cs.fileDir, cs.history.retention)
+}
+
+// Settings 回傳叢集設定
+func (cs *CurrencyStore) Settings() domain.Settings {
+	cs.mu.RLock()
+	defer cs.mu.RUnlock()
+	return cs.settings
 }
 
-// Update 更新指定 uid、貨幣的金額（可加減）
-func (cs *CurrencyStore) Update(uid, currency string, amount float64) {
+// Limits 回傳限額政策與提領計數器
+func (cs *CurrencyStore) Limits() LimitTable {
+	return cs.limits
+}
+
+// Accounts 回傳帳戶表
+func (cs *CurrencyStore) Accounts() AccountTable {
+	return cs.accounts
+}
+
+// Registry 回傳幣別註冊表
+func (cs *CurrencyStore) Registry() CurrencyTable {
+	return cs.registry
+}
+
+// Update 更新指定 uid、貨幣的金額（可加減），回傳更新後的餘額
+func (cs *CurrencyStore) Update(uid, currency string, amount float64) float64 {
+	cs.markDirty(currency, uid)
 	val, loaded := cs.store.Load(currency)
 	if !loaded {
 		sfm := maps.NewSafeFloatMap()
@@ -71,11 +137,51 @@ func (cs *CurrencyStore) Update(uid, currency string, amount float64) {
 		if loaded {
 			sfm = actual.(*maps.SafeFloatMap)
 		}
-		sfm.Add(uid, amount)
-		return
+		return sfm.Add(uid, amount)
 	}
 	sfm := val.(*maps.SafeFloatMap)
-	sfm.Add(uid, amount)
+	return sfm.Add(uid, amount)
+}
+
+// UpdateAt 於 raft index 套用金額變動，並紀錄該版本的餘額
+func (cs *CurrencyStore) UpdateAt(index uint64, uid, currency string, amount float64) {
+	balance := cs.Update(uid, currency, amount)
+	cs.history.Record(index, currency, uid, balance)
+}
+
+// Advance 紀錄最後套用的 raft index 與提案端指定的提交時間，leader 代表提案時提案端為 leader
+// 回傳經單調遞增修正後的時間，狀態機內所有時間相關邏輯都應使用此值
+func (cs *CurrencyStore) Advance(index uint64, proposed int64, leader bool) int64 {
+	return cs.history.Advance(index, proposed, leader)
+}
+
+// LeaderNow 回傳最後一個由 leader 提案的 entry 採用的提交時間，尚未套用過時為 0
+func (cs *CurrencyStore) LeaderNow() int64 {
+	return cs.history.LeaderNow()
+}
+
+// Now 回傳最後套用 entry 的提交時間（Unix 奈秒）
+func (cs *CurrencyStore) Now() int64 {
+	return cs.history.Now()
+}
+
+// Applied 回傳最後套用的 raft index
+func (cs *CurrencyStore) Applied() uint64 {
+	return cs.history.Applied()
+}
+
+// GetAt 取得指定 uid、貨幣在 raft index 套用後的餘額
+func (cs *CurrencyStore) GetAt(uid, currency string, index uint64) (float64, error) {
+	return cs.history.BalanceAt(currency, uid, index)
+}
+
+// GetAsOf 取得指定 uid、貨幣在提交時間 ts（Unix 奈秒）當下的餘額
+func (cs *CurrencyStore) GetAsOf(uid, currency string, ts int64) (float64, error) {
+	index, err := cs.history.IndexAt(ts)
+	if err != nil {
+		return 0, err
+	}
+	return cs.history.BalanceAt(currency, uid, index)
 }
 
 // Get 取得指定 uid、貨幣的餘額，找不到回傳 0
@@ -88,6 +194,28 @@ func (cs *CurrencyStore) Get(uid, currency string) float64 {
 	return sfm.Get(uid)
 }
 
+// Holdings 回傳指定 uid 在所有幣別的餘額，不包含從未寫入的幣別
+func (cs *CurrencyStore) Holdings(uid string) map[string]float64 {
+	result := make(map[string]float64)
+	cs.store.Range(func(key, value any) bool {
+		sfm := value.(*maps.SafeFloatMap)
+		if balance, ok := sfm.Lookup(uid); ok {
+			result[key.(string)] = balance
+		}
+		return true
+	})
+	return result
+}
+
+// RangeBalances 走訪幣別在呼叫當下的所有餘額，不複製其他幣別，fn 回傳 false 時停止
+func (cs *CurrencyStore) RangeBalances(currency string, fn func(uid string, balance float64) bool) {
+	val, ok := cs.store.Load(currency)
+	if !ok {
+		return
+	}
+	val.(*maps.SafeFloatMap).Freeze().Range(fn)
+}
+
 // List 回傳所有帳戶與貨幣餘額快照
 func (cs *CurrencyStore) List() map[string]map[string]float64 {
 	result := make(map[string]map[string]float64)
@@ -106,150 +234,198 @@ func (cs *CurrencyStore) List() map[string]map[string]float64 {
 	return result
 }
 
-// SaveSnapshot 實作 Dragonboat Snapshot 介面，將資料依版本存成多個分檔
-func (cs *CurrencyStore) SaveSnapshot(w io.Writer, fss statemachine.ISnapshotFileCollection, done <-chan struct{}) error {
-	version := configs.GetSnapshotVersion(cs.clusterID, cs.nodeID)
+// PreparedSnapshot 為 PrepareSnapshot 當下的不可變狀態，Save 寫出時不需暫停 Update
+type PreparedSnapshot struct {
+	cs      *CurrencyStore
+	view    *View
+	version uint64
+	seq     uint64
+	dirty   map[string]map[string]struct{}
+}
+
+// PrepareSnapshot 取得目前狀態的不可變檢視與自上次 snapshot 後的變動紀錄，需與 Update 互斥呼叫
+// 只複製各表的根節點參照，成本與帳戶數量無關；version 為寫入的格式版本，應為當下的設定值，避免與之後的切換命令交錯
+func (cs *CurrencyStore) PrepareSnapshot(version uint64) *PreparedSnapshot {
+	seq, dirty := cs.takeDirty()
+	return &PreparedSnapshot{cs: cs, view: cs.View(), version: version, seq: seq, dirty: dirty}
+}
 
-	// 儲存元資料（版本號）
-	meta := SnapshotFile{
-		SnapshotVersion: version,
-		Data:            nil,
+// SaveSnapshot 寫出目前狀態的 snapshot，呼叫端需確保取得狀態期間沒有 Update；與 Update 並行時應分開呼叫 PrepareSnapshot 與 Save
+func (cs *CurrencyStore) SaveSnapshot(version uint64, w io.Writer, fss statemachine.ISnapshotFileCollection, done <-chan struct{}) error {
+	return cs.PrepareSnapshot(version).Save(w, fss, done)
+}
+
+// Save 實作 Dragonboat Snapshot 介面，寫出 PrepareSnapshot 當下的狀態，可與 Update 並行
+// v1 / v2 將資料依幣別存成多個分檔，v3 直接寫入主串流，v4 將餘額寫成 base 與 delta 組成的外部檔案鏈
+func (p *PreparedSnapshot) Save(w io.Writer, fss statemachine.ISnapshotFileCollection, done <-chan struct{}) error {
+	cs, version := p.cs, p.version
+	// 順便清除目前歷史中超出保留範圍的版本，已取得的檢視不受影響
+	cs.history.Prune()
+	// v3 與 v4 直接由檢視的資料寫出，不複製整個帳本
+	switch version {
+	case 3:
+		meta, balances := p.view.meta(), p.view.tables()
+		meta.Manifest = v3Manifest(balances)
+		return encodeSnapshot(w, version, meta, balances, done)
+	case 4:
+		// 延續鏈時只讀取與雜湊變動的紀錄，manifest 以鏈上的 Sum 核對，不重新計算各幣別的完整摘要
+		chain, err := cs.writeChain(p.seq, p.view.tables(), p.dirty, fss, done)
+		if err != nil {
+			return err
+		}
+		meta := p.view.meta()
+		meta.Manifest = &Manifest{Chain: chain}
+		return encodeSnapshot(w, version, meta, nil, done)
 	}
-	if err := gob.NewEncoder(w).Encode(meta); err != nil {
+	content := p.view.Capture()
+	entries, err := cs.writeSnapshotFiles(version, content.Balances, fss, done)
+	if err != nil {
 		return err
 	}
+	content.Meta.Manifest = &Manifest{Entries: entries}
+	return encodeSnapshot(w, version, content.Meta, nil, done)
+}
 
-	var saveErr error
-	var index uint64 = 0
+// Capture 複製目前的完整狀態
+// 與 Update 並行時各部分可能屬於不同 index，需要單一 index 的一致狀態時，呼叫端需確保取得 View 期間沒有 Update
+func (cs *CurrencyStore) Capture() *SnapshotContent {
+	return cs.View().Capture()
+}
 
-	// 遍歷所有貨幣並分別序列化存檔
-	cs.store.Range(func(key, value any) bool {
-		select {
-		case <-done:
-			saveErr = errors.New("snapshot save stopped")
-			return false
-		default:
-		}
+// encodeSnapshot 寫出 snapshot 主串流：先寫元資料，v3 再將 balances 串流接在之後，不產生外部檔案
+// 其他版本的餘額在外部檔案中，balances 不使用
+func encodeSnapshot(w io.Writer, version uint64, meta *StoreMeta, balances map[string]balanceTable, done <-chan struct{}) error {
+	if err := gob.NewEncoder(w).Encode(SnapshotFile{SnapshotVersion: version, Data: meta}); err != nil {
+		return err
+	}
+	if version == 3 {
+		return writeSnapshotV3(w, balances, done)
+	}
+	return nil
+}
 
-		currency := key.(string)
-		sfm := value.(*maps.SafeFloatMap)
-		dataMap := sfm.Snapshot()
+// SnapshotContent 為解碼並驗證後的 snapshot 內容，不依附任何 store，供離線工具檢視與轉換
+type SnapshotContent struct {
+	Version  uint64                        // 寫入該 snapshot 的格式版本
+	Meta     *StoreMeta                    // 舊版 snapshot 缺少的欄位為零值
+	Balances map[string]map[string]float64 // currency -> uid -> balance
+}
 
-		buf := new(bytes.Buffer)
-		var snapshot SnapshotFile
+// DecodeSnapshot 讀取 snapshot 主串流與外部檔案，依版本解碼並核對 manifest，不修改任何狀態
+// v3 的餘額接在元資料之後寫在主串流，v1 / v2 的餘額存放在外部檔案，v4 則依序套用外部檔案中的 base 與 delta
+// DiskStore 寫出的 snapshot 沒有格式版本，Version 為 0
+func DecodeSnapshot(r io.Reader, files []statemachine.SnapshotFile, done <-chan struct{}) (*SnapshotContent, error) {
+	// gob 對實作 io.ByteReader 的 reader 不會預讀，元資料之後的 v3 串流才能接著讀取
+	br := bufio.NewReader(r)
+	if magic, err := br.Peek(len(diskSnapshotMagic)); err == nil && string(magic) == diskSnapshotMagic {
+		br.Discard(len(magic))
+		return readDiskSnapshot(br, done)
+	}
 
-		// 根據版本組裝序列化物件
-		switch version {
-		case 1:
-			snapshot = SnapshotFile{
-				SnapshotVersion: 1,
-				Data:            &StoreV1{Data: dataMap},
-			}
-		case 2:
-			// 將 map 轉成 slice []{Key,Value string}
-			dataSlice := make([]struct {
-				Key   string
-				Value string
-			}, 0, len(dataMap))
-			for k, v := range dataMap {
-				dataSlice = append(dataSlice, struct {
-					Key   string
-					Value string
-				}{
-					Key:   k,
-					Value: fmt.Sprintf("%f", v),
-				})
-			}
-			snapshot = SnapshotFile{
-				SnapshotVersion: 2,
-				Data:            &StoreV2{Data: dataSlice},
-			}
-		default:
-			saveErr = fmt.Errorf("unsupported snapshot version %d", version)
-			return false
-		}
+	// 先解 meta，取得版本號
+	var meta SnapshotFile
+	if err := gob.NewDecoder(br).Decode(&meta); err != nil {
+		return nil, err
+	}
+	m, _ := meta.Data.(*StoreMeta)
+	if m == nil {
+		m = &StoreMeta{}
+	}
 
-		if err := gob.NewEncoder(buf).Encode(snapshot); err != nil {
-			saveErr = err
-			return false
+	var balances map[string]map[string]float64
+	var err error
+	switch meta.SnapshotVersion {
+	case 4:
+		balances, err = readChain(files, m.Manifest, done)
+	case 3:
+		balances, err = readSnapshotV3(br, done)
+		if err == nil && m.Manifest != nil {
+			err = m.Manifest.verifyBalances(balances)
 		}
+	case 1, 2:
+		balances, err = readSnapshotFiles(files, m.Manifest, done)
+	default:
+		err = fmt.Errorf("%w %d", ErrUnsupportedSnapshot, meta.SnapshotVersion)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &SnapshotContent{Version: meta.SnapshotVersion, Meta: m, Balances: balances}, nil
+}
 
-		compressed := snappy.Encode(nil, buf.Bytes())
-
-		filename := fmt.Sprintf("currency_%s.snap", currency)
-		fss.AddFile(index, filename, compressed)
-
-		index++
-		return true
-	})
-
-	return saveErr
+// SnapshotVersion 只解碼 snapshot 開頭的元資料，回傳其格式版本
+// DiskStore 寫出的 snapshot 沒有格式版本，回傳 0
+func SnapshotVersion(r io.Reader) (uint64, error) {
+	br := bufio.NewReader(r)
+	if magic, err := br.Peek(len(diskSnapshotMagic)); err == nil && string(magic) == diskSnapshotMagic {
+		return 0, nil
+	}
+	var meta SnapshotFile
+	if err := gob.NewDecoder(br).Decode(&meta); err != nil {
+		return 0, err
+	}
+	return meta.SnapshotVersion, nil
 }
 
-// RecoverFromSnapshot 依版本還原 Snapshot
+// RecoverFromSnapshot 依版本還原 Snapshot，以 snapshot 內容取代目前所有資料
+// 讀取與驗證完成前不會修改目前狀態；還原過程中的查詢可能看到部分結果，需要原子替換時應還原到 Fresh 回傳的 store
 func (cs *CurrencyStore) RecoverFromSnapshot(r io.Reader, files []statemachine.SnapshotFile, done <-chan struct{}) error {
-	// 先解 meta，取得版本號
-	var meta SnapshotFile
-	if err := gob.NewDecoder(r).Decode(&meta); err != nil {
+	content, err := DecodeSnapshot(r, files, done)
+	if err != nil {
 		return err
 	}
+	m := content.Meta
+	cs.store.Range(func(key, _ any) bool {
+		cs.store.Delete(key)
+		return true
+	})
+	for currency, byUID := range content.Balances {
+		sfm := maps.NewSafeFloatMap()
+		sfm.LoadData(byUID)
+		cs.store.Store(currency, sfm)
+	}
 
-	for _, file := range files {
-		select {
-		case <-done:
-			return errors.New("snapshot recover stopped")
-		default:
-		}
-
-		filename := filepath.Base(file.Filepath)
-		parts := strings.Split(filename, "_")
-		if len(parts) != 2 || !strings.HasSuffix(parts[1], ".snap") {
-			continue
-		}
-		currency := strings.TrimSuffix(parts[1], ".snap")
-
-		raw, err := os.ReadFile(file.Filepath)
-		if err != nil {
-			return err
-		}
-
-		decompressed, err := snappy.Decode(nil, raw)
-		if err != nil {
-			return err
-		}
-
-		var snapshot SnapshotFile
-		if err := gob.NewDecoder(bytes.NewReader(decompressed)).Decode(&snapshot); err != nil {
-			return err
-		}
+	// 舊版 snapshot 沒有歷史資料時，以還原出的餘額作為可查詢的最早版本
+	if m.History != nil {
+		cs.history.LoadData(m.History)
+	} else {
+		cs.history.Seed(content.Balances)
+	}
+	cs.registry.LoadData(m.Currencies)
+	cs.accounts.LoadData(m.Accounts)
+	cs.limits.LoadData(m.Limits)
+	cs.mu.Lock()
+	cs.settings = content.settings()
+	cs.nodes = make(map[uint64]domain.NodeSupport)
+	for _, ns := range m.Nodes {
+		cs.nodes[ns.NodeID] = ns
+	}
+	cs.checksums = m.Checksums
+	cs.mu.Unlock()
 
-		var merged map[string]float64
-		switch snapshot.SnapshotVersion {
-		case 1:
-			dataV1, ok := snapshot.Data.(*StoreV1)
-			if !ok {
-				return errors.New("invalid snapshot data type for v1")
-			}
-			merged, _ = migrateFromV1(dataV1)
-		case 2:
-			dataV2, ok := snapshot.Data.(*StoreV2)
-			if !ok {
-				return errors.New("invalid snapshot data type for v2")
-			}
-			merged, err = migrateFromV2(dataV2)
-			if err != nil {
-				return err
-			}
-		default:
-			return fmt.Errorf("unsupported snapshot version %d", snapshot.SnapshotVersion)
-		}
+	return nil
+}
 
-		for uid, val := range merged {
-			cs.Update(uid, currency, val)
+// settings 回傳 snapshot 中的叢集設定，舊版 snapshot 沒有設定時沿用寫入該 snapshot 的格式版本
+func (c *SnapshotContent) settings() domain.Settings {
+	settings := defaultSettings()
+	if c.Meta.Settings != nil {
+		settings = *c.Meta.Settings
+		if settings.CommandVersion == 0 {
+			settings.CommandVersion = configs.DefaultCommandVersion
 		}
+	} else if c.Version != 0 {
+		settings.SnapshotVersion = c.Version
 	}
+	return settings
+}
 
-	return nil
+// defaultSettings 回傳尚未透過 raft 命令變更前的叢集設定
+func defaultSettings() domain.Settings {
+	return domain.Settings{
+		SnapshotVersion: configs.DefaultSnapshotVersion,
+		CommandVersion:  configs.DefaultCommandVersion,
+	}
 }
 
 // migrateFromV1 將 V1 版本資料轉成 map[string]float64
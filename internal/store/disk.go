@@ -0,0 +1,816 @@
+package store
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+	"strings"
+	"sync"
+
+	"go-raft/internal/domain"
+	"go-raft/pkg/maps"
+
+	"github.com/cockroachdb/pebble"
+)
+
+// Pebble 上的 key 配置：開頭為資料種類，字串欄位以 \x00 分隔，index 與節點 ID 以 8 bytes big endian 編碼以維持排序
+//
+//	a/<uid>                            帳戶（JSON）
+//	b/<currency>\x00<uid>              餘額（float64 bits）
+//	c/<code>                           幣別（JSON）
+//	h/<currency>\x00<uid>\x00<index>   index 套用後的歷史餘額（float64 bits）
+//	i                                  套用進度（JSON diskState），與同一批 entry 的資料一起寫入
+//	k                                  最近的 checksum 結果（JSON）
+//	n/<nodeID>                         節點回報的支援版本（JSON）
+//	r/<ruleKey>                        限額政策（JSON）
+//	s                                  叢集設定（JSON）
+//	t/<index>                          index 的提交時間（int64）
+//	u/<uid>\x00<currency>              uid 寫入過的幣別索引（空值）
+//	w/<currency>\x00<uid>              滾動視窗內的提領紀錄（JSON）
+const (
+	prefixAccount  = "a/"
+	prefixBalance  = "b/"
+	prefixCurrency = "c/"
+	prefixHistory  = "h/"
+	keyState       = "i"
+	keyChecksums   = "k"
+	prefixNode     = "n/"
+	prefixRule     = "r/"
+	keySettings    = "s"
+	prefixMark     = "t/"
+	prefixHolding  = "u/"
+	prefixWindow   = "w/"
+)
+
+// diskState 為 DiskLedger 的套用進度，對應記憶體中 History 的 applied、now、floor
+type diskState struct {
+	Applied   uint64
+	Now       int64
+	Leader    int64 // leader 提案採用過的最大時間
+	Floor     uint64
+	MarkFloor uint64 // 早於此 index 的提交時間已刪除
+	Seeded    bool   // 由無歷史的舊版 snapshot 建立，floor 待下一個 index 決定
+	Swept     int64  // 最後一次清除過期提領紀錄的提交時間
+}
+
+// advance 推進 applied 與 floor，規則與 History.advance 相同；回傳是否需要刪除早於 floor 的提交時間
+func (s *diskState) advance(index, retention uint64) bool {
+	if s.Seeded && index > 0 {
+		s.Applied, s.Floor, s.Seeded = index-1, index-1, false
+	}
+	if index > s.Applied {
+		s.Applied = index
+	}
+	if retention > 0 && s.Applied > retention && s.Applied-retention > s.Floor {
+		s.Floor = s.Applied - retention
+		// 與 History 相同，累積到保留範圍兩倍時才整理以攤銷成本
+		return s.Floor-s.MarkFloor > retention
+	}
+	return false
+}
+
+// DiskStore 將帳本存放在 Pebble 中，資料量不受記憶體限制，重新啟動時不需要從 snapshot 重建
+type DiskStore struct {
+	db        *pebble.DB
+	retention uint64
+	// restoring 於 Restore 期間持有寫鎖，View 等到還原完成才取得 Pebble snapshot，不會看到還原到一半的資料
+	restoring sync.RWMutex
+}
+
+// OpenDiskStore 開啟或建立 dir 下的 Pebble 資料庫，historyRetention 為歷史餘額保留的 raft index 數量，0 代表不清除
+func OpenDiskStore(dir string, historyRetention uint64) (*DiskStore, error) {
+	db, err := pebble.Open(dir, &pebble.Options{})
+	if err != nil {
+		return nil, err
+	}
+	return &DiskStore{db: db, retention: historyRetention}, nil
+}
+
+// Applied 回傳已寫入的最後套用 raft index
+func (d *DiskStore) Applied() (uint64, error) {
+	l, err := d.View()
+	if err != nil {
+		return 0, err
+	}
+	defer l.Close()
+	return l.state.Applied, nil
+}
+
+// Begin 開始套用一批 entry，回傳可讀寫的 DiskLedger；以 Commit 寫入後需 Close
+// 寫入在 Commit 前對其他讀取不可見，同一時間只能有一個進行中的 Begin
+func (d *DiskStore) Begin() (*DiskLedger, error) {
+	b := d.db.NewIndexedBatch()
+	return newDiskLedger(b, b, d.retention)
+}
+
+// View 回傳目前狀態的唯讀 DiskLedger，之後的寫入不影響其內容，用完需 Close；Restore 期間會等待還原完成
+func (d *DiskStore) View() (*DiskLedger, error) {
+	d.restoring.RLock()
+	defer d.restoring.RUnlock()
+	return newDiskLedger(d.db.NewSnapshot(), nil, d.retention)
+}
+
+// Sync 將已 Commit 的寫入 fsync 到磁碟
+func (d *DiskStore) Sync() error {
+	return d.db.LogData(nil, pebble.Sync)
+}
+
+// Close 關閉資料庫，之後不可再使用 DiskStore 與其 DiskLedger
+func (d *DiskStore) Close() error {
+	return d.db.Close()
+}
+
+// DiskLedger 為 DiskStore 在單一時間點的帳本，實作 Ledger
+// 介面方法無法回傳的讀寫錯誤保存在 Err，Commit 時一併回傳
+type DiskLedger struct {
+	r         pebble.Reader
+	batch     *pebble.Batch // 唯讀的 View 為 nil
+	retention uint64
+	state     diskState
+	err       error
+}
+
+func newDiskLedger(r pebble.Reader, batch *pebble.Batch, retention uint64) (*DiskLedger, error) {
+	l := &DiskLedger{r: r, batch: batch, retention: retention}
+	l.getJSON([]byte(keyState), &l.state)
+	if l.err != nil {
+		r.Close()
+		return nil, l.err
+	}
+	return l, nil
+}
+
+// Err 回傳第一個讀寫錯誤
+func (l *DiskLedger) Err() error {
+	return l.err
+}
+
+// Commit 連同套用進度寫入這批 entry 的所有變更，不 fsync；持久化由 DiskStore.Sync 負責
+func (l *DiskLedger) Commit() error {
+	l.setJSON([]byte(keyState), l.state)
+	if l.err != nil {
+		return l.err
+	}
+	return l.batch.Commit(pebble.NoSync)
+}
+
+// Close 釋放 batch 或 Pebble snapshot，未 Commit 的寫入會被捨棄
+func (l *DiskLedger) Close() error {
+	return l.r.Close()
+}
+
+func (l *DiskLedger) fail(err error) {
+	if l.err == nil {
+		l.err = err
+	}
+}
+
+func (l *DiskLedger) get(key []byte) ([]byte, bool) {
+	v, closer, err := l.r.Get(key)
+	if errors.Is(err, pebble.ErrNotFound) {
+		return nil, false
+	}
+	if err != nil {
+		l.fail(err)
+		return nil, false
+	}
+	defer closer.Close()
+	return slices.Clone(v), true
+}
+
+func (l *DiskLedger) getJSON(key []byte, v any) bool {
+	raw, ok := l.get(key)
+	if !ok {
+		return false
+	}
+	if err := json.Unmarshal(raw, v); err != nil {
+		l.fail(fmt.Errorf("decode %q: %w", key, err))
+		return false
+	}
+	return true
+}
+
+func (l *DiskLedger) set(key, value []byte) {
+	if err := l.batch.Set(key, value, nil); err != nil {
+		l.fail(err)
+	}
+}
+
+func (l *DiskLedger) setJSON(key []byte, v any) {
+	raw, err := json.Marshal(v)
+	if err != nil {
+		l.fail(fmt.Errorf("encode %q: %w", key, err))
+		return
+	}
+	l.set(key, raw)
+}
+
+func (l *DiskLedger) delete(key []byte) {
+	if err := l.batch.Delete(key, nil); err != nil {
+		l.fail(err)
+	}
+}
+
+// iter 回傳範圍為 [lower, upper) 的 iterator，upper 為 nil 代表沒有上限
+func (l *DiskLedger) iter(lower, upper []byte) *pebble.Iterator {
+	return l.r.NewIter(&pebble.IterOptions{LowerBound: lower, UpperBound: upper})
+}
+
+// scan 依 key 順序走訪 prefix 下的所有資料，fn 回傳 false 時停止；key 與 value 只在 fn 內有效
+func (l *DiskLedger) scan(prefix string, fn func(key, value []byte) bool) {
+	it := l.iter([]byte(prefix), prefixEnd([]byte(prefix)))
+	for valid := it.First(); valid; valid = it.Next() {
+		if !fn(it.Key()[len(prefix):], it.Value()) {
+			break
+		}
+	}
+	if err := it.Close(); err != nil {
+		l.fail(err)
+	}
+}
+
+// Advance 紀錄最後套用的 raft index 與提交時間，規則與 History.Advance 相同
+func (l *DiskLedger) Advance(index uint64, proposed int64, leader bool) int64 {
+	s := &l.state
+	if index <= s.Applied && !s.Seeded {
+		return s.Now
+	}
+	if proposed > s.Now {
+		s.Now = proposed
+	}
+	if leader && proposed > s.Leader {
+		s.Leader = proposed
+	}
+	if s.advance(index, l.retention) {
+		if err := l.batch.DeleteRange(indexKey(prefixMark, s.MarkFloor), indexKey(prefixMark, s.Floor), nil); err != nil {
+			l.fail(err)
+		}
+		s.MarkFloor = s.Floor
+	}
+	l.set(indexKey(prefixMark, index), encodeInt(s.Now))
+	return s.Now
+}
+
+// Applied 回傳最後套用的 raft index
+func (l *DiskLedger) Applied() uint64 {
+	return l.state.Applied
+}
+
+// Now 回傳最後套用 entry 的提交時間（Unix 奈秒）
+func (l *DiskLedger) Now() int64 {
+	return l.state.Now
+}
+
+// LeaderNow 回傳 leader 提案採用過的最大時間，尚未套用過時為 0
+func (l *DiskLedger) LeaderNow() int64 {
+	return l.state.Leader
+}
+
+// UpdateAt 於 raft index 套用金額變動，並紀錄該版本的餘額
+func (l *DiskLedger) UpdateAt(index uint64, uid, currency string, amount float64) {
+	key := balanceKey(currency, uid)
+	old, ok := l.balance(key)
+	if !ok {
+		l.set(diskKey(prefixHolding, uid, currency), nil)
+	}
+	l.set(key, encodeFloat(old+amount))
+	l.record(index, currency, uid, old+amount)
+}
+
+// record 紀錄 index 套用後的餘額，並與 History.Record 相同地清除 floor 之前已失效的版本
+func (l *DiskLedger) record(index uint64, currency, uid string, balance float64) {
+	prefix := historyPrefix(currency, uid)
+	l.set(indexKey(prefix, index), encodeFloat(balance))
+
+	it := l.iter(prefix, indexKey(prefix, l.state.Floor+1))
+	var stale [][]byte
+	if it.Last() {
+		for it.Prev() {
+			stale = append(stale, slices.Clone(it.Key()))
+		}
+	}
+	if err := it.Close(); err != nil {
+		l.fail(err)
+	}
+	for _, key := range stale {
+		l.delete(key)
+	}
+}
+
+func (l *DiskLedger) balance(key []byte) (float64, bool) {
+	raw, ok := l.get(key)
+	if !ok {
+		return 0, false
+	}
+	return decodeFloat(raw), true
+}
+
+// Get 取得指定 uid、貨幣的餘額，找不到回傳 0
+func (l *DiskLedger) Get(uid, currency string) float64 {
+	balance, _ := l.balance(balanceKey(currency, uid))
+	return balance
+}
+
+// GetAt 取得指定 uid、貨幣在 raft index 套用後的餘額
+func (l *DiskLedger) GetAt(uid, currency string, index uint64) (float64, error) {
+	s := l.state
+	if index > s.Applied {
+		return 0, fmt.Errorf("%w: requested %d, applied %d", ErrIndexNotApplied, index, s.Applied)
+	}
+	if index < s.Floor {
+		return 0, fmt.Errorf("%w: requested %d, oldest available %d", ErrHistoryPruned, index, s.Floor)
+	}
+	prefix := historyPrefix(currency, uid)
+	it := l.iter(prefix, prefixEnd(prefix))
+	defer it.Close()
+	if !it.SeekLT(indexKey(prefix, index+1)) {
+		return 0, it.Error()
+	}
+	return decodeFloat(it.Value()), nil
+}
+
+// GetAsOf 取得指定 uid、貨幣在提交時間 ts（Unix 奈秒）當下的餘額
+func (l *DiskLedger) GetAsOf(uid, currency string, ts int64) (float64, error) {
+	index, err := l.indexAt(ts)
+	if err != nil {
+		return 0, err
+	}
+	return l.GetAt(uid, currency, index)
+}
+
+// indexAt 回傳在提交時間 ts（含）之前最後套用的 raft index
+// 不經過狀態機的 entry（例如成員變更）沒有提交時間，因此以 SeekGE 在 index 範圍上二分搜尋
+func (l *DiskLedger) indexAt(ts int64) (uint64, error) {
+	it := l.iter(indexKey(prefixMark, l.state.MarkFloor), prefixEnd([]byte(prefixMark)))
+	defer it.Close()
+	if !it.First() || decodeInt(it.Value()) > ts {
+		return 0, fmt.Errorf("%w: requested time %d is before retained history", ErrHistoryPruned, ts)
+	}
+	// lo 之前的提交時間都不晚於 ts，hi 之後第一個提交時間晚於 ts
+	lo, hi := markIndex(it.Key()), l.state.Applied+1
+	for lo < hi {
+		mid := lo + (hi-lo)/2
+		if it.SeekGE(indexKey(prefixMark, mid)) && decodeInt(it.Value()) <= ts {
+			lo = markIndex(it.Key()) + 1
+		} else {
+			hi = mid
+		}
+	}
+	if !it.SeekLT(indexKey(prefixMark, lo)) {
+		return 0, fmt.Errorf("%w: requested time %d is before retained history", ErrHistoryPruned, ts)
+	}
+	return markIndex(it.Key()), nil
+}
+
+// Holdings 回傳指定 uid 在所有幣別的餘額，不包含從未寫入的幣別
+func (l *DiskLedger) Holdings(uid string) map[string]float64 {
+	var currencies []string
+	l.scan(prefixHolding+uid+"\x00", func(key, _ []byte) bool {
+		currencies = append(currencies, string(key))
+		return true
+	})
+	result := make(map[string]float64, len(currencies))
+	for _, currency := range currencies {
+		result[currency] = l.Get(uid, currency)
+	}
+	return result
+}
+
+// RangeBalances 依 uid 順序走訪幣別的所有餘額，只掃描該幣別的 key，fn 回傳 false 時停止
+func (l *DiskLedger) RangeBalances(currency string, fn func(uid string, balance float64) bool) {
+	l.scan(prefixBalance+currency+"\x00", func(key, value []byte) bool {
+		return fn(string(key), decodeFloat(value))
+	})
+}
+
+// List 回傳所有帳戶與貨幣餘額
+func (l *DiskLedger) List() map[string]map[string]float64 {
+	result := make(map[string]map[string]float64)
+	l.scan(prefixBalance, func(key, value []byte) bool {
+		currency, uid, _ := strings.Cut(string(key), "\x00")
+		if result[uid] == nil {
+			result[uid] = make(map[string]float64)
+		}
+		result[uid][currency] = decodeFloat(value)
+		return true
+	})
+	return result
+}
+
+// balances 回傳 currency -> uid -> balance 的完整副本
+func (l *DiskLedger) balances() map[string]map[string]float64 {
+	result := make(map[string]map[string]float64)
+	l.scan(prefixBalance, func(key, value []byte) bool {
+		currency, uid, _ := strings.Cut(string(key), "\x00")
+		if result[currency] == nil {
+			result[currency] = make(map[string]float64)
+		}
+		result[currency][uid] = decodeFloat(value)
+		return true
+	})
+	return result
+}
+
+// Stats 以掃描計算指定幣別的彙總統計，幣別不存在時回傳零值
+func (l *DiskLedger) Stats(currency string, topN int) domain.CurrencyStats {
+	agg := maps.NewAggregator(topN)
+	l.scan(prefixBalance+currency+"\x00", func(key, value []byte) bool {
+		agg.Add(string(key), decodeFloat(value))
+		return true
+	})
+	return toCurrencyStats(currency, agg.Result())
+}
+
+// AllStats 回傳所有幣別的彙總統計，依幣別排序
+func (l *DiskLedger) AllStats(topN int) []domain.CurrencyStats {
+	result := make([]domain.CurrencyStats, 0)
+	var current string
+	var agg *maps.Aggregator
+	l.scan(prefixBalance, func(key, value []byte) bool {
+		currency, uid, _ := strings.Cut(string(key), "\x00")
+		if agg == nil || currency != current {
+			if agg != nil {
+				result = append(result, toCurrencyStats(current, agg.Result()))
+			}
+			current, agg = currency, maps.NewAggregator(topN)
+		}
+		agg.Add(uid, decodeFloat(value))
+		return true
+	})
+	if agg != nil {
+		result = append(result, toCurrencyStats(current, agg.Result()))
+	}
+	return result
+}
+
+// VerifyStats 統計每次都以掃描計算，沒有需要比對的增量結果，只回報讀取錯誤
+func (l *DiskLedger) VerifyStats(string, int) error {
+	return l.err
+}
+
+// Settings 回傳叢集設定
+func (l *DiskLedger) Settings() domain.Settings {
+	settings := defaultSettings()
+	l.getJSON([]byte(keySettings), &settings)
+	return settings
+}
+
+// ReportSupport 紀錄節點回報的支援版本，同一節點重複回報時以最新的為準
+func (l *DiskLedger) ReportSupport(index uint64, ns domain.NodeSupport) {
+	l.setJSON(nodeKey(ns.NodeID), reportedAt(index, ns))
+}
+
+// RegisterMember 登記即將加入 shard 的節點，或撤銷尚未回報的登記
+func (l *DiskLedger) RegisterMember(r domain.MemberRegistration) {
+	var ns domain.NodeSupport
+	ok := l.getJSON(nodeKey(r.NodeID), &ns)
+	if next, keep := registered(ns, ok, r); keep {
+		l.setJSON(nodeKey(r.NodeID), next)
+	} else if ok {
+		l.delete(nodeKey(r.NodeID))
+	}
+}
+
+// Activate 在所有成員都回報支援後啟用格式版本，啟用後不可降級
+func (l *DiskLedger) Activate(a domain.Activation) error {
+	settings := l.Settings()
+	err := activate(&settings, l.nodeList(), a)
+	if err != nil {
+		return err
+	}
+	l.setJSON([]byte(keySettings), settings)
+	return nil
+}
+
+// UpgradeState 回傳目前設定與各節點回報的支援版本，依節點 ID 排序
+func (l *DiskLedger) UpgradeState() domain.UpgradeState {
+	return domain.UpgradeState{Settings: l.Settings(), Nodes: l.nodeList()}
+}
+
+func (l *DiskLedger) nodeList() []domain.NodeSupport {
+	result := make([]domain.NodeSupport, 0)
+	l.scan(prefixNode, func(_, value []byte) bool {
+		var ns domain.NodeSupport
+		if err := json.Unmarshal(value, &ns); err != nil {
+			l.fail(err)
+			return false
+		}
+		result = append(result, ns)
+		return true
+	})
+	return result
+}
+
+// OpenChecksum 於 raft index 開始一次 checksum，之後各節點回報的結果紀錄在此 round
+func (l *DiskLedger) OpenChecksum(index uint64) {
+	l.setJSON([]byte(keyChecksums), openChecksum(l.Checksums(), index))
+}
+
+// ReportChecksum 紀錄節點回報的 checksum，同一節點重複回報時以最新的為準
+func (l *DiskLedger) ReportChecksum(r domain.ChecksumReport) error {
+	rounds, err := reportChecksum(l.Checksums(), r)
+	if err != nil {
+		return err
+	}
+	l.setJSON([]byte(keyChecksums), rounds)
+	return nil
+}
+
+// Checksums 回傳保留的 checksum 結果，依 index 排序
+func (l *DiskLedger) Checksums() []domain.ChecksumRound {
+	var rounds []domain.ChecksumRound
+	l.getJSON([]byte(keyChecksums), &rounds)
+	return rounds
+}
+
+// Checksum 計算目前狀態的摘要，在套用 checksum 命令的 batch 中呼叫時包含同一批先前的 entry
+func (l *DiskLedger) Checksum(nodeID uint64) domain.ChecksumReport {
+	settings := l.Settings()
+	return checksum(nodeID, l.state.Applied, l.state.Now, l.balances(), &StoreMeta{
+		Currencies: l.Registry().List(),
+		Accounts:   l.Accounts().List(),
+		Limits:     l.limits(),
+		Settings:   &settings,
+	})
+}
+
+// Capture 複製目前的完整狀態，內容全部載入記憶體，只適合匯出
+func (l *DiskLedger) Capture() *SnapshotContent {
+	dc := newDiskContent()
+	l.scan("", func(key, value []byte) bool {
+		if err := dc.add(key, value); err != nil {
+			l.fail(fmt.Errorf("decode %q: %w", key, err))
+			return false
+		}
+		return true
+	})
+	// batch 中的套用進度在 Commit 時才寫入
+	dc.state = l.state
+	return dc.result()
+}
+
+func (l *DiskLedger) limits() *LimitsData {
+	data := &LimitsData{Rules: l.Limits().Rules(), Windows: make(map[string]map[string][]Withdrawal)}
+	l.scan(prefixWindow, func(key, value []byte) bool {
+		currency, uid, _ := strings.Cut(string(key), "\x00")
+		var ws []Withdrawal
+		if err := json.Unmarshal(value, &ws); err != nil {
+			l.fail(err)
+			return false
+		}
+		if data.Windows[currency] == nil {
+			data.Windows[currency] = make(map[string][]Withdrawal)
+		}
+		data.Windows[currency][uid] = ws
+		return true
+	})
+	return data
+}
+
+// Registry 回傳幣別註冊表
+func (l *DiskLedger) Registry() CurrencyTable {
+	return diskCurrencies{l}
+}
+
+// Accounts 回傳帳戶表
+func (l *DiskLedger) Accounts() AccountTable {
+	return diskAccounts{l}
+}
+
+// Limits 回傳限額政策與提領計數器
+func (l *DiskLedger) Limits() LimitTable {
+	return diskLimits{l}
+}
+
+// diskCurrencies 為 DiskLedger 上的幣別註冊表
+type diskCurrencies struct{ l *DiskLedger }
+
+func (d diskCurrencies) Put(c domain.Currency) {
+	d.l.setJSON(diskKey(prefixCurrency, c.Code), c)
+}
+
+func (d diskCurrencies) Get(code string) (domain.Currency, bool) {
+	var c domain.Currency
+	ok := d.l.getJSON(diskKey(prefixCurrency, code), &c)
+	return c, ok
+}
+
+func (d diskCurrencies) List() []domain.Currency {
+	return scanJSON[domain.Currency](d.l, prefixCurrency)
+}
+
+func (d diskCurrencies) Validate(code string, amount float64) error {
+	c, ok := d.Get(code)
+	return validateAmount(code, c, ok, amount)
+}
+
+// diskAccounts 為 DiskLedger 上的帳戶表
+type diskAccounts struct{ l *DiskLedger }
+
+func (d diskAccounts) Get(uid string) (domain.Account, bool) {
+	var acc domain.Account
+	ok := d.l.getJSON(diskKey(prefixAccount, uid), &acc)
+	return acc, ok
+}
+
+func (d diskAccounts) put(acc domain.Account) {
+	d.l.setJSON(diskKey(prefixAccount, acc.UID), acc)
+}
+
+func (d diskAccounts) Open(index uint64, now int64, uid, tier string, metadata map[string]string) error {
+	if _, ok := d.Get(uid); ok {
+		return fmt.Errorf("%w: %s", ErrAccountExists, uid)
+	}
+	d.put(newAccount(index, now, uid, tier, metadata))
+	return nil
+}
+
+func (d diskAccounts) SetTier(index uint64, uid, tier string) error {
+	acc, ok := d.Get(uid)
+	acc, err := setTier(acc, ok, index, uid, tier)
+	if err != nil {
+		return err
+	}
+	d.put(acc)
+	return nil
+}
+
+func (d diskAccounts) Writable(uid string) (domain.Account, error) {
+	acc, ok := d.Get(uid)
+	if !ok {
+		return domain.Account{}, nil
+	}
+	return acc, writable(acc)
+}
+
+func (d diskAccounts) Ensure(index uint64, now int64, uid string) {
+	if _, ok := d.Get(uid); !ok {
+		d.put(newAccount(index, now, uid, "", nil))
+	}
+}
+
+func (d diskAccounts) Transition(index uint64, uid string, action domain.AccountAction, reason string) error {
+	acc, ok := d.Get(uid)
+	acc, err := transition(acc, ok, index, uid, action, reason)
+	if err != nil {
+		return err
+	}
+	d.put(acc)
+	return nil
+}
+
+func (d diskAccounts) List() []domain.Account {
+	return scanJSON[domain.Account](d.l, prefixAccount)
+}
+
+// diskLimits 為 DiskLedger 上的限額政策與提領計數器
+type diskLimits struct{ l *DiskLedger }
+
+func (d diskLimits) Set(rule domain.LimitRule) {
+	d.l.setJSON(diskKey(prefixRule, ruleKey(rule.Scope, rule.Currency, rule.Tier, rule.UID)), rule)
+}
+
+func (d diskLimits) Remove(rule domain.LimitRule) {
+	d.l.delete(diskKey(prefixRule, ruleKey(rule.Scope, rule.Currency, rule.Tier, rule.UID)))
+}
+
+func (d diskLimits) Rules() []domain.LimitRule {
+	return scanJSON[domain.LimitRule](d.l, prefixRule)
+}
+
+func (d diskLimits) effective(uid, currency, tier string) (domain.LimitRule, bool) {
+	return effectiveRule(func(key string) (domain.LimitRule, bool) {
+		var rule domain.LimitRule
+		ok := d.l.getJSON(diskKey(prefixRule, key), &rule)
+		return rule, ok
+	}, uid, currency, tier)
+}
+
+func (d diskLimits) window(currency, uid string) []Withdrawal {
+	var ws []Withdrawal
+	d.l.getJSON(diskKey(prefixWindow, currency, uid), &ws)
+	return ws
+}
+
+func (d diskLimits) Consume(now int64, uid, currency, tier string, amount float64) error {
+	rule, ok := d.effective(uid, currency, tier)
+	if !ok {
+		return nil
+	}
+	ws, err := consume(rule, d.window(currency, uid), now, amount)
+	if err != nil || !recordsWindow(rule, amount) {
+		return err
+	}
+	if len(ws) == 0 {
+		d.l.delete(diskKey(prefixWindow, currency, uid))
+	} else {
+		d.l.setJSON(diskKey(prefixWindow, currency, uid), ws)
+	}
+	d.sweep(now)
+	return nil
+}
+
+// sweep 與 Limits.sweep 相同，每經過一個視窗長度清除一次所有紀錄皆已過期的使用者
+func (d diskLimits) sweep(now int64) {
+	s := &d.l.state
+	if now-s.Swept < int64(domain.LimitWindow) {
+		return
+	}
+	s.Swept = now
+	var expired [][]byte
+	d.l.scan(prefixWindow, func(key, value []byte) bool {
+		var ws []Withdrawal
+		if err := json.Unmarshal(value, &ws); err != nil {
+			d.l.fail(err)
+			return false
+		}
+		if i, _ := window(ws, now); i == len(ws) {
+			expired = append(expired, []byte(prefixWindow+string(key)))
+		}
+		return true
+	})
+	for _, key := range expired {
+		d.l.delete(key)
+	}
+}
+
+func (d diskLimits) View(now int64, uid, currency, tier string) domain.LimitView {
+	_, withdrawn := window(d.window(currency, uid), now)
+	view := domain.LimitView{UID: uid, Currency: currency, Withdrawn: withdrawn}
+	if rule, ok := d.effective(uid, currency, tier); ok {
+		view.Scope, view.Policy = rule.Scope, rule.Policy
+	}
+	return view
+}
+
+// scanJSON 依 key 順序解碼 prefix 下的所有 JSON 值
+func scanJSON[T any](l *DiskLedger, prefix string) []T {
+	result := make([]T, 0)
+	l.scan(prefix, func(_, value []byte) bool {
+		var v T
+		if err := json.Unmarshal(value, &v); err != nil {
+			l.fail(err)
+			return false
+		}
+		result = append(result, v)
+		return true
+	})
+	return result
+}
+
+func diskKey(prefix string, parts ...string) []byte {
+	return []byte(prefix + strings.Join(parts, "\x00"))
+}
+
+func balanceKey(currency, uid string) []byte {
+	return diskKey(prefixBalance, currency, uid)
+}
+
+func historyPrefix(currency, uid string) []byte {
+	return diskKey(prefixHistory, currency, uid, "")
+}
+
+func nodeKey(id uint64) []byte {
+	return binary.BigEndian.AppendUint64([]byte(prefixNode), id)
+}
+
+// indexKey 於 prefix 之後接上 big endian 編碼的 index，不修改 prefix
+func indexKey[P string | []byte](prefix P, index uint64) []byte {
+	return binary.BigEndian.AppendUint64([]byte(string(prefix)), index)
+}
+
+// markIndex 解析 t/<index> 的 index
+func markIndex(key []byte) uint64 {
+	return binary.BigEndian.Uint64(key[len(prefixMark):])
+}
+
+// prefixEnd 回傳大於所有以 prefix 開頭的 key 的最小 key，作為 iterator 的上限
+func prefixEnd(prefix []byte) []byte {
+	end := slices.Clone(prefix)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xff {
+			end[i]++
+			return end[:i+1]
+		}
+	}
+	return nil
+}
+
+func encodeFloat(v float64) []byte {
+	return binary.BigEndian.AppendUint64(nil, math.Float64bits(v))
+}
+
+func decodeFloat(raw []byte) float64 {
+	return math.Float64frombits(binary.BigEndian.Uint64(raw))
+}
+
+func encodeInt(v int64) []byte {
+	return binary.BigEndian.AppendUint64(nil, uint64(v))
+}
+
+func decodeInt(raw []byte) int64 {
+	return int64(binary.BigEndian.Uint64(raw))
+}
@@ -0,0 +1,424 @@
+package store
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"go-raft/internal/domain"
+
+	"github.com/cockroachdb/pebble"
+)
+
+// DiskStore 的 snapshot 格式：直接傳送 Pebble 中的所有資料，沿用 v3 的 block 結構
+//
+//	magic "GRKV"
+//	block*  blockRecords 的 payload 為 snappy 壓縮的 [uvarint keyLen][key][uvarint valueLen][value] 紀錄；
+//	        blockEnd 的 payload 為全部紀錄數（u64）
+const diskSnapshotMagic = "GRKV"
+
+// diskRestoreBatchSize 還原時單一 batch 累積到此大小（bytes）就寫入，避免整個 snapshot 留在記憶體
+const diskRestoreBatchSize = 4 << 20
+
+// WriteSnapshot 依 key 順序寫出 DiskLedger 的所有資料，應在 View 回傳的 DiskLedger 上呼叫
+func (l *DiskLedger) WriteSnapshot(w io.Writer, done <-chan struct{}) error {
+	if _, err := io.WriteString(w, diskSnapshotMagic); err != nil {
+		return err
+	}
+	vw := &v3Writer{w: w, raw: make([]byte, 0, v3BlockSize+64)}
+	it := l.iter(nil, nil)
+	defer it.Close()
+	for valid := it.First(); valid; valid = it.Next() {
+		if vw.count%4096 == 0 {
+			select {
+			case <-done:
+				return errors.New("snapshot save stopped")
+			default:
+			}
+		}
+		vw.raw = appendKV(vw.raw, it.Key(), it.Value())
+		vw.count++
+		if len(vw.raw) >= v3BlockSize {
+			if err := vw.flush(); err != nil {
+				return err
+			}
+		}
+	}
+	if err := it.Error(); err != nil {
+		return err
+	}
+	return vw.Close()
+}
+
+// Restore 以 snapshot 取代所有資料，接受 WriteSnapshot 寫出的格式，以及 v3 或沒有外部檔案的舊版 snapshot 主串流
+// 先清除套用進度與所有資料，最後才寫入 snapshot 的套用進度並 fsync；中途失敗時 Applied 為 0，重新還原即可
+// 還原期間的 View 會等待到還原結束，之前取得的 View 仍是還原前的內容
+func (d *DiskStore) Restore(r io.Reader, done <-chan struct{}) error {
+	d.restoring.Lock()
+	defer d.restoring.Unlock()
+	br := bufio.NewReader(r)
+	if magic, err := br.Peek(len(diskSnapshotMagic)); err == nil && string(magic) == diskSnapshotMagic {
+		br.Discard(len(magic))
+		return d.restoreKV(br, done)
+	}
+	content, err := DecodeSnapshot(br, nil, done)
+	if err != nil {
+		return err
+	}
+	return d.load(content)
+}
+
+// readDiskSnapshot 讀取 WriteSnapshot 寫出的紀錄並組回 SnapshotContent，開頭的 magic 需已讀取，不寫入任何資料庫
+func readDiskSnapshot(r *bufio.Reader, done <-chan struct{}) (*SnapshotContent, error) {
+	dc := newDiskContent()
+	var count uint64
+	for {
+		select {
+		case <-done:
+			return nil, errors.New("snapshot recover stopped")
+		default:
+		}
+		kind, raw, err := readBlock(r)
+		if err != nil {
+			return nil, err
+		}
+		switch kind {
+		case blockRecords:
+			for len(raw) > 0 {
+				var key, value []byte
+				if key, value, raw, err = nextKV(raw); err != nil {
+					return nil, err
+				}
+				count++
+				if err := dc.add(key, value); err != nil {
+					return nil, fmt.Errorf("%w: %v", ErrSnapshotCorrupted, err)
+				}
+			}
+		case blockEnd:
+			if len(raw) != 8 || binary.LittleEndian.Uint64(raw) != count {
+				return nil, fmt.Errorf("%w: record count mismatch, read %d", ErrSnapshotCorrupted, count)
+			}
+			if !dc.hasState {
+				return nil, fmt.Errorf("%w: missing applied state", ErrSnapshotCorrupted)
+			}
+			return dc.result(), nil
+		default:
+			return nil, fmt.Errorf("%w: unknown block kind %d", ErrSnapshotCorrupted, kind)
+		}
+	}
+}
+
+// diskContent 依 key 順序由 Pebble 的紀錄組回 SnapshotContent，Capture 與 GRKV snapshot 的解碼共用
+type diskContent struct {
+	content  *SnapshotContent
+	history  *HistoryData
+	state    diskState
+	hasState bool
+}
+
+func newDiskContent() *diskContent {
+	return &diskContent{
+		content: &SnapshotContent{
+			Meta: &StoreMeta{
+				Currencies: []domain.Currency{},
+				Accounts:   []domain.Account{},
+				Limits:     &LimitsData{Rules: []domain.LimitRule{}, Windows: make(map[string]map[string][]Withdrawal)},
+				Nodes:      []domain.NodeSupport{},
+			},
+			Balances: make(map[string]map[string]float64),
+		},
+		history: &HistoryData{Marks: []Mark{}, Versions: make(map[string]map[string][]Version)},
+	}
+}
+
+// add 解碼一筆紀錄，key 與 value 之後可被覆寫
+func (dc *diskContent) add(key, value []byte) error {
+	m := dc.content.Meta
+	k := string(key)
+	switch {
+	case k == keyState:
+		dc.hasState = true
+		return json.Unmarshal(value, &dc.state)
+	case k == keySettings:
+		m.Settings = &domain.Settings{}
+		return json.Unmarshal(value, m.Settings)
+	case k == keyChecksums:
+		return json.Unmarshal(value, &m.Checksums)
+	case strings.HasPrefix(k, prefixAccount):
+		return appendJSON(&m.Accounts, value)
+	case strings.HasPrefix(k, prefixCurrency):
+		return appendJSON(&m.Currencies, value)
+	case strings.HasPrefix(k, prefixNode):
+		return appendJSON(&m.Nodes, value)
+	case strings.HasPrefix(k, prefixRule):
+		return appendJSON(&m.Limits.Rules, value)
+	case strings.HasPrefix(k, prefixBalance), strings.HasPrefix(k, prefixWindow):
+		currency, uid, ok := strings.Cut(k[2:], "\x00")
+		if !ok {
+			return fmt.Errorf("invalid key %q", key)
+		}
+		if k[:2] == prefixWindow {
+			var ws []Withdrawal
+			if err := json.Unmarshal(value, &ws); err != nil {
+				return err
+			}
+			setNested(m.Limits.Windows, currency, uid, ws)
+			return nil
+		}
+		if len(value) != 8 {
+			return fmt.Errorf("invalid balance %q", key)
+		}
+		setNested(dc.content.Balances, currency, uid, decodeFloat(value))
+	case strings.HasPrefix(k, prefixHistory):
+		currency, rest, ok := strings.Cut(k[len(prefixHistory):], "\x00")
+		if !ok || len(rest) < 9 || len(value) != 8 {
+			return fmt.Errorf("invalid history %q", key)
+		}
+		uid, index := rest[:len(rest)-9], binary.BigEndian.Uint64([]byte(rest[len(rest)-8:]))
+		byUID := dc.history.Versions[currency]
+		if byUID == nil {
+			byUID = make(map[string][]Version)
+			dc.history.Versions[currency] = byUID
+		}
+		byUID[uid] = append(byUID[uid], Version{Index: index, Balance: decodeFloat(value)})
+	case strings.HasPrefix(k, prefixMark):
+		if len(key) != len(prefixMark)+8 || len(value) != 8 {
+			return fmt.Errorf("invalid mark %q", key)
+		}
+		dc.history.Marks = append(dc.history.Marks, Mark{Index: markIndex(key), Timestamp: decodeInt(value)})
+	}
+	return nil
+}
+
+// result 回傳組回的內容，沒有叢集設定時為預設值
+func (dc *diskContent) result() *SnapshotContent {
+	m := dc.content.Meta
+	if m.Settings == nil {
+		settings := defaultSettings()
+		m.Settings = &settings
+	}
+	dc.history.Applied, dc.history.Now, dc.history.Leader, dc.history.Floor = dc.state.Applied, dc.state.Now, dc.state.Leader, dc.state.Floor
+	m.History = dc.history
+	return dc.content
+}
+
+func appendJSON[T any](dst *[]T, raw []byte) error {
+	var v T
+	if err := json.Unmarshal(raw, &v); err != nil {
+		return err
+	}
+	*dst = append(*dst, v)
+	return nil
+}
+
+func setNested[V any](m map[string]map[string]V, outer, inner string, v V) {
+	if m[outer] == nil {
+		m[outer] = make(map[string]V)
+	}
+	m[outer][inner] = v
+}
+
+// restoreKV 讀取 WriteSnapshot 寫出的紀錄，套用進度保留到所有紀錄與筆數都驗證後才寫入
+func (d *DiskStore) restoreKV(r *bufio.Reader, done <-chan struct{}) error {
+	rw, err := d.newRestoreWriter()
+	if err != nil {
+		return err
+	}
+	defer rw.close()
+	var state []byte
+	var count uint64
+	for {
+		select {
+		case <-done:
+			return errors.New("snapshot recover stopped")
+		default:
+		}
+		kind, raw, err := readBlock(r)
+		if err != nil {
+			return err
+		}
+		switch kind {
+		case blockRecords:
+			for len(raw) > 0 {
+				var key, value []byte
+				if key, value, raw, err = nextKV(raw); err != nil {
+					return err
+				}
+				count++
+				if string(key) == keyState {
+					state = value
+					continue
+				}
+				rw.set(key, value)
+			}
+		case blockEnd:
+			if len(raw) != 8 || binary.LittleEndian.Uint64(raw) != count {
+				return fmt.Errorf("%w: record count mismatch, read %d", ErrSnapshotCorrupted, count)
+			}
+			if state == nil {
+				return fmt.Errorf("%w: missing applied state", ErrSnapshotCorrupted)
+			}
+			return rw.finish(state)
+		default:
+			return fmt.Errorf("%w: unknown block kind %d", ErrSnapshotCorrupted, kind)
+		}
+	}
+}
+
+// load 將解碼後的 snapshot 內容寫入 Pebble，還原規則與 CurrencyStore.RecoverFromSnapshot 相同
+func (d *DiskStore) load(content *SnapshotContent) error {
+	rw, err := d.newRestoreWriter()
+	if err != nil {
+		return err
+	}
+	defer rw.close()
+	m := content.Meta
+	for currency, byUID := range content.Balances {
+		for uid, balance := range byUID {
+			rw.set(balanceKey(currency, uid), encodeFloat(balance))
+			rw.set(diskKey(prefixHolding, uid, currency), nil)
+		}
+	}
+
+	var state diskState
+	if h := m.History; h != nil {
+		state = diskState{Applied: h.Applied, Now: h.Now, Leader: h.Leader, Floor: h.Floor}
+		state.advance(h.Applied, d.retention)
+		for _, mark := range h.Marks {
+			rw.set(indexKey(prefixMark, mark.Index), encodeInt(mark.Timestamp))
+		}
+		for currency, byUID := range h.Versions {
+			for uid, versions := range byUID {
+				for _, v := range versions {
+					rw.set(indexKey(historyPrefix(currency, uid), v.Index), encodeFloat(v.Balance))
+				}
+			}
+		}
+	} else {
+		// 舊版 snapshot 沒有歷史資料時，與 History.Seed 相同以目前餘額作為可查詢的最早版本
+		state.Seeded = true
+		for currency, byUID := range content.Balances {
+			for uid, balance := range byUID {
+				rw.set(indexKey(historyPrefix(currency, uid), 0), encodeFloat(balance))
+			}
+		}
+	}
+
+	for _, c := range m.Currencies {
+		rw.setJSON(diskKey(prefixCurrency, c.Code), c)
+	}
+	for _, acc := range m.Accounts {
+		rw.setJSON(diskKey(prefixAccount, acc.UID), acc)
+	}
+	if m.Limits != nil {
+		for _, rule := range m.Limits.Rules {
+			rw.setJSON(diskKey(prefixRule, ruleKey(rule.Scope, rule.Currency, rule.Tier, rule.UID)), rule)
+		}
+		for currency, byUID := range m.Limits.Windows {
+			for uid, ws := range byUID {
+				if len(ws) > 0 {
+					rw.setJSON(diskKey(prefixWindow, currency, uid), ws)
+				}
+			}
+		}
+	}
+	rw.setJSON([]byte(keySettings), content.settings())
+	for _, ns := range m.Nodes {
+		rw.setJSON(nodeKey(ns.NodeID), ns)
+	}
+	if len(m.Checksums) > 0 {
+		rw.setJSON([]byte(keyChecksums), m.Checksums)
+	}
+
+	raw, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	return rw.finish(raw)
+}
+
+// restoreWriter 以多個 batch 寫入還原的資料，第一個錯誤之後的寫入都會略過
+type restoreWriter struct {
+	db    *pebble.DB
+	batch *pebble.Batch
+	err   error
+}
+
+// newRestoreWriter 先清除所有資料（包含套用進度）並 fsync，之後寫入的資料在 finish 前都不會被視為已套用
+func (d *DiskStore) newRestoreWriter() (*restoreWriter, error) {
+	b := d.db.NewBatch()
+	if err := b.DeleteRange([]byte{0x00}, []byte{0xff}, nil); err != nil {
+		b.Close()
+		return nil, err
+	}
+	if err := b.Commit(pebble.Sync); err != nil {
+		b.Close()
+		return nil, err
+	}
+	b.Close()
+	return &restoreWriter{db: d.db, batch: d.db.NewBatch()}, nil
+}
+
+func (rw *restoreWriter) set(key, value []byte) {
+	if rw.err != nil {
+		return
+	}
+	if rw.err = rw.batch.Set(key, value, nil); rw.err != nil {
+		return
+	}
+	if rw.batch.Len() >= diskRestoreBatchSize {
+		rw.err = rw.batch.Commit(pebble.NoSync)
+		rw.batch.Reset()
+	}
+}
+
+func (rw *restoreWriter) setJSON(key []byte, v any) {
+	raw, err := json.Marshal(v)
+	if err != nil && rw.err == nil {
+		rw.err = fmt.Errorf("encode %q: %w", key, err)
+	}
+	rw.set(key, raw)
+}
+
+// finish 寫入剩餘資料後才寫入套用進度並 fsync
+func (rw *restoreWriter) finish(state []byte) error {
+	if rw.err != nil {
+		return rw.err
+	}
+	if err := rw.batch.Commit(pebble.NoSync); err != nil {
+		return err
+	}
+	rw.batch.Reset()
+	if err := rw.batch.Set([]byte(keyState), state, nil); err != nil {
+		return err
+	}
+	return rw.batch.Commit(pebble.Sync)
+}
+
+func (rw *restoreWriter) close() {
+	rw.batch.Close()
+}
+
+func appendKV(dst, key, value []byte) []byte {
+	dst = binary.AppendUvarint(dst, uint64(len(key)))
+	dst = append(dst, key...)
+	dst = binary.AppendUvarint(dst, uint64(len(value)))
+	return append(dst, value...)
+}
+
+// nextKV 解析 raw 開頭的一筆紀錄，回傳剩餘的 raw
+func nextKV(raw []byte) (key, value, rest []byte, err error) {
+	var fields [2][]byte
+	for i := range fields {
+		l, size := binary.Uvarint(raw)
+		if size <= 0 || uint64(len(raw)-size) < l {
+			return nil, nil, nil, fmt.Errorf("%w: truncated record", ErrSnapshotCorrupted)
+		}
+		fields[i], raw = raw[size:size+int(l)], raw[size+int(l):]
+	}
+	return fields[0], fields[1], raw, nil
+}
@@ -0,0 +1,286 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+
+	"go-raft/pkg/maps"
+)
+
+var (
+	// ErrHistoryPruned 查詢的 index 早於保留範圍，歷史版本已被清除
+	ErrHistoryPruned = errors.New("balance history pruned")
+	// ErrIndexNotApplied 查詢的 index 尚未被本節點套用
+	ErrIndexNotApplied = errors.New("index not applied yet")
+)
+
+// Version 為某個 raft index 套用後的餘額
+type Version struct {
+	Index   uint64
+	Balance float64
+}
+
+// Mark 為 raft index 與其提交時間的對應，用於以時間查詢歷史
+type Mark struct {
+	Index     uint64
+	Timestamp int64
+}
+
+// History 以 raft index 為版本號，保存每個 (currency, uid) 的餘額版本（MVCC）
+// 版本與時間對應只會附加或以重新切片的方式清除，不會原地修改，freeze 取得的檢視可與之後的寫入並行讀取
+type History struct {
+	mu        sync.RWMutex
+	retention uint64                             // 保留最近多少個 index 的版本，0 代表不清除
+	applied   uint64                             // 最後套用的 raft index
+	now       int64                              // 最後套用 entry 的提交時間（Unix 奈秒）
+	leader    int64                              // leader 提案時間的最大值，不含非 leader 提案推進的提交時間
+	marks     []Mark                             // 保留範圍內每個 index 的提交時間，依 index 遞增
+	floor     uint64                             // 可查詢的最小 index，早於此值的版本已被清除
+	seeded    bool                               // 由無歷史的舊版 snapshot 建立，floor 待下一個 index 決定
+	versions  map[string]*maps.COWMap[[]Version] // currency -> uid -> 依 index 遞增的版本
+}
+
+// HistoryData 為 History 在 snapshot 中的序列化格式
+type HistoryData struct {
+	Applied  uint64
+	Now      int64
+	Leader   int64
+	Floor    uint64
+	Marks    []Mark
+	Versions map[string]map[string][]Version
+}
+
+func NewHistory(retention uint64) *History {
+	return &History{
+		retention: retention,
+		versions:  make(map[string]*maps.COWMap[[]Version]),
+	}
+}
+
+// Record 紀錄 index 套用後 (currency, uid) 的新餘額，並清除該 key 超出保留範圍的版本
+func (h *History) Record(index uint64, currency, uid string, balance float64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	byUID, ok := h.versions[currency]
+	if !ok {
+		byUID = maps.NewCOWMap[[]Version]()
+		h.versions[currency] = byUID
+	}
+	versions, _ := byUID.Get(uid)
+	byUID.Set(uid, prune(append(versions, Version{Index: index, Balance: balance}), h.floor))
+}
+
+// Advance 推進最後套用的 raft index 並紀錄其提交時間（包含未改變餘額的 entry）
+// 提案時間早於上一個 entry 時沿用上一個時間，確保時間隨 index 單調遞增，回傳實際採用的時間
+// leader 為 true 時採用的時間同時成為之後非 leader 提案時間上限的基準
+func (h *History) Advance(index uint64, proposed int64, leader bool) int64 {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if index <= h.applied && !h.seeded {
+		return h.now
+	}
+	if proposed > h.now {
+		h.now = proposed
+	}
+	if leader && proposed > h.leader {
+		h.leader = proposed
+	}
+	h.advance(index)
+	h.marks = append(h.marks, Mark{Index: index, Timestamp: h.now})
+	return h.now
+}
+
+// Applied 回傳最後套用的 raft index
+func (h *History) Applied() uint64 {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return h.applied
+}
+
+// Now 回傳最後套用 entry 的提交時間
+func (h *History) Now() int64 {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return h.now
+}
+
+// LeaderNow 回傳 leader 提案採用過的最大時間，非 leader 提案推進的提交時間不計入，尚未套用過時為 0
+func (h *History) LeaderNow() int64 {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return h.leader
+}
+
+// IndexAt 回傳在提交時間 ts（含）之前最後套用的 raft index
+func (h *History) IndexAt(ts int64) (uint64, error) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	if len(h.marks) == 0 || ts < h.marks[0].Timestamp {
+		return 0, fmt.Errorf("%w: requested time %d is before retained history", ErrHistoryPruned, ts)
+	}
+	i := sort.Search(len(h.marks), func(i int) bool { return h.marks[i].Timestamp > ts })
+	return h.marks[i-1].Index, nil
+}
+
+// BalanceAt 回傳 (currency, uid) 在 index 套用後的餘額
+func (h *History) BalanceAt(currency, uid string, index uint64) (float64, error) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	if index > h.applied {
+		return 0, fmt.Errorf("%w: requested %d, applied %d", ErrIndexNotApplied, index, h.applied)
+	}
+	if index < h.floor {
+		return 0, fmt.Errorf("%w: requested %d, oldest available %d", ErrHistoryPruned, index, h.floor)
+	}
+	var versions []Version
+	if byUID, ok := h.versions[currency]; ok {
+		versions, _ = byUID.Get(uid)
+	}
+	i := sort.Search(len(versions), func(i int) bool { return versions[i].Index > index })
+	if i == 0 {
+		return 0, nil
+	}
+	return versions[i-1].Balance, nil
+}
+
+// Prune 清除所有 key 超出保留範圍的版本，只改寫有版本被清除的 key
+func (h *History) Prune() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, byUID := range h.versions {
+		var pruned map[string][]Version
+		byUID.Range(func(uid string, versions []Version) bool {
+			if p := prune(versions, h.floor); len(p) != len(versions) {
+				if pruned == nil {
+					pruned = make(map[string][]Version)
+				}
+				pruned[uid] = p
+			}
+			return true
+		})
+		for uid, versions := range pruned {
+			byUID.Set(uid, versions)
+		}
+	}
+	h.pruneMarks()
+}
+
+// historyView 為 History 在 freeze 當下的不可變檢視
+type historyView struct {
+	applied  uint64
+	now      int64
+	leader   int64
+	floor    uint64
+	marks    []Mark
+	versions map[string]*maps.FrozenMap[[]Version]
+}
+
+// freeze 回傳目前歷史的不可變檢視，只複製各幣別的根節點參照
+func (h *History) freeze() *historyView {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	v := &historyView{
+		applied:  h.applied,
+		now:      h.now,
+		leader:   h.leader,
+		floor:    h.floor,
+		marks:    h.marks[:len(h.marks):len(h.marks)],
+		versions: make(map[string]*maps.FrozenMap[[]Version], len(h.versions)),
+	}
+	for currency, byUID := range h.versions {
+		v.versions[currency] = byUID.Freeze()
+	}
+	return v
+}
+
+// Data 回傳可序列化的歷史資料副本
+func (h *History) Data() *HistoryData {
+	return h.freeze().data()
+}
+
+// data 回傳可序列化的歷史資料副本，超出保留範圍的版本不會寫出
+func (v *historyView) data() *HistoryData {
+	cp := make(map[string]map[string][]Version, len(v.versions))
+	for currency, byUID := range v.versions {
+		m := make(map[string][]Version, byUID.Len())
+		byUID.Range(func(uid string, versions []Version) bool {
+			m[uid] = append([]Version(nil), prune(versions, v.floor)...)
+			return true
+		})
+		cp[currency] = m
+	}
+	i := sort.Search(len(v.marks), func(i int) bool { return v.marks[i].Index >= v.floor })
+	return &HistoryData{
+		Applied:  v.applied,
+		Now:      v.now,
+		Leader:   v.leader,
+		Floor:    v.floor,
+		Marks:    append([]Mark(nil), v.marks[i:]...),
+		Versions: cp,
+	}
+}
+
+// LoadData 以 snapshot 中的歷史資料取代目前內容
+func (h *History) LoadData(data *HistoryData) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.applied, h.now, h.leader, h.floor, h.seeded = data.Applied, data.Now, data.Leader, data.Floor, false
+	h.marks = data.Marks
+	h.versions = make(map[string]*maps.COWMap[[]Version], len(data.Versions))
+	for currency, byUID := range data.Versions {
+		h.versions[currency] = maps.COWMapOf(byUID)
+	}
+	h.advance(data.Applied)
+}
+
+// Seed 以舊版 snapshot 還原出的餘額建立第一個版本
+// 由於無法得知 snapshot 的 index，floor 會在套用下一個 index 時設為其前一個 index
+func (h *History) Seed(balances map[string]map[string]float64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.versions = make(map[string]*maps.COWMap[[]Version], len(balances))
+	for currency, byUID := range balances {
+		m := maps.NewCOWMap[[]Version]()
+		for uid, balance := range byUID {
+			m.Set(uid, []Version{{Index: 0, Balance: balance}})
+		}
+		h.versions[currency] = m
+	}
+	h.applied, h.floor, h.marks, h.seeded = 0, 0, nil, true
+}
+
+// advance 推進 applied 與 floor，呼叫端需持有寫鎖
+func (h *History) advance(index uint64) {
+	if h.seeded && index > 0 {
+		h.applied, h.floor, h.seeded = index-1, index-1, false
+	}
+	if index > h.applied {
+		h.applied = index
+	}
+	if h.retention > 0 && h.applied > h.retention && h.applied-h.retention > h.floor {
+		h.floor = h.applied - h.retention
+		// marks 每個 index 一筆，累積到保留範圍兩倍時才整理以攤銷成本
+		if uint64(len(h.marks)) > 2*h.retention {
+			h.pruneMarks()
+		}
+	}
+}
+
+// pruneMarks 移除早於 floor 的時間對應，呼叫端需持有寫鎖
+// 以重新切片移除，freeze 取得的 marks 不受影響，舊的陣列在下次附加擴容時釋放
+func (h *History) pruneMarks() {
+	i := sort.Search(len(h.marks), func(i int) bool { return h.marks[i].Index >= h.floor })
+	h.marks = h.marks[i:]
+}
+
+// prune 移除早於 floor 的版本，但保留 floor 當下仍有效的最後一個版本
+// 回傳 versions 的子切片而不原地搬移，與 freeze 取得的檢視共用的陣列不會被改寫
+func prune(versions []Version, floor uint64) []Version {
+	i := sort.Search(len(versions), func(i int) bool { return versions[i].Index > floor })
+	if i <= 1 {
+		return versions
+	}
+	return versions[i-1:]
+}
@@ -0,0 +1,263 @@
+package store_test
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"testing"
+
+	"go-raft/internal/store"
+)
+
+// applyHistory 套用 index 1..n，每個 index 的提交時間為 index*1000，alice 每個 index 存入 1，bob 只在 index 2 存入 50
+func applyHistory(l store.Ledger, from, n uint64) {
+	for i := from; i <= n; i++ {
+		l.Advance(i, int64(i)*1000, true)
+		l.UpdateAt(i, "alice", "USD", 1)
+		if i == 2 {
+			l.UpdateAt(i, "bob", "USD", 50)
+		}
+	}
+}
+
+// expectBalanceAt 檢查 (uid, USD) 在 index 套用後的餘額
+func expectBalanceAt(t *testing.T, l store.Ledger, uid string, index uint64, want float64) {
+	t.Helper()
+	got, err := l.GetAt(uid, "USD", index)
+	if err != nil || got != want {
+		t.Fatalf("%s at index %d: got %v, %v, want %v", uid, index, got, err, want)
+	}
+}
+
+func TestHistoryBalanceAtIndex(t *testing.T) {
+	eachLedger(t, func(t *testing.T, l store.Ledger) {
+		applyHistory(l, 1, 5)
+		// 不改變餘額的 entry 也推進 index
+		l.Advance(6, 6000, true)
+
+		for index := uint64(1); index <= 5; index++ {
+			expectBalanceAt(t, l, "alice", index, float64(index))
+		}
+		expectBalanceAt(t, l, "alice", 6, 5)
+		expectBalanceAt(t, l, "bob", 1, 0)
+		expectBalanceAt(t, l, "bob", 6, 50)
+		expectBalanceAt(t, l, "carol", 3, 0)
+		if _, err := l.GetAt("alice", "USD", 7); !errors.Is(err, store.ErrIndexNotApplied) {
+			t.Fatalf("expected ErrIndexNotApplied, got %v", err)
+		}
+	})
+}
+
+func TestHistoryBalanceAsOf(t *testing.T) {
+	eachLedger(t, func(t *testing.T, l store.Ledger) {
+		applyHistory(l, 1, 5)
+
+		cases := []struct {
+			ts   int64
+			want float64
+		}{
+			{1000, 1},
+			{3000, 3},
+			{3999, 3},
+			{5000, 5},
+			{1 << 62, 5},
+		}
+		for _, c := range cases {
+			got, err := l.GetAsOf("alice", "USD", c.ts)
+			if err != nil || got != c.want {
+				t.Fatalf("as of %d: got %v, %v, want %v", c.ts, got, err, c.want)
+			}
+		}
+		if _, err := l.GetAsOf("alice", "USD", 999); !errors.Is(err, store.ErrHistoryPruned) {
+			t.Fatalf("expected ErrHistoryPruned before the first entry, got %v", err)
+		}
+	})
+}
+
+// TestHistoryPrunedAfterRetention 超出保留範圍的 index 與時間回傳 ErrHistoryPruned，floor 當下仍有效的版本保留
+func TestHistoryPrunedAfterRetention(t *testing.T) {
+	eachLedgerRetention(t, 3, func(t *testing.T, l store.Ledger) {
+		applyHistory(l, 1, 10)
+
+		for _, index := range []uint64{1, 6} {
+			if _, err := l.GetAt("alice", "USD", index); !errors.Is(err, store.ErrHistoryPruned) {
+				t.Fatalf("index %d: expected ErrHistoryPruned, got %v", index, err)
+			}
+		}
+		if _, err := l.GetAsOf("alice", "USD", 2000); !errors.Is(err, store.ErrHistoryPruned) {
+			t.Fatalf("expected ErrHistoryPruned for pruned time, got %v", err)
+		}
+		for index := uint64(7); index <= 10; index++ {
+			expectBalanceAt(t, l, "alice", index, float64(index))
+		}
+		// bob 最後一次寫入早於 floor，仍可查到 floor 之後的餘額
+		expectBalanceAt(t, l, "bob", 7, 50)
+		if got, err := l.GetAsOf("alice", "USD", 8500); err != nil || got != 8 {
+			t.Fatalf("as of 8500: got %v, %v, want 8", got, err)
+		}
+	})
+}
+
+// TestHistorySurvivesSnapshot 歷史版本與時間對應經 snapshot 還原後仍可查詢
+func TestHistorySurvivesSnapshot(t *testing.T) {
+	for _, version := range []uint64{3, 4} {
+		t.Run(fmt.Sprintf("v%d", version), func(t *testing.T) {
+			src := store.NewCurrencyStore(1, 1, t.TempDir(), 0)
+			applyHistory(src, 1, 5)
+			var buf bytes.Buffer
+			fss := &fileCollection{}
+			if err := src.SaveSnapshot(version, &buf, fss, nil); err != nil {
+				t.Fatalf("save: %v", err)
+			}
+
+			dst := store.NewCurrencyStore(1, 2, t.TempDir(), 0)
+			if err := dst.RecoverFromSnapshot(bytes.NewReader(buf.Bytes()), fss.files, nil); err != nil {
+				t.Fatalf("recover: %v", err)
+			}
+			assertRestoredHistory(t, dst)
+			// Pebble 還原時不會收到外部檔案，只支援單一串流的格式
+			if version != 3 {
+				return
+			}
+
+			ds, err := store.OpenDiskStore(t.TempDir(), 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer ds.Close()
+			if err := ds.Restore(bytes.NewReader(buf.Bytes()), nil); err != nil {
+				t.Fatalf("restore disk: %v", err)
+			}
+			view, err := ds.View()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer view.Close()
+			assertRestoredHistory(t, view)
+		})
+	}
+}
+
+// TestHistorySurvivesRestart Pebble 上的歷史在重新開啟資料庫與 OnDisk snapshot 還原後仍可查詢並繼續累積
+func TestHistorySurvivesRestart(t *testing.T) {
+	dir := t.TempDir()
+	ds, err := store.OpenDiskStore(dir, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	commit(t, ds, func(l *store.DiskLedger) { applyHistory(l, 1, 5) })
+	if err := ds.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	ds, err = store.OpenDiskStore(dir, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ds.Close()
+	view, err := ds.View()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertRestoredHistory(t, view)
+	var buf bytes.Buffer
+	err = view.WriteSnapshot(&buf, nil)
+	view.Close()
+	if err != nil {
+		t.Fatalf("write snapshot: %v", err)
+	}
+
+	commit(t, ds, func(l *store.DiskLedger) { applyHistory(l, 6, 7) })
+	view, err = ds.View()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectBalanceAt(t, view, "alice", 3, 3)
+	expectBalanceAt(t, view, "alice", 7, 7)
+	view.Close()
+
+	restored, err := store.OpenDiskStore(t.TempDir(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer restored.Close()
+	if err := restored.Restore(bytes.NewReader(buf.Bytes()), nil); err != nil {
+		t.Fatalf("restore: %v", err)
+	}
+	view, err = restored.View()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer view.Close()
+	assertRestoredHistory(t, view)
+}
+
+// assertRestoredHistory 檢查 applyHistory(l, 1, 5) 寫入的歷史
+func assertRestoredHistory(t *testing.T, l store.Ledger) {
+	t.Helper()
+	if l.Applied() != 5 || l.Now() != 5000 || l.LeaderNow() != 5000 {
+		t.Fatalf("applied %d at %d, leader %d, want 5 at 5000", l.Applied(), l.Now(), l.LeaderNow())
+	}
+	for index := uint64(1); index <= 5; index++ {
+		expectBalanceAt(t, l, "alice", index, float64(index))
+	}
+	expectBalanceAt(t, l, "bob", 1, 0)
+	expectBalanceAt(t, l, "bob", 5, 50)
+	if got, err := l.GetAsOf("alice", "USD", 3500); err != nil || got != 3 {
+		t.Fatalf("as of 3500: got %v, %v, want 3", got, err)
+	}
+}
+
+// commit 以新的 batch 執行 fn 後寫入 Pebble
+func commit(t *testing.T, ds *store.DiskStore, fn func(l *store.DiskLedger)) {
+	t.Helper()
+	l, err := ds.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	fn(l)
+	if err := l.Commit(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestAdvanceClampsTime 提案時間早於上一個 entry 時沿用上一個時間，已套用的 index 不改變時間，只有 leader 提案更新 leader 時間
+func TestAdvanceClampsTime(t *testing.T) {
+	eachLedger(t, func(t *testing.T, l store.Ledger) {
+		steps := []struct {
+			index    uint64
+			proposed int64
+			want     int64
+		}{
+			{1, 1000, 1000},
+			{2, 500, 1000},
+			{3, 0, 1000},
+			{4, 3000, 3000},
+			{5, 2999, 3000},
+			// 重送已套用的 index 不推進時間
+			{4, 9000, 3000},
+			{6, 4000, 4000},
+		}
+		for _, s := range steps {
+			if got := l.Advance(s.index, s.proposed, true); got != s.want {
+				t.Fatalf("advance %d at %d: got %d, want %d", s.index, s.proposed, got, s.want)
+			}
+		}
+		if l.Applied() != 6 || l.Now() != 4000 {
+			t.Fatalf("applied %d at %d, want 6 at 4000", l.Applied(), l.Now())
+		}
+
+		// 非 leader 提案推進提交時間但不改變 leader 時間
+		if got := l.Advance(7, 4500, false); got != 4500 || l.LeaderNow() != 4000 {
+			t.Fatalf("follower advance: now %d, leader %d, want 4500, 4000", got, l.LeaderNow())
+		}
+		// leader 時間只取 leader 提案的時間，不沿用非 leader 推進的提交時間，否則每次非 leader 提案後 leader 時間都會再超前一次
+		if got := l.Advance(8, 4200, true); got != 4500 || l.LeaderNow() != 4200 {
+			t.Fatalf("leader advance: now %d, leader %d, want 4500, 4200", got, l.LeaderNow())
+		}
+		if got := l.Advance(9, 4100, true); got != 4500 || l.LeaderNow() != 4200 {
+			t.Fatalf("leader clock behind: now %d, leader %d, want 4500, 4200", got, l.LeaderNow())
+		}
+	})
+}
@@ -0,0 +1,87 @@
+package store
+
+import (
+	"strings"
+
+	"go-raft/internal/domain"
+)
+
+// Ledger 為狀態機套用命令與查詢時使用的帳本操作，記憶體中的 CurrencyStore 與 Pebble 上的 DiskLedger 都實作此介面
+// 所有業務規則都在本套件的共用函式中，兩種實作對相同的 entry 序列必須產生相同的結果
+type Ledger interface {
+	Advance(index uint64, proposed int64, leader bool) int64
+	Applied() uint64
+	Now() int64
+	LeaderNow() int64
+
+	Settings() domain.Settings
+	ReportSupport(index uint64, ns domain.NodeSupport)
+	RegisterMember(r domain.MemberRegistration)
+	Activate(a domain.Activation) error
+	UpgradeState() domain.UpgradeState
+
+	OpenChecksum(index uint64)
+	ReportChecksum(r domain.ChecksumReport) error
+	Checksums() []domain.ChecksumRound
+
+	Registry() CurrencyTable
+	Accounts() AccountTable
+	Limits() LimitTable
+
+	UpdateAt(index uint64, uid, currency string, amount float64)
+	Get(uid, currency string) float64
+	GetAt(uid, currency string, index uint64) (float64, error)
+	GetAsOf(uid, currency string, ts int64) (float64, error)
+	Holdings(uid string) map[string]float64
+	RangeBalances(currency string, fn func(uid string, balance float64) bool)
+	List() map[string]map[string]float64
+
+	Stats(currency string, topN int) domain.CurrencyStats
+	AllStats(topN int) []domain.CurrencyStats
+	VerifyStats(currency string, topN int) error
+
+	Capture() *SnapshotContent
+}
+
+// CurrencyTable 為幣別註冊表的操作
+type CurrencyTable interface {
+	Put(c domain.Currency)
+	Get(code string) (domain.Currency, bool)
+	List() []domain.Currency
+	Validate(code string, amount float64) error
+}
+
+// AccountTable 為帳戶表的操作
+type AccountTable interface {
+	Get(uid string) (domain.Account, bool)
+	Open(index uint64, now int64, uid, tier string, metadata map[string]string) error
+	SetTier(index uint64, uid, tier string) error
+	Writable(uid string) (domain.Account, error)
+	Ensure(index uint64, now int64, uid string)
+	Transition(index uint64, uid string, action domain.AccountAction, reason string) error
+	List() []domain.Account
+}
+
+// LimitTable 為限額政策與提領計數器的操作
+type LimitTable interface {
+	Set(rule domain.LimitRule)
+	Remove(rule domain.LimitRule)
+	Rules() []domain.LimitRule
+	Consume(now int64, uid, currency, tier string, amount float64) error
+	View(now int64, uid, currency, tier string) domain.LimitView
+}
+
+// ValidKey 回傳 uid、幣別等欄位是否都可寫入帳本；DiskStore 的 key 以 \x00 分隔欄位，含 \x00 的值無法正確解析
+func ValidKey(fields ...string) bool {
+	for _, f := range fields {
+		if strings.ContainsRune(f, 0) {
+			return false
+		}
+	}
+	return true
+}
+
+var (
+	_ Ledger = (*CurrencyStore)(nil)
+	_ Ledger = (*DiskLedger)(nil)
+)
@@ -0,0 +1,265 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+
+	"go-raft/internal/domain"
+	"go-raft/pkg/maps"
+)
+
+// ErrLimitExceeded 超過單筆或滾動視窗提領上限
+var ErrLimitExceeded = errors.New("limit exceeded")
+
+// Withdrawal 為滾動視窗內的一筆提領
+type Withdrawal struct {
+	Timestamp int64 // 提交時間（Unix 奈秒）
+	Amount    float64
+}
+
+// LimitsData 為 Limits 在 snapshot 中的序列化格式
+type LimitsData struct {
+	Rules   []domain.LimitRule
+	Windows map[string]map[string][]Withdrawal // currency -> uid -> 依時間遞增的提領紀錄
+}
+
+// Limits 為複製於狀態機中的限額政策與提領計數器
+// 所有時間都來自 entry 的提交時間，確保各副本判斷一致
+type Limits struct {
+	mu      sync.RWMutex
+	rules   map[string]domain.LimitRule
+	windows map[string]*maps.COWMap[[]Withdrawal] // currency -> uid -> 依時間遞增的提領紀錄
+	swept   int64                                 // 最後一次清除過期提領紀錄的提交時間，只存在於本機
+}
+
+// limitsView 為 Limits 在 freeze 當下的不可變檢視
+type limitsView struct {
+	rules   []domain.LimitRule
+	windows map[string]*maps.FrozenMap[[]Withdrawal]
+}
+
+func NewLimits() *Limits {
+	return &Limits{
+		rules:   make(map[string]domain.LimitRule),
+		windows: make(map[string]*maps.COWMap[[]Withdrawal]),
+	}
+}
+
+// Set 新增或取代限額政策
+func (l *Limits) Set(rule domain.LimitRule) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.rules[ruleKey(rule.Scope, rule.Currency, rule.Tier, rule.UID)] = rule
+}
+
+// Remove 移除限額政策
+func (l *Limits) Remove(rule domain.LimitRule) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.rules, ruleKey(rule.Scope, rule.Currency, rule.Tier, rule.UID))
+}
+
+// Rules 回傳所有限額政策，依範圍鍵排序
+func (l *Limits) Rules() []domain.LimitRule {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.sortedRules()
+}
+
+// Consume 檢查 amount 是否符合生效政策，通過且政策設有每日上限時將提領計入滾動視窗，超過上限時不變更任何紀錄
+// 提領以負數金額表示，存入與沒有每日上限的提領只檢查單筆上限
+func (l *Limits) Consume(now int64, uid, currency, tier string, amount float64) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	rule, ok := l.effective(uid, currency, tier)
+	if !ok {
+		return nil
+	}
+	byUID := l.windows[currency]
+	var ws []Withdrawal
+	if byUID != nil {
+		ws, _ = byUID.Get(uid)
+	}
+	ws, err := consume(rule, ws, now, amount)
+	if err != nil || !recordsWindow(rule, amount) {
+		return err
+	}
+	switch {
+	case len(ws) == 0:
+		if byUID != nil {
+			byUID.Delete(uid)
+		}
+	case byUID == nil:
+		byUID = maps.NewCOWMap[[]Withdrawal]()
+		byUID.Set(uid, ws)
+		l.windows[currency] = byUID
+	default:
+		byUID.Set(uid, ws)
+	}
+	l.sweep(now)
+	return nil
+}
+
+// sweep 每經過一個視窗長度清除一次所有紀錄皆已過期的使用者，避免不再提領的使用者的計數器永久保留，呼叫端需持有鎖
+// 過期紀錄不影響限額判斷，checksum 也不計入，各副本清除的時間點不同不影響一致性
+func (l *Limits) sweep(now int64) {
+	if now-l.swept < int64(domain.LimitWindow) {
+		return
+	}
+	l.swept = now
+	for currency, byUID := range l.windows {
+		var expired []string
+		byUID.Range(func(uid string, ws []Withdrawal) bool {
+			if i, _ := window(ws, now); i == len(ws) {
+				expired = append(expired, uid)
+			}
+			return true
+		})
+		for _, uid := range expired {
+			byUID.Delete(uid)
+		}
+		if byUID.Len() == 0 {
+			delete(l.windows, currency)
+		}
+	}
+}
+
+// View 回傳使用者在某幣別生效的限額與 now 當下滾動視窗內的提領金額
+func (l *Limits) View(now int64, uid, currency, tier string) domain.LimitView {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	var ws []Withdrawal
+	if byUID, ok := l.windows[currency]; ok {
+		ws, _ = byUID.Get(uid)
+	}
+	_, withdrawn := window(ws, now)
+	view := domain.LimitView{UID: uid, Currency: currency, Withdrawn: withdrawn}
+	if rule, ok := l.effective(uid, currency, tier); ok {
+		view.Scope, view.Policy = rule.Scope, rule.Policy
+	}
+	return view
+}
+
+// Data 回傳可序列化的限額資料副本
+func (l *Limits) Data() *LimitsData {
+	return l.freeze().data()
+}
+
+// freeze 回傳限額資料的不可變檢視，政策數量很少直接複製，提領紀錄只複製根節點參照
+func (l *Limits) freeze() *limitsView {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	v := &limitsView{rules: l.sortedRules(), windows: make(map[string]*maps.FrozenMap[[]Withdrawal], len(l.windows))}
+	for currency, byUID := range l.windows {
+		v.windows[currency] = byUID.Freeze()
+	}
+	return v
+}
+
+// data 回傳可序列化的限額資料副本
+func (v *limitsView) data() *LimitsData {
+	windows := make(map[string]map[string][]Withdrawal, len(v.windows))
+	for currency, byUID := range v.windows {
+		m := make(map[string][]Withdrawal, byUID.Len())
+		byUID.Range(func(uid string, ws []Withdrawal) bool {
+			m[uid] = append([]Withdrawal(nil), ws...)
+			return true
+		})
+		windows[currency] = m
+	}
+	return &LimitsData{Rules: v.rules, Windows: windows}
+}
+
+// LoadData 以 snapshot 中的限額資料取代目前內容
+func (l *Limits) LoadData(data *LimitsData) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.rules = make(map[string]domain.LimitRule)
+	l.windows = make(map[string]*maps.COWMap[[]Withdrawal])
+	l.swept = 0
+	if data == nil {
+		return
+	}
+	for _, rule := range data.Rules {
+		l.rules[ruleKey(rule.Scope, rule.Currency, rule.Tier, rule.UID)] = rule
+	}
+	for currency, byUID := range data.Windows {
+		l.windows[currency] = maps.COWMapOf(byUID)
+	}
+}
+
+// effective 依 user > tier > currency 的優先順序找出生效政策，呼叫端需持有鎖
+func (l *Limits) effective(uid, currency, tier string) (domain.LimitRule, bool) {
+	return effectiveRule(func(key string) (domain.LimitRule, bool) {
+		rule, ok := l.rules[key]
+		return rule, ok
+	}, uid, currency, tier)
+}
+
+// effectiveRule 依 user > tier > currency 的優先順序以 get 查詢生效政策，get 的參數為 ruleKey
+func effectiveRule(get func(string) (domain.LimitRule, bool), uid, currency, tier string) (domain.LimitRule, bool) {
+	if rule, ok := get(ruleKey(domain.LimitScopeUser, currency, "", uid)); ok {
+		return rule, true
+	}
+	if tier != "" {
+		if rule, ok := get(ruleKey(domain.LimitScopeTier, currency, tier, "")); ok {
+			return rule, true
+		}
+	}
+	return get(ruleKey(domain.LimitScopeCurrency, currency, "", ""))
+}
+
+// recordsWindow 回傳 amount 是否需計入滾動視窗，只有提領且生效政策設有每日上限時才記錄
+func recordsWindow(rule domain.LimitRule, amount float64) bool {
+	return amount < 0 && rule.Policy.DailyWithdrawCap > 0
+}
+
+// consume 依生效政策檢查 amount，回傳更新後的滾動視窗紀錄與檢查結果
+// 需計入視窗時先清除視窗外的紀錄，超過上限時呼叫端不應寫回回傳的紀錄；不需計入時 ws 原樣回傳
+// 清除以重新切片完成，不會改寫 ws 中已有的紀錄，與 freeze 取得的檢視共用的陣列不受影響
+func consume(rule domain.LimitRule, ws []Withdrawal, now int64, amount float64) ([]Withdrawal, error) {
+	abs := math.Abs(amount)
+	if p := rule.Policy; p.MaxSingleAmount > 0 && abs > p.MaxSingleAmount {
+		return ws, fmt.Errorf("%w: %v above %s single amount limit %v", ErrLimitExceeded, amount, rule.Scope, p.MaxSingleAmount)
+	}
+	if !recordsWindow(rule, amount) {
+		return ws, nil
+	}
+	i, withdrawn := window(ws, now)
+	ws = ws[i:]
+	if p := rule.Policy; withdrawn+abs > p.DailyWithdrawCap {
+		return ws, fmt.Errorf("%w: withdrawn %v + %v above %s daily cap %v", ErrLimitExceeded, withdrawn, abs, rule.Scope, p.DailyWithdrawCap)
+	}
+	return append(ws, Withdrawal{Timestamp: now, Amount: abs}), nil
+}
+
+// window 回傳 now 當下滾動視窗內第一筆紀錄的位置與視窗內的提領總額
+func window(ws []Withdrawal, now int64) (int, float64) {
+	cutoff := now - int64(domain.LimitWindow)
+	i := sort.Search(len(ws), func(i int) bool { return ws[i].Timestamp > cutoff })
+	var total float64
+	for _, w := range ws[i:] {
+		total += w.Amount
+	}
+	return i, total
+}
+
+func (l *Limits) sortedRules() []domain.LimitRule {
+	keys := make([]string, 0, len(l.rules))
+	for k := range l.rules {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	result := make([]domain.LimitRule, 0, len(keys))
+	for _, k := range keys {
+		result = append(result, l.rules[k])
+	}
+	return result
+}
+
+func ruleKey(scope domain.LimitScope, currency, tier, uid string) string {
+	return fmt.Sprintf("%s\x00%s\x00%s\x00%s", scope, currency, tier, uid)
+}
@@ -0,0 +1,231 @@
+package store_test
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"go-raft/internal/domain"
+	"go-raft/internal/store"
+)
+
+// eachLedger 對記憶體與 Pebble 上的帳本各執行一次 fn，Pebble 帳本為未 Commit 的 batch
+func eachLedger(t *testing.T, fn func(t *testing.T, l store.Ledger)) {
+	eachLedgerRetention(t, 0, fn)
+}
+
+// eachLedgerRetention 與 eachLedger 相同，但帳本只保留最近 retention 個 index 的歷史
+func eachLedgerRetention(t *testing.T, retention uint64, fn func(t *testing.T, l store.Ledger)) {
+	t.Run("memory", func(t *testing.T) {
+		fn(t, store.NewCurrencyStore(1, 1, t.TempDir(), retention))
+	})
+	t.Run("disk", func(t *testing.T) {
+		ds, err := store.OpenDiskStore(t.TempDir(), retention)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer ds.Close()
+		l, err := ds.Begin()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer l.Close()
+		fn(t, l)
+		if err := l.Err(); err != nil {
+			t.Fatal(err)
+		}
+	})
+}
+
+const day = int64(domain.LimitWindow)
+
+func TestLimitSingleAmount(t *testing.T) {
+	eachLedger(t, func(t *testing.T, l store.Ledger) {
+		l.Limits().Set(domain.LimitRule{Scope: domain.LimitScopeCurrency, Currency: "USD", Policy: domain.LimitPolicy{MaxSingleAmount: 100}})
+		for _, amount := range []float64{100, -100} {
+			if err := l.Limits().Consume(1, "alice", "USD", "", amount); err != nil {
+				t.Fatalf("consume %v: %v", amount, err)
+			}
+		}
+		for _, amount := range []float64{100.01, -100.01} {
+			if err := l.Limits().Consume(2, "alice", "USD", "", amount); !errors.Is(err, store.ErrLimitExceeded) {
+				t.Fatalf("consume %v: expected ErrLimitExceeded, got %v", amount, err)
+			}
+		}
+		// 其他幣別沒有政策
+		if err := l.Limits().Consume(3, "alice", "EUR", "", -1e9); err != nil {
+			t.Fatalf("consume without rule: %v", err)
+		}
+	})
+}
+
+func TestLimitDailyCapRollingWindow(t *testing.T) {
+	eachLedger(t, func(t *testing.T, l store.Ledger) {
+		l.Limits().Set(domain.LimitRule{Scope: domain.LimitScopeCurrency, Currency: "USD", Policy: domain.LimitPolicy{DailyWithdrawCap: 100}})
+		start := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC).UnixNano()
+		if err := l.Limits().Consume(start, "alice", "USD", "", -60); err != nil {
+			t.Fatal(err)
+		}
+		if err := l.Limits().Consume(start+int64(time.Hour), "alice", "USD", "", -40); err != nil {
+			t.Fatal(err)
+		}
+		// 存入不計入累計
+		if err := l.Limits().Consume(start+int64(2*time.Hour), "alice", "USD", "", 500); err != nil {
+			t.Fatal(err)
+		}
+		if err := l.Limits().Consume(start+int64(2*time.Hour), "alice", "USD", "", -0.01); !errors.Is(err, store.ErrLimitExceeded) {
+			t.Fatalf("expected ErrLimitExceeded at cap, got %v", err)
+		}
+		// 視窗邊界上第一筆仍算在視窗外：恰好一個視窗長度後第一筆失效
+		if err := l.Limits().Consume(start+day-1, "alice", "USD", "", -1); !errors.Is(err, store.ErrLimitExceeded) {
+			t.Fatalf("expected ErrLimitExceeded before first withdrawal expires, got %v", err)
+		}
+		if err := l.Limits().Consume(start+day, "alice", "USD", "", -60); err != nil {
+			t.Fatalf("first withdrawal should have left the window: %v", err)
+		}
+		if got := l.Limits().View(start+day, "alice", "USD", "").Withdrawn; got != 100 {
+			t.Fatalf("withdrawn %v, want 100", got)
+		}
+		// 其他使用者的計數器獨立
+		if err := l.Limits().Consume(start+day, "bob", "USD", "", -100); err != nil {
+			t.Fatal(err)
+		}
+	})
+}
+
+func TestLimitPrecedence(t *testing.T) {
+	eachLedger(t, func(t *testing.T, l store.Ledger) {
+		rules := []domain.LimitRule{
+			{Scope: domain.LimitScopeCurrency, Currency: "USD", Policy: domain.LimitPolicy{MaxSingleAmount: 10}},
+			{Scope: domain.LimitScopeTier, Currency: "USD", Tier: "gold", Policy: domain.LimitPolicy{MaxSingleAmount: 100}},
+			{Scope: domain.LimitScopeUser, Currency: "USD", UID: "vip", Policy: domain.LimitPolicy{MaxSingleAmount: 1000}},
+			// 其他幣別的使用者政策不影響 USD
+			{Scope: domain.LimitScopeUser, Currency: "EUR", UID: "carol", Policy: domain.LimitPolicy{MaxSingleAmount: 1000}},
+		}
+		for _, rule := range rules {
+			l.Limits().Set(rule)
+		}
+		cases := []struct {
+			uid, tier string
+			scope     domain.LimitScope
+			max       float64
+		}{
+			{"alice", "", domain.LimitScopeCurrency, 10},
+			{"alice", "silver", domain.LimitScopeCurrency, 10},
+			{"bob", "gold", domain.LimitScopeTier, 100},
+			{"vip", "gold", domain.LimitScopeUser, 1000},
+			{"vip", "", domain.LimitScopeUser, 1000},
+			{"carol", "", domain.LimitScopeCurrency, 10},
+		}
+		for _, c := range cases {
+			view := l.Limits().View(0, c.uid, "USD", c.tier)
+			if view.Scope != c.scope || view.Policy.MaxSingleAmount != c.max {
+				t.Fatalf("%s/%s: got %s %v, want %s %v", c.uid, c.tier, view.Scope, view.Policy.MaxSingleAmount, c.scope, c.max)
+			}
+			if err := l.Limits().Consume(1, c.uid, "USD", c.tier, c.max); err != nil {
+				t.Fatalf("%s/%s: consume at limit: %v", c.uid, c.tier, err)
+			}
+			if err := l.Limits().Consume(1, c.uid, "USD", c.tier, c.max+1); !errors.Is(err, store.ErrLimitExceeded) {
+				t.Fatalf("%s/%s: expected ErrLimitExceeded, got %v", c.uid, c.tier, err)
+			}
+		}
+
+		l.Limits().Remove(rules[2])
+		if view := l.Limits().View(0, "vip", "USD", "gold"); view.Scope != domain.LimitScopeTier {
+			t.Fatalf("removed user rule should fall back to tier, got %s", view.Scope)
+		}
+	})
+}
+
+// TestLimitRejectedLeavesNoState 超過上限的提領不建立帳戶、不改變提領紀錄與 checksum
+func TestLimitRejectedLeavesNoState(t *testing.T) {
+	eachLedger(t, func(t *testing.T, l store.Ledger) {
+		l.Limits().Set(domain.LimitRule{Scope: domain.LimitScopeCurrency, Currency: "USD", Policy: domain.LimitPolicy{DailyWithdrawCap: 50}})
+		if err := l.Limits().Consume(1, "alice", "USD", "", -50); err != nil {
+			t.Fatal(err)
+		}
+		l.Advance(1, day, true)
+		before := l.Capture()
+
+		// 一個視窗長度後第一筆已過期，被拒絕時也不清除
+		if acc, err := l.Accounts().Writable("bob"); err != nil || acc.UID != "" {
+			t.Fatalf("unknown account should be writable without being created: %+v, %v", acc, err)
+		}
+		if err := l.Limits().Consume(day+1, "bob", "USD", "", -51); !errors.Is(err, store.ErrLimitExceeded) {
+			t.Fatalf("expected ErrLimitExceeded, got %v", err)
+		}
+		if err := l.Limits().Consume(day+1, "alice", "USD", "", -51); !errors.Is(err, store.ErrLimitExceeded) {
+			t.Fatalf("expected ErrLimitExceeded, got %v", err)
+		}
+		after := l.Capture()
+		if !reflect.DeepEqual(before.Meta.Limits, after.Meta.Limits) || !reflect.DeepEqual(before.Meta.Accounts, after.Meta.Accounts) {
+			t.Fatalf("rejected withdrawal changed state:\nbefore %+v %+v\nafter  %+v %+v", before.Meta.Limits, before.Meta.Accounts, after.Meta.Limits, after.Meta.Accounts)
+		}
+		if _, ok := l.Accounts().Get("bob"); ok {
+			t.Fatal("rejected withdrawal created an account")
+		}
+	})
+}
+
+// TestLimitSweepsIdleWindows 不再提領的使用者的計數器在視窗過期後清除
+func TestLimitSweepsIdleWindows(t *testing.T) {
+	eachLedger(t, func(t *testing.T, l store.Ledger) {
+		l.Limits().Set(domain.LimitRule{Scope: domain.LimitScopeCurrency, Currency: "USD", Policy: domain.LimitPolicy{DailyWithdrawCap: 100}})
+		l.Limits().Set(domain.LimitRule{Scope: domain.LimitScopeCurrency, Currency: "EUR", Policy: domain.LimitPolicy{DailyWithdrawCap: 100}})
+		for _, uid := range []string{"alice", "bob"} {
+			if err := l.Limits().Consume(1, uid, "USD", "", -10); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := l.Limits().Consume(2, "carol", "EUR", "", -10); err != nil {
+			t.Fatal(err)
+		}
+
+		now := 2 + day
+		if err := l.Limits().Consume(now, "dave", "USD", "", -10); err != nil {
+			t.Fatal(err)
+		}
+		windows := l.Capture().Meta.Limits.Windows
+		if len(windows["USD"]) != 1 || len(windows["USD"]["dave"]) != 1 || len(windows["EUR"]) != 0 {
+			t.Fatalf("expired windows not swept: %+v", windows)
+		}
+	})
+}
+
+// TestLimitWindowOnlyWithCap 只有生效政策設有每日上限時才記錄提領，只限制單筆金額的政策不建立滾動視窗
+func TestLimitWindowOnlyWithCap(t *testing.T) {
+	eachLedger(t, func(t *testing.T, l store.Ledger) {
+		l.Limits().Set(domain.LimitRule{Scope: domain.LimitScopeCurrency, Currency: "USD", Policy: domain.LimitPolicy{DailyWithdrawCap: 100}})
+		// 使用者覆寫只限制單筆金額，優先於幣別的每日上限
+		l.Limits().Set(domain.LimitRule{Scope: domain.LimitScopeUser, Currency: "USD", UID: "vip", Policy: domain.LimitPolicy{MaxSingleAmount: 500}})
+		l.Limits().Set(domain.LimitRule{Scope: domain.LimitScopeCurrency, Currency: "EUR", Policy: domain.LimitPolicy{MaxSingleAmount: 500}})
+
+		for _, c := range []struct{ uid, currency string }{{"vip", "USD"}, {"alice", "EUR"}} {
+			for i := 0; i < 3; i++ {
+				if err := l.Limits().Consume(int64(i+1), c.uid, c.currency, "", -400); err != nil {
+					t.Fatalf("%s/%s: %v", c.uid, c.currency, err)
+				}
+			}
+			if got := l.Limits().View(3, c.uid, c.currency, "").Withdrawn; got != 0 {
+				t.Fatalf("%s/%s: withdrawn %v without a daily cap, want 0", c.uid, c.currency, got)
+			}
+		}
+		if err := l.Limits().Consume(4, "alice", "USD", "", -60); err != nil {
+			t.Fatal(err)
+		}
+		windows := l.Capture().Meta.Limits.Windows
+		if len(windows["EUR"]) != 0 || len(windows["USD"]) != 1 || len(windows["USD"]["alice"]) != 1 {
+			t.Fatalf("windows %+v, want only alice's capped USD withdrawal", windows)
+		}
+
+		// 移除覆寫後改用幣別的每日上限，之前未記錄的提領不計入
+		l.Limits().Remove(domain.LimitRule{Scope: domain.LimitScopeUser, Currency: "USD", UID: "vip"})
+		if err := l.Limits().Consume(5, "vip", "USD", "", -100); err != nil {
+			t.Fatalf("vip under currency cap: %v", err)
+		}
+		if err := l.Limits().Consume(6, "vip", "USD", "", -1); !errors.Is(err, store.ErrLimitExceeded) {
+			t.Fatalf("expected ErrLimitExceeded, got %v", err)
+		}
+	})
+}
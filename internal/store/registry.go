@@ -0,0 +1,100 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+
+	"go-raft/internal/domain"
+)
+
+var (
+	// ErrUnknownCurrency 幣別未註冊
+	ErrUnknownCurrency = errors.New("unknown currency")
+	// ErrCurrencyDisabled 幣別已停用
+	ErrCurrencyDisabled = errors.New("currency disabled")
+	// ErrInvalidAmount 金額不符合幣別的精度或上下限
+	ErrInvalidAmount = errors.New("invalid amount")
+)
+
+// precisionULPs 判斷金額是否符合精度時，允許與四捨五入後的值相差的 ULP 數
+// 誤差隨金額大小縮放，大額金額放大後的捨入誤差不會被誤判為超出精度
+const precisionULPs = 4
+
+// Registry 為複製於狀態機中的幣別註冊表
+type Registry struct {
+	mu         sync.RWMutex
+	currencies map[string]domain.Currency
+}
+
+func NewRegistry() *Registry {
+	return &Registry{currencies: make(map[string]domain.Currency)}
+}
+
+// Put 新增或更新幣別
+func (r *Registry) Put(c domain.Currency) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.currencies[c.Code] = c
+}
+
+// Get 取得幣別註冊資料
+func (r *Registry) Get(code string) (domain.Currency, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	c, ok := r.currencies[code]
+	return c, ok
+}
+
+// List 回傳所有幣別，依代碼排序
+func (r *Registry) List() []domain.Currency {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	result := make([]domain.Currency, 0, len(r.currencies))
+	for _, c := range r.currencies {
+		result = append(result, c)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Code < result[j].Code })
+	return result
+}
+
+// Validate 檢查對幣別 code 寫入 amount 是否被允許
+func (r *Registry) Validate(code string, amount float64) error {
+	c, ok := r.Get(code)
+	return validateAmount(code, c, ok, amount)
+}
+
+// validateAmount 依幣別註冊資料檢查 amount，ok 為幣別是否已註冊
+func validateAmount(code string, c domain.Currency, ok bool, amount float64) error {
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrUnknownCurrency, code)
+	}
+	if !c.Enabled {
+		return fmt.Errorf("%w: %s", ErrCurrencyDisabled, code)
+	}
+	abs := math.Abs(amount)
+	if c.MinAmount > 0 && abs < c.MinAmount {
+		return fmt.Errorf("%w: %v below minimum %v", ErrInvalidAmount, amount, c.MinAmount)
+	}
+	if c.MaxAmount > 0 && abs > c.MaxAmount {
+		return fmt.Errorf("%w: %v above maximum %v", ErrInvalidAmount, amount, c.MaxAmount)
+	}
+	scale := math.Pow10(c.Precision)
+	rounded := math.Round(amount*scale) / scale
+	if math.Abs(amount-rounded) > precisionULPs*(math.Nextafter(abs, math.Inf(1))-abs) {
+		return fmt.Errorf("%w: %v exceeds precision %d", ErrInvalidAmount, amount, c.Precision)
+	}
+	return nil
+}
+
+// LoadData 以 snapshot 中的幣別清單取代目前內容
+func (r *Registry) LoadData(currencies []domain.Currency) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.currencies = make(map[string]domain.Currency, len(currencies))
+	for _, c := range currencies {
+		r.currencies[c.Code] = c
+	}
+}
@@ -0,0 +1,47 @@
+package store_test
+
+import (
+	"errors"
+	"testing"
+
+	"go-raft/internal/domain"
+	"go-raft/internal/store"
+)
+
+func TestRegistryValidate(t *testing.T) {
+	r := store.NewRegistry()
+	r.Put(domain.Currency{Code: "USD", Precision: 2, Enabled: true, MinAmount: 0.01, MaxAmount: 1e6})
+	r.Put(domain.Currency{Code: "JPY", Precision: 0, Enabled: true})
+	r.Put(domain.Currency{Code: "BTC", Precision: 8, Enabled: true})
+	r.Put(domain.Currency{Code: "DOGE", Precision: 2, Enabled: false})
+
+	cases := []struct {
+		code   string
+		amount float64
+		err    error
+	}{
+		{"EUR", 1, store.ErrUnknownCurrency},
+		{"DOGE", 1, store.ErrCurrencyDisabled},
+		{"USD", 0.01, nil},
+		{"USD", -0.01, nil},
+		{"USD", 0.009, store.ErrInvalidAmount},
+		{"USD", 1e6, nil},
+		{"USD", -1e6 - 1, store.ErrInvalidAmount},
+		{"USD", 0.1 + 0.2, nil},
+		{"USD", 19.99, nil},
+		{"USD", 1.005, store.ErrInvalidAmount},
+		{"JPY", 500, nil},
+		{"JPY", 0.5, store.ErrInvalidAmount},
+		{"BTC", 0.00000001, nil},
+		{"BTC", 0.000000015, store.ErrInvalidAmount},
+		{"BTC", 20999999.97690001, nil},
+		// 大額金額放大後的捨入誤差不可被視為超出精度
+		{"JPY", 123456789012345, nil},
+		{"BTC", 123456.12345678, nil},
+	}
+	for _, c := range cases {
+		if err := r.Validate(c.code, c.amount); !errors.Is(err, c.err) {
+			t.Errorf("validate %s %v: got %v, want %v", c.code, c.amount, err, c.err)
+		}
+	}
+}
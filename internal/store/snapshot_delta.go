@@ -0,0 +1,266 @@
+package store
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"go-raft/internal/configs"
+
+	"github.com/lni/dragonboat/v4/statemachine"
+)
+
+// v4 snapshot 格式：餘額存放在外部檔案組成的 delta 鏈，元資料仍完整寫在主串流
+//
+// 鏈的第一個檔案為完整餘額的 base，之後每個 delta 只包含自前一個 snapshot 後寫入過的餘額，
+// 檔案內容皆為 v3 的 block 串流。鏈上的檔案保留在 fileDir，之後的 snapshot 直接再次加入，
+// dragonboat 以 hard link 收錄，未變動的檔案不會重新寫入；還原時依序套用 base 與各 delta。
+// manifest 不列出各幣別的完整摘要，每個檔案以 Sum 串接前一個檔案的摘要，寫出 delta 時只需計算變動的紀錄。
+
+// ChainEntry 為 v4 snapshot 鏈上的一個外部檔案
+type ChainEntry struct {
+	FileID  uint64
+	Delta   bool // false 為 base，只能是鏈的第一個檔案
+	Records int
+	Digest  string // 檔案內容的 sha256
+	// Sum 為 sha256(前一個檔案的 Sum + Digest)，base 的前一個 Sum 為空字串；最後一個檔案的 Sum 涵蓋整條鏈
+	Sum string
+}
+
+// chainSum 將檔案摘要接在前一個檔案的 Sum 之後計算 sha256
+func chainSum(parent, digest string) string {
+	sum := sha256.Sum256([]byte(parent + digest))
+	return hex.EncodeToString(sum[:])
+}
+
+// deltaChain 為最近一次 v4 snapshot 寫出的檔案，之後的 snapshot 延續此鏈
+type deltaChain struct {
+	seq     uint64 // 最後寫入此鏈的 PrepareSnapshot 序號
+	dir     string
+	entries []ChainEntry
+	paths   []string
+	base    int // base 的紀錄數
+	records int // 各 delta 的紀錄數總和
+}
+
+// extends 判斷序號為 seq 的 snapshot 是否延續此鏈
+// 中間有其他版本或未寫出的 snapshot 時，變動紀錄已被取走，需重新寫出 base；delta 數量達到上限或累計紀錄數超過 base 時也是
+func (c *deltaChain) extends(seq uint64, records int) bool {
+	if c == nil || c.seq+1 != seq {
+		return false
+	}
+	if records == 0 {
+		return true
+	}
+	return len(c.entries) <= configs.DeltaSnapshotChain && c.records+records <= c.base
+}
+
+func (c *deltaChain) add(path string, records int, digest string) {
+	entry := ChainEntry{FileID: uint64(len(c.entries)), Delta: len(c.entries) > 0, Records: records, Digest: digest}
+	if entry.Delta {
+		c.records += records
+		entry.Sum = chainSum(c.entries[len(c.entries)-1].Sum, digest)
+	} else {
+		c.base = records
+		entry.Sum = chainSum("", digest)
+	}
+	c.entries = append(c.entries, entry)
+	c.paths = append(c.paths, path)
+}
+
+// markDirty 紀錄 uid 在 currency 的餘額自上次 snapshot 後有變動
+func (cs *CurrencyStore) markDirty(currency, uid string) {
+	cs.dirtyMu.Lock()
+	defer cs.dirtyMu.Unlock()
+	byUID := cs.dirty[currency]
+	if byUID == nil {
+		byUID = make(map[string]struct{})
+		cs.dirty[currency] = byUID
+	}
+	byUID[uid] = struct{}{}
+}
+
+// takeDirty 取出並清空變動紀錄，回傳本次 snapshot 的序號；需與 Update 互斥呼叫，之後的寫入會留到下一個 snapshot
+func (cs *CurrencyStore) takeDirty() (uint64, map[string]map[string]struct{}) {
+	cs.dirtyMu.Lock()
+	defer cs.dirtyMu.Unlock()
+	dirty := cs.dirty
+	cs.dirty = make(map[string]map[string]struct{})
+	cs.snapshots++
+	return cs.snapshots, dirty
+}
+
+// writeChain 寫出 v4 的外部檔案並將鏈上所有檔案加入 fss，回傳依套用順序排列的檔案摘要
+// 延續目前的鏈時只讀取與寫出 dirty 中的餘額，沒有任何變動時不寫入新檔案；寫出失敗時下一個 snapshot 重新寫出 base
+func (cs *CurrencyStore) writeChain(seq uint64, balances map[string]balanceTable, dirty map[string]map[string]struct{}, fss statemachine.ISnapshotFileCollection, done <-chan struct{}) ([]ChainEntry, error) {
+	chain := cs.chain
+	cs.chain = nil
+
+	delta, records := dirtyBalances(balances, dirty)
+	if chain.extends(seq, records) {
+		if records > 0 {
+			path, digest, err := writeChainFile(chain.dir, fmt.Sprintf("delta-%d.snap", len(chain.entries)), tablesOf(delta), done)
+			if err != nil {
+				return nil, err
+			}
+			chain.add(path, records, digest)
+		}
+	} else {
+		dir, err := cs.newSnapshotDir()
+		if err != nil {
+			return nil, err
+		}
+		path, digest, err := writeChainFile(dir, "base.snap", balances, done)
+		if err != nil {
+			return nil, err
+		}
+		chain = &deltaChain{dir: dir}
+		chain.add(path, countRecords(balances), digest)
+	}
+
+	for i, entry := range chain.entries {
+		fss.AddFile(entry.FileID, chain.paths[i], nil)
+	}
+	chain.seq = seq
+	cs.chain = chain
+	return append([]ChainEntry(nil), chain.entries...), nil
+}
+
+// dirtyBalances 回傳 dirty 中各 uid 目前的餘額與紀錄數
+func dirtyBalances(balances map[string]balanceTable, dirty map[string]map[string]struct{}) (map[string]map[string]float64, int) {
+	delta := make(map[string]map[string]float64)
+	var records int
+	for currency, uids := range dirty {
+		byUID, ok := balances[currency]
+		if !ok {
+			continue
+		}
+		for uid := range uids {
+			balance, ok := byUID.Get(uid)
+			if !ok {
+				continue
+			}
+			if delta[currency] == nil {
+				delta[currency] = make(map[string]float64)
+			}
+			delta[currency][uid] = balance
+			records++
+		}
+	}
+	return delta, records
+}
+
+func countRecords(balances map[string]balanceTable) int {
+	var n int
+	for _, byUID := range balances {
+		n += byUID.Len()
+	}
+	return n
+}
+
+// writeChainFile 以 v3 的 block 串流將 balances 寫入 dir/name 並 fsync，回傳檔案路徑與內容的 sha256
+func writeChainFile(dir, name string, balances map[string]balanceTable, done <-chan struct{}) (string, string, error) {
+	path := filepath.Join(dir, name)
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o644)
+	if err != nil {
+		return "", "", err
+	}
+	h := sha256.New()
+	bw := bufio.NewWriter(io.MultiWriter(f, h))
+	if err := writeSnapshotV3(bw, balances, done); err != nil {
+		f.Close()
+		return "", "", fmt.Errorf("write snapshot file %s: %w", name, err)
+	}
+	if err := bw.Flush(); err != nil {
+		f.Close()
+		return "", "", fmt.Errorf("write snapshot file %s: %w", name, err)
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return "", "", err
+	}
+	return path, hex.EncodeToString(h.Sum(nil)), f.Close()
+}
+
+// readChain 依 manifest 的順序讀取 base 與各 delta，後面的檔案覆蓋前面的餘額
+// 檔案缺少、多出、順序不符、內容與摘要不同或 Sum 無法由前一個檔案接續都會失敗
+func readChain(files []statemachine.SnapshotFile, manifest *Manifest, done <-chan struct{}) (map[string]map[string]float64, error) {
+	if manifest == nil || len(manifest.Chain) == 0 {
+		return nil, fmt.Errorf("%w: missing delta chain", ErrManifestMismatch)
+	}
+	paths := make(map[uint64]string, len(files))
+	for _, file := range files {
+		paths[file.FileID] = file.Filepath
+	}
+
+	balances := make(map[string]map[string]float64)
+	var parent string
+	for i, entry := range manifest.Chain {
+		select {
+		case <-done:
+			return nil, errors.New("snapshot recover stopped")
+		default:
+		}
+		if entry.Delta != (i > 0) {
+			return nil, fmt.Errorf("%w: chain file %d out of order", ErrManifestMismatch, entry.FileID)
+		}
+		if sum := chainSum(parent, entry.Digest); sum != entry.Sum {
+			return nil, fmt.Errorf("%w: chain file %d sum %s, manifest %s", ErrManifestMismatch, entry.FileID, sum, entry.Sum)
+		}
+		parent = entry.Sum
+		path, ok := paths[entry.FileID]
+		if !ok {
+			return nil, fmt.Errorf("%w: missing chain file %d", ErrManifestMismatch, entry.FileID)
+		}
+		delete(paths, entry.FileID)
+
+		part, err := readChainFile(path, entry, done)
+		if err != nil {
+			return nil, err
+		}
+		for currency, byUID := range part {
+			if balances[currency] == nil {
+				balances[currency] = byUID
+				continue
+			}
+			for uid, balance := range byUID {
+				balances[currency][uid] = balance
+			}
+		}
+	}
+	for fileID := range paths {
+		return nil, fmt.Errorf("%w: unexpected file %d", ErrManifestMismatch, fileID)
+	}
+	return balances, nil
+}
+
+// readChainFile 讀取鏈上的單一檔案，核對紀錄數與整個檔案內容的 sha256
+func readChainFile(path string, entry ChainEntry, done <-chan struct{}) (map[string]map[string]float64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	h := sha256.New()
+	br := bufio.NewReader(io.TeeReader(f, h))
+	part, err := readSnapshotV3(br, done)
+	if err != nil {
+		return nil, err
+	}
+	// 結尾之後的內容也計入 digest，附加資料的檔案同樣視為不符
+	if _, err := io.Copy(io.Discard, br); err != nil {
+		return nil, err
+	}
+	if records := countRecords(tablesOf(part)); records != entry.Records {
+		return nil, fmt.Errorf("%w: chain file %d has %d records, manifest %d", ErrManifestMismatch, entry.FileID, records, entry.Records)
+	}
+	if digest := hex.EncodeToString(h.Sum(nil)); digest != entry.Digest {
+		return nil, fmt.Errorf("%w: chain file %d digest %s, manifest %s", ErrManifestMismatch, entry.FileID, digest, entry.Digest)
+	}
+	return part, nil
+}
@@ -0,0 +1,218 @@
+package store
+
+import (
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/golang/snappy"
+	"github.com/lni/dragonboat/v4/statemachine"
+)
+
+// newSnapshotDir 建立本次 SaveSnapshot 寫出外部檔案的目錄，並清除先前 snapshot 留下的目錄
+// dragonboat 在 SaveSnapshot 回傳後才以 hard link 收錄外部檔案，因此只能在下一次儲存時清理
+func (cs *CurrencyStore) newSnapshotDir() (string, error) {
+	if err := os.MkdirAll(cs.fileDir, 0o755); err != nil {
+		return "", err
+	}
+	entries, err := os.ReadDir(cs.fileDir)
+	if err != nil {
+		return "", err
+	}
+	for _, e := range entries {
+		if err := os.RemoveAll(filepath.Join(cs.fileDir, e.Name())); err != nil {
+			return "", err
+		}
+	}
+	return os.MkdirTemp(cs.fileDir, "snapshot-")
+}
+
+// writeSnapshotFile 將 data 完整寫入 dir/name 並 fsync，回傳檔案路徑
+// 外部檔案加入 snapshot 後內容不可再變動
+func writeSnapshotFile(dir, name string, data []byte) (string, error) {
+	path := filepath.Join(dir, name)
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o644)
+	if err != nil {
+		return "", err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return "", fmt.Errorf("write snapshot file %s: %w", name, err)
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return "", err
+	}
+	return path, f.Close()
+}
+
+// writeSnapshotFiles 依幣別排序將 v1 / v2 資料寫成外部檔案並加入 fss，回傳各檔案的摘要
+// 檔名只包含 FileID，幣別記錄在 manifest 與檔案 metadata
+func (cs *CurrencyStore) writeSnapshotFiles(version uint64, balances map[string]map[string]float64, fss statemachine.ISnapshotFileCollection, done <-chan struct{}) ([]ManifestEntry, error) {
+	dir, err := cs.newSnapshotDir()
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]ManifestEntry, 0, len(balances))
+	for index, currency := range sortedKeys(balances) {
+		select {
+		case <-done:
+			return nil, errors.New("snapshot save stopped")
+		default:
+		}
+
+		data, written, err := encodeSnapshotFile(version, balances[currency])
+		if err != nil {
+			return nil, err
+		}
+		fileID := uint64(index)
+		path, err := writeSnapshotFile(dir, fmt.Sprintf("currency-%d.snap", fileID), data)
+		if err != nil {
+			return nil, err
+		}
+		fss.AddFile(fileID, path, []byte(currency))
+		entries = append(entries, newManifestEntry(fileID, currency, balanceMap(written), fileDigest(data)))
+	}
+	return entries, nil
+}
+
+// encodeSnapshotFile 依版本將單一幣別的餘額序列化並壓縮
+// 同時回傳還原時會讀到的餘額（v2 以字串保存，精度可能與原值不同）
+func encodeSnapshotFile(version uint64, dataMap map[string]float64) ([]byte, map[string]float64, error) {
+	var snapshot SnapshotFile
+	written := dataMap
+
+	// 根據版本組裝序列化物件
+	switch version {
+	case 1:
+		snapshot = SnapshotFile{
+			SnapshotVersion: 1,
+			Data:            &StoreV1{Data: dataMap},
+		}
+	case 2:
+		// 將 map 轉成 slice []{Key,Value string}
+		dataSlice := make([]struct {
+			Key   string
+			Value string
+		}, 0, len(dataMap))
+		for k, v := range dataMap {
+			dataSlice = append(dataSlice, struct {
+				Key   string
+				Value string
+			}{
+				Key:   k,
+				Value: fmt.Sprintf("%f", v),
+			})
+		}
+		dataV2 := &StoreV2{Data: dataSlice}
+		snapshot = SnapshotFile{
+			SnapshotVersion: 2,
+			Data:            dataV2,
+		}
+		var err error
+		if written, err = migrateFromV2(dataV2); err != nil {
+			return nil, nil, err
+		}
+	default:
+		return nil, nil, fmt.Errorf("unsupported snapshot version %d", version)
+	}
+
+	buf := new(bytes.Buffer)
+	if err := gob.NewEncoder(buf).Encode(snapshot); err != nil {
+		return nil, nil, err
+	}
+	return snappy.Encode(nil, buf.Bytes()), written, nil
+}
+
+// readSnapshotFiles 讀取 v1 / v2 外部檔案，回傳 currency -> uid -> balance
+// 有 manifest 時逐一核對檔案內容，缺少、多出或內容不符都會失敗
+// 沒有 manifest 的舊版 snapshot，幣別取自檔案 metadata，最舊的 snapshot 才從檔名解析
+func readSnapshotFiles(files []statemachine.SnapshotFile, manifest *Manifest, done <-chan struct{}) (map[string]map[string]float64, error) {
+	var byID map[uint64]ManifestEntry
+	if manifest != nil {
+		byID = make(map[uint64]ManifestEntry, len(manifest.Entries))
+		for _, entry := range manifest.Entries {
+			byID[entry.FileID] = entry
+		}
+	}
+	result := make(map[string]map[string]float64)
+	for _, file := range files {
+		select {
+		case <-done:
+			return nil, errors.New("snapshot recover stopped")
+		default:
+		}
+
+		var currency string
+		entry, listed := byID[file.FileID]
+		switch {
+		case manifest != nil && !listed:
+			return nil, fmt.Errorf("%w: unexpected file %d", ErrManifestMismatch, file.FileID)
+		case listed:
+			currency = entry.Currency
+			delete(byID, file.FileID)
+		default:
+			currency = string(file.Metadata)
+		}
+		if currency == "" {
+			name, found := strings.CutPrefix(filepath.Base(file.Filepath), "currency_")
+			if !found || !strings.HasSuffix(name, ".snap") {
+				continue
+			}
+			currency = strings.TrimSuffix(name, ".snap")
+		}
+
+		raw, err := os.ReadFile(file.Filepath)
+		if err != nil {
+			return nil, err
+		}
+		byUID, err := decodeSnapshotFile(raw)
+		if err != nil {
+			return nil, err
+		}
+		if listed {
+			if err := entry.check(byUID, fileDigest(raw)); err != nil {
+				return nil, err
+			}
+		}
+		result[currency] = byUID
+	}
+	for _, entry := range byID {
+		return nil, fmt.Errorf("%w: missing file %d for %q", ErrManifestMismatch, entry.FileID, entry.Currency)
+	}
+	return result, nil
+}
+
+// decodeSnapshotFile 解壓並依版本解析單一外部檔案
+func decodeSnapshotFile(raw []byte) (map[string]float64, error) {
+	decompressed, err := snappy.Decode(nil, raw)
+	if err != nil {
+		return nil, err
+	}
+
+	var snapshot SnapshotFile
+	if err := gob.NewDecoder(bytes.NewReader(decompressed)).Decode(&snapshot); err != nil {
+		return nil, err
+	}
+
+	switch snapshot.SnapshotVersion {
+	case 1:
+		dataV1, ok := snapshot.Data.(*StoreV1)
+		if !ok {
+			return nil, errors.New("invalid snapshot data type for v1")
+		}
+		return migrateFromV1(dataV1)
+	case 2:
+		dataV2, ok := snapshot.Data.(*StoreV2)
+		if !ok {
+			return nil, errors.New("invalid snapshot data type for v2")
+		}
+		return migrateFromV2(dataV2)
+	}
+	return nil, fmt.Errorf("%w %d", ErrUnsupportedSnapshot, snapshot.SnapshotVersion)
+}
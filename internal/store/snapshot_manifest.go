@@ -0,0 +1,121 @@
+package store
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math"
+)
+
+// ErrManifestMismatch snapshot 內容與 manifest 不符（檔案缺少、多出或內容不同）
+var ErrManifestMismatch = errors.New("snapshot manifest mismatch")
+
+// Manifest 列出 snapshot 中每個幣別的資料摘要，寫在元資料中，還原時逐一核對
+// 沒有 manifest 的舊版 snapshot 無法核對，照原本方式還原
+type Manifest struct {
+	Entries []ManifestEntry
+	Chain   []ChainEntry // v4 依套用順序排列的 base 與 delta 檔案，v4 只以此核對，Entries 為空
+}
+
+// ManifestEntry 為單一幣別的資料摘要
+// v1 / v2 的 Digest 為外部檔案內容的 sha256，檔名只使用 FileID，任意幣別代碼都不會影響檔案路徑
+// v3 沒有外部檔案，Digest 為依 uid 排序後紀錄內容的 sha256
+type ManifestEntry struct {
+	FileID   uint64
+	Currency string
+	Records  int
+	Total    float64 // 依 uid 排序加總，還原時以相同順序重算可得到完全相同的值
+	Digest   string
+}
+
+// newManifestEntry 依幣別資料建立摘要，digest 為空時使用紀錄內容計算
+func newManifestEntry(fileID uint64, currency string, byUID balanceTable, digest string) ManifestEntry {
+	records, total := summarize(byUID)
+	if digest == "" {
+		digest = records
+	}
+	return ManifestEntry{
+		FileID:   fileID,
+		Currency: currency,
+		Records:  byUID.Len(),
+		Total:    total,
+		Digest:   digest,
+	}
+}
+
+// check 核對還原出的幣別資料筆數與總額，digest 為空時以紀錄內容核對
+func (e ManifestEntry) check(byUID map[string]float64, digest string) error {
+	records, total := summarize(balanceMap(byUID))
+	if digest == "" {
+		digest = records
+	}
+	switch {
+	case len(byUID) != e.Records:
+		return fmt.Errorf("%w: %q has %d records, manifest %d", ErrManifestMismatch, e.Currency, len(byUID), e.Records)
+	case total != e.Total:
+		return fmt.Errorf("%w: %q total %v, manifest %v", ErrManifestMismatch, e.Currency, total, e.Total)
+	case digest != e.Digest:
+		return fmt.Errorf("%w: %q digest %s, manifest %s", ErrManifestMismatch, e.Currency, digest, e.Digest)
+	}
+	return nil
+}
+
+// v3Manifest 依幣別排序建立 v3 的 manifest
+func v3Manifest(balances map[string]balanceTable) *Manifest {
+	manifest := &Manifest{}
+	for _, currency := range sortedKeys(balances) {
+		manifest.Entries = append(manifest.Entries, newManifestEntry(0, currency, balances[currency], ""))
+	}
+	return manifest
+}
+
+// verifyBalances 核對 v3 還原出的所有幣別，缺少或多出幣別都視為不符
+func (m *Manifest) verifyBalances(balances map[string]map[string]float64) error {
+	seen := make(map[string]bool, len(m.Entries))
+	for _, e := range m.Entries {
+		byUID, ok := balances[e.Currency]
+		if !ok {
+			return fmt.Errorf("%w: missing currency %q", ErrManifestMismatch, e.Currency)
+		}
+		if err := e.check(byUID, ""); err != nil {
+			return err
+		}
+		seen[e.Currency] = true
+	}
+	for currency := range balances {
+		if !seen[currency] {
+			return fmt.Errorf("%w: unexpected currency %q", ErrManifestMismatch, currency)
+		}
+	}
+	return nil
+}
+
+// fileDigest 回傳外部檔案內容的 sha256
+func fileDigest(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// summarize 依 uid 排序後，回傳以 v3 紀錄格式計算的 sha256 與依相同順序加總的總額
+// 還原時以相同順序重算可得到完全相同的總額
+func summarize(byUID balanceTable) (string, float64) {
+	h := sha256.New()
+	var buf []byte
+	var total float64
+	for _, uid := range sortedUIDs(byUID) {
+		balance, _ := byUID.Get(uid)
+		buf = appendRecord(buf[:0], uid, balance)
+		h.Write(buf)
+		total += balance
+	}
+	return hex.EncodeToString(h.Sum(nil)), total
+}
+
+// appendRecord 以 [uvarint uidLen][uid][float64 bits 8B] 編碼一筆紀錄
+func appendRecord(dst []byte, uid string, balance float64) []byte {
+	dst = binary.AppendUvarint(dst, uint64(len(uid)))
+	dst = append(dst, uid...)
+	return binary.LittleEndian.AppendUint64(dst, math.Float64bits(balance))
+}
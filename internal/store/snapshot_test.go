@@ -0,0 +1,350 @@
+package store_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"math"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"go-raft/internal/domain"
+	"go-raft/internal/store"
+
+	"github.com/golang/snappy"
+	"github.com/lni/dragonboat/v4/statemachine"
+)
+
+// fileCollection 模擬 dragonboat 收集外部檔案，還原時直接交回相同路徑
+type fileCollection struct {
+	files []statemachine.SnapshotFile
+}
+
+func (f *fileCollection) AddFile(fileID uint64, path string, metadata []byte) {
+	f.files = append(f.files, statemachine.SnapshotFile{FileID: fileID, Filepath: path, Metadata: metadata})
+}
+
+func TestSnapshotRoundTripAllVersions(t *testing.T) {
+	for _, version := range []uint64{1, 2, 3, 4} {
+		t.Run(fmt.Sprintf("v%d", version), func(t *testing.T) {
+			dir := t.TempDir()
+			src := store.NewCurrencyStore(1, 1, dir, 0)
+			// 舊版命令不經幣別註冊表，幣別可能包含底線或路徑分隔符號
+			currencies := []string{"USD", "BTC", "USDT_TRC20", "../evil", "a/b"}
+			for i := 0; i < 3000; i++ {
+				src.UpdateAt(uint64(i+1), fmt.Sprintf("user-%d", i%700), currencies[i%len(currencies)], float64(i%50)+0.25)
+			}
+
+			var buf bytes.Buffer
+			fss := &fileCollection{}
+			if err := src.SaveSnapshot(version, &buf, fss, nil); err != nil {
+				t.Fatalf("save: %v", err)
+			}
+			if version == 3 && len(fss.files) != 0 {
+				t.Fatalf("v3 should not write external files, got %d", len(fss.files))
+			}
+			for _, f := range fss.files {
+				if filepath.Dir(filepath.Dir(f.Filepath)) != dir {
+					t.Fatalf("snapshot file %s escapes %s", f.Filepath, dir)
+				}
+			}
+
+			dst := store.NewCurrencyStore(1, 2, t.TempDir(), 0)
+			if err := dst.RecoverFromSnapshot(bytes.NewReader(buf.Bytes()), fss.files, nil); err != nil {
+				t.Fatalf("recover: %v", err)
+			}
+			if !reflect.DeepEqual(src.List(), dst.List()) {
+				t.Fatal("recovered balances differ from source")
+			}
+			if got := dst.Settings().SnapshotVersion; got != 1 {
+				t.Fatalf("settings should come from the snapshot, got version %d", got)
+			}
+		})
+	}
+}
+
+func TestSnapshotV3DetectsCorruption(t *testing.T) {
+	src := store.NewCurrencyStore(1, 1, t.TempDir(), 0)
+	for i := 0; i < 100; i++ {
+		src.UpdateAt(uint64(i+1), fmt.Sprintf("user-%d", i), "USD", float64(i))
+	}
+	var buf bytes.Buffer
+	if err := src.SaveSnapshot(3, &buf, &fileCollection{}, nil); err != nil {
+		t.Fatalf("save: %v", err)
+	}
+	data := buf.Bytes()
+
+	// 截斷與位元翻轉都必須被偵測，不可還原出部分資料
+	cases := map[string][]byte{
+		"truncated": data[:len(data)-5],
+		"flipped":   append(append([]byte(nil), data[:len(data)-40]...), append([]byte{data[len(data)-40] ^ 0xff}, data[len(data)-39:]...)...),
+	}
+	for name, corrupted := range cases {
+		t.Run(name, func(t *testing.T) {
+			dst := store.NewCurrencyStore(1, 2, t.TempDir(), 0)
+			err := dst.RecoverFromSnapshot(bytes.NewReader(corrupted), nil, nil)
+			if !errors.Is(err, store.ErrSnapshotCorrupted) {
+				t.Fatalf("expected ErrSnapshotCorrupted, got %v", err)
+			}
+		})
+	}
+}
+
+func TestSnapshotUnsupportedVersion(t *testing.T) {
+	for _, version := range []uint64{0, 5, 1 << 40} {
+		var buf bytes.Buffer
+		if err := gob.NewEncoder(&buf).Encode(store.SnapshotFile{SnapshotVersion: version, Data: &store.StoreMeta{}}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := store.DecodeSnapshot(&buf, nil, nil); !errors.Is(err, store.ErrUnsupportedSnapshot) {
+			t.Fatalf("v%d: expected ErrUnsupportedSnapshot, got %v", version, err)
+		}
+	}
+}
+
+// TestSnapshotV3RecordLengthOverflow 區塊的 checksum 正確但紀錄長度接近 uint64 上限時，回報損毀而不是 panic
+func TestSnapshotV3RecordLengthOverflow(t *testing.T) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(store.SnapshotFile{SnapshotVersion: 3, Data: &store.StoreMeta{}}); err != nil {
+		t.Fatal(err)
+	}
+	buf.WriteString("GRS3")
+	// 依 v3 的 block 格式寫出 [kind][rawLen][compLen][crc32c(raw)][payload]
+	block := func(kind byte, raw []byte, compress bool) {
+		payload := raw
+		if compress {
+			payload = snappy.Encode(nil, raw)
+		}
+		header := []byte{kind}
+		header = binary.LittleEndian.AppendUint32(header, uint32(len(raw)))
+		header = binary.LittleEndian.AppendUint32(header, uint32(len(payload)))
+		header = binary.LittleEndian.AppendUint32(header, crc32.Checksum(raw, crc32.MakeTable(crc32.Castagnoli)))
+		buf.Write(header)
+		buf.Write(payload)
+	}
+	block(1, []byte("USD"), false)
+	block(2, append(binary.AppendUvarint(nil, math.MaxUint64-3), make([]byte, 8)...), true)
+
+	if _, err := store.DecodeSnapshot(&buf, nil, nil); !errors.Is(err, store.ErrSnapshotCorrupted) {
+		t.Fatalf("expected ErrSnapshotCorrupted, got %v", err)
+	}
+}
+
+func TestRecoverReplacesState(t *testing.T) {
+	src := store.NewCurrencyStore(1, 1, t.TempDir(), 0)
+	for i := 0; i < 50; i++ {
+		src.UpdateAt(uint64(i+1), fmt.Sprintf("user-%d", i%10), "USD", 1)
+	}
+	var buf bytes.Buffer
+	if err := src.SaveSnapshot(3, &buf, &fileCollection{}, nil); err != nil {
+		t.Fatalf("save: %v", err)
+	}
+
+	// 還原到已有資料的 store 兩次，結果都必須與 snapshot 內容相同，不可累加
+	dst := store.NewCurrencyStore(1, 2, t.TempDir(), 0)
+	dst.Update("stale", "BTC", 7)
+	dst.Update("user-1", "USD", 100)
+	for i := 0; i < 2; i++ {
+		if err := dst.RecoverFromSnapshot(bytes.NewReader(buf.Bytes()), nil, nil); err != nil {
+			t.Fatalf("recover #%d: %v", i+1, err)
+		}
+		if !reflect.DeepEqual(src.List(), dst.List()) {
+			t.Fatalf("recover #%d: balances %v, want %v", i+1, dst.List(), src.List())
+		}
+	}
+}
+
+func TestSnapshotManifestDetectsTampering(t *testing.T) {
+	src := store.NewCurrencyStore(1, 1, t.TempDir(), 0)
+	for i := 0; i < 30; i++ {
+		src.UpdateAt(uint64(i+1), fmt.Sprintf("user-%d", i), []string{"USD", "BTC", "ETH"}[i%3], float64(i))
+	}
+	var buf bytes.Buffer
+	fss := &fileCollection{}
+	if err := src.SaveSnapshot(2, &buf, fss, nil); err != nil {
+		t.Fatalf("save: %v", err)
+	}
+	if len(fss.files) != 3 {
+		t.Fatalf("expected 3 files, got %d", len(fss.files))
+	}
+
+	// 以另一個 store 的檔案替換內容，格式合法但資料不同
+	other := store.NewCurrencyStore(1, 3, t.TempDir(), 0)
+	other.Update("user-0", "BTC", 1)
+	otherFiles := &fileCollection{}
+	if err := other.SaveSnapshot(2, &bytes.Buffer{}, otherFiles, nil); err != nil {
+		t.Fatalf("save other: %v", err)
+	}
+	replaced := append([]statemachine.SnapshotFile(nil), fss.files...)
+	replaced[0].Filepath = otherFiles.files[0].Filepath
+
+	extra := append(append([]statemachine.SnapshotFile(nil), fss.files...), statemachine.SnapshotFile{FileID: 9, Filepath: fss.files[0].Filepath})
+
+	cases := map[string][]statemachine.SnapshotFile{
+		"missing":  fss.files[1:],
+		"extra":    extra,
+		"replaced": replaced,
+	}
+	for name, files := range cases {
+		t.Run(name, func(t *testing.T) {
+			dst := store.NewCurrencyStore(1, 2, t.TempDir(), 0)
+			err := dst.RecoverFromSnapshot(bytes.NewReader(buf.Bytes()), files, nil)
+			if !errors.Is(err, store.ErrManifestMismatch) {
+				t.Fatalf("expected ErrManifestMismatch, got %v", err)
+			}
+		})
+	}
+}
+
+func TestSnapshotDeltaChain(t *testing.T) {
+	src := store.NewCurrencyStore(1, 1, t.TempDir(), 0)
+	index := uint64(0)
+	update := func(uid, currency string, amount float64) {
+		index++
+		src.UpdateAt(index, uid, currency, amount)
+	}
+	for i := 0; i < 400; i++ {
+		update(fmt.Sprintf("user-%d", i), []string{"USD", "BTC"}[i%2], float64(i)+0.5)
+	}
+
+	type saved struct {
+		data  []byte
+		files []statemachine.SnapshotFile
+		want  map[string]map[string]float64
+	}
+	save := func() saved {
+		t.Helper()
+		var buf bytes.Buffer
+		fss := &fileCollection{}
+		if err := src.SaveSnapshot(4, &buf, fss, nil); err != nil {
+			t.Fatalf("save: %v", err)
+		}
+		return saved{data: buf.Bytes(), files: fss.files, want: src.List()}
+	}
+	restore := func(s saved) {
+		t.Helper()
+		dst := store.NewCurrencyStore(1, 2, t.TempDir(), 0)
+		if err := dst.RecoverFromSnapshot(bytes.NewReader(s.data), s.files, nil); err != nil {
+			t.Fatalf("recover: %v", err)
+		}
+		if !reflect.DeepEqual(s.want, dst.List()) {
+			t.Fatal("recovered balances differ from source")
+		}
+	}
+
+	// 第一個 snapshot 寫出 base，之後只寫出變動的餘額，沒有變動時不新增檔案
+	base := save()
+	update("user-1", "BTC", 3)
+	update("user-2", "USD", -1)
+	update("new-user", "ETH", 7)
+	first := save()
+	idle := save()
+	update("user-1", "BTC", 1)
+	second := save()
+	if len(base.files) != 1 || len(first.files) != 2 || len(idle.files) != 2 || len(second.files) != 3 {
+		t.Fatalf("chain lengths %d, %d, %d, %d", len(base.files), len(first.files), len(idle.files), len(second.files))
+	}
+	if first.files[0].Filepath != base.files[0].Filepath || second.files[1].Filepath != first.files[1].Filepath {
+		t.Fatal("unchanged chain files should be reused")
+	}
+	for _, s := range []saved{base, first, idle, second} {
+		restore(s)
+	}
+
+	// 缺少或調換 delta 都不可還原出部分資料
+	swapped := append([]statemachine.SnapshotFile(nil), second.files...)
+	swapped[1].Filepath, swapped[2].Filepath = swapped[2].Filepath, swapped[1].Filepath
+	for name, files := range map[string][]statemachine.SnapshotFile{"missing": second.files[:2], "swapped": swapped} {
+		dst := store.NewCurrencyStore(1, 2, t.TempDir(), 0)
+		if err := dst.RecoverFromSnapshot(bytes.NewReader(second.data), files, nil); !errors.Is(err, store.ErrManifestMismatch) {
+			t.Fatalf("%s: expected ErrManifestMismatch, got %v", name, err)
+		}
+	}
+
+	// delta 的 Sum 需接續前一個檔案，manifest 不另外列出各幣別的完整摘要
+	content, err := store.DecodeSnapshot(bytes.NewReader(second.data), second.files, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m := content.Meta.Manifest; len(m.Entries) != 0 || len(m.Chain) != 3 {
+		t.Fatalf("manifest has %d entries, %d chain files", len(m.Entries), len(m.Chain))
+	}
+	content.Meta.Manifest.Chain[1].Sum = content.Meta.Manifest.Chain[0].Sum
+	var relinked bytes.Buffer
+	if err := gob.NewEncoder(&relinked).Encode(store.SnapshotFile{SnapshotVersion: 4, Data: content.Meta}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.DecodeSnapshot(&relinked, second.files, nil); !errors.Is(err, store.ErrManifestMismatch) {
+		t.Fatalf("relinked: expected ErrManifestMismatch, got %v", err)
+	}
+
+	// 變動的紀錄多於 base 時重新寫出 base
+	for i := 0; i < 400; i++ {
+		update(fmt.Sprintf("user-%d", i), []string{"USD", "BTC"}[i%2], 1)
+	}
+	rebased := save()
+	if len(rebased.files) != 1 || rebased.files[0].Filepath == base.files[0].Filepath {
+		t.Fatalf("expected a new base, got %d files", len(rebased.files))
+	}
+	restore(rebased)
+}
+
+func TestPreparedSnapshotIsPointInTime(t *testing.T) {
+	src := store.NewCurrencyStore(1, 1, t.TempDir(), 20)
+	for i := 0; i < 2000; i++ {
+		src.Advance(uint64(i+1), int64(i), true)
+		src.UpdateAt(uint64(i+1), fmt.Sprintf("user-%d", i%300), []string{"USD", "BTC"}[i%2], 1)
+	}
+	if err := src.Accounts().Open(2000, 0, "user-1", "vip", nil); err != nil {
+		t.Fatal(err)
+	}
+	src.Limits().Set(domain.LimitRule{Scope: domain.LimitScopeCurrency, Currency: "USD", Policy: domain.LimitPolicy{DailyWithdrawCap: 1e9}})
+	consume := func(n int) {
+		for i := 0; i < n; i++ {
+			if err := src.Limits().Consume(int64(i), fmt.Sprintf("user-%d", i%50), "USD", "", -1); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	consume(100)
+
+	for _, version := range []uint64{2, 3, 4} {
+		t.Run(fmt.Sprintf("v%d", version), func(t *testing.T) {
+			want := src.Capture()
+			prepared := src.PrepareSnapshot(version)
+
+			// Save 與之後的寫入並行，寫出的內容仍須是 PrepareSnapshot 當下的狀態
+			var buf bytes.Buffer
+			fss := &fileCollection{}
+			saved := make(chan error, 1)
+			go func() { saved <- prepared.Save(&buf, fss, nil) }()
+			applied := src.Applied()
+			for i := 0; i < 500; i++ {
+				applied++
+				src.Advance(applied, int64(applied), true)
+				src.UpdateAt(applied, fmt.Sprintf("user-%d", i%400), []string{"USD", "BTC", "ETH"}[i%3], 2)
+			}
+			src.Accounts().Transition(applied, "user-1", domain.AccountFreeze, "later")
+			consume(80)
+			if err := <-saved; err != nil {
+				t.Fatalf("save: %v", err)
+			}
+
+			got, err := store.DecodeSnapshot(bytes.NewReader(buf.Bytes()), fss.files, nil)
+			if err != nil {
+				t.Fatalf("decode: %v", err)
+			}
+			// 以 copy-on-write 共用的部分都必須是 PrepareSnapshot 當下的內容
+			if !reflect.DeepEqual(want.Balances, got.Balances) ||
+				!reflect.DeepEqual(want.Meta.History, got.Meta.History) ||
+				!reflect.DeepEqual(want.Meta.Accounts, got.Meta.Accounts) ||
+				!reflect.DeepEqual(want.Meta.Limits.Windows, got.Meta.Limits.Windows) {
+				t.Fatal("snapshot differs from the state at PrepareSnapshot")
+			}
+		})
+	}
+}
@@ -0,0 +1,290 @@
+package store
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"math"
+	"sort"
+
+	"github.com/golang/snappy"
+)
+
+// v3 snapshot 格式：接在元資料之後直接寫入主串流，不產生外部檔案
+//
+//	magic "GRS3"
+//	block*  每個 block 為 [kind 1B][rawLen u32][compLen u32][crc32c(raw) u32][payload compLen]
+//
+// kind 為 blockCurrency 時 payload 為幣別名稱，之後的 blockRecords 都屬於該幣別；
+// blockRecords 的 payload 為 snappy 壓縮的 [uvarint uidLen][uid][float64 bits 8B] 紀錄；
+// blockEnd 的 payload 為全部紀錄數（u64），用來偵測截斷的串流
+const (
+	v3Magic        = "GRS3"
+	v3BlockSize    = 64 << 10 // 單一 records block 解壓後的目標大小
+	v3MaxBlockSize = 16 << 20 // 讀取時允許的 block 上限，避免損毀的長度造成大量配置
+	v3HeaderSize   = 13
+)
+
+const (
+	blockCurrency byte = iota + 1
+	blockRecords
+	blockEnd
+)
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+// ErrSnapshotCorrupted snapshot 內容損毀或 checksum 不符
+var ErrSnapshotCorrupted = errors.New("snapshot corrupted")
+
+// ErrUnsupportedSnapshot snapshot 的格式版本不是本版程式可以讀取的版本
+var ErrUnsupportedSnapshot = errors.New("unsupported snapshot version")
+
+// v3Writer 以 block 為單位將紀錄壓縮後寫入主串流
+type v3Writer struct {
+	w     io.Writer
+	raw   []byte
+	count uint64
+}
+
+func newV3Writer(w io.Writer) (*v3Writer, error) {
+	if _, err := io.WriteString(w, v3Magic); err != nil {
+		return nil, err
+	}
+	return &v3Writer{w: w, raw: make([]byte, 0, v3BlockSize+64)}, nil
+}
+
+// Currency 開始寫入一個幣別的紀錄
+func (vw *v3Writer) Currency(currency string) error {
+	if err := vw.flush(); err != nil {
+		return err
+	}
+	return vw.block(blockCurrency, []byte(currency), false)
+}
+
+// Record 寫入一筆餘額，累積到 block 大小時寫出
+func (vw *v3Writer) Record(uid string, balance float64) error {
+	vw.raw = appendRecord(vw.raw, uid, balance)
+	vw.count++
+	if len(vw.raw) >= v3BlockSize {
+		return vw.flush()
+	}
+	return nil
+}
+
+// Close 寫出剩餘紀錄與結尾 block
+func (vw *v3Writer) Close() error {
+	if err := vw.flush(); err != nil {
+		return err
+	}
+	return vw.block(blockEnd, binary.LittleEndian.AppendUint64(nil, vw.count), false)
+}
+
+func (vw *v3Writer) flush() error {
+	if len(vw.raw) == 0 {
+		return nil
+	}
+	err := vw.block(blockRecords, vw.raw, true)
+	vw.raw = vw.raw[:0]
+	return err
+}
+
+func (vw *v3Writer) block(kind byte, raw []byte, compress bool) error {
+	payload := raw
+	if compress {
+		payload = snappy.Encode(nil, raw)
+	}
+	var header [v3HeaderSize]byte
+	header[0] = kind
+	binary.LittleEndian.PutUint32(header[1:], uint32(len(raw)))
+	binary.LittleEndian.PutUint32(header[5:], uint32(len(payload)))
+	binary.LittleEndian.PutUint32(header[9:], crc32.Checksum(raw, crcTable))
+	if _, err := vw.w.Write(header[:]); err != nil {
+		return err
+	}
+	_, err := vw.w.Write(payload)
+	return err
+}
+
+// balanceTable 為單一幣別 uid -> balance 的唯讀表
+// snapshot 檢視中的 FrozenMap 直接實作此介面，寫出 v3 時不需先複製整個帳本
+type balanceTable interface {
+	Len() int
+	Get(uid string) (float64, bool)
+	Range(fn func(uid string, balance float64) bool)
+}
+
+// balanceMap 讓解碼出的一般 map 可作為 balanceTable
+type balanceMap map[string]float64
+
+func (m balanceMap) Len() int { return len(m) }
+
+func (m balanceMap) Get(uid string) (float64, bool) {
+	balance, ok := m[uid]
+	return balance, ok
+}
+
+func (m balanceMap) Range(fn func(uid string, balance float64) bool) {
+	for uid, balance := range m {
+		if !fn(uid, balance) {
+			return
+		}
+	}
+}
+
+// tablesOf 將 currency -> uid -> balance 轉成以 balanceTable 表示，不複製資料
+func tablesOf(balances map[string]map[string]float64) map[string]balanceTable {
+	tables := make(map[string]balanceTable, len(balances))
+	for currency, byUID := range balances {
+		tables[currency] = balanceMap(byUID)
+	}
+	return tables
+}
+
+// sortedUIDs 回傳 t 中依字典序排序的 uid
+func sortedUIDs(t balanceTable) []string {
+	uids := make([]string, 0, t.Len())
+	t.Range(func(uid string, _ float64) bool {
+		uids = append(uids, uid)
+		return true
+	})
+	sort.Strings(uids)
+	return uids
+}
+
+// writeSnapshotV3 依幣別與 uid 排序寫出所有餘額，相同狀態會產生相同內容
+// 一次只排序一個幣別的 uid，額外記憶體與最大幣別的帳戶數成正比
+func writeSnapshotV3(w io.Writer, balances map[string]balanceTable, done <-chan struct{}) error {
+	vw, err := newV3Writer(w)
+	if err != nil {
+		return err
+	}
+	for _, currency := range sortedKeys(balances) {
+		select {
+		case <-done:
+			return errors.New("snapshot save stopped")
+		default:
+		}
+		if err := vw.Currency(currency); err != nil {
+			return err
+		}
+		byUID := balances[currency]
+		for _, uid := range sortedUIDs(byUID) {
+			balance, _ := byUID.Get(uid)
+			if err := vw.Record(uid, balance); err != nil {
+				return err
+			}
+		}
+	}
+	return vw.Close()
+}
+
+// readSnapshotV3 讀取並驗證 v3 串流，回傳 currency -> uid -> balance
+func readSnapshotV3(r *bufio.Reader, done <-chan struct{}) (map[string]map[string]float64, error) {
+	magic := make([]byte, len(v3Magic))
+	if _, err := io.ReadFull(r, magic); err != nil {
+		return nil, fmt.Errorf("%w: read magic: %v", ErrSnapshotCorrupted, err)
+	}
+	if string(magic) != v3Magic {
+		return nil, fmt.Errorf("%w: bad magic %q", ErrSnapshotCorrupted, magic)
+	}
+
+	result := make(map[string]map[string]float64)
+	var current map[string]float64
+	var count uint64
+	for {
+		select {
+		case <-done:
+			return nil, errors.New("snapshot recover stopped")
+		default:
+		}
+		kind, raw, err := readBlock(r)
+		if err != nil {
+			return nil, err
+		}
+		switch kind {
+		case blockCurrency:
+			currency := string(raw)
+			current = result[currency]
+			if current == nil {
+				current = make(map[string]float64)
+				result[currency] = current
+			}
+		case blockRecords:
+			if current == nil {
+				return nil, fmt.Errorf("%w: records before currency", ErrSnapshotCorrupted)
+			}
+			n, err := decodeRecords(raw, current)
+			if err != nil {
+				return nil, err
+			}
+			count += n
+		case blockEnd:
+			if len(raw) != 8 || binary.LittleEndian.Uint64(raw) != count {
+				return nil, fmt.Errorf("%w: record count mismatch, read %d", ErrSnapshotCorrupted, count)
+			}
+			return result, nil
+		default:
+			return nil, fmt.Errorf("%w: unknown block kind %d", ErrSnapshotCorrupted, kind)
+		}
+	}
+}
+
+// readBlock 讀取一個 block，解壓後以 CRC32 驗證內容
+func readBlock(r io.Reader) (byte, []byte, error) {
+	var header [v3HeaderSize]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return 0, nil, fmt.Errorf("%w: read block header: %v", ErrSnapshotCorrupted, err)
+	}
+	kind := header[0]
+	rawLen := binary.LittleEndian.Uint32(header[1:])
+	compLen := binary.LittleEndian.Uint32(header[5:])
+	sum := binary.LittleEndian.Uint32(header[9:])
+	if rawLen > v3MaxBlockSize || compLen > v3MaxBlockSize {
+		return 0, nil, fmt.Errorf("%w: block size %d/%d too large", ErrSnapshotCorrupted, rawLen, compLen)
+	}
+	payload := make([]byte, compLen)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return 0, nil, fmt.Errorf("%w: read block: %v", ErrSnapshotCorrupted, err)
+	}
+	raw := payload
+	if kind == blockRecords {
+		var err error
+		if raw, err = snappy.Decode(nil, payload); err != nil {
+			return 0, nil, fmt.Errorf("%w: decompress block: %v", ErrSnapshotCorrupted, err)
+		}
+	}
+	if uint32(len(raw)) != rawLen || crc32.Checksum(raw, crcTable) != sum {
+		return 0, nil, fmt.Errorf("%w: block checksum mismatch", ErrSnapshotCorrupted)
+	}
+	return kind, raw, nil
+}
+
+// decodeRecords 解析 records block 寫入 dst，回傳紀錄數
+func decodeRecords(raw []byte, dst map[string]float64) (uint64, error) {
+	var n uint64
+	for len(raw) > 0 {
+		l, size := binary.Uvarint(raw)
+		// 損毀的長度可能接近 uint64 上限，先比較 l 再扣除，避免 l+8 溢位
+		if size <= 0 || l > uint64(len(raw)-size) || uint64(len(raw)-size)-l < 8 {
+			return 0, fmt.Errorf("%w: truncated record", ErrSnapshotCorrupted)
+		}
+		raw = raw[size:]
+		uid := string(raw[:l])
+		dst[uid] = math.Float64frombits(binary.LittleEndian.Uint64(raw[l:]))
+		raw = raw[l+8:]
+		n++
+	}
+	return n, nil
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
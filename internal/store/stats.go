@@ -0,0 +1,89 @@
+package store
+
+import (
+	"fmt"
+	"math"
+	"sort"
+
+	"go-raft/internal/domain"
+	"go-raft/pkg/maps"
+)
+
+// sumTolerance 增量總和與全表掃描總和之間允許的相對誤差（浮點累加順序不同）
+const sumTolerance = 1e-9
+
+// Stats 回傳指定幣別由 Update 增量維護的彙總統計，幣別不存在時回傳零值
+func (cs *CurrencyStore) Stats(currency string, topN int) domain.CurrencyStats {
+	val, ok := cs.store.Load(currency)
+	if !ok {
+		return domain.CurrencyStats{Currency: currency, TopHolders: []domain.Holder{}}
+	}
+	return toCurrencyStats(currency, val.(*maps.SafeFloatMap).Aggregate(topN))
+}
+
+// AllStats 回傳所有幣別的彙總統計，依幣別排序
+func (cs *CurrencyStore) AllStats(topN int) []domain.CurrencyStats {
+	result := make([]domain.CurrencyStats, 0)
+	cs.store.Range(func(key, value any) bool {
+		currency := key.(string)
+		result = append(result, toCurrencyStats(currency, value.(*maps.SafeFloatMap).Aggregate(topN)))
+		return true
+	})
+	sort.Slice(result, func(i, j int) bool { return result[i].Currency < result[j].Currency })
+	return result
+}
+
+// VerifyStats 以全表掃描重新計算指定幣別的統計並與增量結果比對
+// currency 為空代表驗證所有幣別
+func (cs *CurrencyStore) VerifyStats(currency string, topN int) error {
+	var verifyErr error
+	cs.store.Range(func(key, value any) bool {
+		name := key.(string)
+		if currency != "" && name != currency {
+			return true
+		}
+		sfm := value.(*maps.SafeFloatMap)
+		if err := compareAggregate(sfm.Aggregate(topN), sfm.ScanAggregate(topN)); err != nil {
+			verifyErr = fmt.Errorf("currency %s: %w", name, err)
+			return false
+		}
+		return true
+	})
+	return verifyErr
+}
+
+func compareAggregate(inc, full maps.Aggregate) error {
+	if math.Abs(inc.Sum-full.Sum) > sumTolerance*math.Max(1, math.Abs(full.Sum)) {
+		return fmt.Errorf("sum mismatch: incremental %v, scan %v", inc.Sum, full.Sum)
+	}
+	if inc.NonZero != full.NonZero {
+		return fmt.Errorf("account count mismatch: incremental %d, scan %d", inc.NonZero, full.NonZero)
+	}
+	if inc.Min != full.Min || inc.Max != full.Max {
+		return fmt.Errorf("min/max mismatch: incremental %v/%v, scan %v/%v", inc.Min, inc.Max, full.Min, full.Max)
+	}
+	if len(inc.Top) != len(full.Top) {
+		return fmt.Errorf("top holders length mismatch: incremental %d, scan %d", len(inc.Top), len(full.Top))
+	}
+	for i := range inc.Top {
+		if inc.Top[i] != full.Top[i] {
+			return fmt.Errorf("top holder #%d mismatch: incremental %+v, scan %+v", i, inc.Top[i], full.Top[i])
+		}
+	}
+	return nil
+}
+
+func toCurrencyStats(currency string, agg maps.Aggregate) domain.CurrencyStats {
+	holders := make([]domain.Holder, 0, len(agg.Top))
+	for _, e := range agg.Top {
+		holders = append(holders, domain.Holder{UID: e.Key, Balance: e.Value})
+	}
+	return domain.CurrencyStats{
+		Currency:   currency,
+		Total:      agg.Sum,
+		Accounts:   agg.NonZero,
+		Min:        agg.Min,
+		Max:        agg.Max,
+		TopHolders: holders,
+	}
+}
@@ -0,0 +1,59 @@
+package store_test
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+
+	"go-raft/internal/store"
+)
+
+func TestStatsMatchFullScan(t *testing.T) {
+	cs := store.NewCurrencyStore(1, 1, t.TempDir(), 0)
+	rng := rand.New(rand.NewSource(42))
+	currencies := []string{"USD", "BTC", "ETH"}
+
+	for i := 0; i < 5000; i++ {
+		uid := fmt.Sprintf("user-%d", rng.Intn(200))
+		currency := currencies[rng.Intn(len(currencies))]
+		amount := float64(rng.Intn(2000)-1000) / 100
+		cs.Update(uid, currency, amount)
+
+		// 偶爾將帳戶歸零，確認非零帳戶數與排序索引會同步移除
+		if i%97 == 0 {
+			cs.Update(uid, currency, -cs.Get(uid, currency))
+		}
+	}
+
+	if err := cs.VerifyStats("", 20); err != nil {
+		t.Fatalf("incremental stats diverged from full scan: %v", err)
+	}
+
+	for _, currency := range currencies {
+		stats := cs.Stats(currency, 5)
+		var total float64
+		accounts := 0
+		for _, balances := range cs.List() {
+			if v, ok := balances[currency]; ok {
+				total += v
+				if v != 0 {
+					accounts++
+				}
+			}
+		}
+		if stats.Accounts != accounts {
+			t.Errorf("%s: expected %d accounts, got %d", currency, accounts, stats.Accounts)
+		}
+		if diff := stats.Total - total; diff > 1e-6 || diff < -1e-6 {
+			t.Errorf("%s: expected total %v, got %v", currency, total, stats.Total)
+		}
+		for i := 1; i < len(stats.TopHolders); i++ {
+			if stats.TopHolders[i-1].Balance < stats.TopHolders[i].Balance {
+				t.Fatalf("%s: top holders not sorted: %+v", currency, stats.TopHolders)
+			}
+		}
+		if len(stats.TopHolders) > 0 && stats.TopHolders[0].Balance != stats.Max {
+			t.Errorf("%s: first top holder %v does not match max %v", currency, stats.TopHolders[0].Balance, stats.Max)
+		}
+	}
+}
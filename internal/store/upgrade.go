@@ -0,0 +1,123 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+
+	"go-raft/internal/domain"
+)
+
+var (
+	// ErrDowngradeBlocked 版本已透過升級流程啟用，不可降級
+	ErrDowngradeBlocked = errors.New("downgrade blocked after activation")
+	// ErrUpgradeNotReady 尚有成員未回報支援指定版本
+	ErrUpgradeNotReady = errors.New("not all members support version")
+	// ErrUnknownFeature 不支援切換的格式
+	ErrUnknownFeature = errors.New("unknown feature")
+)
+
+// ReportSupport 紀錄節點回報的支援版本，同一節點重複回報時以最新的為準
+func (cs *CurrencyStore) ReportSupport(index uint64, ns domain.NodeSupport) {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	cs.nodes[ns.NodeID] = reportedAt(index, ns)
+}
+
+// RegisterMember 登記即將加入 shard 的節點，或撤銷尚未回報的登記
+func (cs *CurrencyStore) RegisterMember(r domain.MemberRegistration) {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	ns, ok := cs.nodes[r.NodeID]
+	if next, keep := registered(ns, ok, r); keep {
+		cs.nodes[r.NodeID] = next
+	} else {
+		delete(cs.nodes, r.NodeID)
+	}
+}
+
+// Activate 在所有成員都回報支援後啟用格式版本，啟用後不可降級
+func (cs *CurrencyStore) Activate(a domain.Activation) error {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	return activate(&cs.settings, cs.nodeList(), a)
+}
+
+// reportedAt 回傳於 raft index 回報的支援版本副本
+func reportedAt(index uint64, ns domain.NodeSupport) domain.NodeSupport {
+	ns.ReportedIndex = index
+	ns.SnapshotVersions = slices.Clone(ns.SnapshotVersions)
+	ns.CommandVersions = slices.Clone(ns.CommandVersions)
+	return ns
+}
+
+// registered 回傳套用登記後的節點紀錄，keep 為 false 時應刪除紀錄
+// 已回報的節點不因登記或撤銷而改變
+func registered(ns domain.NodeSupport, exists bool, r domain.MemberRegistration) (domain.NodeSupport, bool) {
+	switch {
+	case exists && ns.Reported():
+		return ns, true
+	case r.Cancel:
+		return domain.NodeSupport{}, false
+	}
+	return domain.NodeSupport{NodeID: r.NodeID}, true
+}
+
+// activate 檢查 nodes 中回報的支援版本，各成員都支援且沒有回報過或已登記的節點被遺漏後，於 settings 啟用格式版本
+func activate(settings *domain.Settings, nodes []domain.NodeSupport, a domain.Activation) error {
+	var active *uint64
+	floor := uint64(0)
+	switch a.Feature {
+	case domain.FeatureSnapshot:
+		active, floor = &settings.SnapshotVersion, settings.SnapshotFloor
+	case domain.FeatureCommand:
+		active, floor = &settings.CommandVersion, settings.CommandVersion
+	default:
+		return fmt.Errorf("%w: %s", ErrUnknownFeature, a.Feature)
+	}
+	if a.Version < floor {
+		return fmt.Errorf("%w: %s version %d below %d", ErrDowngradeBlocked, a.Feature, a.Version, floor)
+	}
+	if len(a.Members) == 0 {
+		return fmt.Errorf("%w: no members given", ErrUpgradeNotReady)
+	}
+	reported := make(map[uint64]domain.NodeSupport, len(nodes))
+	for _, ns := range nodes {
+		// 回報過或已登記的節點必須仍是成員或已移除，否則提案使用的成員清單已過期
+		if !slices.Contains(a.Members, ns.NodeID) && !slices.Contains(a.Removed, ns.NodeID) {
+			return fmt.Errorf("%w: node %d is registered but not in members", ErrUpgradeNotReady, ns.NodeID)
+		}
+		reported[ns.NodeID] = ns
+	}
+	for _, id := range a.Members {
+		ns, ok := reported[id]
+		if !ok || !ns.Supports(a.Feature, a.Version) {
+			return fmt.Errorf("%w: node %d does not support %s version %d", ErrUpgradeNotReady, id, a.Feature, a.Version)
+		}
+	}
+	*active = a.Version
+	if a.Feature == domain.FeatureSnapshot {
+		settings.SnapshotFloor = a.Version
+	}
+	return nil
+}
+
+// UpgradeState 回傳目前設定與各節點回報的支援版本，依節點 ID 排序
+func (cs *CurrencyStore) UpgradeState() domain.UpgradeState {
+	cs.mu.RLock()
+	defer cs.mu.RUnlock()
+	return domain.UpgradeState{Settings: cs.settings, Nodes: cs.nodeList()}
+}
+
+// nodeList 回傳節點支援清單副本，呼叫端需持有鎖
+func (cs *CurrencyStore) nodeList() []domain.NodeSupport {
+	result := make([]domain.NodeSupport, 0, len(cs.nodes))
+	for _, ns := range cs.nodes {
+		ns.SnapshotVersions = slices.Clone(ns.SnapshotVersions)
+		ns.CommandVersions = slices.Clone(ns.CommandVersions)
+		result = append(result, ns)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].NodeID < result[j].NodeID })
+	return result
+}
@@ -0,0 +1,95 @@
+package store_test
+
+import (
+	"errors"
+	"testing"
+
+	"go-raft/internal/domain"
+	"go-raft/internal/store"
+)
+
+// TestActivateChecksReportedNodes 所有成員都支援才啟用，成員清單遺漏回報過的節點時拒絕，啟用後不可降級
+func TestActivateChecksReportedNodes(t *testing.T) {
+	eachLedger(t, func(t *testing.T, l store.Ledger) {
+		for id := uint64(1); id <= 3; id++ {
+			l.ReportSupport(id, domain.NodeSupport{NodeID: id, SnapshotVersions: []uint64{1, 2, 3, 4}, CommandVersions: []uint64{0, 1}})
+		}
+		// 節點 3 的舊版程式不支援 v4
+		l.ReportSupport(4, domain.NodeSupport{NodeID: 3, SnapshotVersions: []uint64{1, 2, 3}, CommandVersions: []uint64{0, 1}})
+
+		cases := []struct {
+			name string
+			a    domain.Activation
+			want error
+		}{
+			{"no members", domain.Activation{Feature: domain.FeatureSnapshot, Version: 4}, store.ErrUpgradeNotReady},
+			{"member lacks support", domain.Activation{Feature: domain.FeatureSnapshot, Version: 4, Members: []uint64{1, 2, 3}}, store.ErrUpgradeNotReady},
+			{"stale members omit reported node", domain.Activation{Feature: domain.FeatureSnapshot, Version: 4, Members: []uint64{1, 2}}, store.ErrUpgradeNotReady},
+			{"member not reported", domain.Activation{Feature: domain.FeatureSnapshot, Version: 3, Members: []uint64{1, 2, 3, 5}}, store.ErrUpgradeNotReady},
+			{"unknown feature", domain.Activation{Feature: "wire", Version: 1, Members: []uint64{1, 2, 3}}, store.ErrUnknownFeature},
+			{"removed node excluded", domain.Activation{Feature: domain.FeatureSnapshot, Version: 4, Members: []uint64{1, 2}, Removed: []uint64{3}}, nil},
+			{"downgrade below floor", domain.Activation{Feature: domain.FeatureSnapshot, Version: 3, Members: []uint64{1, 2}, Removed: []uint64{3}}, store.ErrDowngradeBlocked},
+		}
+		for _, c := range cases {
+			if err := l.Activate(c.a); !errors.Is(err, c.want) {
+				t.Fatalf("%s: got %v, want %v", c.name, err, c.want)
+			}
+		}
+		if s := l.Settings(); s.SnapshotVersion != 4 || s.SnapshotFloor != 4 {
+			t.Fatalf("settings %+v, want snapshot v4 with floor 4", s)
+		}
+	})
+}
+
+// TestActivateRejectsMemberAddedAfterRead 成員清單讀取後、啟用套用前登記並加入的節點尚未回報，啟用須被拒絕
+func TestActivateRejectsMemberAddedAfterRead(t *testing.T) {
+	eachLedger(t, func(t *testing.T, l store.Ledger) {
+		for id := uint64(1); id <= 2; id++ {
+			l.ReportSupport(id, domain.NodeSupport{NodeID: id, SnapshotVersions: []uint64{1, 2, 3, 4}, CommandVersions: []uint64{0, 1}})
+		}
+		// 啟用提案時讀到的成員為 1、2，之後節點 3 登記並加入 shard
+		stale := domain.Activation{Feature: domain.FeatureSnapshot, Version: 4, Members: []uint64{1, 2}}
+		l.RegisterMember(domain.MemberRegistration{NodeID: 3})
+		if err := l.Activate(stale); !errors.Is(err, store.ErrUpgradeNotReady) {
+			t.Fatalf("stale members: got %v, want %v", err, store.ErrUpgradeNotReady)
+		}
+		// 新的成員清單包含節點 3，但其尚未回報支援
+		current := domain.Activation{Feature: domain.FeatureSnapshot, Version: 4, Members: []uint64{1, 2, 3}}
+		if err := l.Activate(current); !errors.Is(err, store.ErrUpgradeNotReady) {
+			t.Fatalf("unreported member: got %v, want %v", err, store.ErrUpgradeNotReady)
+		}
+		if s := l.Settings(); s.SnapshotVersion == 4 {
+			t.Fatalf("settings %+v activated before node 3 reported", s)
+		}
+
+		// 回報後再次登記或撤銷都不會覆蓋回報的版本
+		l.ReportSupport(10, domain.NodeSupport{NodeID: 3, SnapshotVersions: []uint64{1, 2, 3, 4}, CommandVersions: []uint64{0, 1}})
+		l.RegisterMember(domain.MemberRegistration{NodeID: 3})
+		l.RegisterMember(domain.MemberRegistration{NodeID: 3, Cancel: true})
+		if err := l.Activate(current); err != nil {
+			t.Fatalf("all members reported: %v", err)
+		}
+	})
+}
+
+// TestCancelMemberRegistration 加入失敗撤銷登記後，該節點不再阻擋啟用
+func TestCancelMemberRegistration(t *testing.T) {
+	eachLedger(t, func(t *testing.T, l store.Ledger) {
+		l.ReportSupport(1, domain.NodeSupport{NodeID: 1, SnapshotVersions: []uint64{1, 2}, CommandVersions: []uint64{0, 1}})
+		l.RegisterMember(domain.MemberRegistration{NodeID: 2})
+		if nodes := l.UpgradeState().Nodes; len(nodes) != 2 || nodes[1].Reported() {
+			t.Fatalf("nodes %+v, want node 2 registered without report", nodes)
+		}
+		a := domain.Activation{Feature: domain.FeatureSnapshot, Version: 2, Members: []uint64{1}}
+		if err := l.Activate(a); !errors.Is(err, store.ErrUpgradeNotReady) {
+			t.Fatalf("registered node: got %v, want %v", err, store.ErrUpgradeNotReady)
+		}
+		l.RegisterMember(domain.MemberRegistration{NodeID: 2, Cancel: true})
+		if nodes := l.UpgradeState().Nodes; len(nodes) != 1 {
+			t.Fatalf("nodes %+v, want registration of node 2 cancelled", nodes)
+		}
+		if err := l.Activate(a); err != nil {
+			t.Fatalf("after cancel: %v", err)
+		}
+	})
+}
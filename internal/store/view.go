@@ -0,0 +1,75 @@
+package store
+
+import (
+	"go-raft/internal/domain"
+	"go-raft/pkg/maps"
+)
+
+// View 為 CurrencyStore 在某個時間點的不可變狀態
+// 餘額、歷史、帳戶與提領紀錄以 copy-on-write 共用，建立時只複製根節點參照，之後的寫入只複製被修改的路徑
+type View struct {
+	balances   map[string]*maps.FrozenMap[float64]
+	history    *historyView
+	currencies []domain.Currency
+	accounts   *maps.FrozenMap[domain.Account]
+	limits     *limitsView
+	settings   domain.Settings
+	nodes      []domain.NodeSupport
+	checksums  []domain.ChecksumRound
+}
+
+// View 取得目前狀態的不可變檢視，需要單一 index 的一致狀態時，呼叫端需確保期間沒有 Update
+func (cs *CurrencyStore) View() *View {
+	v := &View{
+		balances:   make(map[string]*maps.FrozenMap[float64]),
+		history:    cs.history.freeze(),
+		currencies: cs.registry.List(),
+		accounts:   cs.accounts.freeze(),
+		limits:     cs.limits.freeze(),
+	}
+	cs.store.Range(func(key, value any) bool {
+		v.balances[key.(string)] = value.(*maps.SafeFloatMap).Freeze()
+		return true
+	})
+	cs.mu.RLock()
+	v.settings, v.nodes, v.checksums = cs.settings, cs.nodeList(), cs.checksums
+	cs.mu.RUnlock()
+	return v
+}
+
+// Applied 回傳檢視當下最後套用的 raft index
+func (v *View) Applied() uint64 {
+	return v.history.applied
+}
+
+// Capture 複製檢視的完整內容，可與 Update 並行呼叫
+func (v *View) Capture() *SnapshotContent {
+	balances := make(map[string]map[string]float64, len(v.balances))
+	for currency, byUID := range v.balances {
+		balances[currency] = byUID.Clone()
+	}
+	return &SnapshotContent{Meta: v.meta(), Balances: balances}
+}
+
+// meta 複製檢視中餘額以外的狀態
+func (v *View) meta() *StoreMeta {
+	settings := v.settings
+	return &StoreMeta{
+		History:    v.history.data(),
+		Currencies: v.currencies,
+		Accounts:   listAccounts(v.accounts.Range, v.accounts.Len()),
+		Limits:     v.limits.data(),
+		Settings:   &settings,
+		Nodes:      v.nodes,
+		Checksums:  v.checksums,
+	}
+}
+
+// tables 回傳各幣別餘額的唯讀表，直接共用檢視的資料而不複製
+func (v *View) tables() map[string]balanceTable {
+	tables := make(map[string]balanceTable, len(v.balances))
+	for currency, byUID := range v.balances {
+		tables[currency] = byUID
+	}
+	return tables
+}
@@ -0,0 +1,74 @@
+// Package tracing 設定 OpenTelemetry 的 tracer，並在 HTTP 標頭與 raft 命令之間傳遞 trace context
+package tracing
+
+import (
+	"context"
+	"net/http"
+	"os"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// ServiceName 為預設的 service.name，可由 OTEL_SERVICE_NAME 覆寫
+const ServiceName = "go-raft"
+
+// propagator 固定使用 W3C trace context，不依賴全域設定，命令中的格式在各節點間一致
+var propagator = propagation.TraceContext{}
+
+// Tracer 回傳全域 TracerProvider 的 tracer，未設定 exporter 時為 no-op
+func Tracer() trace.Tracer {
+	return otel.Tracer(ServiceName)
+}
+
+// Setup 於設定 OTEL_EXPORTER_OTLP_ENDPOINT 或 OTEL_EXPORTER_OTLP_TRACES_ENDPOINT 時以 OTLP/HTTP 匯出 span，
+// 其餘 exporter 設定（標頭、逾時等）同樣由 OTEL_EXPORTER_OTLP_* 環境變數指定；未設定時維持 no-op
+// 回傳的 shutdown 於結束前送出尚未匯出的 span
+func Setup(ctx context.Context) (func(context.Context) error, error) {
+	if os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT") == "" && os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT") == "" {
+		return func(context.Context) error { return nil }, nil
+	}
+	exporter, err := otlptracehttp.New(ctx)
+	if err != nil {
+		return nil, err
+	}
+	res, err := resource.New(ctx,
+		resource.WithAttributes(attribute.String("service.name", ServiceName)),
+		resource.WithFromEnv(),
+		resource.WithTelemetrySDK(),
+	)
+	if err != nil {
+		return nil, err
+	}
+	provider := sdktrace.NewTracerProvider(sdktrace.WithBatcher(exporter), sdktrace.WithResource(res))
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagator)
+	return provider.Shutdown, nil
+}
+
+// Inject 回傳 ctx 中 span 的 trace context，span 未被取樣記錄時回傳 nil
+// 未設定 exporter 時 no-op tracer 只會轉傳上游的 traceparent，不寫入命令以免每個 entry 都帶著無用的 trace context
+func Inject(ctx context.Context) map[string]string {
+	span := trace.SpanFromContext(ctx)
+	if !span.IsRecording() || !span.SpanContext().IsSampled() {
+		return nil
+	}
+	carrier := propagation.MapCarrier{}
+	propagator.Inject(ctx, carrier)
+	return carrier
+}
+
+// Extract 回傳 Inject 寫出的 trace context，格式不符時回傳無效的 SpanContext
+func Extract(carrier map[string]string) trace.SpanContext {
+	return trace.SpanContextFromContext(propagator.Extract(context.Background(), propagation.MapCarrier(carrier)))
+}
+
+// ExtractHTTP 回傳帶有上游 traceparent 標頭的 context，沒有時回傳原本的 ctx
+func ExtractHTTP(ctx context.Context, header http.Header) context.Context {
+	return propagator.Extract(ctx, propagation.HeaderCarrier(header))
+}
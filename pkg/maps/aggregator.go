@@ -0,0 +1,67 @@
+package maps
+
+import (
+	"container/heap"
+	"sort"
+)
+
+// Aggregator 以串流方式計算彙總統計，只保留前 top 名，適合無法整個載入記憶體的資料
+// SafeFloatMap.ScanAggregate 以此重新計算，結果與增量維護的 Aggregate 相同（總和的浮點累加順序除外）
+type Aggregator struct {
+	top int
+	agg Aggregate
+	min topHeap // 目前前 top 名中最小的在 min[0]
+}
+
+func NewAggregator(top int) *Aggregator {
+	return &Aggregator{top: top}
+}
+
+// Add 加入一個 key 的數值，零值只計入總和
+func (a *Aggregator) Add(key string, value float64) {
+	a.agg.Sum += value
+	if value == 0 {
+		return
+	}
+	e := Entry{Key: key, Value: value}
+	if a.agg.NonZero == 0 || value < a.agg.Min {
+		a.agg.Min = value
+	}
+	if a.agg.NonZero == 0 || value > a.agg.Max {
+		a.agg.Max = value
+	}
+	a.agg.NonZero++
+	switch {
+	case a.top <= 0:
+	case len(a.min) < a.top:
+		heap.Push(&a.min, e)
+	case less(a.min[0], e):
+		a.min[0] = e
+		heap.Fix(&a.min, 0)
+	}
+}
+
+// Result 回傳目前的彙總統計，Top 由大到小
+func (a *Aggregator) Result() Aggregate {
+	agg := a.agg
+	agg.Top = nil
+	if len(a.min) > 0 {
+		agg.Top = append([]Entry(nil), a.min...)
+		sort.Slice(agg.Top, func(i, j int) bool { return less(agg.Top[j], agg.Top[i]) })
+	}
+	return agg
+}
+
+// topHeap 為依 (Value, Key) 排序的 min-heap
+type topHeap []Entry
+
+func (h topHeap) Len() int           { return len(h) }
+func (h topHeap) Less(i, j int) bool { return less(h[i], h[j]) }
+func (h topHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+func (h *topHeap) Push(x any)        { *h = append(*h, x.(Entry)) }
+func (h *topHeap) Pop() any {
+	old := *h
+	e := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return e
+}
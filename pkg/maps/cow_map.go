@@ -0,0 +1,201 @@
+package maps
+
+const (
+	cowFanout   = 16 // 內部節點的子節點數，每一層使用 hash 的 4 bits
+	cowLeafSize = 64 // 葉節點達到此筆數時分裂，凍結後第一次寫入最多複製這麼多筆
+	cowMaxDepth = 8  // 32-bit hash 用完後葉節點不再分裂
+)
+
+// COWMap 為以 hash trie 保存的 copy-on-write map，Freeze 以固定成本取得不可變的檢視
+// 之後的寫入只複製該 key 路徑上的節點：每層一個 cowFanout 個指標的節點與最多 cowLeafSize 筆的葉節點，與資料量無關
+// 本身不是並行安全的，讀寫與 Freeze 需由呼叫端加鎖；Freeze 回傳的 FrozenMap 不再變動，可在任何 goroutine 讀取
+type COWMap[V any] struct {
+	epoch uint64
+	len   int
+	root  *cowNode[V]
+}
+
+// cowNode 為 trie 的節點，children 為 nil 時為葉節點，資料存放在 m
+type cowNode[V any] struct {
+	epoch    uint64 // 建立或複製此節點時的 epoch，小於 COWMap.epoch 代表可能被 FrozenMap 共用
+	m        map[string]V
+	children *[cowFanout]*cowNode[V]
+}
+
+// FrozenMap 為 COWMap 在 Freeze 當下的內容
+type FrozenMap[V any] struct {
+	len  int
+	root *cowNode[V]
+}
+
+func NewCOWMap[V any]() *COWMap[V] {
+	return &COWMap[V]{}
+}
+
+// COWMapOf 以 data 的內容建立 COWMap，不保留 data 的參照
+func COWMapOf[V any](data map[string]V) *COWMap[V] {
+	m := NewCOWMap[V]()
+	for k, v := range data {
+		m.Set(k, v)
+	}
+	return m
+}
+
+func (m *COWMap[V]) Get(key string) (V, bool) {
+	return m.root.get(key)
+}
+
+func (m *COWMap[V]) Set(key string, value V) {
+	b := m.writable(key)
+	if _, ok := b[key]; !ok {
+		m.len++
+	}
+	b[key] = value
+}
+
+func (m *COWMap[V]) Delete(key string) {
+	if _, ok := m.Get(key); !ok {
+		return
+	}
+	delete(m.writable(key), key)
+	m.len--
+}
+
+func (m *COWMap[V]) Len() int {
+	return m.len
+}
+
+// Range 依 trie 的順序走訪所有 key，fn 回傳 false 時停止；走訪期間不可寫入
+func (m *COWMap[V]) Range(fn func(key string, value V) bool) {
+	m.root.walk(fn)
+}
+
+// Freeze 回傳目前內容的不可變檢視，只複製根節點參照
+func (m *COWMap[V]) Freeze() *FrozenMap[V] {
+	f := &FrozenMap[V]{len: m.len, root: m.root}
+	m.epoch++
+	return f
+}
+
+// writable 回傳 key 所在且可寫入的葉節點資料，路徑上與 FrozenMap 共用的節點先複製，已滿的葉節點先分裂
+func (m *COWMap[V]) writable(key string) map[string]V {
+	h := hashOf(key)
+	p := &m.root
+	for depth := 0; ; depth++ {
+		n := m.own(*p)
+		*p = n
+		if n.children == nil {
+			if len(n.m) < cowLeafSize || depth == cowMaxDepth {
+				return n.m
+			}
+			m.split(n, depth)
+		}
+		p = &n.children[childOf(h, depth)]
+	}
+}
+
+// own 回傳可在目前 epoch 修改的節點，n 為 nil 時建立空的葉節點，與 FrozenMap 共用時複製一層
+func (m *COWMap[V]) own(n *cowNode[V]) *cowNode[V] {
+	switch {
+	case n == nil:
+		return &cowNode[V]{epoch: m.epoch, m: make(map[string]V)}
+	case n.epoch == m.epoch:
+		return n
+	}
+	cp := &cowNode[V]{epoch: m.epoch}
+	if n.children != nil {
+		children := *n.children
+		cp.children = &children
+		return cp
+	}
+	cp.m = make(map[string]V, len(n.m)+1)
+	for k, v := range n.m {
+		cp.m[k] = v
+	}
+	return cp
+}
+
+// split 將可寫入的葉節點 n 依 depth 層的 hash 分配到新的子節點
+func (m *COWMap[V]) split(n *cowNode[V], depth int) {
+	children := new([cowFanout]*cowNode[V])
+	for k, v := range n.m {
+		i := childOf(hashOf(k), depth)
+		if children[i] == nil {
+			children[i] = &cowNode[V]{epoch: m.epoch, m: make(map[string]V)}
+		}
+		children[i].m[k] = v
+	}
+	n.m, n.children = nil, children
+}
+
+func (n *cowNode[V]) get(key string) (V, bool) {
+	h := hashOf(key)
+	for depth := 0; n != nil && n.children != nil; depth++ {
+		n = n.children[childOf(h, depth)]
+	}
+	if n == nil {
+		var zero V
+		return zero, false
+	}
+	v, ok := n.m[key]
+	return v, ok
+}
+
+// walk 依子節點順序走訪，fn 回傳 false 時停止並回傳 false
+func (n *cowNode[V]) walk(fn func(key string, value V) bool) bool {
+	if n == nil {
+		return true
+	}
+	if n.children == nil {
+		for k, v := range n.m {
+			if !fn(k, v) {
+				return false
+			}
+		}
+		return true
+	}
+	for _, child := range n.children {
+		if !child.walk(fn) {
+			return false
+		}
+	}
+	return true
+}
+
+func (f *FrozenMap[V]) Get(key string) (V, bool) {
+	return f.root.get(key)
+}
+
+func (f *FrozenMap[V]) Len() int {
+	return f.len
+}
+
+// Range 依 trie 的順序走訪所有 key，fn 回傳 false 時停止
+func (f *FrozenMap[V]) Range(fn func(key string, value V) bool) {
+	f.root.walk(fn)
+}
+
+// Clone 回傳內容的一般 map 副本
+func (f *FrozenMap[V]) Clone() map[string]V {
+	cp := make(map[string]V, f.len)
+	f.Range(func(k string, v V) bool {
+		cp[k] = v
+		return true
+	})
+	return cp
+}
+
+// hashOf 回傳 key 的 FNV-1a hash
+func hashOf(key string) uint32 {
+	h := uint32(2166136261)
+	for i := 0; i < len(key); i++ {
+		h ^= uint32(key[i])
+		h *= 16777619
+	}
+	return h
+}
+
+// childOf 回傳 hash 在 depth 層對應的子節點
+func childOf(h uint32, depth int) int {
+	return int(h>>(4*depth)) & (cowFanout - 1)
+}
@@ -0,0 +1,196 @@
+package maps
+
+import (
+	"fmt"
+	"maps"
+	"math/rand"
+	"testing"
+)
+
+// frozenCase 為一次 Freeze 的結果與當下的內容
+type frozenCase struct {
+	frozen *FrozenMap[int]
+	want   map[string]int
+}
+
+// checkFrozen 比對 FrozenMap 與 Freeze 當下的內容
+func checkFrozen(t *testing.T, name string, f *FrozenMap[int], want map[string]int) {
+	t.Helper()
+	if f.Len() != len(want) {
+		t.Fatalf("%s: len %d, want %d", name, f.Len(), len(want))
+	}
+	if got := f.Clone(); !maps.Equal(got, want) {
+		t.Fatalf("%s: contents differ from the map at freeze (%d vs %d keys)", name, len(got), len(want))
+	}
+	for k, v := range want {
+		if got, ok := f.Get(k); !ok || got != v {
+			t.Fatalf("%s: get %q = %d, %v, want %d", name, k, got, ok, v)
+		}
+	}
+}
+
+// checkCOW 比對 COWMap 與一般 map 的內容
+func checkCOW(t *testing.T, m *COWMap[int], want map[string]int) {
+	t.Helper()
+	if m.Len() != len(want) {
+		t.Fatalf("len %d, want %d", m.Len(), len(want))
+	}
+	seen := 0
+	m.Range(func(k string, v int) bool {
+		if w, ok := want[k]; !ok || w != v {
+			t.Fatalf("range %q = %d, want %d, %v", k, v, w, ok)
+		}
+		seen++
+		return true
+	})
+	if seen != len(want) {
+		t.Fatalf("range visited %d keys, want %d", seen, len(want))
+	}
+}
+
+// TestCOWMapMatchesMap 隨機寫入、刪除並穿插 Freeze，COWMap 與每個 FrozenMap 都與一般 map 的結果相同
+// key 數量遠超過 cowLeafSize，葉節點會多次分裂，每次 Freeze 都開始新的 epoch
+func TestCOWMapMatchesMap(t *testing.T) {
+	rng := rand.New(rand.NewSource(42))
+	m := NewCOWMap[int]()
+	want := map[string]int{}
+	var frozen []frozenCase
+
+	for i := 0; i < 50000; i++ {
+		key := fmt.Sprintf("user-%d", rng.Intn(5000))
+		switch r := rng.Intn(100); {
+		case r < 60:
+			m.Set(key, i)
+			want[key] = i
+		case r < 95:
+			m.Delete(key)
+			delete(want, key)
+		default:
+			v, ok := m.Get(key)
+			if w, wok := want[key]; ok != wok || v != w {
+				t.Fatalf("get %q = %d, %v, want %d, %v", key, v, ok, w, wok)
+			}
+		}
+		if i%97 == 0 {
+			frozen = append(frozen, frozenCase{m.Freeze(), maps.Clone(want)})
+		}
+	}
+	checkCOW(t, m, want)
+	if m.root == nil || m.root.children == nil {
+		t.Fatal("root leaf never split")
+	}
+	if len(frozen) < 500 {
+		t.Fatalf("only %d freezes", len(frozen))
+	}
+	// 之後的寫入不影響任何一個先前的 FrozenMap
+	for i, c := range frozen {
+		checkFrozen(t, fmt.Sprintf("freeze %d", i), c.frozen, c.want)
+	}
+}
+
+// TestCOWMapDeleteAfterFreeze Freeze 後刪除所有 key，FrozenMap 仍保留原內容，COWMap 變為空的
+func TestCOWMapDeleteAfterFreeze(t *testing.T) {
+	m := NewCOWMap[int]()
+	want := map[string]int{}
+	for i := 0; i < 1000; i++ {
+		key := fmt.Sprintf("k%d", i)
+		m.Set(key, i)
+		want[key] = i
+	}
+	f := m.Freeze()
+	for k := range want {
+		m.Delete(k)
+	}
+	// 不存在的 key 不改變 Len
+	m.Delete("missing")
+	checkCOW(t, m, map[string]int{})
+	checkFrozen(t, "frozen", f, want)
+
+	// 刪除後再寫入同一個 key，舊的檢視不受影響
+	m.Set("k1", -1)
+	if v, ok := f.Get("k1"); !ok || v != 1 {
+		t.Fatalf("frozen k1 = %d, %v, want 1", v, ok)
+	}
+	checkCOW(t, m, map[string]int{"k1": -1})
+}
+
+// TestCOWMapCollisionLeaf hash 完全相同的 key 超過 cowLeafSize 時停在 cowMaxDepth 的葉節點，仍可正確寫入、刪除與凍結
+func TestCOWMapCollisionLeaf(t *testing.T) {
+	keys := collidingKeys(t, 2*cowLeafSize)
+	m := NewCOWMap[int]()
+	want := map[string]int{}
+	for i, k := range keys {
+		m.Set(k, i)
+		want[k] = i
+	}
+	// 其他 key 讓其餘路徑也分裂
+	for i := 0; i < 500; i++ {
+		key := fmt.Sprintf("other-%d", i)
+		m.Set(key, -i)
+		want[key] = -i
+	}
+	checkCOW(t, m, want)
+
+	leaf, depth := m.root, 0
+	for ; leaf.children != nil; depth++ {
+		leaf = leaf.children[childOf(hashOf(keys[0]), depth)]
+	}
+	if depth != cowMaxDepth || len(leaf.m) != len(keys) {
+		t.Fatalf("colliding keys at depth %d in a leaf of %d, want depth %d with %d", depth, len(leaf.m), cowMaxDepth, len(keys))
+	}
+
+	before := maps.Clone(want)
+	f := m.Freeze()
+	for _, k := range keys[:len(keys)/2] {
+		m.Delete(k)
+		delete(want, k)
+	}
+	m.Set(keys[len(keys)-1], 1000)
+	want[keys[len(keys)-1]] = 1000
+	checkCOW(t, m, want)
+	checkFrozen(t, "frozen", f, before)
+}
+
+// collidingKeys 回傳 n 個 FNV-1a hash 相同的 key
+// 每個 key 為不同的前綴加上 4 bytes：前兩個 byte 由前綴往後算，後兩個 byte 由目標 hash 往回算，兩邊的狀態相同時即為一組解
+func collidingKeys(t *testing.T, n int) []string {
+	t.Helper()
+	const prime = uint32(16777619)
+	// prime 為奇數，在 mod 2^32 下有乘法反元素
+	inv := prime
+	for i := 0; i < 5; i++ {
+		inv *= 2 - prime*inv
+	}
+	const target = uint32(0x5eed1234)
+	// 目標 hash 往回兩個 byte 前的狀態
+	back := make(map[uint32][2]byte, 1<<16)
+	for b4 := 0; b4 < 256; b4++ {
+		s3 := (target * inv) ^ uint32(b4)
+		for b3 := 0; b3 < 256; b3++ {
+			back[(s3*inv)^uint32(b3)] = [2]byte{byte(b3), byte(b4)}
+		}
+	}
+
+	var keys []string
+	for p := 0; len(keys) < n; p++ {
+		if p > 100*n {
+			t.Fatalf("found only %d colliding keys", len(keys))
+		}
+		prefix := fmt.Sprintf("collide-%d-", p)
+		s := hashOf(prefix)
+		for b1 := 0; b1 < 256 && len(keys) < n; b1++ {
+			s1 := (s ^ uint32(b1)) * prime
+			for b2 := 0; b2 < 256 && len(keys) < n; b2++ {
+				if tail, ok := back[(s1^uint32(b2))*prime]; ok {
+					keys = append(keys, prefix+string([]byte{byte(b1), byte(b2), tail[0], tail[1]}))
+				}
+			}
+		}
+	}
+	for _, k := range keys {
+		if hashOf(k) != target {
+			t.Fatalf("key %q hashes to %08x, want %08x", k, hashOf(k), target)
+		}
+	}
+	return keys
+}
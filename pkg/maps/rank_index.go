@@ -0,0 +1,119 @@
+package maps
+
+// maxRankLevel 為 skiplist 的最大層數，以每層 1/4 的機率可容納遠超過 2^32 筆資料
+const maxRankLevel = 16
+
+// rankIndex 為依 (Value, Key) 由小到大排序的 skiplist，插入與刪除為 O(log n)
+// 最底層另有反向指標，可由最大值往回走訪前 N 名
+type rankIndex struct {
+	head  *rankNode
+	tail  *rankNode // 最大的 entry，空的時候為 nil
+	level int
+	len   int
+	rng   uint64 // xorshift 狀態，只影響節點層數，不影響排序結果
+}
+
+type rankNode struct {
+	entry Entry
+	next  []*rankNode
+	prev  *rankNode // 最底層的前一個節點，第一個節點為 nil
+}
+
+func newRankIndex() *rankIndex {
+	return &rankIndex{
+		head:  &rankNode{next: make([]*rankNode, maxRankLevel)},
+		level: 1,
+		rng:   0x9E3779B97F4A7C15,
+	}
+}
+
+// Len 回傳 entry 數量
+func (r *rankIndex) Len() int {
+	return r.len
+}
+
+// Insert 加入 e，呼叫端需確保相同的 (Value, Key) 不在索引中
+func (r *rankIndex) Insert(e Entry) {
+	var update [maxRankLevel]*rankNode
+	r.find(e, &update)
+	level := r.randomLevel()
+	if level > r.level {
+		for i := r.level; i < level; i++ {
+			update[i] = r.head
+		}
+		r.level = level
+	}
+	n := &rankNode{entry: e, next: make([]*rankNode, level)}
+	for i := 0; i < level; i++ {
+		n.next[i] = update[i].next[i]
+		update[i].next[i] = n
+	}
+	if update[0] != r.head {
+		n.prev = update[0]
+	}
+	if n.next[0] != nil {
+		n.next[0].prev = n
+	} else {
+		r.tail = n
+	}
+	r.len++
+}
+
+// Remove 移除 e，不存在時不做任何事
+func (r *rankIndex) Remove(e Entry) {
+	var update [maxRankLevel]*rankNode
+	n := r.find(e, &update)
+	if n == nil || n.entry != e {
+		return
+	}
+	for i := 0; i < r.level && update[i].next[i] == n; i++ {
+		update[i].next[i] = n.next[i]
+	}
+	if n.next[0] != nil {
+		n.next[0].prev = n.prev
+	} else {
+		r.tail = n.prev
+	}
+	for r.level > 1 && r.head.next[r.level-1] == nil {
+		r.level--
+	}
+	r.len--
+}
+
+// Min 回傳最小的 entry，索引為空時 ok 為 false
+func (r *rankIndex) Min() (Entry, bool) {
+	if n := r.head.next[0]; n != nil {
+		return n.entry, true
+	}
+	return Entry{}, false
+}
+
+// Descend 由大到小走訪，fn 回傳 false 時停止
+func (r *rankIndex) Descend(fn func(e Entry) bool) {
+	for n := r.tail; n != nil && fn(n.entry); n = n.prev {
+	}
+}
+
+// find 找出每一層中最後一個小於 e 的節點放入 update，回傳最底層第一個不小於 e 的節點
+func (r *rankIndex) find(e Entry, update *[maxRankLevel]*rankNode) *rankNode {
+	x := r.head
+	for i := r.level - 1; i >= 0; i-- {
+		for x.next[i] != nil && less(x.next[i].entry, e) {
+			x = x.next[i]
+		}
+		update[i] = x
+	}
+	return x.next[0]
+}
+
+// randomLevel 以每層 1/4 的機率決定新節點的層數
+func (r *rankIndex) randomLevel() int {
+	r.rng ^= r.rng << 13
+	r.rng ^= r.rng >> 7
+	r.rng ^= r.rng << 17
+	level := 1
+	for x := r.rng; level < maxRankLevel && x&3 == 0; x >>= 2 {
+		level++
+	}
+	return level
+}
@@ -0,0 +1,88 @@
+package maps
+
+import (
+	"fmt"
+	"math/rand"
+	"slices"
+	"sort"
+	"testing"
+)
+
+// checkRank 比對 rankIndex 與依 less 排序的 slice
+func checkRank(t *testing.T, r *rankIndex, sorted []Entry) {
+	t.Helper()
+	if r.Len() != len(sorted) {
+		t.Fatalf("len %d, want %d", r.Len(), len(sorted))
+	}
+	first, ok := r.Min()
+	if ok != (len(sorted) > 0) || (ok && first != sorted[0]) {
+		t.Fatalf("min %+v, %v", first, ok)
+	}
+	var got []Entry
+	r.Descend(func(e Entry) bool {
+		got = append(got, e)
+		return true
+	})
+	want := slices.Clone(sorted)
+	slices.Reverse(want)
+	if !slices.Equal(got, want) {
+		t.Fatalf("descend order differs: got %d entries, want %d", len(got), len(want))
+	}
+}
+
+// TestRankIndexMatchesSortedSlice 隨機插入與移除後，rankIndex 的順序、最小值與數量都與排序後的 slice 相同
+// 數值範圍很小，相同數值時依 key 排序
+func TestRankIndexMatchesSortedSlice(t *testing.T) {
+	rng := rand.New(rand.NewSource(42))
+	r := newRankIndex()
+	current := map[string]float64{}
+	var sorted []Entry
+	index := func(e Entry) int {
+		return sort.Search(len(sorted), func(i int) bool { return !less(sorted[i], e) })
+	}
+
+	for i := 0; i < 20000; i++ {
+		key := fmt.Sprintf("user-%d", rng.Intn(2000))
+		if v, ok := current[key]; ok {
+			e := Entry{Key: key, Value: v}
+			r.Remove(e)
+			j := index(e)
+			sorted = slices.Delete(sorted, j, j+1)
+			delete(current, key)
+		}
+		if rng.Intn(3) > 0 {
+			e := Entry{Key: key, Value: float64(rng.Intn(50))}
+			r.Insert(e)
+			j := index(e)
+			sorted = slices.Insert(sorted, j, e)
+			current[key] = e.Value
+		}
+		if i%1000 == 0 {
+			checkRank(t, r, sorted)
+		}
+	}
+	checkRank(t, r, sorted)
+
+	// 不存在的 entry 不影響索引
+	r.Remove(Entry{Key: "missing", Value: 1})
+	checkRank(t, r, sorted)
+
+	// Descend 在 fn 回傳 false 時停止
+	var top []Entry
+	r.Descend(func(e Entry) bool {
+		top = append(top, e)
+		return len(top) < 5
+	})
+	if len(top) != 5 || top[0] != sorted[len(sorted)-1] || top[4] != sorted[len(sorted)-5] {
+		t.Fatalf("top 5 %+v", top)
+	}
+
+	// 全部移除後回到空的索引
+	for _, e := range sorted {
+		r.Remove(e)
+	}
+	checkRank(t, r, nil)
+	if r.level != 1 || r.tail != nil {
+		t.Fatalf("empty index has level %d, tail %v", r.level, r.tail)
+	}
+}
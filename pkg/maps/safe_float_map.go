@@ -3,41 +3,138 @@ package maps
 import "sync"
 
 // SafeFloatMap 封裝每個 currency 的 uid->balance map，帶鎖保證寫入安全
+// 並在每次寫入時以增量方式維護彙總統計（總和、非零帳戶數、依餘額排序的索引）
+// 資料以 COWMap 保存，Freeze 取得的檢視不受之後的寫入影響
 type SafeFloatMap struct {
-	mu   sync.RWMutex
-	data map[string]float64
+	mu     sync.RWMutex
+	data   *COWMap[float64]
+	sum    float64
+	ranked *rankIndex // 非零餘額依 (Value, Key) 排序的索引，每次寫入 O(log n)
+}
+
+// Entry 為單一 key 與其數值
+type Entry struct {
+	Key   string
+	Value float64
+}
+
+// Aggregate 為 SafeFloatMap 的彙總統計
+// Min / Max / Top 只計算非零的數值
+type Aggregate struct {
+	Sum     float64
+	NonZero int
+	Min     float64
+	Max     float64
+	Top     []Entry // 由大到小
 }
 
 func NewSafeFloatMap() *SafeFloatMap {
 	return &SafeFloatMap{
-		data: make(map[string]float64),
+		data:   NewCOWMap[float64](),
+		ranked: newRankIndex(),
 	}
 }
 
 func (s *SafeFloatMap) Get(uid string) float64 {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
-	return s.data[uid]
+	v, _ := s.data.Get(uid)
+	return v
+}
+
+// Lookup 取得 uid 的數值，並回傳 uid 是否存在
+func (s *SafeFloatMap) Lookup(uid string) (float64, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.data.Get(uid)
 }
 
-func (s *SafeFloatMap) Add(uid string, amount float64) {
+// Add 對 uid 加上 amount（可為負數），回傳更新後的數值
+func (s *SafeFloatMap) Add(uid string, amount float64) float64 {
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	s.data[uid] += amount
+	old, _ := s.data.Get(uid)
+	s.data.Set(uid, old+amount)
+	s.track(uid, old, old+amount)
+	return old + amount
 }
 
 func (s *SafeFloatMap) Snapshot() map[string]float64 {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
-	cp := make(map[string]float64, len(s.data))
-	for k, v := range s.data {
+	cp := make(map[string]float64, s.data.Len())
+	s.data.Range(func(k string, v float64) bool {
 		cp[k] = v
-	}
+		return true
+	})
 	return cp
 }
 
+// Freeze 回傳目前資料的不可變檢視，成本與資料量無關；之後的寫入只複製該 uid 路徑上的節點
+func (s *SafeFloatMap) Freeze() *FrozenMap[float64] {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.data.Freeze()
+}
+
 func (s *SafeFloatMap) LoadData(newData map[string]float64) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	s.data = newData
+	s.data = COWMapOf(newData)
+	s.sum, s.ranked = 0, newRankIndex()
+	s.data.Range(func(k string, v float64) bool {
+		s.track(k, 0, v)
+		return true
+	})
+}
+
+// Aggregate 回傳增量維護的彙總統計，top 為要回傳的最大持有者數量
+func (s *SafeFloatMap) Aggregate(top int) Aggregate {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	agg := Aggregate{Sum: s.sum, NonZero: s.ranked.Len()}
+	if lowest, ok := s.ranked.Min(); ok {
+		agg.Min = lowest.Value
+	}
+	s.ranked.Descend(func(e Entry) bool {
+		if len(agg.Top) == 0 {
+			agg.Max = e.Value
+		}
+		if len(agg.Top) >= top {
+			return false
+		}
+		agg.Top = append(agg.Top, e)
+		return true
+	})
+	return agg
+}
+
+// ScanAggregate 以全表掃描重新計算彙總統計，用於驗證增量結果
+func (s *SafeFloatMap) ScanAggregate(top int) Aggregate {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	a := NewAggregator(top)
+	s.data.Range(func(k string, v float64) bool {
+		a.Add(k, v)
+		return true
+	})
+	return a.Result()
+}
+
+// track 依舊值與新值調整彙總統計，呼叫端需持有寫鎖
+func (s *SafeFloatMap) track(key string, old, new float64) {
+	s.sum += new - old
+	if old != 0 {
+		s.ranked.Remove(Entry{Key: key, Value: old})
+	}
+	if new != 0 {
+		s.ranked.Insert(Entry{Key: key, Value: new})
+	}
+}
+
+func less(a, b Entry) bool {
+	if a.Value != b.Value {
+		return a.Value < b.Value
+	}
+	return a.Key < b.Key
 }